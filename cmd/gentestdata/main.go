@@ -0,0 +1,297 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command gentestdata generates signed ID tokens and the matching certificate
+// for testing token verification.
+//
+// The tokens are described by a JSON template:
+//
+//	{
+//	  "keyId": "qwYevA",
+//	  "certificate": {
+//	    "organization": "Identity Toolkit Test",
+//	    "commonName": "Identity Toolkit",
+//	    "notBefore": "2015-11-01T00:10:30Z",
+//	    "notAfter": "2065-10-19T00:10:30Z"
+//	  },
+//	  "header": {"alg": "RS256", "kid": "qwYevA"},
+//	  "claims": {"iss": "https://identitytoolkit.google.com/", ...},
+//	  "tokens": [
+//	    {"name": "validToken"},
+//	    {"name": "expiredToken", "claims": {"exp": 1400438715}},
+//	    {"name": "malformedToken", "malformed": true},
+//	    {"name": "invalidSignatureToken", "badSignature": true}
+//	  ]
+//	}
+//
+// Each token starts from the top level header and claims. The fields of its own
+// header and claims replace or extend them, keeping the order of the fields.
+// A malformed token lacks the separator between the header and the claims, and
+// a token with a bad signature has one byte of its signature flipped.
+//
+// All tokens are signed with RS256 using the PKCS #1 RSA private key given by
+// -key, so the output only changes when the template or the key changes. The
+// output is a Go file declaring testKeyID, testCertPEM and one string constant
+// per token, e.g.,
+//
+//	//go:generate go run ../cmd/gentestdata/main.go -key testdata/testkey.pem -template testdata/fixtures.json -o fixtures_test.go
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	keyPath      = flag.String("key", "testdata/testkey.pem", "path of the PEM encoded RSA private key")
+	templatePath = flag.String("template", "testdata/fixtures.json", "path of the JSON fixture template")
+	outPath      = flag.String("o", "fixtures_test.go", "path of the generated Go file")
+	pkgName      = flag.String("package", "gitkit", "package name of the generated Go file")
+)
+
+const license = `// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+`
+
+type template struct {
+	KeyID       string `json:"keyId"`
+	Certificate struct {
+		Organization string    `json:"organization"`
+		CommonName   string    `json:"commonName"`
+		NotBefore    time.Time `json:"notBefore"`
+		NotAfter     time.Time `json:"notAfter"`
+	} `json:"certificate"`
+	Header json.RawMessage `json:"header"`
+	Claims json.RawMessage `json:"claims"`
+	Tokens []struct {
+		Name         string          `json:"name"`
+		Header       json.RawMessage `json:"header"`
+		Claims       json.RawMessage `json:"claims"`
+		Malformed    bool            `json:"malformed"`
+		BadSignature bool            `json:"badSignature"`
+	} `json:"tokens"`
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("gentestdata: ")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gentestdata [-key key.pem] [-template fixtures.json] [-o fixtures_test.go] [-package name]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	key, err := loadKey(*keyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(*templatePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var t template
+	if err := json.Unmarshal(b, &t); err != nil {
+		log.Fatalf("invalid template %s: %v", *templatePath, err)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprint(&out, license)
+	fmt.Fprintf(&out, "\n// Code generated by gentestdata from %s; DO NOT EDIT.\n\n", *templatePath)
+	fmt.Fprintf(&out, "package %s\n\n", *pkgName)
+	cert, err := createCert(&t, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(&out, "// testKeyID is the key ID of testCertPEM.\nconst testKeyID = %q\n\n", t.KeyID)
+	fmt.Fprintf(&out, "// testCertPEM is the self-signed certificate of the test key.\nconst testCertPEM = `%s`\n\n", strings.TrimSpace(cert))
+	fmt.Fprintln(&out, "const (")
+	for _, tt := range t.Tokens {
+		header, err := merge(t.Header, tt.Header)
+		if err != nil {
+			log.Fatalf("%s: invalid header: %v", tt.Name, err)
+		}
+		claims, err := merge(t.Claims, tt.Claims)
+		if err != nil {
+			log.Fatalf("%s: invalid claims: %v", tt.Name, err)
+		}
+		token, err := sign(key, header, claims, tt.Malformed, tt.BadSignature)
+		if err != nil {
+			log.Fatalf("%s: %v", tt.Name, err)
+		}
+		fmt.Fprintf(&out, "\t%s = %q\n", tt.Name, token)
+	}
+	fmt.Fprintln(&out, ")")
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*outPath, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func loadKey(path string) (*rsa.PrivateKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// createCert creates the PEM encoded self-signed certificate of the key.
+// RSA PKCS #1 v1.5 signatures are deterministic, so is the certificate.
+func createCert(t *template, key *rsa.PrivateKey) (string, error) {
+	name := pkix.Name{
+		Country:      []string{"US"},
+		Province:     []string{"CA"},
+		Organization: []string{t.Certificate.Organization},
+		CommonName:   t.Certificate.CommonName,
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               name,
+		Issuer:                name,
+		NotBefore:             t.Certificate.NotBefore,
+		NotAfter:              t.Certificate.NotAfter,
+		SignatureAlgorithm:    x509.SHA256WithRSA,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), nil
+}
+
+// sign creates a JWT signed with RS256 from the compact JSON header and claims.
+func sign(key *rsa.PrivateKey, header, claims []byte, malformed, badSignature bool) (string, error) {
+	input := encodeSegment(header) + "." + encodeSegment(claims)
+	hashed := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	if badSignature {
+		sig[len(sig)-1] ^= 0xff
+	}
+	if malformed {
+		input = strings.Replace(input, ".", "", 1)
+	}
+	return input + "." + encodeSegment(sig), nil
+}
+
+func encodeSegment(b []byte) string {
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(b), "=")
+}
+
+// field is a member of a JSON object.
+type field struct {
+	name  string
+	value json.RawMessage
+}
+
+// merge returns the compact JSON object of base with the fields of override
+// replacing or appended to the fields of base.
+func merge(base, override json.RawMessage) ([]byte, error) {
+	fields, err := decodeObject(base)
+	if err != nil {
+		return nil, err
+	}
+	if len(override) != 0 {
+		overrides, err := decodeObject(override)
+		if err != nil {
+			return nil, err
+		}
+	next:
+		for _, o := range overrides {
+			for i := range fields {
+				if fields[i].name == o.name {
+					fields[i].value = o.value
+					continue next
+				}
+			}
+			fields = append(fields, o)
+		}
+	}
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(f.name)
+		b.Write(name)
+		b.WriteByte(':')
+		if err := json.Compact(&b, f.value); err != nil {
+			return nil, err
+		}
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// decodeObject decodes a JSON object into its fields in the original order.
+func decodeObject(obj json.RawMessage) ([]field, error) {
+	dec := json.NewDecoder(bytes.NewReader(obj))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, fmt.Errorf("not a JSON object: %s", obj)
+	}
+	var fields []field
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		fields = append(fields, field{t.(string), v})
+	}
+	return fields, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+	"golang.org/x/net/context"
+)
+
+// configExport implements "gitkit config export".
+func configExport(args []string) error {
+	fs := flag.NewFlagSet("config export", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gitkit config export [flags]")
+		fs.PrintDefaults()
+	}
+	credentials := fs.String("credentials", "", "service account JSON key file; Application Default Credentials are used if empty")
+	format := fs.String("format", "yaml", "output format: yaml or json")
+	out := fs.String("o", "-", "output file; - for standard output")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	c, err := newClient(ctx, *credentials)
+	if err != nil {
+		return err
+	}
+	w, closeFn, err := create(*out)
+	if err != nil {
+		return err
+	}
+	if err := c.ExportProjectConfig(ctx, w, gitkit.ConfigFormat(*format)); err != nil {
+		closeFn()
+		return err
+	}
+	return closeFn()
+}
+
+// configApply implements "gitkit config apply".
+func configApply(args []string) error {
+	fs := flag.NewFlagSet("config apply", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gitkit config apply [flags] <file|->")
+		fmt.Fprintln(os.Stderr, "\nThe file is a YAML or JSON document, e.g., as written by \"gitkit config export\".")
+		fs.PrintDefaults()
+	}
+	credentials := fs.String("credentials", "", "service account JSON key file; Application Default Credentials are used if empty")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	c, err := newClient(ctx, *credentials)
+	if err != nil {
+		return err
+	}
+	r, closeFn, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	return c.ApplyProjectConfig(ctx, r)
+}
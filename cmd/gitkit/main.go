@@ -0,0 +1,94 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command gitkit is a tool for debugging and administering Google Identity
+// Toolkit integrations.
+//
+// Usage:
+//
+//	gitkit <command> <subcommand> [flags] [arguments]
+//
+// The commands are:
+//
+//	config export	export the configuration of the project
+//	config apply	apply a configuration to the project
+//	token verify	validate an ID token and print its claims
+//	users export	export all user accounts
+//	users import	import user accounts with hashed passwords
+//
+// Run "gitkit <command> <subcommand> -h" for the flags of a subcommand.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A command is a subcommand of the gitkit tool, e.g., "token verify".
+type command struct {
+	name  string // Command and subcommand names separated by a space.
+	short string // Short description shown in the usage message.
+	run   func(args []string) error
+}
+
+var commands = []*command{
+	{"config export", "export the configuration of the project", configExport},
+	{"config apply", "apply a configuration to the project", configApply},
+	{"token verify", "validate an ID token and print its claims", tokenVerify},
+	{"users export", "export all user accounts", usersExport},
+	{"users import", "import user accounts with hashed passwords", usersImport},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: gitkit <command> <subcommand> [flags] [arguments]")
+	fmt.Fprint(os.Stderr, "\nThe commands are:\n\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "\t%-16s%s\n", c.name, c.short)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		usage()
+	}
+	name := os.Args[1] + " " + os.Args[2]
+	for _, c := range commands {
+		if c.name == name {
+			if err := c.run(os.Args[3:]); err != nil {
+				fmt.Fprintf(os.Stderr, "gitkit %s: %v\n", c.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "gitkit: unknown command %q\n", name)
+	usage()
+}
+
+// splitList splits a comma separated flag value into a list. It returns nil
+// for an empty value.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var l []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			l = append(l, e)
+		}
+	}
+	return l
+}
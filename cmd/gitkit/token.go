@@ -0,0 +1,143 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+)
+
+const defaultCertsURL = "https://www.googleapis.com/identitytoolkit/v3/relyingparty/publicKeys"
+
+// tokenVerify implements "gitkit token verify".
+func tokenVerify(args []string) error {
+	fs := flag.NewFlagSet("token verify", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gitkit token verify [flags] <token|->")
+		fs.PrintDefaults()
+	}
+	aud := fs.String("aud", "", "comma separated list of accepted audiences, i.e., OAuth2 client IDs (required)")
+	iss := fs.String("iss", "", "comma separated list of accepted issuers; any issuer is accepted if empty")
+	certsFile := fs.String("certs", "", "file containing the certificates in the public keys endpoint format; fetched from -certs_url if empty")
+	certsURL := fs.String("certs_url", defaultCertsURL, "URL of the public keys endpoint")
+	skew := fs.Duration("clock_skew", gitkit.DefaultClockSkew, "leeway of the exp and iat checks; negative for none")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	audiences := splitList(*aud)
+	if len(audiences) == 0 {
+		return errors.New("-aud is required")
+	}
+
+	token := strings.TrimSpace(fs.Arg(0))
+	if token == "-" {
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	// Print what the token claims before validating it, so that the values
+	// can be compared against the failed check.
+	parts := strings.Split(token, ".")
+	claims := make(map[string]interface{})
+	for i, name := range []string{"Header", "Claims"} {
+		if i >= len(parts) {
+			break
+		}
+		b, err := decodeSegment(parts[i])
+		if err != nil {
+			fmt.Printf("%s: cannot decode: %v\n", name, err)
+			continue
+		}
+		var out bytes.Buffer
+		if json.Indent(&out, b, "", "  ") != nil {
+			fmt.Printf("%s: %s\n", name, b)
+			continue
+		}
+		fmt.Printf("%s: %s\n", name, out.Bytes())
+		if name == "Claims" {
+			json.Unmarshal(b, &claims)
+		}
+	}
+
+	var certs *gitkit.Certificates
+	if *certsFile != "" {
+		b, err := ioutil.ReadFile(*certsFile)
+		if err != nil {
+			return err
+		}
+		if certs, err = gitkit.ParseCertificates(b); err != nil {
+			return fmt.Errorf("invalid certificates in %s: %v", *certsFile, err)
+		}
+	} else {
+		certs = &gitkit.Certificates{URL: *certsURL}
+		if err := certs.LoadIfNecessary(http.DefaultTransport); err != nil {
+			return fmt.Errorf("cannot fetch certificates: %v", err)
+		}
+	}
+
+	opts := &gitkit.VerifyOptions{Audiences: audiences, Issuers: splitList(*iss), ClockSkew: *skew}
+	if _, err := gitkit.VerifyTokenWithOptions(token, certs, opts); err != nil {
+		return fmt.Errorf("invalid token: %s", explain(err, claims, opts))
+	}
+	fmt.Println("Token is valid.")
+	return nil
+}
+
+// explain describes the failed check with the values from the token.
+func explain(err error, claims map[string]interface{}, opts *gitkit.VerifyOptions) string {
+	switch err {
+	case gitkit.ErrInvalidAudience:
+		return fmt.Sprintf("%v: aud %v is not one of %q", err, claims["aud"], opts.Audiences)
+	case gitkit.ErrInvalidIssuer:
+		return fmt.Sprintf("%v: iss %v is not one of %q", err, claims["iss"], opts.Issuers)
+	case gitkit.ErrExpired:
+		if exp, ok := claims["exp"].(float64); ok {
+			return fmt.Sprintf("%v: exp %s is before now %s minus the clock skew %v", err,
+				time.Unix(int64(exp), 0).UTC().Format(time.RFC3339), time.Now().UTC().Format(time.RFC3339), opts.ClockSkew)
+		}
+	case gitkit.ErrIssuedInFuture:
+		if iat, ok := claims["iat"].(float64); ok {
+			return fmt.Sprintf("%v: iat %s is after now %s plus the clock skew %v", err,
+				time.Unix(int64(iat), 0).UTC().Format(time.RFC3339), time.Now().UTC().Format(time.RFC3339), opts.ClockSkew)
+		}
+	case gitkit.ErrKeyNotFound:
+		return fmt.Sprintf("%v: no certificate matches the kid in the header", err)
+	case gitkit.ErrInvalidAlgorithm:
+		return fmt.Sprintf("%v: only RS256 and ES256 are accepted", err)
+	case gitkit.ErrInvalidSignature:
+		return fmt.Sprintf("%v: the signature does not match the certificate of the kid", err)
+	}
+	return err.Error()
+}
+
+// decodeSegment decodes the Base64 encoding segment of the JWT token.
+func decodeSegment(s string) ([]byte, error) {
+	return base64.URLEncoding.DecodeString(s + strings.Repeat("=", (4-len(s)%4)%4))
+}
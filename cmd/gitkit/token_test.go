@@ -0,0 +1,62 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+)
+
+func TestExplain(t *testing.T) {
+	claims := map[string]interface{}{
+		"aud": "other-client",
+		"iss": "https://example.com",
+		"exp": float64(1400438715),
+		"iat": float64(4102444800),
+	}
+	opts := &gitkit.VerifyOptions{Audiences: []string{"client"}, Issuers: []string{"https://identitytoolkit.google.com/"}, ClockSkew: time.Minute}
+	tests := []struct {
+		err  error
+		want []string // Substrings of the explanation.
+	}{
+		{gitkit.ErrInvalidAudience, []string{"aud other-client", `["client"]`}},
+		{gitkit.ErrInvalidIssuer, []string{"iss https://example.com", `["https://identitytoolkit.google.com/"]`}},
+		{gitkit.ErrExpired, []string{"exp 2014-05-18T18:45:15Z", "clock skew 1m0s"}},
+		{gitkit.ErrIssuedInFuture, []string{"iat 2100-01-01T00:00:00Z", "clock skew 1m0s"}},
+		{gitkit.ErrKeyNotFound, []string{"kid"}},
+		{gitkit.ErrInvalidAlgorithm, []string{"RS256 and ES256"}},
+		{gitkit.ErrInvalidSignature, []string{"signature does not match"}},
+		{errors.New("other error"), []string{"other error"}},
+	}
+	for _, tt := range tests {
+		got := explain(tt.err, claims, opts)
+		if !strings.HasPrefix(got, tt.err.Error()) {
+			t.Errorf("explain(%v) = %q; want the error first", tt.err, got)
+		}
+		for _, s := range tt.want {
+			if !strings.Contains(got, s) {
+				t.Errorf("explain(%v) = %q; want %q in it", tt.err, got, s)
+			}
+		}
+	}
+	// The time checks fall back on the error without the claims.
+	if got := explain(gitkit.ErrExpired, nil, opts); got != gitkit.ErrExpired.Error() {
+		t.Errorf("explain(ErrExpired) without exp = %q; want %q", got, gitkit.ErrExpired.Error())
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+	"golang.org/x/net/context"
+)
+
+const (
+	// exportPageSize is the number of users fetched per downloadAccount call.
+	exportPageSize = 500
+	// quotaRetries is the number of times an import batch is uploaded again
+	// after exceeding the quota.
+	quotaRetries = 5
+	// defaultQuotaWait is the pause after a quota error without a retry hint.
+	defaultQuotaWait = time.Minute
+)
+
+// usersExport implements "gitkit users export".
+func usersExport(args []string) error {
+	fs := flag.NewFlagSet("users export", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gitkit users export [flags]")
+		fs.PrintDefaults()
+	}
+	credentials := fs.String("credentials", "", "service account JSON key file; Application Default Credentials are used if empty")
+	format := fs.String("format", "jsonl", "output format: jsonl (one user per line) or json (an array of users)")
+	out := fs.String("o", "-", "output file; - for standard output")
+	fs.Parse(args)
+	if *format != "jsonl" && *format != "json" {
+		return fmt.Errorf("unsupported format %q", *format)
+	}
+
+	ctx := context.Background()
+	c, err := newClient(ctx, *credentials)
+	if err != nil {
+		return err
+	}
+	w, closeFn, err := create(*out)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	bw := bufio.NewWriter(w)
+	if *format == "json" {
+		bw.WriteString("[\n")
+	}
+	n := 0
+	var pageToken gitkit.Cursor
+	for {
+		users, next, err := c.ListUsersN(ctx, exportPageSize, pageToken)
+		if err != nil {
+			return fmt.Errorf("exported %d users before failure: %v", n, err)
+		}
+		for _, u := range users {
+			b, err := json.Marshal(u)
+			if err != nil {
+				return err
+			}
+			if *format == "json" && n > 0 {
+				bw.WriteString(",\n")
+			}
+			bw.Write(b)
+			if *format == "jsonl" {
+				bw.WriteByte('\n')
+			}
+			n++
+		}
+		fmt.Fprintf(os.Stderr, "exported %d users\n", n)
+		if len(users) == 0 || next == "" {
+			break
+		}
+		pageToken = next
+	}
+	if *format == "json" {
+		bw.WriteString("\n]\n")
+	}
+	return bw.Flush()
+}
+
+// An importFailure is a line of the failure report of "gitkit users import".
+type importFailure struct {
+	Line    int    `json:"line"`
+	LocalID string `json:"localId,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Message string `json:"message"`
+	// Reason classifies the upload failures. Retryable is set if the failure
+	// is transient.
+	Reason    gitkit.UploadErrorReason `json:"reason,omitempty"`
+	Retryable bool                     `json:"retryable,omitempty"`
+}
+
+// usersImport implements "gitkit users import".
+func usersImport(args []string) error {
+	fs := flag.NewFlagSet("users import", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gitkit users import -hash <algorithm> [-key <key>] [flags] <file|->")
+		fmt.Fprintln(os.Stderr, "\nThe file contains one JSON encoded user per line, e.g., as written by \"gitkit users export\".")
+		fs.PrintDefaults()
+	}
+	credentials := fs.String("credentials", "", "service account JSON key file; Application Default Credentials are used if empty")
+	hash := fs.String("hash", "", "password hash algorithm, e.g., scrypt, hmac_sha256 (required)")
+	key := fs.String("key", "", "base64 encoded signer key of the password hash (required for the HMAC and SCRYPT algorithms)")
+	saltSeparator := fs.String("salt_separator", "", "base64 encoded separator between password and salt")
+	rounds := fs.Int("rounds", 0, "number of iterations of the password hash")
+	order := fs.String("order", "", "order of the salted hash input: salt_and_password or password_and_salt")
+	batch := fs.Int("batch", 1000, "number of users uploaded per request")
+	failures := fs.String("failures", "import_failures.jsonl", "file the failed users are reported to")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *hash == "" {
+		return errors.New("-hash is required")
+	}
+	signerKey, err := base64.StdEncoding.DecodeString(*key)
+	if err != nil {
+		return fmt.Errorf("-key must be a base64 string: %v", err)
+	}
+	sep, err := base64.StdEncoding.DecodeString(*saltSeparator)
+	if err != nil {
+		return fmt.Errorf("-salt_separator must be a base64 string: %v", err)
+	}
+	if *batch <= 0 {
+		return errors.New("-batch must be positive")
+	}
+	opts := &gitkit.UploadOptions{
+		HashAlgorithm:     strings.ToUpper(*hash),
+		SignerKey:         signerKey,
+		SaltSeparator:     sep,
+		Rounds:            *rounds,
+		PasswordHashOrder: strings.ToUpper(*order),
+	}
+	switch opts.PasswordHashOrder {
+	case "", gitkit.SaltAndPassword, gitkit.PasswordAndSalt:
+	default:
+		return fmt.Errorf("unsupported -order %q", *order)
+	}
+
+	ctx := context.Background()
+	c, err := newClient(ctx, *credentials)
+	if err != nil {
+		return err
+	}
+	r, closeIn, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closeIn()
+	fw, closeReport, err := create(*failures)
+	if err != nil {
+		return err
+	}
+	defer closeReport()
+	report := json.NewEncoder(fw)
+
+	var (
+		users  []*gitkit.User
+		lines  []int // Line number of each user in users.
+		done   int
+		failed int
+	)
+	fail := func(f importFailure) {
+		failed++
+		report.Encode(f)
+	}
+	upload := func() {
+		if len(users) == 0 {
+			return
+		}
+		err := c.UploadUsersWithOptions(ctx, users, opts)
+		for i := 0; i < quotaRetries; i++ {
+			qe, ok := err.(*gitkit.QuotaError)
+			if !ok {
+				break
+			}
+			wait := qe.RetryAfter
+			if wait <= 0 {
+				wait = defaultQuotaWait
+			}
+			fmt.Fprintf(os.Stderr, "quota exceeded (%s), pausing for %v\n", qe.Reason, wait)
+			time.Sleep(wait)
+			err = c.UploadUsersWithOptions(ctx, users, opts)
+		}
+		if ue, ok := err.(gitkit.UploadError); ok {
+			fmt.Fprintln(os.Stderr, ue.Summary())
+		}
+		for _, f := range uploadFailures(err, users, lines) {
+			fail(f)
+		}
+		done += len(users)
+		fmt.Fprintf(os.Stderr, "processed %d users, %d failed\n", done, failed)
+		users, lines = users[:0], lines[:0]
+	}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1<<20)
+	for line := 1; s.Scan(); line++ {
+		b := s.Bytes()
+		if len(strings.TrimSpace(string(b))) == 0 {
+			continue
+		}
+		u := &gitkit.User{}
+		if err := json.Unmarshal(b, u); err != nil {
+			fail(importFailure{Line: line, Message: fmt.Sprintf("invalid JSON: %v", err)})
+			continue
+		}
+		users = append(users, u)
+		lines = append(lines, line)
+		if len(users) == *batch {
+			upload()
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	upload()
+	if failed > 0 {
+		return fmt.Errorf("%d users failed to import, see %s", failed, *failures)
+	}
+	return nil
+}
+
+// uploadFailures returns the failures of the users uploaded from the lines
+// when the upload fails with err: the failed users of an UploadError, or else
+// all of them.
+func uploadFailures(err error, users []*gitkit.User, lines []int) []importFailure {
+	if err == nil {
+		return nil
+	}
+	var failures []importFailure
+	if ue, ok := err.(gitkit.UploadError); ok {
+		for _, e := range ue {
+			if e.Index >= 0 && e.Index < len(users) {
+				u := users[e.Index]
+				failures = append(failures, importFailure{lines[e.Index], u.LocalID, u.Email, e.Message, e.Reason(), e.IsRetryable()})
+			}
+		}
+		return failures
+	}
+	// The whole batch failed.
+	for i, u := range users {
+		failures = append(failures, importFailure{Line: lines[i], LocalID: u.LocalID, Email: u.Email, Message: err.Error()})
+	}
+	return failures
+}
+
+// newClient creates a gitkit client authorized by the service account key file
+// or Application Default Credentials if path is empty.
+func newClient(ctx context.Context, path string) (*gitkit.Client, error) {
+	return gitkit.New(ctx, &gitkit.Config{GoogleAppCredentialsPath: path})
+}
+
+// open opens the named file for reading, or standard input for "-".
+func open(name string) (io.Reader, func() error, error) {
+	if name == "-" {
+		return os.Stdin, func() error { return nil }, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
+
+// create creates the named file for writing, or returns standard output for
+// "-".
+func create(name string) (io.Writer, func() error, error) {
+	if name == "-" {
+		return os.Stdout, func() error { return nil }, nil
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+)
+
+func TestUploadFailures(t *testing.T) {
+	users := []*gitkit.User{
+		{LocalID: "1", Email: "a@example.com"},
+		{LocalID: "2", Email: "b@example.com"},
+		{LocalID: "3", Email: "c@example.com"},
+	}
+	lines := []int{1, 3, 4}
+	tests := []struct {
+		err  error
+		want []importFailure
+	}{
+		{nil, nil},
+		{
+			gitkit.UploadError{
+				{Index: 1, Message: "EMAIL_EXISTS"},
+				{Index: 2, Message: "backend error"},
+				{Index: 7, Message: "out of range"},
+			},
+			[]importFailure{
+				{Line: 3, LocalID: "2", Email: "b@example.com", Message: "EMAIL_EXISTS", Reason: gitkit.UploadErrorDuplicateEmail},
+				{Line: 4, LocalID: "3", Email: "c@example.com", Message: "backend error", Reason: gitkit.UploadErrorTransient, Retryable: true},
+			},
+		},
+		{
+			errors.New("permission denied"),
+			[]importFailure{
+				{Line: 1, LocalID: "1", Email: "a@example.com", Message: "permission denied"},
+				{Line: 3, LocalID: "2", Email: "b@example.com", Message: "permission denied"},
+				{Line: 4, LocalID: "3", Email: "c@example.com", Message: "permission denied"},
+			},
+		},
+	}
+	for i, tt := range tests {
+		if got := uploadFailures(tt.err, users, lines); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d. uploadFailures(%v) = %+v; want %+v", i, tt.err, got, tt.want)
+		}
+	}
+}
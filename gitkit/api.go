@@ -19,13 +19,14 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"net/http"
-	"reflect"
+	"net/url"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"golang.org/x/net/context"
 	"google.golang.org/api/googleapi"
 )
 
@@ -71,6 +72,14 @@ type ProviderUserInfo struct {
 	ProviderID string `json:"providerId,omitempty"`
 	// FederatedID is a unique identifier for the user within the IDP.
 	FederatedID string `json:"federatedId,omitempty"`
+	// RawID is the ID of the user assigned by the IDP, e.g., the sub claim of
+	// an OpenID Connect provider.
+	RawID string `json:"rawId,omitempty"`
+	// Email is the email address of the user at the IDP.
+	Email string `json:"email,omitempty"`
+	// ScreenName is the screen name of the user at the IDP, e.g., the Twitter
+	// handle.
+	ScreenName string `json:"screenName,omitempty"`
 	// DisplayName is the name of the user at the IDP.
 	DisplayName string `json:"displayName,omitempty"`
 	// PhotoURL is the profile picture URL of the user at the IDP.
@@ -103,6 +112,10 @@ type User struct {
 	ProviderID string `json:"providerId,omitempty"`
 	// Password is the raw password of the user. It is only used to set new password.
 	Password string `json:"-"`
+	// Disabled indicates if the user is disabled and cannot sign in.
+	Disabled bool `json:"disabled,omitempty"`
+	// CustomAttributes is the JSON object of the custom attributes of the user.
+	CustomAttributes string `json:"customAttributes,omitempty"`
 }
 
 // IdpConfig holds the IDP configuration.
@@ -110,6 +123,15 @@ type IdpConfig struct {
 	Provider string `json:"provider,omitempty"`
 	Enabled  bool   `json:"enabled,omitempty"`
 	ClientID string `json:"clientId,omitempty"`
+	// Secret is the OAuth2 client secret of the provider. It is only sent by
+	// SetProjectConfig, never returned.
+	Secret string `json:"secret,omitempty"`
+	// ExperimentPercent is the percentage of the users the provider is
+	// offered to, for a gradual rollout.
+	ExperimentPercent int `json:"experimentPercent,omitempty"`
+	// WhitelistedAudiences are the other client IDs whose tokens are
+	// accepted for the provider.
+	WhitelistedAudiences []string `json:"whitelistedAudiences,omitempty"`
 }
 
 // Identitytoolkit API endpoint URL common parts.
@@ -128,7 +150,13 @@ const (
 	uploadAccount    apiMethod = "uploadAccount"
 	downloadAccount  apiMethod = "downloadAccount"
 	getOOBCode       apiMethod = "getOobConfirmationCode"
+	resetPassword    apiMethod = "resetPassword"
+	verifyPassword   apiMethod = "verifyPassword"
+	signupNewUser    apiMethod = "signupNewUser"
+	createAuthURI    apiMethod = "createAuthUri"
+	verifyAssertion  apiMethod = "verifyAssertion"
 	getProjectConfig apiMethod = "getProjectConfig"
+	setProjectConfig apiMethod = "setProjectConfig"
 )
 
 // URL returns the full URL of the API method.
@@ -136,13 +164,37 @@ func (m apiMethod) url() string {
 	return strings.Join([]string{APIBaseURI, APIVersion, APIPath, string(m)}, "/")
 }
 
+// apiMethodKey is the context key of the API method of a request.
+type apiMethodKey struct{}
+
+// calledMethodKey is the context key of the API method called by the
+// APIClient, which is kept when the request is translated to another API
+// version, unlike apiMethodKey.
+type calledMethodKey struct{}
+
 // An APIClient is an HTTP client that sends requests and receives responses
 // from identitytoolkit APIs.
 //
 // The underlying http.Client should add appropriate auth credentials according
 // to the auth level of the API.
+//
+// The context given to the methods is carried by their HTTP requests, so that
+// its cancellation or deadline aborts them, including their retries.
 type APIClient struct {
 	http.Client
+
+	// BaseURL, if set, is the base URL of the API methods, i.e., the URL the
+	// method names are appended to, e.g., of a local fake server in tests.
+	// Otherwise, the URLs are built from APIBaseURI, APIVersion and APIPath.
+	BaseURL string
+}
+
+// url returns the URL of the API method.
+func (c *APIClient) url(m apiMethod) string {
+	if c.BaseURL != "" {
+		return strings.TrimRight(c.BaseURL, "/") + "/" + string(m)
+	}
+	return m.url()
 }
 
 type httpMethod string
@@ -152,46 +204,138 @@ const (
 	POST httpMethod = "POST"
 )
 
-func (c *APIClient) do(httpMethod httpMethod, m apiMethod, body []byte) ([]byte, error) {
+// apiBuffer is a request or response body buffer with a JSON encoder writing
+// into it.
+type apiBuffer struct {
+	bytes.Buffer
+	enc *json.Encoder
+}
+
+// bufferPool holds the apiBuffers, so that busy clients don't allocate new
+// buffers and encoders for every API call.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		b := new(apiBuffer)
+		b.enc = json.NewEncoder(&b.Buffer)
+		return b
+	},
+}
+
+// maxPooledBuffer is the capacity above which a buffer is dropped rather than
+// returned to the pool, e.g., after a large upload.
+const maxPooledBuffer = 1 << 20
+
+func getBuffer() *apiBuffer {
+	b := bufferPool.Get().(*apiBuffer)
+	b.Reset()
+	return b
+}
+
+func putBuffer(b *apiBuffer) {
+	if b.Cap() <= maxPooledBuffer {
+		bufferPool.Put(b)
+	}
+}
+
+// pooledBody is a request body that returns its buffer to the pool once the
+// transport closes it.
+type pooledBody struct {
+	*bytes.Reader
+	buf  *apiBuffer
+	once sync.Once
+}
+
+// Close implements the io.Closer interface.
+func (b *pooledBody) Close() error {
+	b.once.Do(func() { putBuffer(b.buf) })
+	return nil
+}
+
+// do sends the request with the body, if not nil, and reads the response body
+// into out. The ownership of body passes to do.
+func (c *APIClient) do(ctx context.Context, httpMethod httpMethod, u string, body, out *apiBuffer) error {
 	var req *http.Request
-	if httpMethod == POST {
-		req, _ = http.NewRequest(string(httpMethod), m.url(), bytes.NewReader(body))
+	if httpMethod == POST && body != nil {
+		req, _ = http.NewRequest(string(httpMethod), u, nil)
+		req.Body = &pooledBody{Reader: bytes.NewReader(body.Bytes()), buf: body}
+		req.ContentLength = int64(body.Len())
 	} else {
-		req, _ = http.NewRequest(string(httpMethod), m.url(), nil)
+		req, _ = http.NewRequest(string(httpMethod), u, nil)
+		if body != nil {
+			putBuffer(body)
+		}
 	}
-	resp, err := c.Do(req)
+	resp, err := c.Do(req.WithContext(ctx))
 	if err != nil {
-		return nil, err
+		// The errors of the API layers are not transport failures.
+		if ue, ok := err.(*url.Error); ok {
+			switch e := ue.Err.(type) {
+			case *UnsupportedMethodError, *UnknownFieldsError, *InitError:
+				return e
+			}
+		}
+		return err
 	}
 	defer resp.Body.Close()
 	if err := googleapi.CheckResponse(resp); err != nil {
-		return nil, err
+		return asQuotaError(resp, err)
 	}
-	return ioutil.ReadAll(resp.Body)
+	_, err = out.ReadFrom(resp.Body)
+	return err
+}
+
+// apiResponse is implemented by the pointers to the API responses. It lets the
+// compiler, instead of a runtime check, ensure that request decodes the
+// responses into pointers.
+type apiResponse interface {
+	apiResponse()
+}
+
+func (*GetAccountInfoResponse) apiResponse()   {}
+func (*SetAccountInfoResponse) apiResponse()   {}
+func (*DeleteAccountResponse) apiResponse()    {}
+func (*UploadAccountResponse) apiResponse()    {}
+func (*DownloadAccountResponse) apiResponse()  {}
+func (*GetOOBCodeResponse) apiResponse()       {}
+func (*ResetPasswordResponse) apiResponse()    {}
+func (*VerifyPasswordResponse) apiResponse()   {}
+func (*SignupNewUserResponse) apiResponse()    {}
+func (*CreateAuthURIResponse) apiResponse()    {}
+func (*VerifyAssertionResponse) apiResponse()  {}
+func (*GetProjectConfigResponse) apiResponse() {}
+func (*SetProjectConfigResponse) apiResponse() {}
+
+// request sends the JSON encoded req, unless it is nil, to the API method and
+// decodes the response into resp.
+func (c *APIClient) request(ctx context.Context, httpMethod httpMethod, m apiMethod, req interface{}, resp apiResponse) error {
+	return c.requestQuery(ctx, httpMethod, m, nil, req, resp)
 }
 
-func (c *APIClient) request(httpMethod httpMethod, m apiMethod, req, resp interface{}) error {
-	t := reflect.TypeOf(resp)
-	if t.Kind() != reflect.Ptr {
-		log.Fatal("Resp must be a pointer.")
+// requestQuery is like request with the query parameters q. The method is
+// carried by the context of the HTTP request, so that the transports can tell
+// the API method whatever the URL.
+func (c *APIClient) requestQuery(ctx context.Context, httpMethod httpMethod, m apiMethod, q url.Values, req interface{}, resp apiResponse) error {
+	u := c.url(m)
+	if len(q) != 0 {
+		u += "?" + q.Encode()
 	}
-	var body []byte
-	var err error
+	ctx = context.WithValue(context.WithValue(ctx, apiMethodKey{}, m), calledMethodKey{}, m)
+	var body *apiBuffer
 	if req != nil {
-		body, err = json.Marshal(req)
-	}
-	if err != nil {
-		return err
-	}
-	body, err = c.do(httpMethod, m, body)
-	if err != nil {
-		return err
+		body = getBuffer()
+		if err := body.enc.Encode(req); err != nil {
+			putBuffer(body)
+			return err
+		}
+		// Drop the newline added by the encoder.
+		body.Truncate(body.Len() - 1)
 	}
-	err = json.Unmarshal(body, resp)
-	if err != nil {
+	out := getBuffer()
+	defer putBuffer(out)
+	if err := c.do(ctx, httpMethod, u, body, out); err != nil {
 		return err
 	}
-	return nil
+	return json.Unmarshal(out.Bytes(), resp)
 }
 
 // GetAccountInfoRequest contains the email addresses or user IDs which are used
@@ -208,47 +352,68 @@ type GetAccountInfoResponse struct {
 }
 
 // GetAccountInfo retreives the users' account information.
-func (c *APIClient) GetAccountInfo(req *GetAccountInfoRequest) (*GetAccountInfoResponse, error) {
+func (c *APIClient) GetAccountInfo(ctx context.Context, req *GetAccountInfoRequest) (*GetAccountInfoResponse, error) {
 	if len(req.Emails) == 0 && len(req.LocalIDs) == 0 {
 		return nil, fmt.Errorf("GetAccountInfo: must provide an email or a local ID")
 	}
 
 	resp := &GetAccountInfoResponse{}
-	if err := c.request(POST, getAccountInfo, req, resp); err != nil {
+	if err := c.request(ctx, POST, getAccountInfo, req, resp); err != nil {
 		return nil, err
 	}
 	return resp, nil
 }
 
 // SetAccountInfoRequest contains account information to update.
-// Either LocalID or Email should be provided to find the account.
+// Either LocalID or Email should be provided to find the account, unless
+// OOBCode is: an email change OOB code applies the change to its account.
 // The Password field contains the new raw password if provided.
+// DisableUser, if not nil, disables or enables the account. CustomAttributes,
+// if not empty, replaces the custom attributes JSON object of the account.
 type SetAccountInfoRequest struct {
-	LocalID       string `json:"localId,omitempty"`
-	Email         string `json:"email,omitempty"`
-	DisplayName   string `json:"displayName,omitempty"`
-	Password      string `json:"password,omitempty"`
-	EmailVerified bool   `json:"emailVerified,omitempty"`
+	OOBCode          string `json:"oobCode,omitempty"`
+	LocalID          string `json:"localId,omitempty"`
+	Email            string `json:"email,omitempty"`
+	DisplayName      string `json:"displayName,omitempty"`
+	Password         string `json:"password,omitempty"`
+	EmailVerified    bool   `json:"emailVerified,omitempty"`
+	DisableUser      *bool  `json:"disableUser,omitempty"`
+	CustomAttributes string `json:"customAttributes,omitempty"`
 }
 
-// SetAccountInfoResponse is the response for a SetAccountInfoRequest upon success.
-// It is an empty response.
+// SetAccountInfoResponse is the response for a SetAccountInfoRequest upon
+// success. It is empty unless an OOB code is applied, in which case it
+// identifies the account: its old Email and its NewEmail for an email change
+// code, or its Email and EmailVerified for a verify email code.
 type SetAccountInfoResponse struct {
+	LocalID       string `json:"localId,omitempty"`
+	Email         string `json:"email,omitempty"`
+	NewEmail      string `json:"newEmail,omitempty"`
+	EmailVerified bool   `json:"emailVerified,omitempty"`
 }
 
 // SetAccountInfo updates the account information.
-func (c *APIClient) SetAccountInfo(req *SetAccountInfoRequest) (*SetAccountInfoResponse, error) {
-	if req.Email == "" && req.LocalID == "" {
-		return nil, fmt.Errorf("SetAccountInfo: must provide an email or a local ID")
+func (c *APIClient) SetAccountInfo(ctx context.Context, req *SetAccountInfoRequest) (*SetAccountInfoResponse, error) {
+	if req.Email == "" && req.LocalID == "" && req.OOBCode == "" {
+		return nil, fmt.Errorf("SetAccountInfo: must provide an email, a local ID or an OOB code")
 	}
 
 	resp := &SetAccountInfoResponse{}
-	if err := c.request(POST, setAccountInfo, req, resp); err != nil {
+	if err := c.request(ctx, POST, setAccountInfo, req, resp); err != nil {
 		return nil, err
 	}
 	return resp, nil
 }
 
+// ConfirmEmailVerification applies the verify email OOB code, i.e., marks the
+// email address of the user it was generated for as verified.
+func (c *APIClient) ConfirmEmailVerification(ctx context.Context, oobCode string) (*SetAccountInfoResponse, error) {
+	if oobCode == "" {
+		return nil, fmt.Errorf("ConfirmEmailVerification: must provide the OOB code")
+	}
+	return c.SetAccountInfo(ctx, &SetAccountInfoRequest{OOBCode: oobCode})
+}
+
 // DeleteAccountRequest contains the user ID to be deleted.
 type DeleteAccountRequest struct {
 	LocalID string `json:"localId,omitempty"`
@@ -260,33 +425,171 @@ type DeleteAccountResponse struct {
 }
 
 // DeleteAccount deletes an account.
-func (c *APIClient) DeleteAccount(req *DeleteAccountRequest) (*DeleteAccountResponse, error) {
+func (c *APIClient) DeleteAccount(ctx context.Context, req *DeleteAccountRequest) (*DeleteAccountResponse, error) {
 	if req.LocalID == "" {
 		return nil, fmt.Errorf("DeleteAccount: must provide a local ID")
 	}
 
 	resp := &DeleteAccountResponse{}
-	if err := c.request(POST, deleteAccount, req, resp); err != nil {
+	if err := c.request(ctx, POST, deleteAccount, req, resp); err != nil {
 		return nil, err
 	}
 	return resp, nil
 }
 
 // UploadAccountRequest the account information of users to upload.
-// The hash algorithm and signer key for the password are required.
+// The hash algorithm for the password is required, and so is the signer key
+// for the HMAC and SCRYPT algorithms. Rounds is the number of iterations of
+// the hash, and PasswordHashOrder the order of the password and the salt in
+// the hashed input.
 type UploadAccountRequest struct {
-	Users         []*User `json:"users,omitempty"`
-	HashAlgorithm string  `json:"hashAlgorithm,omitempty"`
-	SignerKey     Bytes   `json:"signerKey,omitempty"`
-	SaltSeparator Bytes   `json:"saltSeparator,omitempty"`
+	Users             []*User `json:"users,omitempty"`
+	HashAlgorithm     string  `json:"hashAlgorithm,omitempty"`
+	SignerKey         Bytes   `json:"signerKey,omitempty"`
+	SaltSeparator     Bytes   `json:"saltSeparator,omitempty"`
+	Rounds            int     `json:"rounds,omitempty"`
+	MemoryCost        int     `json:"memoryCost,omitempty"`
+	PasswordHashOrder string  `json:"passwordHashOrder,omitempty"`
+	// Argon2Parameters is required by the ARGON2 algorithm.
+	Argon2Parameters *Argon2Parameters `json:"argon2Parameters,omitempty"`
+}
+
+// Argon2 hash types.
+const (
+	Argon2D  = "ARGON2_D"
+	Argon2I  = "ARGON2_I"
+	Argon2ID = "ARGON2_ID"
+)
+
+// Argon2Parameters are the parameters of the ARGON2 password hash algorithm.
+type Argon2Parameters struct {
+	// HashType is Argon2D, Argon2I or Argon2ID.
+	HashType string `json:"hashType,omitempty"`
+	// Version is the Argon2 version, e.g., 19 for version 1.3. The latest
+	// version is assumed if zero.
+	Version int `json:"version,omitempty"`
+	// HashLengthBytes is the length of the hashes, between 1 and 256.
+	HashLengthBytes int `json:"hashLengthBytes,omitempty"`
+	// Iterations is the number of passes, between 1 and 16.
+	Iterations int `json:"iterations,omitempty"`
+	// MemoryCostKib is the memory used in KiB, at most 32768.
+	MemoryCostKib int `json:"memoryCostKib,omitempty"`
+	// Parallelism is the number of lanes, between 1 and 16.
+	Parallelism int `json:"parallelism,omitempty"`
+	// AssociatedData is the optional associated data.
+	AssociatedData Bytes `json:"associatedData,omitempty"`
+}
+
+// validate checks the parameters are within the ranges accepted by the API.
+func (p *Argon2Parameters) validate() error {
+	switch p.HashType {
+	case Argon2D, Argon2I, Argon2ID:
+	default:
+		return fmt.Errorf("invalid Argon2 hash type %q", p.HashType)
+	}
+	if p.HashLengthBytes < 1 || p.HashLengthBytes > 256 {
+		return fmt.Errorf("Argon2 hash length must be between 1 and 256, got %d", p.HashLengthBytes)
+	}
+	if p.Iterations < 1 || p.Iterations > 16 {
+		return fmt.Errorf("Argon2 iterations must be between 1 and 16, got %d", p.Iterations)
+	}
+	if p.MemoryCostKib < 1 || p.MemoryCostKib > 32768 {
+		return fmt.Errorf("Argon2 memory cost must be between 1 and 32768 KiB, got %d", p.MemoryCostKib)
+	}
+	if p.Parallelism < 1 || p.Parallelism > 16 {
+		return fmt.Errorf("Argon2 parallelism must be between 1 and 16, got %d", p.Parallelism)
+	}
+	return nil
+}
+
+// Orders of the password and the salt in the input of salted hashes.
+const (
+	SaltAndPassword = "SALT_AND_PASSWORD"
+	PasswordAndSalt = "PASSWORD_AND_SALT"
+)
+
+// requiresSignerKey reports whether the hash algorithm needs a signer key.
+func requiresSignerKey(algorithm string) bool {
+	return strings.HasPrefix(algorithm, "HMAC_") || algorithm == "SCRYPT"
 }
 
 // UploadError is the error object for partial upload failure.
-type UploadError []*struct {
+type UploadError []*UploadFailure
+
+// UploadFailure describes the failure to upload one account.
+type UploadFailure struct {
 	// Index indicates the index of the failed account.
 	Index int `json:"index,omitempty"`
 	// Message is the uploading error message for the failed account.
 	Message string `json:"message,omitempty"`
+	// User is the failed account. It is set by the Client upload methods, but
+	// not by APIClient.UploadAccount.
+	User *User `json:"-"`
+}
+
+// UploadErrorReason classifies the message of an UploadFailure.
+type UploadErrorReason string
+
+// Reasons of upload failures.
+const (
+	UploadErrorUnknown          UploadErrorReason = "UNKNOWN"
+	UploadErrorDuplicateEmail   UploadErrorReason = "DUPLICATE_EMAIL"
+	UploadErrorDuplicateLocalID UploadErrorReason = "DUPLICATE_LOCAL_ID"
+	UploadErrorInvalidEmail     UploadErrorReason = "INVALID_EMAIL"
+	UploadErrorInvalidHash      UploadErrorReason = "INVALID_PASSWORD_HASH"
+	UploadErrorTransient        UploadErrorReason = "TRANSIENT"
+	UploadErrorEmailPolicy      UploadErrorReason = "EMAIL_POLICY"
+)
+
+// uploadErrorPatterns maps the substrings of the upload error messages to
+// their reasons. The messages are matched case-insensitively and in order.
+var uploadErrorPatterns = []struct {
+	substr string
+	reason UploadErrorReason
+}{
+	{"email_policy", UploadErrorEmailPolicy},
+	{"email exists", UploadErrorDuplicateEmail},
+	{"duplicate_email", UploadErrorDuplicateEmail},
+	{"email_exists", UploadErrorDuplicateEmail},
+	{"localid exists", UploadErrorDuplicateLocalID},
+	{"duplicate_local_id", UploadErrorDuplicateLocalID},
+	{"invalid email", UploadErrorInvalidEmail},
+	{"invalid_email", UploadErrorInvalidEmail},
+	{"malformed email", UploadErrorInvalidEmail},
+	{"password hash", UploadErrorInvalidHash},
+	{"invalid_password_hash", UploadErrorInvalidHash},
+	{"invalid hash", UploadErrorInvalidHash},
+	{"salt", UploadErrorInvalidHash},
+	{"internal error", UploadErrorTransient},
+	{"internal_error", UploadErrorTransient},
+	{"backend error", UploadErrorTransient},
+	{"backend_error", UploadErrorTransient},
+	{"deadline exceeded", UploadErrorTransient},
+	{"timeout", UploadErrorTransient},
+	{"unavailable", UploadErrorTransient},
+	{"try again", UploadErrorTransient},
+}
+
+// Reason classifies the failure from its message.
+func (f *UploadFailure) Reason() UploadErrorReason {
+	msg := strings.ToLower(f.Message)
+	for _, p := range uploadErrorPatterns {
+		if strings.Contains(msg, p.substr) {
+			return p.reason
+		}
+	}
+	return UploadErrorUnknown
+}
+
+// IsRetryable reports whether uploading the account again may succeed without
+// changing it.
+func (f *UploadFailure) IsRetryable() bool {
+	return f.Reason().IsRetryable()
+}
+
+// IsRetryable reports whether the failures of the reason are transient.
+func (r UploadErrorReason) IsRetryable() bool {
+	return r == UploadErrorTransient
 }
 
 // Error implements error interface.
@@ -298,26 +601,119 @@ func (e UploadError) Error() string {
 	return b.String()
 }
 
+// ByReason groups the failures by reason.
+func (e UploadError) ByReason() map[UploadErrorReason]UploadError {
+	m := make(map[UploadErrorReason]UploadError)
+	for _, v := range e {
+		r := v.Reason()
+		m[r] = append(m[r], v)
+	}
+	return m
+}
+
+// Summary describes the failures in one line for human logs, e.g.,
+// "3 users failed to upload: 2 DUPLICATE_EMAIL, 1 INVALID_EMAIL".
+func (e UploadError) Summary() string {
+	var counts reasonCounts
+	for r, failures := range e.ByReason() {
+		counts = append(counts, reasonCount{r, len(failures)})
+	}
+	sort.Sort(counts)
+	var b bytes.Buffer
+	if len(e) == 1 {
+		b.WriteString("1 user failed to upload")
+	} else {
+		fmt.Fprintf(&b, "%d users failed to upload", len(e))
+	}
+	for i, c := range counts {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%d %s", c.n, c.reason)
+	}
+	return b.String()
+}
+
+type reasonCount struct {
+	reason UploadErrorReason
+	n      int
+}
+
+// reasonCounts sorts the most frequent reasons first.
+type reasonCounts []reasonCount
+
+func (s reasonCounts) Len() int      { return len(s) }
+func (s reasonCounts) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s reasonCounts) Less(i, j int) bool {
+	if s[i].n != s[j].n {
+		return s[i].n > s[j].n
+	}
+	return s[i].reason < s[j].reason
+}
+
+// Retryable returns the failures which may succeed when retried.
+func (e UploadError) Retryable() UploadError {
+	var r UploadError
+	for _, v := range e {
+		if v.IsRetryable() {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+// FailedUsers returns the users of the uploaded slice which failed to upload,
+// in their original order.
+func (e UploadError) FailedUsers(users []*User) []*User {
+	failed := make(map[int]bool)
+	for _, v := range e {
+		failed[v.Index] = true
+	}
+	var r []*User
+	for i, u := range users {
+		if failed[i] {
+			r = append(r, u)
+		}
+	}
+	return r
+}
+
 // UploadAccountResponse contains the error information if some accounts are
 // failed to upload.
 type UploadAccountResponse struct {
 	Error UploadError `json:"error,omitempty"`
 }
 
+// FailedUsers returns the users of the uploaded slice which failed to upload,
+// in their original order.
+func (r *UploadAccountResponse) FailedUsers(users []*User) []*User {
+	return r.Error.FailedUsers(users)
+}
+
 // UploadAccount uploads accounts to identitytoolkit service.
-func (c *APIClient) UploadAccount(req *UploadAccountRequest) (*UploadAccountResponse, error) {
+func (c *APIClient) UploadAccount(ctx context.Context, req *UploadAccountRequest) (*UploadAccountResponse, error) {
 	if len(req.Users) == 0 {
 		return nil, fmt.Errorf("UploadAccount: must provide at lease one account")
 	}
 	if req.HashAlgorithm == "" {
 		return nil, fmt.Errorf("UploadAccount: must provide the hash algorithm")
 	}
-	if len(req.SignerKey) == 0 {
+	if len(req.SignerKey) == 0 && requiresSignerKey(req.HashAlgorithm) {
 		return nil, fmt.Errorf("UploadAccount: must provide the signer key")
 	}
+	if req.HashAlgorithm == "ARGON2" {
+		if req.Argon2Parameters == nil {
+			return nil, fmt.Errorf("UploadAccount: must provide the Argon2 parameters")
+		}
+		if err := req.Argon2Parameters.validate(); err != nil {
+			return nil, fmt.Errorf("UploadAccount: %v", err)
+		}
+	}
 
 	resp := &UploadAccountResponse{}
-	if err := c.request(POST, uploadAccount, req, resp); err != nil {
+	if err := c.request(ctx, POST, uploadAccount, req, resp); err != nil {
 		return nil, err
 	}
 	return resp, nil
@@ -340,9 +736,9 @@ type DownloadAccountResponse struct {
 }
 
 // DownloadAccount donwloads accounts from identitytoolkit service.
-func (c *APIClient) DownloadAccount(req *DownloadAccountRequest) (*DownloadAccountResponse, error) {
+func (c *APIClient) DownloadAccount(ctx context.Context, req *DownloadAccountRequest) (*DownloadAccountResponse, error) {
 	resp := &DownloadAccountResponse{}
-	if err := c.request(POST, downloadAccount, req, resp); err != nil {
+	if err := c.request(ctx, POST, downloadAccount, req, resp); err != nil {
 		return nil, err
 	}
 	return resp, nil
@@ -361,7 +757,8 @@ const (
 // There are three kinds of OOB code:
 //
 // 1. OOB code for password recovery. The RequestType should be PASSWORD_RESET
-// and Email, CAPTCHAChallenge and CAPTCHAResponse are required.
+// and Email, CAPTCHAChallenge and CAPTCHAResponse are required, unless
+// CAPTCHAVerified is set.
 //
 // 2. OOB code for email change. The RequestType should be NEW_EMAIL_ACCEPT and
 // Email, newEmail and Token are required.
@@ -376,6 +773,10 @@ type GetOOBCodeRequest struct {
 	NewEmail         string `json:"newEmail,omitempty"`
 	Token            string `json:"idToken,omitempty"`
 	UserIP           string `json:"userIp,omitempty"`
+	// CAPTCHAVerified indicates that the CAPTCHA of a password recovery
+	// request was verified by the caller, e.g., by a CaptchaProvider, so that
+	// CAPTCHAResponse is not required.
+	CAPTCHAVerified bool `json:"-"`
 }
 
 // GetOOBCodeResponse contains the OOB code upon success.
@@ -384,14 +785,14 @@ type GetOOBCodeResponse struct {
 }
 
 // GetOOBCode retrieves an OOB code.
-func (c *APIClient) GetOOBCode(req *GetOOBCodeRequest) (*GetOOBCodeResponse, error) {
+func (c *APIClient) GetOOBCode(ctx context.Context, req *GetOOBCodeRequest) (*GetOOBCodeResponse, error) {
 	switch req.RequestType {
 
 	case ResetPasswordRequestType:
 		if req.Email == "" {
 			return nil, fmt.Errorf("GetOOBCode: must provide an email")
 		}
-		if req.CAPTCHAResponse == "" {
+		if req.CAPTCHAResponse == "" && !req.CAPTCHAVerified {
 			return nil, fmt.Errorf("GetOOBCode: must provide CAPTCHA response")
 		}
 
@@ -416,25 +817,300 @@ func (c *APIClient) GetOOBCode(req *GetOOBCodeRequest) (*GetOOBCodeResponse, err
 	}
 
 	resp := &GetOOBCodeResponse{}
-	if err := c.request(POST, getOOBCode, req, resp); err != nil {
+	if err := c.request(ctx, POST, getOOBCode, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ResetPasswordRequest contains the OOB code of a reset password email and the
+// new password of the user.
+type ResetPasswordRequest struct {
+	OOBCode     string `json:"oobCode,omitempty"`
+	NewPassword string `json:"newPassword,omitempty"`
+}
+
+// ResetPasswordResponse contains the email address of the user whose password
+// is reset.
+type ResetPasswordResponse struct {
+	Email       string `json:"email,omitempty"`
+	RequestType string `json:"requestType,omitempty"`
+}
+
+// ResetPassword sets the password of the user the OOB code was generated for.
+func (c *APIClient) ResetPassword(ctx context.Context, req *ResetPasswordRequest) (*ResetPasswordResponse, error) {
+	if req.OOBCode == "" {
+		return nil, fmt.Errorf("ResetPassword: must provide the OOB code")
+	}
+	if req.NewPassword == "" {
+		return nil, fmt.Errorf("ResetPassword: must provide the new password")
+	}
+	resp := &ResetPasswordResponse{}
+	if err := c.request(ctx, POST, resetPassword, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// VerifyPasswordRequest contains the email address and password of a user
+// signing in. CAPTCHAChallenge and CAPTCHAResponse are required once
+// identitytoolkit asks for a CAPTCHA after repeated failures.
+// PendingIDToken, if set, is the ID token of the account the password account
+// is linked to.
+type VerifyPasswordRequest struct {
+	Email            string `json:"email,omitempty"`
+	Password         string `json:"password,omitempty"`
+	PendingIDToken   string `json:"pendingIdToken,omitempty"`
+	CAPTCHAChallenge string `json:"captchaChallenge,omitempty"`
+	CAPTCHAResponse  string `json:"captchaResponse,omitempty"`
+}
+
+// VerifyPasswordResponse contains the ID token and the information of the user
+// signed in.
+type VerifyPasswordResponse struct {
+	IDToken     string `json:"idToken,omitempty"`
+	LocalID     string `json:"localId,omitempty"`
+	Email       string `json:"email,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	PhotoURL    string `json:"photoUrl,omitempty"`
+	Registered  bool   `json:"registered,omitempty"`
+}
+
+// VerifyPassword checks the password of the user and signs the user in.
+func (c *APIClient) VerifyPassword(ctx context.Context, req *VerifyPasswordRequest) (*VerifyPasswordResponse, error) {
+	if req.Email == "" {
+		return nil, fmt.Errorf("VerifyPassword: must provide an email")
+	}
+	if req.Password == "" {
+		return nil, fmt.Errorf("VerifyPassword: must provide the password")
+	}
+	resp := &VerifyPasswordResponse{}
+	if err := c.request(ctx, POST, verifyPassword, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// SignupNewUserRequest contains the email address, password and display name
+// of a new password account.
+type SignupNewUserRequest struct {
+	Email            string `json:"email,omitempty"`
+	Password         string `json:"password,omitempty"`
+	DisplayName      string `json:"displayName,omitempty"`
+	CAPTCHAChallenge string `json:"captchaChallenge,omitempty"`
+	CAPTCHAResponse  string `json:"captchaResponse,omitempty"`
+}
+
+// SignupNewUserResponse contains the local ID of the new user and an ID token
+// signing the user in.
+type SignupNewUserResponse struct {
+	LocalID     string `json:"localId,omitempty"`
+	Email       string `json:"email,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	IDToken     string `json:"idToken,omitempty"`
+}
+
+// SignupNewUser creates a password account.
+func (c *APIClient) SignupNewUser(ctx context.Context, req *SignupNewUserRequest) (*SignupNewUserResponse, error) {
+	if req.Email == "" {
+		return nil, fmt.Errorf("SignupNewUser: must provide an email")
+	}
+	if req.Password == "" {
+		return nil, fmt.Errorf("SignupNewUser: must provide the password")
+	}
+	resp := &SignupNewUserResponse{}
+	if err := c.request(ctx, POST, signupNewUser, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CreateAuthURIRequest selects the IDP a user signs in with, or the email
+// address whose IDP is looked up. ContinueURI is the URL the IDP redirects
+// the user to after the sign in.
+type CreateAuthURIRequest struct {
+	ProviderID      string            `json:"providerId,omitempty"`
+	Identifier      string            `json:"identifier,omitempty"`
+	ContinueURI     string            `json:"continueUri,omitempty"`
+	OAuthScope      string            `json:"oauthScope,omitempty"`
+	OpenIDRealm     string            `json:"openidRealm,omitempty"`
+	Context         string            `json:"context,omitempty"`
+	CustomParameter map[string]string `json:"customParameter,omitempty"`
+}
+
+// CreateAuthURIResponse contains the authorization URL of the IDP and the
+// session ID to pass to VerifyAssertion. For an Identifier, Registered tells
+// whether an account exists for the email address and AllProviders lists the
+// providers it can sign in with.
+type CreateAuthURIResponse struct {
+	AuthURI             string   `json:"authUri,omitempty"`
+	ProviderID          string   `json:"providerId,omitempty"`
+	SessionID           string   `json:"sessionId,omitempty"`
+	Registered          bool     `json:"registered,omitempty"`
+	ForExistingProvider bool     `json:"forExistingProvider,omitempty"`
+	AllProviders        []string `json:"allProviders,omitempty"`
+	CAPTCHARequired     bool     `json:"captchaRequired,omitempty"`
+}
+
+// CreateAuthURI creates the URL redirecting a user to the IDP to sign in.
+func (c *APIClient) CreateAuthURI(ctx context.Context, req *CreateAuthURIRequest) (*CreateAuthURIResponse, error) {
+	if req.ProviderID == "" && req.Identifier == "" {
+		return nil, fmt.Errorf("CreateAuthURI: must provide a provider ID or an identifier")
+	}
+	if req.ContinueURI == "" {
+		return nil, fmt.Errorf("CreateAuthURI: must provide the continue URI")
+	}
+	resp := &CreateAuthURIResponse{}
+	if err := c.request(ctx, POST, createAuthURI, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// VerifyAssertionRequest contains the response of an IDP to a federated sign
+// in. RequestURI is the URL the IDP redirected the user to, and PostBody the
+// URL encoded parameters it passed, either in the query or the body.
+// PendingIDToken, if set, is the ID token of the account the IDP account is
+// linked to. SessionID is the one returned by CreateAuthURI, if the sign in
+// started there.
+type VerifyAssertionRequest struct {
+	RequestURI          string `json:"requestUri,omitempty"`
+	PostBody            string `json:"postBody,omitempty"`
+	PendingIDToken      string `json:"pendingIdToken,omitempty"`
+	ReturnIDPCredential bool   `json:"returnIdpCredential,omitempty"`
+	SessionID           string `json:"sessionId,omitempty"`
+}
+
+// VerifyAssertionResponse contains the ID token and the information of the
+// user signed in with the IDP. If NeedConfirmation is set, an account with
+// the email address already exists for another provider and the user must
+// sign in with it first to link the accounts; no ID token is issued then.
+type VerifyAssertionResponse struct {
+	IDToken          string `json:"idToken,omitempty"`
+	LocalID          string `json:"localId,omitempty"`
+	ProviderID       string `json:"providerId,omitempty"`
+	FederatedID      string `json:"federatedId,omitempty"`
+	Email            string `json:"email,omitempty"`
+	EmailVerified    bool   `json:"emailVerified,omitempty"`
+	DisplayName      string `json:"displayName,omitempty"`
+	FirstName        string `json:"firstName,omitempty"`
+	LastName         string `json:"lastName,omitempty"`
+	PhotoURL         string `json:"photoUrl,omitempty"`
+	ScreenName       string `json:"screenName,omitempty"`
+	RawUserInfo      string `json:"rawUserInfo,omitempty"`
+	OAuthAccessToken string `json:"oauthAccessToken,omitempty"`
+	OAuthIDToken     string `json:"oauthIdToken,omitempty"`
+	OAuthExpireIn    int64  `json:"oauthExpireIn,omitempty"`
+	NeedConfirmation bool   `json:"needConfirmation,omitempty"`
+	IsNewUser        bool   `json:"isNewUser,omitempty"`
+	ErrorMessage     string `json:"errorMessage,omitempty"`
+	// Profile is the profile of the user set by Client.VerifyAssertion if
+	// Config.EnrichProfiles is set.
+	Profile *Profile `json:"-"`
+}
+
+// VerifyAssertion verifies the IDP response of a federated sign in and signs
+// the user in.
+func (c *APIClient) VerifyAssertion(ctx context.Context, req *VerifyAssertionRequest) (*VerifyAssertionResponse, error) {
+	if req.RequestURI == "" {
+		return nil, fmt.Errorf("VerifyAssertion: must provide the request URI")
+	}
+	resp := &VerifyAssertionResponse{}
+	if err := c.request(ctx, POST, verifyAssertion, req, resp); err != nil {
 		return nil, err
 	}
 	return resp, nil
 }
 
+// EmailTemplate is the template of the emails identitytoolkit sends on behalf
+// of the project.
+type EmailTemplate struct {
+	Body            string `json:"body,omitempty"`
+	Format          string `json:"format,omitempty"`
+	From            string `json:"from,omitempty"`
+	FromDisplayName string `json:"fromDisplayName,omitempty"`
+	ReplyTo         string `json:"replyTo,omitempty"`
+	Subject         string `json:"subject,omitempty"`
+}
+
+// GetProjectConfigRequest selects the project whose configuration is
+// retrieved. The zero value selects the project of the credentials.
+type GetProjectConfigRequest struct {
+	ProjectNumber          string
+	DelegatedProjectNumber string
+}
+
 // GetProjectConfigResponse contains the project ID, API key, whether password login is
 // enabled and a list of IDP configs.
 type GetProjectConfigResponse struct {
-	ProjectID         string       `json:"projectId,omitempty"`
-	APIKey            string       `json:"apiKey,omitempty"`
-	AllowPasswordUser bool         `json:"allowPasswordUser,omitempty"`
-	IdpConfigs        []*IdpConfig `json:"idpConfig,omitempty"`
+	ProjectID             string         `json:"projectId,omitempty"`
+	APIKey                string         `json:"apiKey,omitempty"`
+	AllowPasswordUser     bool           `json:"allowPasswordUser,omitempty"`
+	IdpConfigs            []*IdpConfig   `json:"idpConfig,omitempty"`
+	AuthorizedDomains     []string       `json:"authorizedDomains,omitempty"`
+	EnableAnonymousUser   bool           `json:"enableAnonymousUser,omitempty"`
+	UseEmailSending       bool           `json:"useEmailSending,omitempty"`
+	ResetPasswordTemplate *EmailTemplate `json:"resetPasswordTemplate,omitempty"`
+	ChangeEmailTemplate   *EmailTemplate `json:"changeEmailTemplate,omitempty"`
+	VerifyEmailTemplate   *EmailTemplate `json:"verifyEmailTemplate,omitempty"`
 }
 
 // GetProjectConfig retrieves the configuration information for the project.
-func (c *APIClient) GetProjectConfig() (*GetProjectConfigResponse, error) {
+func (c *APIClient) GetProjectConfig(ctx context.Context) (*GetProjectConfigResponse, error) {
+	return c.GetProjectConfigWithRequest(ctx, nil)
+}
+
+// GetProjectConfigWithRequest retrieves the configuration information for the
+// project selected by req, if not nil.
+func (c *APIClient) GetProjectConfigWithRequest(ctx context.Context, req *GetProjectConfigRequest) (*GetProjectConfigResponse, error) {
+	q := url.Values{}
+	if req != nil {
+		if req.ProjectNumber != "" {
+			q.Set("projectNumber", req.ProjectNumber)
+		}
+		if req.DelegatedProjectNumber != "" {
+			q.Set("delegatedProjectNumber", req.DelegatedProjectNumber)
+		}
+	}
 	resp := &GetProjectConfigResponse{}
-	if err := c.request(GET, getProjectConfig, nil, resp); err != nil {
+	if err := c.requestQuery(ctx, GET, getProjectConfig, q, nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// SetProjectConfigRequest contains the changes to the configuration of the
+// project. The fields which are nil or empty are left unchanged, so the
+// booleans are pointers to be able to turn the settings off.
+type SetProjectConfigRequest struct {
+	// DelegatedProjectNumber selects the project delegating its
+	// authentication to the project of the credentials, if not empty.
+	DelegatedProjectNumber string `json:"delegatedProjectNumber,omitempty"`
+	AllowPasswordUser      *bool  `json:"allowPasswordUser,omitempty"`
+	EnableAnonymousUser    *bool  `json:"enableAnonymousUser,omitempty"`
+	// IdpConfigs replace the configurations of the identity providers. The
+	// providers missing from the list are disabled.
+	IdpConfigs []*IdpConfig `json:"idpConfig,omitempty"`
+	// AuthorizedDomains replace the domains the widget may be served from.
+	AuthorizedDomains     []string       `json:"authorizedDomains,omitempty"`
+	UseEmailSending       *bool          `json:"useEmailSending,omitempty"`
+	ResetPasswordTemplate *EmailTemplate `json:"resetPasswordTemplate,omitempty"`
+	ChangeEmailTemplate   *EmailTemplate `json:"changeEmailTemplate,omitempty"`
+	VerifyEmailTemplate   *EmailTemplate `json:"verifyEmailTemplate,omitempty"`
+}
+
+// SetProjectConfigResponse contains the ID of the updated project.
+type SetProjectConfigResponse struct {
+	ProjectID string `json:"projectId,omitempty"`
+}
+
+// SetProjectConfig updates the configuration of the project, e.g., the
+// identity providers, the authorized domains or the templates of the emails of
+// the out-of-band actions. Infrastructure-as-code tooling can read the current
+// configuration with GetProjectConfig and apply the differences.
+func (c *APIClient) SetProjectConfig(ctx context.Context, req *SetProjectConfigRequest) (*SetProjectConfigResponse, error) {
+	resp := &SetProjectConfigResponse{}
+	if err := c.request(ctx, POST, setProjectConfig, req, resp); err != nil {
 		return nil, err
 	}
 	return resp, nil
@@ -17,9 +17,14 @@ package gitkit
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"reflect"
 	"testing"
+
+	"golang.org/x/net/context"
 )
 
 func TestBytes(t *testing.T) {
@@ -62,7 +67,7 @@ func prepareClient(err bool, respBody string) *APIClient {
 	} else {
 		statusCode = 200
 	}
-	return &APIClient{http.Client{Transport: &roundTripper{statusCode, respBody}}}
+	return &APIClient{Client: http.Client{Transport: &roundTripper{statusCode, respBody}}}
 }
 
 func TestGetAccountInfo(t *testing.T) {
@@ -94,10 +99,23 @@ func TestGetAccountInfo(t *testing.T) {
 			`{"users": [{"localId": "12345", "email": "user@example.com", "emailVerified": true}]}`,
 			&GetAccountInfoResponse{[]*User{{LocalID: "12345", Email: "user@example.com", EmailVerified: true}}},
 		},
+		{
+			"provider_user_info",
+			&GetAccountInfoRequest{LocalIDs: []string{"12345"}},
+			false,
+			`{"users": [{"localId": "12345", "providerUserInfo": [{"providerId": "twitter.com", "federatedId": "http://twitter.com/42", "rawId": "42", "email": "user@example.com", "screenName": "user"}]}]}`,
+			&GetAccountInfoResponse{[]*User{{LocalID: "12345", ProviderUserInfo: []ProviderUserInfo{{
+				ProviderID:  "twitter.com",
+				FederatedID: "http://twitter.com/42",
+				RawID:       "42",
+				Email:       "user@example.com",
+				ScreenName:  "user",
+			}}}}},
+		},
 	}
 	for _, gt := range getAccountTests {
 		c := prepareClient(gt.err, gt.json)
-		resp, err := c.GetAccountInfo(gt.req)
+		resp, err := c.GetAccountInfo(context.Background(), gt.req)
 		if gt.err && err == nil {
 			t.Errorf("%s: GetAccountInfo() = %v, nil; want nil, err", gt.name, resp)
 		}
@@ -139,7 +157,7 @@ func TestSetAccountInfo(t *testing.T) {
 	}
 	for _, st := range setAccountTests {
 		c := prepareClient(st.err, st.json)
-		resp, err := c.SetAccountInfo(st.req)
+		resp, err := c.SetAccountInfo(context.Background(), st.req)
 		if st.err && err == nil {
 			t.Errorf("%s: SetAccountInfo() = %v, nil; want nil, err", st.name, resp)
 		}
@@ -177,7 +195,7 @@ func TestDeleteAccount(t *testing.T) {
 	}
 	for _, dt := range deleteAccountTests {
 		c := prepareClient(dt.err, dt.json)
-		resp, err := c.DeleteAccount(dt.req)
+		resp, err := c.DeleteAccount(context.Background(), dt.req)
 		if dt.err && err == nil {
 			t.Errorf("%s: DeleteAccountInfo() = %v, nil; want nil, err", dt.name, resp)
 		}
@@ -216,6 +234,47 @@ func TestUploadAccount(t *testing.T) {
 			"",
 			nil,
 		},
+		{
+			"no_key_needed",
+			&UploadAccountRequest{
+				Users:             []*User{{LocalID: "12345"}},
+				HashAlgorithm:     "MD5",
+				Rounds:            2,
+				PasswordHashOrder: SaltAndPassword,
+			},
+			false,
+			"{}",
+			&UploadAccountResponse{},
+		},
+		{
+			"no_argon2_parameters",
+			&UploadAccountRequest{Users: []*User{{LocalID: "12345"}}, HashAlgorithm: "ARGON2"},
+			true,
+			"",
+			nil,
+		},
+		{
+			"invalid_argon2_parameters",
+			&UploadAccountRequest{
+				Users:            []*User{{LocalID: "12345"}},
+				HashAlgorithm:    "ARGON2",
+				Argon2Parameters: &Argon2Parameters{HashType: Argon2ID, HashLengthBytes: 32, Iterations: 3, MemoryCostKib: 65536, Parallelism: 4},
+			},
+			true,
+			"",
+			nil,
+		},
+		{
+			"argon2",
+			&UploadAccountRequest{
+				Users:            []*User{{LocalID: "12345"}},
+				HashAlgorithm:    "ARGON2",
+				Argon2Parameters: &Argon2Parameters{HashType: Argon2ID, HashLengthBytes: 32, Iterations: 3, MemoryCostKib: 4096, Parallelism: 1},
+			},
+			false,
+			"{}",
+			&UploadAccountResponse{},
+		},
 		{
 			"api_error",
 			&UploadAccountRequest{
@@ -247,12 +306,12 @@ func TestUploadAccount(t *testing.T) {
 			},
 			false,
 			`{"error": [{"index": 0, "message": "upload error"}]}`,
-			&UploadAccountResponse{UploadError{{0, "upload error"}}},
+			&UploadAccountResponse{UploadError{{Index: 0, Message: "upload error"}}},
 		},
 	}
 	for _, ut := range uploadAccountTests {
 		c := prepareClient(ut.err, ut.json)
-		resp, err := c.UploadAccount(ut.req)
+		resp, err := c.UploadAccount(context.Background(), ut.req)
 		if ut.err && err == nil {
 			t.Errorf("%s: UploadAccount() = %v, nil; want nil, err", ut.name, resp)
 		}
@@ -299,10 +358,17 @@ func TestDownloadAccount(t *testing.T) {
 			`{"users": [{"localId": "456"}, {"localId": "789"}]}`,
 			&DownloadAccountResponse{[]*User{{LocalID: "456"}, {LocalID: "789"}}, ""},
 		},
+		{
+			"provider_user_info",
+			&DownloadAccountRequest{5, ""},
+			false,
+			`{"users": [{"localId": "123", "providerUserInfo": [{"providerId": "google.com", "rawId": "1001", "email": "user@gmail.com"}]}]}`,
+			&DownloadAccountResponse{[]*User{{LocalID: "123", ProviderUserInfo: []ProviderUserInfo{{ProviderID: "google.com", RawID: "1001", Email: "user@gmail.com"}}}}, ""},
+		},
 	}
 	for _, dt := range downloadAccountTests {
 		c := prepareClient(dt.err, dt.json)
-		resp, err := c.DownloadAccount(dt.req)
+		resp, err := c.DownloadAccount(context.Background(), dt.req)
 		if dt.err && err == nil {
 			t.Errorf("%s: DownloadAccount() = %v, nil; want nil, err", dt.name, resp)
 		}
@@ -439,7 +505,7 @@ func TestGetOOBCode(t *testing.T) {
 	}
 	for _, gt := range getOOBCodeTestss {
 		c := prepareClient(gt.err, gt.json)
-		resp, err := c.GetOOBCode(gt.req)
+		resp, err := c.GetOOBCode(context.Background(), gt.req)
 		if gt.err && err == nil {
 			t.Errorf("%s: GetOOBConfirmationCode() = %v, nil; want nil, err", gt.name, resp)
 		}
@@ -471,7 +537,7 @@ func TestGetProjectConfig(t *testing.T) {
 	}
 	for _, gt := range getConfigTests {
 		c := prepareClient(gt.err, gt.json)
-		resp, err := c.GetProjectConfig()
+		resp, err := c.GetProjectConfig(context.Background())
 		if gt.err && err == nil {
 			t.Errorf("%s: GetProjectConfig() = %v, nil; want nil, err", gt.name, resp)
 		}
@@ -481,3 +547,159 @@ func TestGetProjectConfig(t *testing.T) {
 	}
 
 }
+
+func TestSetProjectConfig(t *testing.T) {
+	rt := &recordingRoundTripper{roundTripper: roundTripper{200, `{"projectId": "project_id"}`}}
+	c := &APIClient{Client: http.Client{Transport: rt}}
+	off := false
+	req := &SetProjectConfigRequest{
+		AllowPasswordUser: &off,
+		IdpConfigs: []*IdpConfig{
+			{Provider: "GOOGLE", Enabled: true, ClientID: "client_id", Secret: "secret"},
+			{Provider: "FACEBOOK", ClientID: "app_id"},
+		},
+		AuthorizedDomains: []string{"example.com"},
+	}
+	resp, err := c.SetProjectConfig(context.Background(), req)
+	if err != nil {
+		t.Fatalf("SetProjectConfig() returns error: %v", err)
+	}
+	if resp.ProjectID != "project_id" {
+		t.Errorf("SetProjectConfig().ProjectID = %q; want %q", resp.ProjectID, "project_id")
+	}
+	b, _ := ioutil.ReadAll(rt.reqs[0].Body)
+	want := `{"allowPasswordUser":false,"idpConfig":[{"provider":"GOOGLE","enabled":true,"clientId":"client_id","secret":"secret"},{"provider":"FACEBOOK","clientId":"app_id"}],"authorizedDomains":["example.com"]}`
+	if string(b) != want {
+		t.Errorf("SetProjectConfig() sends %s; want %s", b, want)
+	}
+
+	c = prepareClient(true, `{"error": {"code": 403, "errors": [{"reason": "forbidden"}]}}`)
+	if _, err := c.SetProjectConfig(context.Background(), req); err == nil {
+		t.Error("SetProjectConfig() returns no error on failure")
+	}
+}
+
+func TestUploadFailureReason(t *testing.T) {
+	tests := []struct {
+		message   string
+		reason    UploadErrorReason
+		retryable bool
+	}{
+		{"email exists in other account in the request", UploadErrorDuplicateEmail, false},
+		{"DUPLICATE_EMAIL", UploadErrorDuplicateEmail, false},
+		{"localId exists in other account in the request", UploadErrorDuplicateLocalID, false},
+		{"Invalid email: foo", UploadErrorInvalidEmail, false},
+		{"password hash is too long", UploadErrorInvalidHash, false},
+		{"Internal error encountered.", UploadErrorTransient, true},
+		{"BACKEND_ERROR", UploadErrorTransient, true},
+		{"something else", UploadErrorUnknown, false},
+	}
+	for _, tt := range tests {
+		f := &UploadFailure{Message: tt.message}
+		if got := f.Reason(); got != tt.reason {
+			t.Errorf("Reason() for %q = %s; want %s", tt.message, got, tt.reason)
+		}
+		if got := f.IsRetryable(); got != tt.retryable {
+			t.Errorf("IsRetryable() for %q = %v; want %v", tt.message, got, tt.retryable)
+		}
+	}
+
+	e := UploadError{{Index: 0, Message: "invalid email"}, {Index: 2, Message: "backend error"}}
+	if r := e.Retryable(); len(r) != 1 || r[0].Index != 2 {
+		t.Errorf("Retryable() = %v; want the failure of index 2", r)
+	}
+}
+
+func TestUploadErrorSummary(t *testing.T) {
+	tests := []struct {
+		e    UploadError
+		want string
+	}{
+		{UploadError{{Index: 0, Message: "email exists"}}, "1 user failed to upload: 1 DUPLICATE_EMAIL"},
+		{
+			UploadError{
+				{Index: 0, Message: "invalid email"},
+				{Index: 1, Message: "backend error"},
+				{Index: 2, Message: "email exists"},
+				{Index: 3, Message: "internal error"},
+			},
+			"4 users failed to upload: 2 TRANSIENT, 1 DUPLICATE_EMAIL, 1 INVALID_EMAIL",
+		},
+		{nil, "0 users failed to upload"},
+	}
+	for i, tt := range tests {
+		if got := tt.e.Summary(); got != tt.want {
+			t.Errorf("%d. Summary() = %q; want %q", i, got, tt.want)
+		}
+	}
+}
+
+// benchRoundTripper consumes the request body and responds with body.
+type benchRoundTripper struct {
+	body []byte
+}
+
+func (r benchRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		io.Copy(ioutil.Discard, req.Body)
+		req.Body.Close()
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(bytes.NewReader(r.body)),
+		Request:    req,
+	}, nil
+}
+
+func benchUsers(n int) []*User {
+	users := make([]*User, n)
+	for i := range users {
+		users[i] = &User{
+			LocalID:      fmt.Sprintf("%020d", i),
+			Email:        fmt.Sprintf("user%d@example.com", i),
+			DisplayName:  "Benchmark User",
+			PasswordHash: Bytes("0123456789abcdef0123456789abcdef"),
+			Salt:         Bytes("salt"),
+		}
+	}
+	return users
+}
+
+func BenchmarkUploadAccount(b *testing.B) {
+	c := &APIClient{Client: http.Client{Transport: benchRoundTripper{[]byte(`{"kind": "identitytoolkit#UploadAccountResponse"}`)}}}
+	req := &UploadAccountRequest{Users: benchUsers(100), HashAlgorithm: "HMAC_SHA256", SignerKey: Bytes("key")}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.UploadAccount(context.Background(), req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDownloadAccount(b *testing.B) {
+	body, _ := json.Marshal(&DownloadAccountResponse{Users: benchUsers(100), NextPageToken: "next"})
+	c := &APIClient{Client: http.Client{Transport: benchRoundTripper{body}}}
+	req := &DownloadAccountRequest{MaxResults: 100}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.DownloadAccount(context.Background(), req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetAccountInfo(b *testing.B) {
+	body, _ := json.Marshal(&GetAccountInfoResponse{Users: benchUsers(1)})
+	c := &APIClient{Client: http.Client{Transport: benchRoundTripper{body}}}
+	req := &GetAccountInfoRequest{LocalIDs: []string{"00000000000000000000"}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.GetAccountInfo(context.Background(), req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// Versions of the identitytoolkit API selectable by Config.APIVersion.
+const (
+	// APIv3 is the relyingparty API the client has been built on.
+	APIv3 = "v3"
+	// APIv1 is the Identity Platform accounts API, e.g., accounts:lookup in
+	// place of getAccountInfo.
+	APIv1 = "v1"
+)
+
+// IdentityPlatformV1BaseURL is the base URL of the Identity Platform v1 API.
+const IdentityPlatformV1BaseURL = "https://identitytoolkit.googleapis.com/v1"
+
+// v1Methods are the v1 paths of the v3 methods, relative to
+// IdentityPlatformV1BaseURL. "%s" is replaced by the project ID.
+var v1Methods = map[apiMethod]string{
+	getAccountInfo:   "accounts:lookup",
+	setAccountInfo:   "accounts:update",
+	deleteAccount:    "accounts:delete",
+	uploadAccount:    "projects/%s/accounts:batchCreate",
+	downloadAccount:  "projects/%s/accounts:batchGet",
+	getOOBCode:       "accounts:sendOobCode",
+	resetPassword:    "accounts:resetPassword",
+	verifyPassword:   "accounts:signInWithPassword",
+	signupNewUser:    "accounts:signUp",
+	createAuthURI:    "accounts:createAuthUri",
+	verifyAssertion:  "accounts:signInWithIdp",
+	getProjectConfig: "projects",
+}
+
+// An UnsupportedMethodError is returned for the API methods that the selected
+// API version does not provide.
+type UnsupportedMethodError struct {
+	Method  string
+	Version string
+}
+
+func (e *UnsupportedMethodError) Error() string {
+	return fmt.Sprintf("gitkit: API method %s is not available in API %s", e.Method, e.Version)
+}
+
+// APIVersionMiddleware returns a TransportMiddleware sending the v3 requests
+// of APIClient to the given version of the API, e.g., for an APIClient created
+// without a Client. APIv3 leaves the requests alone.
+//
+// With APIv1, the requests are sent to the accounts methods of
+// IdentityPlatformV1BaseURL and adapted where the shapes differ:
+//   - the sign in methods ask for the ID token with returnSecureToken,
+//   - getOobConfirmationCode asks for the code with returnOobLink, and the
+//     NEW_EMAIL_ACCEPT request type is renamed VERIFY_AND_CHANGE_EMAIL,
+//   - downloadAccount is a GET request with the page in the query.
+//
+// uploadAccount and downloadAccount are project methods, which need the
+// projectID. setProjectConfig has no v1 equivalent and fails with an
+// UnsupportedMethodError. The responses are shaped alike.
+func APIVersionMiddleware(version, projectID string) TransportMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		if version == "" || version == APIv3 {
+			return next
+		}
+		return &apiVersionTransport{next, version, projectID}
+	}
+}
+
+// apiVersionTransport translates the v3 API requests to another version.
+type apiVersionTransport struct {
+	http.RoundTripper
+	version   string
+	projectID string
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *apiVersionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name, ok := apiMethodOf(req)
+	if !ok {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	m := apiMethod(name)
+	path, ok := v1Methods[m]
+	if !ok || t.version != APIv1 {
+		closeBody(req)
+		return nil, &UnsupportedMethodError{name, t.version}
+	}
+	if strings.Contains(path, "%s") {
+		if t.projectID == "" {
+			closeBody(req)
+			return nil, fmt.Errorf("gitkit: API method %s needs a project ID in API %s", name, t.version)
+		}
+		path = fmt.Sprintf(path, url.PathEscape(t.projectID))
+	}
+	target, err := url.Parse(IdentityPlatformV1BaseURL + "/" + path)
+	if err != nil {
+		closeBody(req)
+		return nil, err
+	}
+	target.RawQuery = req.URL.RawQuery
+	// The inner layers do not take the v1 request for a v3 method.
+	r := req.WithContext(context.WithValue(req.Context(), apiMethodKey{}, apiMethod("")))
+	r.URL = target
+	r.Host = ""
+	if req.Body != nil {
+		if err := adaptV1Request(r, m); err != nil {
+			return nil, err
+		}
+	}
+	return t.RoundTripper.RoundTrip(r)
+}
+
+// adaptV1Request rewrites the JSON body of the v3 request r of method m to
+// the v1 shape.
+func adaptV1Request(r *http.Request, m apiMethod) error {
+	b, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]json.RawMessage)
+	if len(bytes.TrimSpace(b)) > 0 {
+		if err := json.Unmarshal(b, &fields); err != nil {
+			return err
+		}
+	}
+	switch m {
+	case verifyPassword, signupNewUser, verifyAssertion:
+		fields["returnSecureToken"] = json.RawMessage("true")
+	case getOOBCode:
+		fields["returnOobLink"] = json.RawMessage("true")
+		var t string
+		if json.Unmarshal(fields["requestType"], &t) == nil && t == ChangeEmailRequestType {
+			fields["requestType"] = json.RawMessage(`"VERIFY_AND_CHANGE_EMAIL"`)
+		}
+	case downloadAccount:
+		// The page moves to the query of a GET request.
+		var page DownloadAccountRequest
+		if len(b) > 0 {
+			if err := json.Unmarshal(b, &page); err != nil {
+				return err
+			}
+		}
+		q := r.URL.Query()
+		if page.MaxResults > 0 {
+			q.Set("maxResults", strconv.Itoa(page.MaxResults))
+		}
+		if page.NextPageToken != "" {
+			q.Set("nextPageToken", page.NextPageToken)
+		}
+		r.URL.RawQuery = q.Encode()
+		r.Method = "GET"
+		r.Body, r.ContentLength = nil, 0
+		return nil
+	}
+	if b, err = json.Marshal(fields); err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	r.ContentLength = int64(len(b))
+	return nil
+}
+
+// closeBody closes the body of a request that is not sent, as the
+// http.RoundTripper contract requires.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestAPIVersionMiddleware(t *testing.T) {
+	const base = IdentityPlatformV1BaseURL + "/"
+	tests := []struct {
+		call       func(*APIClient) error
+		wantMethod string
+		wantURL    string
+		wantBody   string
+	}{
+		{
+			func(c *APIClient) error {
+				_, err := c.GetAccountInfo(context.Background(), &GetAccountInfoRequest{LocalIDs: []string{"123"}})
+				return err
+			},
+			"POST", base + "accounts:lookup", `{"localId":["123"]}`,
+		},
+		{
+			func(c *APIClient) error {
+				_, err := c.VerifyPassword(context.Background(), &VerifyPasswordRequest{Email: "a@example.com", Password: "p"})
+				return err
+			},
+			"POST", base + "accounts:signInWithPassword", `{"email":"a@example.com","password":"p","returnSecureToken":true}`,
+		},
+		{
+			func(c *APIClient) error {
+				_, err := c.GetOOBCode(context.Background(), &GetOOBCodeRequest{RequestType: ChangeEmailRequestType, Email: "a@example.com", NewEmail: "b@example.com", Token: "t"})
+				return err
+			},
+			"POST", base + "accounts:sendOobCode", `{"email":"a@example.com","idToken":"t","newEmail":"b@example.com","requestType":"VERIFY_AND_CHANGE_EMAIL","returnOobLink":true}`,
+		},
+		{
+			func(c *APIClient) error {
+				_, err := c.DownloadAccount(context.Background(), &DownloadAccountRequest{MaxResults: 10, NextPageToken: "next"})
+				return err
+			},
+			"GET", base + "projects/p1/accounts:batchGet?maxResults=10&nextPageToken=next", "",
+		},
+		{
+			func(c *APIClient) error {
+				_, err := c.UploadAccount(context.Background(), &UploadAccountRequest{Users: []*User{{LocalID: "1"}}, HashAlgorithm: "SHA1"})
+				return err
+			},
+			"POST", base + "projects/p1/accounts:batchCreate", `{"hashAlgorithm":"SHA1","users":[{"localId":"1"}]}`,
+		},
+	}
+	for i, tt := range tests {
+		rt := &recordingRoundTripper{roundTripper: roundTripper{http.StatusOK, "{}"}}
+		c := &APIClient{Client: http.Client{Transport: APIVersionMiddleware(APIv1, "p1")(rt)}}
+		if err := tt.call(c); err != nil {
+			t.Errorf("[%d] call returns error: %v", i, err)
+			continue
+		}
+		r := rt.reqs[0]
+		if r.Method != tt.wantMethod || r.URL.String() != tt.wantURL {
+			t.Errorf("[%d] request sent as %s %s; want %s %s", i, r.Method, r.URL, tt.wantMethod, tt.wantURL)
+		}
+		var body []byte
+		if r.Body != nil {
+			body, _ = ioutil.ReadAll(r.Body)
+		}
+		if string(body) != tt.wantBody {
+			t.Errorf("[%d] request body = %s; want %s", i, body, tt.wantBody)
+		}
+	}
+}
+
+func TestAPIVersionMiddleware_unsupported(t *testing.T) {
+	rt := &recordingRoundTripper{roundTripper: roundTripper{http.StatusOK, "{}"}}
+	c := &APIClient{Client: http.Client{Transport: APIVersionMiddleware(APIv1, "")(rt)}}
+	_, err := c.SetProjectConfig(context.Background(), &SetProjectConfigRequest{})
+	if e, ok := err.(*UnsupportedMethodError); !ok || e.Method != "setProjectConfig" || e.Version != APIv1 {
+		t.Errorf("SetProjectConfig() returns error %v; want UnsupportedMethodError", err)
+	}
+	if _, err := c.DownloadAccount(context.Background(), &DownloadAccountRequest{}); err == nil {
+		t.Error("DownloadAccount() without a project ID succeeds")
+	}
+	if len(rt.reqs) != 0 {
+		t.Errorf("%d requests sent; want none", len(rt.reqs))
+	}
+
+	// APIv3 leaves the requests alone.
+	rt = &recordingRoundTripper{roundTripper: roundTripper{http.StatusOK, "{}"}}
+	c = &APIClient{Client: http.Client{Transport: APIVersionMiddleware(APIv3, "")(rt)}}
+	if _, err := c.SetProjectConfig(context.Background(), &SetProjectConfigRequest{}); err != nil {
+		t.Fatalf("SetProjectConfig() returns error: %v", err)
+	}
+	if got, want := rt.reqs[0].URL.String(), setProjectConfig.url(); got != want {
+		t.Errorf("request sent to %s; want %s", got, want)
+	}
+}
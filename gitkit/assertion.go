@@ -0,0 +1,93 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// maxAssertionBody is the maximum size of the IDP responses posted to the
+// sign in callback.
+const maxAssertionBody = 1 << 20
+
+// AssertionError is returned by VerifyAssertion when identitytoolkit rejects
+// the IDP response, e.g., because the user denied the access.
+type AssertionError struct {
+	Message string
+}
+
+// Error implements the error interface.
+func (e *AssertionError) Error() string {
+	return "gitkit: IDP sign in failed: " + e.Message
+}
+
+// CreateAuthURI starts a federated sign in on the server with the IDP of
+// providerID, e.g., "google.com", or, if providerID is empty, the IDP of the
+// account with the email address. The user is redirected to the AuthURI of
+// the response, and back to continueURI, whose handler completes the sign in
+// with VerifyAssertion. Registered and AllProviders of the response tell
+// which providers the account of email, if given, can sign in with.
+func (c *Client) CreateAuthURI(ctx context.Context, providerID, continueURI, email string) (*CreateAuthURIResponse, error) {
+	return c.callAPIClient(ctx).CreateAuthURI(ctx, &CreateAuthURIRequest{
+		ProviderID:  providerID,
+		Identifier:  email,
+		ContinueURI: continueURI,
+	})
+}
+
+// VerifyAssertion completes a federated sign in on the server: req is the
+// request of the IDP redirecting the user back to the site, whose URL,
+// including the query, and posted body are verified by identitytoolkit. The
+// returned ID token can be validated with ValidateToken and set as the token
+// cookie with SetTokenCookie.
+//
+// If the response has NeedConfirmation set, the user must sign in with an
+// existing account first and no ID token is returned. If
+// Config.EnrichProfiles is set, the Profile of the response is filled by
+// EnrichProfile.
+func (c *Client) VerifyAssertion(ctx context.Context, req *http.Request) (*VerifyAssertionResponse, error) {
+	var postBody string
+	if req.Method == "POST" && req.Body != nil {
+		b, err := ioutil.ReadAll(io.LimitReader(req.Body, maxAssertionBody))
+		if err != nil {
+			return nil, err
+		}
+		postBody = string(b)
+	}
+	u := c.requestURL(req)
+	u.RawQuery = req.URL.RawQuery
+	resp, err := c.callAPIClient(ctx).VerifyAssertion(ctx, &VerifyAssertionRequest{
+		RequestURI: u.String(),
+		PostBody:   postBody,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.ErrorMessage != "" {
+		return nil, &AssertionError{resp.ErrorMessage}
+	}
+	if c.config != nil && c.config.EnrichProfiles {
+		// The sign in succeeded: an enrichment failure is only logged.
+		var err error
+		if resp.Profile, err = c.EnrichProfile(ctx, resp); err != nil && c.config.Logf != nil {
+			c.config.Logf("gitkit: enriching the profile of %s: %v", resp.LocalID, err)
+		}
+	}
+	return resp, nil
+}
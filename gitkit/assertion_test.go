@@ -0,0 +1,93 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyAssertion(t *testing.T) {
+	tests := []struct {
+		method   string
+		url      string
+		body     string
+		resp     string
+		uri      string
+		postBody string
+		err      bool
+	}{
+		{
+			"GET", "http://www.example.com/callback?code=abc&state=xyz", "",
+			`{"idToken":"token","localId":"123","providerId":"google.com","email":"user@example.com"}`,
+			"http://www.example.com/callback?code=abc&state=xyz", "", false,
+		},
+		{
+			"POST", "http://www.example.com/callback", "SAMLResponse=abc",
+			`{"idToken":"token","localId":"123","providerId":"saml.example"}`,
+			"http://www.example.com/callback", "SAMLResponse=abc", false,
+		},
+		{
+			"GET", "http://www.example.com/callback?error=access_denied", "",
+			`{"errorMessage":"access_denied"}`,
+			"http://www.example.com/callback?error=access_denied", "", true,
+		},
+	}
+	for i, tt := range tests {
+		rt := &recordingRoundTripper{roundTripper: roundTripper{http.StatusOK, tt.resp}}
+		c := &Client{api: &APIClient{Client: http.Client{Transport: rt}}}
+		req, _ := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+		resp, err := c.VerifyAssertion(context.Background(), req)
+		if tt.err {
+			if _, ok := err.(*AssertionError); !ok {
+				t.Errorf("[%d] VerifyAssertion() returns error %v; want *AssertionError", i, err)
+			}
+		} else if err != nil {
+			t.Errorf("[%d] VerifyAssertion() returns error: %v", i, err)
+		} else if resp.IDToken != "token" || resp.LocalID != "123" {
+			t.Errorf("[%d] VerifyAssertion() = %+v; want the ID token of user 123", i, resp)
+		}
+		var sent VerifyAssertionRequest
+		json.NewDecoder(rt.reqs[0].Body).Decode(&sent)
+		if sent.RequestURI != tt.uri || sent.PostBody != tt.postBody {
+			t.Errorf("[%d] request = %+v; want URI %q and post body %q", i, sent, tt.uri, tt.postBody)
+		}
+	}
+}
+
+func TestCreateAuthURI(t *testing.T) {
+	rt := &recordingRoundTripper{roundTripper: roundTripper{http.StatusOK,
+		`{"authUri":"https://accounts.google.com/o/oauth2/auth?state=abc","providerId":"google.com","sessionId":"session","registered":true,"allProviders":["google.com","password"]}`}}
+	c := &Client{api: &APIClient{Client: http.Client{Transport: rt}}}
+	resp, err := c.CreateAuthURI(context.Background(), "google.com", "http://www.example.com/callback", "user@example.com")
+	if err != nil {
+		t.Fatalf("CreateAuthURI() returns error: %v", err)
+	}
+	if resp.AuthURI == "" || resp.SessionID != "session" || !resp.Registered || len(resp.AllProviders) != 2 {
+		t.Errorf("CreateAuthURI() = %+v; want the auth URI, session ID and providers", resp)
+	}
+	var sent CreateAuthURIRequest
+	json.NewDecoder(rt.reqs[0].Body).Decode(&sent)
+	if sent.ProviderID != "google.com" || sent.ContinueURI != "http://www.example.com/callback" || sent.Identifier != "user@example.com" {
+		t.Errorf("request = %+v; want the provider, continue URI and identifier", sent)
+	}
+	if _, err := c.CreateAuthURI(context.Background(), "", "http://www.example.com/callback", ""); err == nil {
+		t.Errorf("CreateAuthURI() without provider ID and email returns nil error")
+	}
+}
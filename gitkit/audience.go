@@ -0,0 +1,71 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+type requestHostKey struct{}
+
+// WithRequestHost returns a copy of ctx carrying the host of the request being
+// served, e.g., req.Host. ValidateToken and UserByToken called with ctx and no
+// audiences accept the audiences Config.AudiencesByHost maps the host to.
+func WithRequestHost(ctx context.Context, host string) context.Context {
+	return context.WithValue(ctx, requestHostKey{}, host)
+}
+
+// RequestHost returns the host carried by ctx, or an empty string if ctx is not
+// created by WithRequestHost.
+func RequestHost(ctx context.Context) string {
+	host, _ := ctx.Value(requestHostKey{}).(string)
+	return host
+}
+
+// HostAudiences returns the audiences m maps the host to. The port of host, if
+// any, is ignored and the host names are compared case-insensitively.
+func HostAudiences(m map[string][]string, host string) []string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(host, ".")
+	if auds, ok := m[host]; ok {
+		return auds
+	}
+	for h, auds := range m {
+		if strings.EqualFold(h, host) {
+			return auds
+		}
+	}
+	return nil
+}
+
+// audiences returns the audiences a token validated with ctx must be issued
+// for: the given ones, or those of the request host if none are given, or
+// Config.Audiences if the host has none.
+func (c *Client) audiences(ctx context.Context, audiences []string) []string {
+	if len(audiences) != 0 {
+		return audiences
+	}
+	if len(c.config.AudiencesByHost) != 0 {
+		if auds := HostAudiences(c.config.AudiencesByHost, RequestHost(ctx)); len(auds) != 0 {
+			return auds
+		}
+	}
+	return c.config.Audiences
+}
@@ -0,0 +1,110 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestHostAudiences(t *testing.T) {
+	m := map[string][]string{
+		"app.example.com":  {"app"},
+		"Blog.example.com": {"blog", "blog-legacy"},
+	}
+	tests := []struct {
+		host string
+		want []string
+	}{
+		{"app.example.com", []string{"app"}},
+		{"app.example.com:8443", []string{"app"}},
+		{"APP.example.com.", []string{"app"}},
+		{"blog.example.com", []string{"blog", "blog-legacy"}},
+		{"example.com", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := HostAudiences(m, tt.host); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("HostAudiences(%q) = %v; want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestValidateToken_audiencesByHost(t *testing.T) {
+	c := newMiddlewareClient()
+	c.config.AudiencesByHost = map[string][]string{
+		"app.example.com":   {audience},
+		"other.example.com": {"other-client-id"},
+	}
+	ctx := context.Background()
+	tests := []struct {
+		host string
+		err  error
+	}{
+		{"app.example.com", nil},
+		{"other.example.com", ErrInvalidAudience},
+		{"unknown.example.com", ErrMissingAudience},
+		{"", ErrMissingAudience},
+	}
+	for _, tt := range tests {
+		if _, err := c.ValidateToken(WithRequestHost(ctx, tt.host), validToken, nil); err != tt.err {
+			t.Errorf("ValidateToken() for host %q returns error %v; want %v", tt.host, err, tt.err)
+		}
+	}
+	// Explicit audiences take precedence over the host.
+	if _, err := c.ValidateToken(WithRequestHost(ctx, "other.example.com"), validToken, []string{audience}); err != nil {
+		t.Errorf("ValidateToken() with audiences returns error %v", err)
+	}
+
+	h := c.RequireToken(nil, func(w http.ResponseWriter, r *http.Request, t *Token) {})
+	for host, want := range map[string]int{"app.example.com": http.StatusOK, "other.example.com": http.StatusUnauthorized} {
+		req, _ := http.NewRequest("GET", "http://"+host+"/", nil)
+		req.AddCookie(&http.Cookie{Name: "gtoken", Value: validToken})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("RequireToken(nil) for host %s responds %d; want %d", host, w.Code, want)
+		}
+	}
+}
+
+func TestValidateToken_configAudiences(t *testing.T) {
+	c := newMiddlewareClient()
+	c.config.Audiences = []string{"android-client-id", audience}
+	c.config.AudiencesByHost = map[string][]string{"other.example.com": {"other-client-id"}}
+	ctx := context.Background()
+	tests := []struct {
+		host string
+		err  error
+	}{
+		{"", nil},
+		{"app.example.com", nil},
+		// The audiences of the host take precedence.
+		{"other.example.com", ErrInvalidAudience},
+	}
+	for _, tt := range tests {
+		if _, err := c.ValidateToken(WithRequestHost(ctx, tt.host), validToken, nil); err != tt.err {
+			t.Errorf("ValidateToken() for host %q returns error %v; want %v", tt.host, err, tt.err)
+		}
+	}
+	c.config.Audiences = []string{"ios-client-id"}
+	if _, err := c.ValidateToken(ctx, validToken, nil); err != ErrInvalidAudience {
+		t.Errorf("ValidateToken() returns error %v; want %v", err, ErrInvalidAudience)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Operations reported in AuditRecord.
+const (
+	AuditOpUpdateUser    = "UpdateUser"
+	AuditOpDeleteUser    = "DeleteUser"
+	AuditOpUploadUsers   = "UploadUsers"
+	AuditOpQuarantine    = "QuarantineUser"
+	AuditOpRestore       = "RestoreUser"
+	AuditOpResetPassword = "ResetPassword"
+	AuditOpChangeEmail   = "ChangeEmail"
+	AuditOpCreateUser    = "CreateUser"
+	AuditOpVerifyEmail   = "VerifyEmail"
+	AuditOpRecordLinks   = "RecordProviderLinks"
+)
+
+// An AuditRecord describes a call made through a Client that mutates user
+// accounts.
+type AuditRecord struct {
+	// Op is the mutating operation, e.g., AuditOpUpdateUser.
+	Op string
+	// LocalIDs are the local IDs of the affected users, if known.
+	LocalIDs []string
+	// Actor identifies the admin on whose behalf the call was made. It is
+	// empty if neither Config.ActingAdmin nor WithActingAdmin provides one.
+	Actor string
+	// Time is when the call completed.
+	Time time.Time
+	// Err is the error returned by the call, if any.
+	Err error
+}
+
+type actingAdminKey struct{}
+
+// WithActingAdmin returns a copy of ctx that attributes the mutating calls made
+// with it to the admin, overriding Config.ActingAdmin.
+func WithActingAdmin(ctx context.Context, admin string) context.Context {
+	return context.WithValue(ctx, actingAdminKey{}, admin)
+}
+
+// actingAdmin returns the admin the calls made with ctx are attributed to.
+func (c *Client) actingAdmin(ctx context.Context) string {
+	if admin, ok := ctx.Value(actingAdminKey{}).(string); ok {
+		return admin
+	}
+	return c.config.ActingAdmin
+}
+
+// mutatingAPIClient returns the APIClient for the mutating calls made with
+// ctx. If Config.ActingAdminHeader is set, the requests carry the acting admin
+// in that header.
+func (c *Client) mutatingAPIClient(ctx context.Context) *APIClient {
+	h := c.requestHeaders(ctx)
+	if admin := c.actingAdmin(ctx); c.config.ActingAdminHeader != "" && admin != "" {
+		if h == nil {
+			h = make(http.Header)
+		}
+		h.Set(c.config.ActingAdminHeader, admin)
+	}
+	return c.apiClientWithHeader(ctx, h)
+}
+
+// audit reports the mutating call to Config.AuditHook if set.
+func (c *Client) audit(ctx context.Context, op string, localIDs []string, err error) {
+	if c.config.AuditHook == nil {
+		return
+	}
+	c.config.AuditHook(ctx, &AuditRecord{
+		Op:       op,
+		LocalIDs: localIDs,
+		Actor:    c.actingAdmin(ctx),
+		Time:     time.Now(),
+		Err:      err,
+	})
+}
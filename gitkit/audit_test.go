@@ -0,0 +1,91 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// recordingRoundTripper records the requests and responds with a fixed
+// response.
+type recordingRoundTripper struct {
+	roundTripper
+	reqs []*http.Request
+}
+
+func (r *recordingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.reqs = append(r.reqs, req)
+	return r.roundTripper.RoundTrip(req)
+}
+
+func TestAudit(t *testing.T) {
+	var records []*AuditRecord
+	rt := &recordingRoundTripper{roundTripper: roundTripper{200, "{}"}}
+	c := &Client{
+		config: &Config{
+			ActingAdmin:       "default@example.com",
+			ActingAdminHeader: "X-Acting-Admin",
+			AuditHook: func(ctx context.Context, r *AuditRecord) {
+				records = append(records, r)
+			},
+		},
+		api: &APIClient{Client: http.Client{Transport: rt}},
+	}
+	ctx := context.Background()
+	if err := c.UpdateUser(ctx, &User{LocalID: "123"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteUser(WithActingAdmin(ctx, "admin@example.com"), &User{LocalID: "456"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		op, actor string
+		localIDs  []string
+	}{
+		{AuditOpUpdateUser, "default@example.com", []string{"123"}},
+		{AuditOpDeleteUser, "admin@example.com", []string{"456"}},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d audit records; want %d", len(records), len(want))
+	}
+	for i, w := range want {
+		r := records[i]
+		if r.Op != w.op || r.Actor != w.actor || !reflect.DeepEqual(r.LocalIDs, w.localIDs) || r.Err != nil {
+			t.Errorf("%d. audit record = %+v; want op %s, actor %s, local IDs %v", i, r, w.op, w.actor, w.localIDs)
+		}
+		if h := rt.reqs[i].Header.Get("X-Acting-Admin"); h != w.actor {
+			t.Errorf("%d. X-Acting-Admin header = %q; want %q", i, h, w.actor)
+		}
+	}
+}
+
+func TestAudit_noHeader(t *testing.T) {
+	rt := &recordingRoundTripper{roundTripper: roundTripper{200, "{}"}}
+	c := &Client{
+		config: &Config{ActingAdmin: "default@example.com"},
+		api:    &APIClient{Client: http.Client{Transport: rt}},
+	}
+	if err := c.DeleteUser(context.Background(), &User{LocalID: "456"}); err != nil {
+		t.Fatal(err)
+	}
+	if h := rt.reqs[0].Header.Get("X-Acting-Admin"); h != "" {
+		t.Errorf("X-Acting-Admin header = %q; want none", h)
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// defaultBatchConcurrency is the number of concurrent calls made by the batch
+// methods if Config.MaxConcurrentRequests is not set.
+const defaultBatchConcurrency = 10
+
+// UserUpdate contains the account information to update for one user in
+// UpdateUsers. Empty fields are left unchanged.
+type UserUpdate struct {
+	LocalID       string
+	Email         string
+	DisplayName   string
+	Password      string
+	EmailVerified bool
+}
+
+// UpdateUsers updates the users concurrently, with at most
+// Config.MaxConcurrentRequests calls in flight. It returns nil if all the
+// updates succeed. Otherwise, the returned slice holds the error of each
+// update at the same index, nil for the successful ones.
+//
+// For example, to mark the emails of imported users as verified,
+//
+//	updates := make([]*gitkit.UserUpdate, len(users))
+//	for i, u := range users {
+//		updates[i] = &gitkit.UserUpdate{LocalID: u.LocalID, EmailVerified: true}
+//	}
+//	if errs := c.UpdateUsers(ctx, updates); errs != nil {
+//		...
+//	}
+func (c *Client) UpdateUsers(ctx context.Context, updates []*UserUpdate) []error {
+	n := defaultBatchConcurrency
+	if c.config.MaxConcurrentRequests > 0 {
+		n = c.config.MaxConcurrentRequests
+	}
+	if n > len(updates) {
+		n = len(updates)
+	}
+	errs := make([]error, len(updates))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				u := updates[i]
+				errs[i] = c.UpdateUser(ctx, &User{
+					LocalID:       u.LocalID,
+					Email:         u.Email,
+					DisplayName:   u.DisplayName,
+					Password:      u.Password,
+					EmailVerified: u.EmailVerified,
+				})
+				if errs[i] == nil {
+					c.count(MetricUsersUpdated, 1)
+				}
+			}
+		}()
+	}
+	for i := range updates {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return errs
+		}
+	}
+	return nil
+}
+
+// RetryFailedUploads uploads again the users of a previous UploadUsers call
+// which failed with err. It returns nil if err is nil and returns err as is if
+// it is not an UploadError. Otherwise, only the failed users are uploaded and
+// the indexes of the returned UploadError, if any, refer to users, so that the
+// call can be repeated with its own result.
+//
+// For example,
+//
+//	err := c.UploadUsers(ctx, users, algorithm, key, nil)
+//	for i := 0; i < 3 && err != nil; i++ {
+//		err = c.RetryFailedUploads(ctx, users, err, algorithm, key, nil)
+//	}
+func (c *Client) RetryFailedUploads(ctx context.Context, users []*User, err error, algorithm string, key, saltSeparator []byte) error {
+	uploadErr, ok := err.(UploadError)
+	if !ok || len(uploadErr) == 0 {
+		return err
+	}
+	var indexes []int
+	var failed []*User
+	for _, v := range uploadErr {
+		if v.Index >= 0 && v.Index < len(users) {
+			indexes = append(indexes, v.Index)
+			failed = append(failed, users[v.Index])
+		}
+	}
+	if len(failed) == 0 {
+		return err
+	}
+	err = c.UploadUsers(ctx, failed, algorithm, key, saltSeparator)
+	uploadErr, ok = err.(UploadError)
+	if !ok {
+		return err
+	}
+	for _, v := range uploadErr {
+		if v.Index >= 0 && v.Index < len(indexes) {
+			v.Index = indexes[v.Index]
+		}
+	}
+	return uploadErr
+}
+
+// MaxUploadChunk is the maximum number of users uploaded per request by
+// UploadUsersChunked.
+const MaxUploadChunk = 1000
+
+// Chunk sizes of UploadUsersChunked before a deadline: the first chunk
+// measures the upload latency, and the upload stops once less than
+// minDeadlineChunk users can be uploaded in time.
+const (
+	probeDeadlineChunk = 50
+	minDeadlineChunk   = 10
+)
+
+// ErrDeadlineCheckpoint is returned by UploadUsersChunked when it stops before
+// the deadline of the context with users left to upload.
+var ErrDeadlineCheckpoint = errors.New("gitkit: upload stopped before the context deadline")
+
+// ChunkedUpload reports the outcome of UploadUsersChunked.
+type ChunkedUpload struct {
+	// Uploaded is the number of users uploaded successfully.
+	Uploaded int
+	// Failed are the users rejected by identitytoolkit, whose indexes refer
+	// to the users given to UploadUsersChunked.
+	Failed UploadError
+	// Remaining are the users left to upload, in order.
+	Remaining []*User
+}
+
+// UploadUsersChunked uploads the users like UploadUsersWithOptions, in chunks
+// of at most chunkSize users, or MaxUploadChunk if chunkSize is not positive.
+// If a chunk fails as a whole, the upload stops with its error and its users
+// are among the remaining ones.
+//
+// If ctx has a deadline, e.g., on App Engine, the latency of the uploaded
+// chunks is measured and the next chunks shrink so that they complete before
+// the deadline. When too few users can still be uploaded in time, the upload
+// stops cleanly at that checkpoint with ErrDeadlineCheckpoint, rather than
+// failing in the middle of a chunk, and the remaining users can be uploaded
+// by a later call, e.g.,
+//
+//	r, err := c.UploadUsersChunked(ctx, users, 0, opts)
+//	if err == gitkit.ErrDeadlineCheckpoint {
+//		// Schedule a task uploading r.Remaining.
+//	}
+func (c *Client) UploadUsersChunked(ctx context.Context, users []*User, chunkSize int, opts *UploadOptions) (*ChunkedUpload, error) {
+	if chunkSize <= 0 || chunkSize > MaxUploadChunk {
+		chunkSize = MaxUploadChunk
+	}
+	deadline, hasDeadline := ctx.Deadline()
+	minChunk := minDeadlineChunk
+	if minChunk > chunkSize {
+		minChunk = chunkSize
+	}
+	r := &ChunkedUpload{}
+	var (
+		elapsed time.Duration // Time spent uploading the sent users.
+		sent    int
+	)
+	for i := 0; i < len(users); {
+		n := chunkSize
+		if hasDeadline {
+			n = deadlineChunk(chunkSize, deadline.Sub(time.Now()), elapsed, sent)
+			if n < minChunk && n < len(users)-i {
+				r.Remaining = users[i:]
+				return r, ErrDeadlineCheckpoint
+			}
+		}
+		if n > len(users)-i {
+			n = len(users) - i
+		}
+		chunk := users[i : i+n]
+		start := time.Now()
+		err := c.UploadUsersWithOptions(ctx, chunk, opts)
+		elapsed += time.Since(start)
+		sent += n
+		uploadErr, ok := err.(UploadError)
+		if err != nil && !ok {
+			r.Remaining = users[i:]
+			return r, err
+		}
+		for _, f := range uploadErr {
+			f.Index += i
+		}
+		r.Failed = append(r.Failed, uploadErr...)
+		r.Uploaded += n - len(uploadErr)
+		i += n
+	}
+	return r, nil
+}
+
+// deadlineChunk returns the number of users, at most chunkSize, which can be
+// uploaded within 80% of the time left before the deadline, at the average
+// latency per user of the sent ones. Without measure, it is the probe size.
+func deadlineChunk(chunkSize int, left, elapsed time.Duration, sent int) int {
+	if left <= 0 {
+		return 0
+	}
+	if sent == 0 || elapsed <= 0 {
+		if chunkSize < probeDeadlineChunk {
+			return chunkSize
+		}
+		return probeDeadlineChunk
+	}
+	perUser := elapsed / time.Duration(sent)
+	if perUser <= 0 {
+		return chunkSize
+	}
+	n := int(left * 4 / 5 / perUser)
+	if n > chunkSize {
+		n = chunkSize
+	}
+	return n
+}
@@ -0,0 +1,303 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// failingRoundTripper fails the setAccountInfo requests for the local IDs in
+// fail and records the maximum number of concurrent requests.
+type failingRoundTripper struct {
+	fail map[string]bool
+
+	mu       sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (f *failingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	f.inFlight++
+	if f.inFlight > f.max {
+		f.max = f.inFlight
+	}
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}()
+
+	var body SetAccountInfoRequest
+	b, _ := ioutil.ReadAll(req.Body)
+	json.Unmarshal(b, &body)
+	if f.fail[body.LocalID] {
+		return roundTripper{400, `{"error":{"code":400,"message":"USER_NOT_FOUND"}}`}.RoundTrip(req)
+	}
+	return roundTripper{200, `{}`}.RoundTrip(req)
+}
+
+func TestUpdateUsers(t *testing.T) {
+	rt := &failingRoundTripper{fail: map[string]bool{"2": true, "4": true}}
+	c := &Client{
+		config: &Config{MaxConcurrentRequests: 2},
+		api:    &APIClient{Client: http.Client{Transport: rt}},
+	}
+	var updates []*UserUpdate
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		updates = append(updates, &UserUpdate{LocalID: id, EmailVerified: true})
+	}
+	errs := c.UpdateUsers(context.Background(), updates)
+	if len(errs) != len(updates) {
+		t.Fatalf("UpdateUsers() returns %d errors; want %d", len(errs), len(updates))
+	}
+	for i, err := range errs {
+		if failed := rt.fail[updates[i].LocalID]; failed != (err != nil) {
+			t.Errorf("%d. error = %v; want failure %v", i, err, failed)
+		} else if failed && !strings.Contains(err.Error(), "USER_NOT_FOUND") {
+			t.Errorf("%d. error = %v; want USER_NOT_FOUND", i, err)
+		}
+	}
+	if rt.max > 2 {
+		t.Errorf("%d concurrent requests; want at most 2", rt.max)
+	}
+
+	rt.fail = nil
+	if errs := c.UpdateUsers(context.Background(), updates); errs != nil {
+		t.Errorf("UpdateUsers() = %v; want nil", errs)
+	}
+}
+
+func TestUploadErrorFailedUsers(t *testing.T) {
+	var resp UploadAccountResponse
+	if err := json.Unmarshal([]byte(`{"error":[{"index":1,"message":"a"},{"index":3,"message":"b"}]}`), &resp); err != nil {
+		t.Fatal(err)
+	}
+	users := []*User{{LocalID: "0"}, {LocalID: "1"}, {LocalID: "2"}, {LocalID: "3"}}
+	failed := resp.FailedUsers(users)
+	if len(failed) != 2 || failed[0].LocalID != "1" || failed[1].LocalID != "3" {
+		t.Errorf("FailedUsers() = %v; want users 1 and 3", failed)
+	}
+}
+
+func TestRetryFailedUploads(t *testing.T) {
+	rt := &methodRoundTripper{resps: map[string]string{
+		"uploadAccount": `{"error":[{"index":1,"message":"still failing"}]}`,
+	}}
+	c := &Client{config: &Config{}, api: &APIClient{Client: http.Client{Transport: rt}}}
+	ctx := context.Background()
+	users := []*User{{LocalID: "0"}, {LocalID: "1"}, {LocalID: "2"}, {LocalID: "3"}}
+	var prev UploadError
+	json.Unmarshal([]byte(`[{"index":1,"message":"a"},{"index":3,"message":"b"}]`), &prev)
+
+	err := c.RetryFailedUploads(ctx, users, prev, "HMAC_SHA256", []byte("key"), nil)
+	uploadErr, ok := err.(UploadError)
+	if !ok || len(uploadErr) != 1 || uploadErr[0].Index != 3 {
+		t.Errorf("RetryFailedUploads() = %#v; want UploadError for index 3", err)
+	}
+	var req UploadAccountRequest
+	json.Unmarshal([]byte(rt.reqs[0][len("uploadAccount "):]), &req)
+	if len(req.Users) != 2 || req.Users[0].LocalID != "1" || req.Users[1].LocalID != "3" {
+		t.Errorf("uploaded users = %v; want users 1 and 3", req.Users)
+	}
+
+	rt.reqs = nil
+	for _, err := range []error{nil, fmt.Errorf("network error")} {
+		if got := c.RetryFailedUploads(ctx, users, err, "HMAC_SHA256", []byte("key"), nil); got != err {
+			t.Errorf("RetryFailedUploads(%v) = %v; want the same error", err, got)
+		}
+	}
+	if len(rt.reqs) != 0 {
+		t.Errorf("RetryFailedUploads() made %d requests without an UploadError; want 0", len(rt.reqs))
+	}
+}
+
+// chunkRoundTripper answers the uploadAccount requests after perUser for each
+// uploaded user, failing the user at index 1, and records the chunk sizes.
+type chunkRoundTripper struct {
+	perUser time.Duration
+	sizes   []int
+}
+
+func (r *chunkRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body UploadAccountRequest
+	json.NewDecoder(req.Body).Decode(&body)
+	r.sizes = append(r.sizes, len(body.Users))
+	time.Sleep(r.perUser * time.Duration(len(body.Users)))
+	return roundTripper{200, `{"error":[{"index":1,"message":"INVALID_EMAIL"}]}`}.RoundTrip(req)
+}
+
+func chunkUsers(n int) []*User {
+	users := make([]*User, n)
+	for i := range users {
+		users[i] = &User{LocalID: fmt.Sprint(i)}
+	}
+	return users
+}
+
+func TestUploadUsersChunked(t *testing.T) {
+	rt := &chunkRoundTripper{}
+	c := &Client{config: &Config{}, api: &APIClient{Client: http.Client{Transport: rt}}}
+	users := chunkUsers(25)
+	r, err := c.UploadUsersChunked(context.Background(), users, 10, &UploadOptions{HashAlgorithm: "SHA256"})
+	if err != nil {
+		t.Fatalf("UploadUsersChunked() returns error: %v", err)
+	}
+	if fmt.Sprint(rt.sizes) != "[10 10 5]" {
+		t.Errorf("chunk sizes = %v; want [10 10 5]", rt.sizes)
+	}
+	if r.Uploaded != 22 || len(r.Remaining) != 0 || len(r.Failed) != 3 {
+		t.Fatalf("UploadUsersChunked() = %+v; want 22 uploaded and 3 failed", r)
+	}
+	for i, f := range r.Failed {
+		if want := 10*i + 1; f.Index != want || f.User != users[want] {
+			t.Errorf("failure %d has index %d, user %v; want %d", i, f.Index, f.User, want)
+		}
+	}
+}
+
+func TestUploadUsersChunked_deadline(t *testing.T) {
+	rt := &chunkRoundTripper{perUser: time.Millisecond}
+	c := &Client{config: &Config{}, api: &APIClient{Client: http.Client{Transport: rt}}}
+	users := chunkUsers(1000)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	r, err := c.UploadUsersChunked(ctx, users, 0, &UploadOptions{HashAlgorithm: "SHA256"})
+	if err != ErrDeadlineCheckpoint {
+		t.Fatalf("UploadUsersChunked() returns error %v; want ErrDeadlineCheckpoint", err)
+	}
+	if ctx.Err() != nil {
+		t.Errorf("UploadUsersChunked() returns after the deadline")
+	}
+	sent := 0
+	for _, n := range rt.sizes {
+		sent += n
+	}
+	if sent+len(r.Remaining) != len(users) || r.Remaining[0] != users[sent] {
+		t.Errorf("%d users sent, %d remaining; want the remaining users to follow the sent ones", sent, len(r.Remaining))
+	}
+	if rt.sizes[0] != probeDeadlineChunk || len(rt.sizes) < 2 {
+		t.Errorf("chunk sizes = %v; want a probe chunk of %d, then chunks fitting the deadline", rt.sizes, probeDeadlineChunk)
+	}
+}
+
+func TestDeadlineChunk(t *testing.T) {
+	tests := []struct {
+		chunkSize     int
+		left, elapsed time.Duration
+		sent          int
+		want          int
+	}{
+		{1000, time.Second, 0, 0, probeDeadlineChunk},
+		{20, time.Second, 0, 0, 20},
+		{1000, 0, time.Second, 100, 0},
+		{1000, time.Second, 100 * time.Millisecond, 100, 800},
+		{500, time.Second, 100 * time.Millisecond, 100, 500},
+		{1000, 10 * time.Millisecond, 100 * time.Millisecond, 100, 8},
+	}
+	for i, tt := range tests {
+		if n := deadlineChunk(tt.chunkSize, tt.left, tt.elapsed, tt.sent); n != tt.want {
+			t.Errorf("[%d] deadlineChunk() = %d; want %d", i, n, tt.want)
+		}
+	}
+}
+
+// batchRoundTripper answers the uploadAccount requests, failing once the
+// users in transient with a retryable error, the users in invalid with a
+// permanent one, and the whole batches holding broken.
+type batchRoundTripper struct {
+	mu        sync.Mutex
+	sizes     []int
+	transient map[string]bool
+	invalid   map[string]bool
+	broken    string
+}
+
+func (r *batchRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body UploadAccountRequest
+	json.NewDecoder(req.Body).Decode(&body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sizes = append(r.sizes, len(body.Users))
+	var failures []string
+	for i, u := range body.Users {
+		switch {
+		case u.LocalID == r.broken:
+			return roundTripper{400, `{"error":{"code":400,"message":"INVALID_REQUEST"}}`}.RoundTrip(req)
+		case r.transient[u.LocalID]:
+			delete(r.transient, u.LocalID)
+			failures = append(failures, fmt.Sprintf(`{"index":%d,"message":"INTERNAL_ERROR"}`, i))
+		case r.invalid[u.LocalID]:
+			failures = append(failures, fmt.Sprintf(`{"index":%d,"message":"INVALID_EMAIL"}`, i))
+		}
+	}
+	return roundTripper{200, `{"error":[` + strings.Join(failures, ",") + `]}`}.RoundTrip(req)
+}
+
+func TestUploadUsersWithOptions_batches(t *testing.T) {
+	rt := &batchRoundTripper{
+		transient: map[string]bool{"3": true, "12": true},
+		invalid:   map[string]bool{"7": true},
+		broken:    "21",
+	}
+	c := &Client{config: &Config{}, api: &APIClient{Client: http.Client{Transport: rt}}}
+	users := chunkUsers(25)
+	err := c.UploadUsersWithOptions(context.Background(), users, &UploadOptions{
+		HashAlgorithm: "SHA256",
+		BatchSize:     10,
+		Concurrency:   2,
+		RetryFailed:   1,
+	})
+	uploadErr, ok := err.(UploadError)
+	if !ok {
+		t.Fatalf("UploadUsersWithOptions() returns error %v; want an UploadError", err)
+	}
+	// The transient failures are retried, the users of the broken batch fail
+	// with its error.
+	var indexes []int
+	for _, f := range uploadErr {
+		if f.User != users[f.Index] {
+			t.Errorf("failure %d refers to user %v", f.Index, f.User)
+		}
+		indexes = append(indexes, f.Index)
+	}
+	if fmt.Sprint(indexes) != "[7 20 21 22 23 24]" {
+		t.Errorf("failed indexes = %v; want [7 20 21 22 23 24]", indexes)
+	}
+	// Only the 2 users with a transient failure are uploaded again.
+	sort.Ints(rt.sizes)
+	if fmt.Sprint(rt.sizes) != "[2 5 10 10]" {
+		t.Errorf("batch sizes = %v; want [2 5 10 10]", rt.sizes)
+	}
+
+	// A single batch failing as a whole returns its error as is.
+	rt = &batchRoundTripper{broken: "0"}
+	c.api = &APIClient{Client: http.Client{Transport: rt}}
+	if _, ok := c.UploadUsersWithOptions(context.Background(), users[:5], &UploadOptions{HashAlgorithm: "SHA256"}).(*googleapi.Error); !ok {
+		t.Error("UploadUsersWithOptions() of a failing batch does not return its error")
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// A CaptchaProvider verifies the CAPTCHA responses of the reset password
+// requests in place of identitytoolkit, which only knows reCAPTCHA, e.g., to
+// deploy hCaptcha or Turnstile. See Config.CaptchaProvider.
+type CaptchaProvider interface {
+	// VerifyCaptcha returns an error, typically a *CaptchaError, if the
+	// response to the challenge, sent by the client at remoteIP, is not
+	// valid.
+	VerifyCaptcha(ctx context.Context, challenge, response, remoteIP string) error
+}
+
+// CaptchaProviderFunc is an adapter to use a function as a CaptchaProvider.
+type CaptchaProviderFunc func(ctx context.Context, challenge, response, remoteIP string) error
+
+// VerifyCaptcha implements the CaptchaProvider interface.
+func (f CaptchaProviderFunc) VerifyCaptcha(ctx context.Context, challenge, response, remoteIP string) error {
+	return f(ctx, challenge, response, remoteIP)
+}
+
+// CaptchaError is returned when a CaptchaProvider rejects a CAPTCHA response.
+type CaptchaError struct {
+	// Codes are the error codes reported by the provider, if any.
+	Codes []string
+}
+
+// Error implements the error interface.
+func (e *CaptchaError) Error() string {
+	if len(e.Codes) == 0 {
+		return "gitkit: CAPTCHA check failed"
+	}
+	return "gitkit: CAPTCHA check failed: " + strings.Join(e.Codes, ", ")
+}
+
+// The siteverify endpoints of the common CAPTCHA services.
+const (
+	RecaptchaVerifyURL = "https://www.google.com/recaptcha/api/siteverify"
+	HCaptchaVerifyURL  = "https://api.hcaptcha.com/siteverify"
+	TurnstileVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+)
+
+// A SiteVerifyProvider is a CaptchaProvider verifying the responses with the
+// siteverify protocol shared by reCAPTCHA, hCaptcha and Turnstile.
+//
+// For example, with Turnstile,
+//
+//	conf.CaptchaProvider = &gitkit.SiteVerifyProvider{
+//		URL:    gitkit.TurnstileVerifyURL,
+//		Secret: secret,
+//	}
+type SiteVerifyProvider struct {
+	// URL is the siteverify endpoint, e.g., HCaptchaVerifyURL.
+	URL string
+	// Secret is the secret key of the site.
+	Secret string
+	// Client sends the verification requests. If nil, a client with the
+	// default transport of the context is used.
+	Client *http.Client
+}
+
+// VerifyCaptcha implements the CaptchaProvider interface. The challenge is
+// not used: the response identifies it.
+func (p *SiteVerifyProvider) VerifyCaptcha(ctx context.Context, challenge, response, remoteIP string) error {
+	if response == "" {
+		return &CaptchaError{[]string{"missing-input-response"}}
+	}
+	form := url.Values{"secret": {p.Secret}, "response": {response}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	hc := p.Client
+	if hc == nil {
+		hc = &http.Client{Transport: defaultTransport(ctx)}
+	}
+	req, err := http.NewRequest("POST", p.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gitkit: CAPTCHA verification failed with status %s", resp.Status)
+	}
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("gitkit: malformed CAPTCHA verification response: %v", err)
+	}
+	if !result.Success {
+		return &CaptchaError{result.ErrorCodes}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestSiteVerifyProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("secret") != "secret" || r.PostFormValue("remoteip") != "1.2.3.4" {
+			t.Errorf("verification request = %v; want the secret and the remote IP", r.PostForm)
+		}
+		if r.PostFormValue("response") == "valid" {
+			w.Write([]byte(`{"success":true}`))
+			return
+		}
+		w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
+	}))
+	defer srv.Close()
+	p := &SiteVerifyProvider{URL: srv.URL, Secret: "secret"}
+	ctx := context.Background()
+	if err := p.VerifyCaptcha(ctx, "", "valid", "1.2.3.4"); err != nil {
+		t.Errorf("VerifyCaptcha() of a valid response returns error: %v", err)
+	}
+	err := p.VerifyCaptcha(ctx, "", "forged", "1.2.3.4")
+	if e, ok := err.(*CaptchaError); !ok || len(e.Codes) != 1 || e.Codes[0] != "invalid-input-response" {
+		t.Errorf("VerifyCaptcha() of an invalid response returns error %v; want a *CaptchaError", err)
+	}
+	if code, status := ErrorCodeOf(err); code != ErrorCodeCaptchaCheckFailed || status != http.StatusBadRequest {
+		t.Errorf("ErrorCodeOf(%v) = %s, %d; want CAPTCHA_CHECK_FAILED, 400", err, code, status)
+	}
+	if _, ok := p.VerifyCaptcha(ctx, "", "", "1.2.3.4").(*CaptchaError); !ok {
+		t.Error("VerifyCaptcha() of a missing response returns no *CaptchaError")
+	}
+}
+
+// bodyRoundTripper records the bodies of the requests.
+type bodyRoundTripper struct {
+	roundTripper
+	bodies []string
+}
+
+func (r *bodyRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	b, _ := ioutil.ReadAll(req.Body)
+	r.bodies = append(r.bodies, string(b))
+	return r.roundTripper.RoundTrip(req)
+}
+
+func TestClient_GenerateResetPasswordOOBCode_captchaProvider(t *testing.T) {
+	rt := &bodyRoundTripper{roundTripper: roundTripper{200, `{"oobCode":"code"}`}}
+	c := &Client{
+		config: &Config{
+			CaptchaProvider: CaptchaProviderFunc(func(ctx context.Context, challenge, response, remoteIP string) error {
+				if response != "turnstile-token" {
+					return &CaptchaError{}
+				}
+				return nil
+			}),
+		},
+		api: &APIClient{Client: http.Client{Transport: rt}},
+	}
+	ctx := context.Background()
+	req := httptest.NewRequest("POST", "/oob", nil)
+	resp, err := c.GenerateResetPasswordOOBCode(ctx, req, "user@example.com", "", "turnstile-token")
+	if err != nil {
+		t.Fatalf("GenerateResetPasswordOOBCode() returns error: %v", err)
+	}
+	if resp.OOBCode != "code" {
+		t.Errorf("OOB code = %s; want code", resp.OOBCode)
+	}
+	if len(rt.bodies) != 1 || strings.Contains(rt.bodies[0], "captchaResp") {
+		t.Errorf("API requests = %v; want one without the CAPTCHA response", rt.bodies)
+	}
+	if _, err := c.GenerateResetPasswordOOBCode(ctx, req, "user@example.com", "", "forged"); err == nil {
+		t.Error("GenerateResetPasswordOOBCode() with a rejected CAPTCHA returns no error")
+	}
+	if len(rt.bodies) != 1 {
+		t.Errorf("%d API requests; want no request for the rejected CAPTCHA", len(rt.bodies))
+	}
+}
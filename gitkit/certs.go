@@ -18,36 +18,166 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/net/context"
 )
 
 // Certificates contains a set of availabe identitytoolkit public certificates
 // which are indexed by key IDs ("kid"). It caches the certificates according
 // to the HTTP response cache setting and refetches them upon cache expiring.
+// Concurrent refetches are deduplicated: the callers share a single download.
 // It is safe to use a Certificates from multiple concurrent goroutines.
 type Certificates struct {
 	URL string // Certificates URL.
+	// URLs are further certificates URLs, e.g., SecureTokenCertsURL. Their
+	// certificates are merged with those of URL by key ID, the earlier URL
+	// winning if two share a key ID, so one Certificates verifies the tokens
+	// of all of them. An update succeeds only if every URL is downloaded, and
+	// the merged certificates expire with the shortest cache time.
+	URLs []string
+	// HedgeDelay, if positive, enables request hedging: if the certificates
+	// are not downloaded within HedgeDelay, a second request is sent and the
+	// first successful response wins.
+	HedgeDelay time.Duration
+	// StaleGrace, if positive, is how long the expired certificates keep being
+	// served when they cannot be refreshed. Meanwhile, the refresh is retried
+	// in the background. Past the grace window, LoadIfNecessary fails if the
+	// certificates still cannot be refreshed.
+	StaleGrace time.Duration
+	// RefreshMargin, if positive, makes LoadIfNecessary refresh the
+	// certificates in the background once they expire within RefreshMargin,
+	// serving the cached ones meanwhile, so that no caller blocks on the
+	// download as long as the refresh succeeds before the expiration.
+	RefreshMargin time.Duration
+	// Manual, if true, disables the automatic refresh: LoadIfNecessary never
+	// downloads the certificates and they are only fetched by Refresh, e.g.,
+	// from a cron job. The certificates are served regardless of their cache
+	// expiration.
+	Manual bool
+	// Transport, if set, downloads the certificates instead of the transport
+	// given to LoadIfNecessary and the default transport used by Refresh.
+	Transport http.RoundTripper
+	// Logf, if set, receives the *CertificateError of the certificates which
+	// cannot be parsed and are skipped because the others of the response
+	// are valid.
+	Logf func(format string, args ...interface{})
+
+	certs      map[string]*x509.Certificate
+	mu         sync.RWMutex // Lock for updating the map
+	exp        time.Time    // Certificates expiration tiem.
+	refreshing bool         // Whether a background refresh is running.
+
+	fetchMu  sync.Mutex
+	fetch    *certsFetch     // The download in flight, if any.
+	life     context.Context // Context of the downloads, canceled by Close.
+	stopLife context.CancelFunc
+}
 
-	certs map[string]*x509.Certificate
-	mu    sync.RWMutex // Lock for updating the map
-	exp   time.Time    // Certificates expiration tiem.
+// certsFetch is a download of the certificates shared by the concurrent
+// updates.
+type certsFetch struct {
+	done chan struct{} // Closed when the download completes.
+	err  error
 }
 
+// SecureTokenCertsURL is the URL of the public certificates of the securetoken
+// service, which signs the ID tokens of the Firebase Authentication projects.
+const SecureTokenCertsURL = "https://www.googleapis.com/robot/v1/metadata/x509/securetoken@system.gserviceaccount.com"
+
+// staleRetryWait is the wait before the first background refresh of stale
+// certificates. It doubles after every failure, up to maxStaleRetryWait.
+var (
+	staleRetryWait    = time.Second
+	maxStaleRetryWait = time.Minute
+)
+
+// ErrCertsNotLoaded is returned by LoadIfNecessary when the certificates are
+// refreshed manually and Refresh has not succeeded yet.
+var ErrCertsNotLoaded = errors.New("gitkit: certificates not loaded; call Certificates.Refresh")
+
 // LoadIfNecessary downloads the certificates if there are no cached ones or the
 // cache expired.
 func (c *Certificates) LoadIfNecessary(transport http.RoundTripper) error {
-	if c.exp.Before(time.Now()) {
-		return c.update(transport)
+	return c.loadIfNecessary(context.Background(), transport)
+}
+
+// loadIfNecessary is like LoadIfNecessary, but stops waiting for the download
+// when ctx is done.
+func (c *Certificates) loadIfNecessary(ctx context.Context, transport http.RoundTripper) error {
+	if c.Transport != nil {
+		transport = c.Transport
 	}
+	c.mu.RLock()
+	exp, stale := c.exp, c.certs != nil
+	c.mu.RUnlock()
+	if c.Manual {
+		if !stale {
+			return ErrCertsNotLoaded
+		}
+		return nil
+	}
+	now := time.Now()
+	if !exp.Before(now) {
+		if stale && c.RefreshMargin > 0 && exp.Sub(now) < c.RefreshMargin {
+			// Refresh ahead of the expiration.
+			c.refreshInBackground(transport, exp.Add(c.StaleGrace))
+		}
+		return nil
+	}
+	cutoff := exp.Add(c.StaleGrace)
+	if !stale || c.StaleGrace <= 0 || !now.Before(cutoff) {
+		return c.update(ctx, transport)
+	}
+	// Serve the stale certificates while revalidating them.
+	c.refreshInBackground(transport, cutoff)
 	return nil
 }
 
+// refreshInBackground updates the certificates, retrying until it succeeds or
+// the cutoff time passes. At most one background refresh runs at a time.
+func (c *Certificates) refreshInBackground(transport http.RoundTripper, cutoff time.Time) {
+	life := c.lifetime()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refreshing || life.Err() != nil {
+		return
+	}
+	c.refreshing = true
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			c.refreshing = false
+			c.mu.Unlock()
+		}()
+		wait := staleRetryWait
+		for c.update(life, transport) != nil {
+			if d := cutoff.Sub(time.Now()); d < wait {
+				wait = d
+			}
+			if wait <= 0 {
+				return
+			}
+			select {
+			case <-time.After(wait):
+			case <-life.Done():
+				return
+			}
+			if wait *= 2; wait > maxStaleRetryWait {
+				wait = maxStaleRetryWait
+			}
+		}
+	}()
+}
+
 // Cert returns the public certificate for the given key ID.
 func (c *Certificates) Cert(keyID string) (*x509.Certificate, error) {
 	c.mu.RLock()
@@ -59,12 +189,82 @@ func (c *Certificates) Cert(keyID string) (*x509.Certificate, error) {
 	return cert, nil
 }
 
-// update fetches and caches the certificates.
-func (c *Certificates) update(transport http.RoundTripper) error {
-	certs, cacheTime, err := downloadCerts(c.URL, transport)
+// Refresh downloads the certificates now, regardless of the cache expiration.
+// It is the only way the certificates are fetched if Manual is set. It returns
+// when the download completes or ctx is done.
+func (c *Certificates) Refresh(ctx context.Context) error {
+	if c.Transport != nil {
+		return c.update(ctx, c.Transport)
+	}
+	return c.update(ctx, defaultTransport(ctx))
+}
+
+// Close stops the background refreshes of the certificates and aborts the
+// download in flight, if any. The cached certificates keep being served, but
+// are never downloaded again: LoadIfNecessary fails once they expire.
+func (c *Certificates) Close() error {
+	c.lifetime()
+	c.stopLife()
+	return nil
+}
+
+// lifetime returns the context of the downloads, which is canceled by Close.
+func (c *Certificates) lifetime() context.Context {
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+	if c.life == nil {
+		c.life, c.stopLife = context.WithCancel(context.Background())
+	}
+	return c.life
+}
+
+// update fetches and caches the certificates of all URLs, waiting until the
+// download completes or ctx is done. The concurrent calls share the download
+// in flight, if any, which is only canceled by Close.
+func (c *Certificates) update(ctx context.Context, transport http.RoundTripper) error {
+	life := c.lifetime()
+	c.fetchMu.Lock()
+	f := c.fetch
+	if f == nil {
+		f = &certsFetch{done: make(chan struct{})}
+		c.fetch = f
+		go func() {
+			f.err = c.download(life, transport)
+			c.fetchMu.Lock()
+			c.fetch = nil
+			c.fetchMu.Unlock()
+			close(f.done)
+		}()
+	}
+	c.fetchMu.Unlock()
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// download fetches and caches the certificates of all URLs.
+func (c *Certificates) download(ctx context.Context, transport http.RoundTripper) error {
+	certs, cacheTime, err := downloadCertsHedged(ctx, c.URL, transport, c.HedgeDelay, c.Logf)
 	if err != nil {
 		return err
 	}
+	for _, url := range c.URLs {
+		more, d, err := downloadCertsHedged(ctx, url, transport, c.HedgeDelay, c.Logf)
+		if err != nil {
+			return err
+		}
+		for k, v := range more {
+			if _, found := certs[k]; !found {
+				certs[k] = v
+			}
+		}
+		if d < cacheTime {
+			cacheTime = d
+		}
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.certs = certs
@@ -72,10 +272,61 @@ func (c *Certificates) update(transport http.RoundTripper) error {
 	return nil
 }
 
-// downloadCerts downloads and parses the certificates from the given URL.
-func downloadCerts(url string, transport http.RoundTripper) (map[string]*x509.Certificate, time.Duration, error) {
+// downloadResult is the outcome of a downloadCerts call.
+type downloadResult struct {
+	certs     map[string]*x509.Certificate
+	cacheTime time.Duration
+	err       error
+}
+
+// downloadCertsHedged downloads the certificates like downloadCerts. If delay
+// is positive and the first request neither succeeds nor fails within delay, a
+// second request is sent. The first successful response wins and the other
+// request is canceled.
+func downloadCertsHedged(ctx context.Context, url string, transport http.RoundTripper, delay time.Duration, logf func(string, ...interface{})) (map[string]*x509.Certificate, time.Duration, error) {
+	if delay <= 0 {
+		return downloadCerts(ctx, url, transport, logf)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan downloadResult, 2)
+	start := func() {
+		go func() {
+			certs, d, err := downloadCerts(ctx, url, transport, logf)
+			results <- downloadResult{certs, d, err}
+		}()
+	}
+	start()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending, hedged := 1, false
+	for {
+		select {
+		case <-timer.C:
+			hedged = true
+			pending++
+			start()
+		case r := <-results:
+			pending--
+			// Return the first success, or the error if no request is left.
+			// A failure before the delay is not hedged.
+			if r.err == nil || pending == 0 || !hedged {
+				return r.certs, r.cacheTime, r.err
+			}
+		}
+	}
+}
+
+// downloadCerts downloads and parses the certificates from the given URL. The
+// request is canceled when ctx is done. The invalid certificates are skipped,
+// and reported to logf if not nil, unless none is valid.
+func downloadCerts(ctx context.Context, url string, transport http.RoundTripper, logf func(string, ...interface{})) (map[string]*x509.Certificate, time.Duration, error) {
 	client := http.Client{Transport: transport}
-	resp, err := client.Get(url)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, 0, err
 	}
@@ -89,34 +340,101 @@ func downloadCerts(url string, transport http.RoundTripper) (map[string]*x509.Ce
 	}
 	certs, err := parseCerts(b)
 	if err != nil {
-		return nil, 0, err
+		if _, ok := err.(*CertificateError); !ok || len(certs) == 0 {
+			return nil, 0, err
+		}
+		if logf != nil {
+			logf("gitkit: skipping the invalid certificates of %s: %v", url, err)
+		}
 	}
 	return certs, cacheTime(resp), nil
 }
 
+// ParseCertificates creates a Certificates from the JSON encoding certificates
+// in the same format as the identitytoolkit public keys endpoint returns, e.g.,
+// a previously downloaded response. The returned Certificates never expires
+// and is never refetched. A *CertificateError naming the key IDs is returned
+// if any certificate cannot be parsed.
+func ParseCertificates(b []byte) (*Certificates, error) {
+	certs, err := parseCerts(b)
+	if err != nil {
+		return nil, err
+	}
+	return &Certificates{certs: certs, exp: neverExpire}, nil
+}
+
+// neverExpire is the expiration time of certificates that are not fetched from
+// an URL.
+var neverExpire = time.Unix(1<<62, 0)
+
 // parseCerts parses the JSON encoding certificates response.
 // The response has the following format:
 //	{
 //	  "40QoZg": "-----BEGIN CERTIFICATE-----...-----END CERTIFICATE-----",
 //	  "5DaIwB": "-----BEGIN CERTIFICATE-----...-----END CERTIFICATE-----"
 //	}
+//
+// The certificates which cannot be parsed are reported by a *CertificateError,
+// returned with the valid ones.
 func parseCerts(resp []byte) (map[string]*x509.Certificate, error) {
 	m := make(map[string]string)
 	if err := json.Unmarshal(resp, &m); err != nil {
 		return nil, err
 	}
 	certs := make(map[string]*x509.Certificate)
+	var certErr *CertificateError
 	for k, v := range m {
-		block, _ := pem.Decode([]byte(v))
-		c, err := x509.ParseCertificate(block.Bytes)
+		c, err := parseCertPEM(v)
 		if err != nil {
-			return nil, err
+			if certErr == nil {
+				certErr = &CertificateError{Errors: make(map[string]error)}
+			}
+			certErr.Errors[k] = err
+			continue
 		}
 		certs[k] = c
 	}
+	if certErr != nil {
+		return certs, certErr
+	}
 	return certs, nil
 }
 
+// parseCertPEM parses a PEM encoded certificate.
+func parseCertPEM(s string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+	if block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("PEM block of type %q, not CERTIFICATE", block.Type)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// A CertificateError reports the certificates of a public keys response which
+// cannot be parsed.
+type CertificateError struct {
+	// Errors are the parse errors by key ID.
+	Errors map[string]error
+}
+
+func (e *CertificateError) Error() string {
+	kids := make([]string, 0, len(e.Errors))
+	for kid := range e.Errors {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	msgs := make([]string, len(kids))
+	for i, kid := range kids {
+		msgs[i] = fmt.Sprintf("%q: %v", kid, e.Errors[kid])
+	}
+	if len(msgs) == 1 {
+		return "gitkit: invalid certificate of key ID " + msgs[0]
+	}
+	return "gitkit: invalid certificates of key IDs " + strings.Join(msgs, "; ")
+}
+
 const defaultCertsCacheTime = 1 * time.Hour
 
 // cacheTime extracts the cache time from the HTTP response header.
@@ -0,0 +1,103 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// A CertificateSource returns the certificates verifying the signatures of the
+// tokens by key ID. The identitytoolkit certificates downloaded by
+// *Certificates are the default source; StaticCertificates hold a fixed set,
+// e.g., for air-gapped deployments or unit tests. See
+// Config.CertificateSource.
+type CertificateSource interface {
+	Cert(keyID string) (*x509.Certificate, error)
+}
+
+// StaticCertificates is a CertificateSource of a fixed set of certificates
+// indexed by key ID. It is never refreshed.
+type StaticCertificates map[string]*x509.Certificate
+
+// Cert implements the CertificateSource interface.
+func (s StaticCertificates) Cert(keyID string) (*x509.Certificate, error) {
+	cert, ok := s[keyID]
+	if !ok {
+		return nil, fmt.Errorf("certificate not found for keyID: %s", keyID)
+	}
+	return cert, nil
+}
+
+// PEMKeyIDHeader is the PEM header giving the key ID of a certificate of a
+// bundle parsed by ParsePEMCertificates.
+const PEMKeyIDHeader = "Key-Id"
+
+// ParsePEMCertificates parses a bundle of PEM encoded certificates, e.g., a
+// local copy of the identitytoolkit certificates, into StaticCertificates.
+// Each CERTIFICATE block must give its key ID in a Key-Id header:
+//
+//	-----BEGIN CERTIFICATE-----
+//	Key-Id: 40QoZg
+//
+//	MIIDHDCCAgSgAwIBAgIIN...
+//	-----END CERTIFICATE-----
+//
+// The JSON encoded certificates of the public keys endpoint are parsed by
+// ParseCertificates instead.
+func ParsePEMCertificates(b []byte) (StaticCertificates, error) {
+	certs := make(StaticCertificates)
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		keyID := block.Headers[PEMKeyIDHeader]
+		if keyID == "" {
+			return nil, errors.New("gitkit: PEM certificate without a Key-Id header")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("gitkit: certificate %s: %v", keyID, err)
+		}
+		certs[keyID] = cert
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("gitkit: no PEM certificate found")
+	}
+	return certs, nil
+}
+
+// sourceKeyResolver is a KeyResolver of the public keys of the certificates
+// of a CertificateSource.
+type sourceKeyResolver struct {
+	src CertificateSource
+}
+
+// ResolveKey implements the KeyResolver interface.
+func (r sourceKeyResolver) ResolveKey(keyID, algorithm string) (crypto.PublicKey, error) {
+	cert, err := r.src.Cert(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return cert.PublicKey, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// keyedPEM adds a Key-Id header to the PEM encoded certificate.
+func keyedPEM(keyID, certPEM string) string {
+	return strings.Replace(certPEM, "-----\n", "-----\n"+PEMKeyIDHeader+": "+keyID+"\n\n", 1)
+}
+
+func TestParsePEMCertificates(t *testing.T) {
+	tests := []struct {
+		bundle  string
+		keyIDs  []string
+		wantErr bool
+	}{
+		{keyedPEM(testKeyID, testCertPEM), []string{testKeyID}, false},
+		{keyedPEM("a", testCertPEM) + "\n" + keyedPEM("b", testCertPEM), []string{"a", "b"}, false},
+		{testCertPEM, nil, true},
+		{"not PEM", nil, true},
+	}
+	for i, tt := range tests {
+		certs, err := ParsePEMCertificates([]byte(tt.bundle))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("[%d]: ParsePEMCertificates() returns no error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%d]: ParsePEMCertificates() returns error: %v", i, err)
+			continue
+		}
+		if len(certs) != len(tt.keyIDs) {
+			t.Errorf("[%d]: ParsePEMCertificates() returns %d certificates; want %d", i, len(certs), len(tt.keyIDs))
+		}
+		for _, k := range tt.keyIDs {
+			if _, err := certs.Cert(k); err != nil {
+				t.Errorf("[%d]: Cert(%q) returns error: %v", i, k, err)
+			}
+		}
+	}
+}
+
+func TestValidateToken_certificateSource(t *testing.T) {
+	certs, err := ParsePEMCertificates([]byte(keyedPEM(testKeyID, testCertPEM)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{
+		config: &Config{CertificateSource: certs},
+		// Downloading the identitytoolkit certificates fails.
+		certs: &Certificates{URL: publicCertsURL, Transport: roundTripper{http.StatusServiceUnavailable, ""}},
+	}
+	if _, err := c.ValidateToken(context.Background(), validToken, []string{audience}); err != nil {
+		t.Errorf("ValidateToken() returns error: %v", err)
+	}
+	c.config.CertificateSource = StaticCertificates{}
+	if _, err := c.ValidateToken(context.Background(), validToken, []string{audience}); err != ErrKeyNotFound {
+		t.Errorf("ValidateToken() with no certificate returns error %v; want %v", err, ErrKeyNotFound)
+	}
+}
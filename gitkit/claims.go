@@ -0,0 +1,157 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Claims returns all the claims of the token, including the custom ones which
+// have no Token field. JSON numbers are decoded as float64. The map is a copy
+// of the claims decoded when the token was verified, whose values are shared
+// and must not be modified.
+func (t *Token) Claims() (map[string]interface{}, error) {
+	claims, err := t.claimSet()
+	if err != nil {
+		return nil, err
+	}
+	cp := make(map[string]interface{}, len(claims))
+	for k, v := range claims {
+		cp[k] = v
+	}
+	return cp, nil
+}
+
+// claimSet returns the claims decoded by the verification, or else decodes
+// them from TokenString, e.g., for a Token built by a test. The result must
+// not be modified.
+func (t *Token) claimSet() (map[string]interface{}, error) {
+	if t.claims != nil {
+		return t.claims, nil
+	}
+	parts := strings.Split(t.TokenString, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	b, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return nil, ErrMalformed
+	}
+	return claims, nil
+}
+
+// A ClaimRequirement checks the claims of a token, returning a *ClaimError if
+// they don't meet the requirement. See Config.RequiredClaims and
+// TokenRequirement.Claims.
+type ClaimRequirement func(claims map[string]interface{}) error
+
+// ClaimError is returned when a token does not meet a ClaimRequirement.
+type ClaimError struct {
+	Claim  string // Name of the claim.
+	Reason string
+}
+
+// Error implements the error interface.
+func (e *ClaimError) Error() string {
+	return fmt.Sprintf("gitkit: claim %s %s", e.Claim, e.Reason)
+}
+
+// ClaimPresent requires the claim to be present.
+func ClaimPresent(name string) ClaimRequirement {
+	return func(claims map[string]interface{}) error {
+		if _, ok := claims[name]; !ok {
+			return &ClaimError{name, "is missing"}
+		}
+		return nil
+	}
+}
+
+// ClaimEquals requires the claim to be equal to value, once both are JSON
+// encoded, e.g., ClaimEquals("verified", true) requires a verified email
+// address.
+func ClaimEquals(name string, value interface{}) ClaimRequirement {
+	want := normalizeClaim(value)
+	return func(claims map[string]interface{}) error {
+		v, ok := claims[name]
+		if !ok {
+			return &ClaimError{name, "is missing"}
+		}
+		if !reflect.DeepEqual(v, want) {
+			return &ClaimError{name, fmt.Sprintf("is %v, not %v", v, want)}
+		}
+		return nil
+	}
+}
+
+// ClaimContains requires the claim to be an array containing value, e.g.,
+// ClaimContains("roles", "admin").
+func ClaimContains(name string, value interface{}) ClaimRequirement {
+	want := normalizeClaim(value)
+	return func(claims map[string]interface{}) error {
+		v, ok := claims[name]
+		if !ok {
+			return &ClaimError{name, "is missing"}
+		}
+		a, ok := v.([]interface{})
+		if !ok {
+			return &ClaimError{name, "is not an array"}
+		}
+		for _, e := range a {
+			if reflect.DeepEqual(e, want) {
+				return nil
+			}
+		}
+		return &ClaimError{name, fmt.Sprintf("does not contain %v", want)}
+	}
+}
+
+// normalizeClaim converts the value to its JSON decoded form, e.g., 1 to
+// float64(1), so that it compares equal to the decoded claims.
+func normalizeClaim(value interface{}) interface{} {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return value
+	}
+	return v
+}
+
+// CheckClaims returns the error of the first requirement the token does not
+// meet, or nil if it meets all of them.
+func CheckClaims(t *Token, reqs ...ClaimRequirement) error {
+	if len(reqs) == 0 {
+		return nil
+	}
+	claims, err := t.claimSet()
+	if err != nil {
+		return err
+	}
+	for _, r := range reqs {
+		if err := r(claims); err != nil {
+			return err
+		}
+	}
+	return nil
+}
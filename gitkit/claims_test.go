@@ -0,0 +1,141 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestTokenClaims(t *testing.T) {
+	claims, err := (&Token{TokenString: validToken}).Claims()
+	if err != nil {
+		t.Fatalf("Claims() returns error: %v", err)
+	}
+	if claims["verified"] != true || claims["provider_id"] != "google.com" || claims["iat"] != float64(1400437715) {
+		t.Errorf("Claims() = %v; want the claims of validToken", claims)
+	}
+	if _, err := (&Token{TokenString: malformedToken}).Claims(); err != ErrMalformed {
+		t.Errorf("Claims() of malformed token returns error %v; want %v", err, ErrMalformed)
+	}
+}
+
+func TestTokenClaims_verified(t *testing.T) {
+	token, err := VerifyToken(validToken, []string{audience}, nil, initCerts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The claims are decoded once, not from TokenString.
+	token.TokenString = malformedToken
+	claims, err := token.Claims()
+	if err != nil {
+		t.Fatalf("Claims() returns error: %v", err)
+	}
+	if claims["user_id"] != "16109857760607106080" || claims["display_name"] != "John Doe" {
+		t.Errorf("Claims() = %v; want the claims of validToken", claims)
+	}
+	claims["user_id"] = "other"
+	if again, _ := token.Claims(); again["user_id"] != "16109857760607106080" {
+		t.Errorf("Claims() after modifying its result = %v; want a copy", again)
+	}
+}
+
+func TestClaimRequirements(t *testing.T) {
+	claims := map[string]interface{}{
+		"verified": true,
+		"level":    float64(3),
+		"roles":    []interface{}{"editor", "admin"},
+	}
+	tests := []struct {
+		req ClaimRequirement
+		ok  bool
+	}{
+		{ClaimPresent("roles"), true},
+		{ClaimPresent("tenant"), false},
+		{ClaimEquals("verified", true), true},
+		{ClaimEquals("verified", false), false},
+		{ClaimEquals("level", 3), true},
+		{ClaimEquals("level", "3"), false},
+		{ClaimEquals("tenant", "a"), false},
+		{ClaimContains("roles", "admin"), true},
+		{ClaimContains("roles", "owner"), false},
+		{ClaimContains("level", 3), false},
+	}
+	for i, tt := range tests {
+		err := tt.req(claims)
+		if tt.ok && err != nil {
+			t.Errorf("%d. requirement returns error: %v", i, err)
+		}
+		if _, ok := err.(*ClaimError); !tt.ok && !ok {
+			t.Errorf("%d. requirement returns error %v; want *ClaimError", i, err)
+		}
+	}
+}
+
+func TestValidateToken_requiredClaims(t *testing.T) {
+	c := newMiddlewareClient()
+	c.config.RequiredClaims = []ClaimRequirement{ClaimEquals("verified", true)}
+	if _, err := c.ValidateToken(context.Background(), validToken, []string{audience}); err != nil {
+		t.Errorf("ValidateToken() returns error: %v", err)
+	}
+	c.config.RequiredClaims = append(c.config.RequiredClaims, ClaimPresent("role"))
+	if _, err := c.ValidateToken(context.Background(), validToken, []string{audience}); err == nil {
+		t.Error("ValidateToken() returns no error; want *ClaimError")
+	} else if e, ok := err.(*ClaimError); !ok || e.Claim != "role" {
+		t.Errorf("ValidateToken() returns error %v; want *ClaimError for role", err)
+	}
+}
+
+func TestRequireToken_claims(t *testing.T) {
+	c := newMiddlewareClient()
+	called := false
+	h := c.RequireToken([]string{audience}, func(w http.ResponseWriter, r *http.Request, t *Token) {
+		called = true
+	})
+	h.Claims = []ClaimRequirement{ClaimContains("roles", "admin")}
+	req, _ := http.NewRequest("GET", "http://example.com/admin", nil)
+	req.AddCookie(&http.Cookie{Name: "gtoken", Value: validToken})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || called {
+		t.Errorf("status = %d, handler called %v; want %d, not called", w.Code, called, http.StatusForbidden)
+	}
+}
+
+func TestValidateToken_requireVerifiedEmail(t *testing.T) {
+	c := newMiddlewareClient()
+	if _, err := c.ValidateToken(context.Background(), unverifiedEmailToken, []string{audience}); err != nil {
+		t.Errorf("ValidateToken() returns error: %v", err)
+	}
+	c.config.RequireVerifiedEmail = true
+	if _, err := c.ValidateToken(context.Background(), unverifiedEmailToken, []string{audience}); err != ErrEmailNotVerified {
+		t.Errorf("ValidateToken() returns error %v; want %v", err, ErrEmailNotVerified)
+	}
+	if _, err := c.ValidateToken(context.Background(), validToken, []string{audience}); err != nil {
+		t.Errorf("ValidateToken() returns error: %v", err)
+	}
+
+	h := c.RequireToken([]string{audience}, func(w http.ResponseWriter, r *http.Request, t *Token) {})
+	req, _ := http.NewRequest("GET", "http://example.com/account", nil)
+	req.AddCookie(&http.Cookie{Name: "gtoken", Value: unverifiedEmailToken})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d; want %d", w.Code, http.StatusUnauthorized)
+	}
+}
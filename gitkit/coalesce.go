@@ -0,0 +1,125 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// lookupBatcher coalesces the concurrent UserByLocalID lookups into batched
+// getAccountInfo calls. See Config.LookupBatchWindow.
+type lookupBatcher struct {
+	c       *Client
+	window  time.Duration // How long a batch collects lookups.
+	maxSize int           // Maximum number of local IDs of a batch.
+
+	mu      sync.Mutex
+	pending *lookupBatch // The batch collecting lookups, if any.
+}
+
+// lookupBatch is a getAccountInfo call shared by several lookups.
+type lookupBatch struct {
+	ctx      context.Context // Context of the first lookup.
+	localIDs []string
+	seen     map[string]bool
+	lookups  int
+	done     chan struct{} // Closed when users and err are set.
+	users    map[string]*User
+	err      error
+}
+
+func newLookupBatcher(c *Client, window time.Duration, maxSize int) *lookupBatcher {
+	if maxSize <= 0 || maxSize > maxLookupBatch {
+		maxSize = maxLookupBatch
+	}
+	return &lookupBatcher{c: c, window: window, maxSize: maxSize}
+}
+
+// lookup adds localID to the pending batch, starting one if necessary, and
+// waits for the batch to complete or ctx to be done.
+func (b *lookupBatcher) lookup(ctx context.Context, localID string) (*User, error) {
+	b.mu.Lock()
+	batch := b.pending
+	if batch == nil {
+		batch = &lookupBatch{ctx: ctx, seen: make(map[string]bool), done: make(chan struct{})}
+		b.pending = batch
+		time.AfterFunc(b.window, func() { b.flush(batch) })
+	}
+	batch.lookups++
+	if !batch.seen[localID] {
+		batch.seen[localID] = true
+		batch.localIDs = append(batch.localIDs, localID)
+	}
+	if len(batch.localIDs) >= b.maxSize {
+		b.pending = nil
+		go b.send(batch)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-batch.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if batch.err != nil {
+		return nil, batch.err
+	}
+	u, ok := batch.users[localID]
+	if !ok {
+		return nil, UserNotFoundError(localID)
+	}
+	return u, nil
+}
+
+// flush sends the batch when its window ends, unless it was sent full.
+func (b *lookupBatcher) flush(batch *lookupBatch) {
+	b.mu.Lock()
+	if b.pending != batch {
+		b.mu.Unlock()
+		return
+	}
+	b.pending = nil
+	b.mu.Unlock()
+	b.send(batch)
+}
+
+// send looks up the users of the batch. The call is detached from the
+// cancellation of the first lookup, which must not fail the other ones.
+func (b *lookupBatcher) send(batch *lookupBatch) {
+	defer close(batch.done)
+	b.c.count(MetricLookupsCoalesced, int64(batch.lookups-1))
+	resp, err := b.c.lookupAccountInfo(detachedContext{batch.ctx}, &GetAccountInfoRequest{LocalIDs: batch.localIDs})
+	if err != nil {
+		batch.err = err
+		return
+	}
+	batch.users = make(map[string]*User, len(resp.Users))
+	for _, u := range resp.Users {
+		batch.users[u.LocalID] = u
+	}
+}
+
+// detachedContext carries the values of its parent, e.g., the App Engine
+// request, but neither its deadline nor its cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
@@ -0,0 +1,119 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// accountsRoundTripper answers the getAccountInfo requests with the users of
+// the requested local IDs, except "missing", and records the requests.
+type accountsRoundTripper struct {
+	mu   sync.Mutex
+	reqs [][]string
+}
+
+func (r *accountsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var in GetAccountInfoRequest
+	json.NewDecoder(req.Body).Decode(&in)
+	r.mu.Lock()
+	r.reqs = append(r.reqs, in.LocalIDs)
+	r.mu.Unlock()
+	out := &GetAccountInfoResponse{}
+	for _, id := range in.LocalIDs {
+		if id != "missing" {
+			out.Users = append(out.Users, &User{LocalID: id, Email: id + "@example.com"})
+		}
+	}
+	b, _ := json.Marshal(out)
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(b))}, nil
+}
+
+func TestUserByLocalID_coalesced(t *testing.T) {
+	tests := []struct {
+		localIDs  []string
+		batchSize int
+		wantReqs  int
+	}{
+		{[]string{"1", "2", "3", "2", "missing"}, 0, 1},
+		{[]string{"1", "2", "3", "4"}, 2, 2},
+	}
+	for i, tt := range tests {
+		rt := &accountsRoundTripper{}
+		m := &counters{}
+		c := &Client{
+			config: &Config{Metrics: m},
+			api:    &APIClient{Client: http.Client{Transport: rt}},
+		}
+		c.lookups = newLookupBatcher(c, 20*time.Millisecond, tt.batchSize)
+		var wg sync.WaitGroup
+		for _, id := range tt.localIDs {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				u, err := c.UserByLocalID(context.Background(), id)
+				if id == "missing" {
+					if _, ok := err.(UserNotFoundError); !ok {
+						t.Errorf("[%d] UserByLocalID(%q) returns error %v; want UserNotFoundError", i, id, err)
+					}
+					return
+				}
+				if err != nil || u.LocalID != id {
+					t.Errorf("[%d] UserByLocalID(%q) = %v, %v; want user %s", i, id, u, err, id)
+				}
+			}(id)
+		}
+		wg.Wait()
+		if len(rt.reqs) != tt.wantReqs {
+			t.Errorf("[%d] %d requests sent (%v); want %d", i, len(rt.reqs), rt.reqs, tt.wantReqs)
+		}
+		if got, want := m.m[MetricLookupsCoalesced], int64(len(tt.localIDs)-tt.wantReqs); got != want {
+			t.Errorf("[%d] %s = %d; want %d", i, MetricLookupsCoalesced, got, want)
+		}
+	}
+}
+
+func TestUserByLocalID_coalescedCanceled(t *testing.T) {
+	rt := &accountsRoundTripper{}
+	c := &Client{config: &Config{}, api: &APIClient{Client: http.Client{Transport: rt}}}
+	c.lookups = newLookupBatcher(c, 20*time.Millisecond, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := c.UserByLocalID(ctx, "1")
+		errc <- err
+	}()
+	// Let the canceled lookup start the batch.
+	time.Sleep(5 * time.Millisecond)
+	cancel()
+	u, err := c.UserByLocalID(context.Background(), "2")
+	if err != nil || u.LocalID != "2" {
+		t.Errorf("UserByLocalID() = %v, %v; want user 2", u, err)
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("canceled UserByLocalID() returns error %v; want %v", err, context.Canceled)
+	}
+	if len(rt.reqs) != 1 || len(rt.reqs[0]) != 2 {
+		t.Errorf("requests = %v; want one for both users", rt.reqs)
+	}
+}
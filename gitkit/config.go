@@ -16,10 +16,20 @@ package gitkit
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
 )
 
 // Config contains the configurations for creating a Client.
+//
+// The durations of the JSON configurations, e.g., "clockSkew", are strings
+// accepted by time.ParseDuration such as "5m", or integer nanoseconds.
 type Config struct {
 	// WidgetURL is the identitytoolkit javascript widget URL.
 	// It is used to generate the reset password or change email URL and
@@ -30,14 +40,297 @@ type Config struct {
 	// to other value in the javascript widget, this field should be set to the
 	// same value.
 	WidgetModeParamName string `json:"widgetModeParamName,omitempty"`
+	// BaseURL is the scheme and host the site is served from, e.g.,
+	// https://www.example.com. If set, it is used instead of the request to
+	// build the absolute OOB code and sign in URLs.
+	BaseURL string `json:"baseUrl,omitempty"`
+	// TrustedProxies are the IP addresses or CIDR ranges of the reverse
+	// proxies, e.g., TLS terminating load balancers, whose X-Forwarded-Proto,
+	// X-Forwarded-Host and X-Forwarded-For headers are trusted to build the
+	// URLs and find the IP address of the users.
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+	// AllowedOrigins are the origins, e.g., https://www.example.com, besides
+	// the site itself, allowed to post the OOB code requests handled by
+	// GenerateOOBCode. If empty, the origin is not checked.
+	AllowedOrigins []string `json:"allowedOrigins,omitempty"`
 	// CookieName is the name of the cookie that stores the ID token.
 	CookieName string `json:"cookieName,omitempty"`
+	// LegacyCookieNames are the former names of the cookie, still checked
+	// in order by TokenFromRequest after CookieName during a cookie rename.
+	// SetTokenCookie only writes CookieName and deletes them.
+	LegacyCookieNames []string `json:"legacyCookieNames,omitempty"`
+	// TokenExtractor, if set, finds the ID tokens in the requests for
+	// TokenFromRequest and RequireToken instead of the CookieName cookie, e.g.,
+	//
+	//	TokenExtractor: gitkit.TokenExtractors{gitkit.BearerExtractor{}, gitkit.CookieExtractor("gtoken")}
+	//
+	// accepts the tokens of mobile apps as well as browsers.
+	TokenExtractor TokenExtractor `json:"-"`
+	// Audiences are the OAuth client IDs of the app, e.g., its web, Android
+	// and iOS clients. ValidateToken, RequireToken and UserByToken accept the
+	// tokens issued for any of them when they are given no audiences and
+	// AudiencesByHost has none for the request host.
+	Audiences []string `json:"audiences,omitempty"`
+	// AudiencesByHost maps request host names, without ports, to the OAuth
+	// client IDs the ID tokens of the requests to that host must be issued
+	// for. It lets a gateway serving several apps validate tokens without a
+	// global audience: ValidateToken and RequireToken use it when they are
+	// given no audiences.
+	AudiencesByHost map[string][]string `json:"audiencesByHost,omitempty"`
+	// OOBCodeURLKey, if set, is the HMAC key which signs the OOB code URLs
+	// along with an expiration time, so that VerifyOOBCodeURL rejects the
+	// links leaked in logs or forwarded emails once they expire.
+	OOBCodeURLKey []byte `json:"oobCodeUrlKey,omitempty"`
+	// OOBCodeURLLifetime is the lifetime of the signed OOB code URLs.
+	// DefaultOOBCodeURLLifetime is used if it is not set.
+	OOBCodeURLLifetime time.Duration `json:"oobCodeUrlLifetime,omitempty"`
+	// RequireVerifiedEmail makes ValidateToken, and thus RequireToken and
+	// UserByToken, reject the tokens whose email address is not verified, so
+	// that the unverified users are treated as not signed in.
+	RequireVerifiedEmail bool `json:"requireVerifiedEmail,omitempty"`
+	// KeyResolver, if set, resolves the public keys verifying the signatures
+	// of the tokens validated by ValidateToken instead of the identitytoolkit
+	// certificates, which are not downloaded then, e.g., StaticKeys of the
+	// public keys held by Cloud KMS.
+	KeyResolver KeyResolver `json:"-"`
+	// CertificateSource, if set, returns the certificates verifying the
+	// signatures of the tokens validated by ValidateToken instead of the
+	// downloaded identitytoolkit certificates, e.g., StaticCertificates parsed
+	// from a local PEM bundle. KeyResolver takes precedence.
+	CertificateSource CertificateSource `json:"-"`
+	// EnrichProfiles makes VerifyAssertion fetch the profile of the user from
+	// the userinfo endpoint of the IDP, see Client.EnrichProfile.
+	EnrichProfiles bool `json:"enrichProfiles,omitempty"`
+	// UserInfoEndpoints add to or override the userinfo endpoints of
+	// UserInfoEndpoints by provider ID.
+	UserInfoEndpoints map[string]string `json:"userInfoEndpoints,omitempty"`
+	// UserFromTokenFallback makes UserByToken return the user built from the
+	// token claims by UserFromToken when the account information can't be
+	// retrieved, so that signing in keeps working through API outages.
+	UserFromTokenFallback bool `json:"userFromTokenFallback,omitempty"`
+	// CustomTokenSigner, if set, signs the custom tokens minted by
+	// CustomToken instead of the private key of the service account
+	// credentials, e.g., NewIAMSigner when Application Default Credentials
+	// carry no private key. ServiceAccountEmail must be set then.
+	CustomTokenSigner Signer `json:"-"`
+	// ServiceAccountEmail is the service account the custom tokens signed by
+	// CustomTokenSigner are issued by.
+	ServiceAccountEmail string `json:"serviceAccountEmail,omitempty"`
+	// LookupHedgeDelay, if positive, hedges the account lookups of
+	// UserByToken, UserByLocalID and UserByEmail, which are on the sign in
+	// path: a second request is sent if the first one is not answered within
+	// LookupHedgeDelay, e.g., the P99 latency, or fails sooner with a server
+	// or network error. The first successful response is used and at most two
+	// requests are sent per lookup.
+	LookupHedgeDelay time.Duration `json:"lookupHedgeDelay,omitempty"`
+	// LookupBatchWindow, if positive, coalesces the concurrent UserByLocalID
+	// lookups, including those of UserByToken, into batched getAccountInfo
+	// calls: a batch collects the lookups started within LookupBatchWindow,
+	// e.g., a few milliseconds, or until it has LookupBatchSize local IDs.
+	LookupBatchWindow time.Duration `json:"lookupBatchWindow,omitempty"`
+	// LookupBatchSize is the maximum number of local IDs of a batched lookup,
+	// 100 if it is not set or larger.
+	LookupBatchSize int `json:"lookupBatchSize,omitempty"`
+	// TokenCacheSize, if positive, is the number of verified ID tokens
+	// ValidateToken caches, so that the signature of a token sent with every
+	// request of a session is only verified once. The least recently used
+	// tokens are evicted first. The cached tokens remain valid until they
+	// expire, even if the key they are signed with is revoked in the meantime.
+	TokenCacheSize int `json:"tokenCacheSize,omitempty"`
+	// TokenCacheTTL, if positive, is the maximum time a token is cached,
+	// which is otherwise until it expires.
+	TokenCacheTTL time.Duration `json:"tokenCacheTtl,omitempty"`
+	// RequiredClaims are checked by ValidateToken, and thus RequireToken and
+	// UserByToken, on the valid tokens, e.g.,
+	//
+	//	RequiredClaims: []gitkit.ClaimRequirement{gitkit.ClaimEquals("verified", true)}
+	//
+	// rejects the tokens of users whose email address is not verified.
+	RequiredClaims []ClaimRequirement `json:"-"`
+	// RoleMapper, if set, maps the claims of the tokens validated by
+	// ValidateToken to the application roles of Token.Roles. See
+	// RequireRole.
+	RoleMapper *RoleMapper `json:"roleMapper,omitempty"`
+	// ReturnURLKey is the HMAC key which signs the return URLs added to the
+	// sign in URLs built by SignInURL. It is required to redirect browsers to
+	// the widget in RequireToken.
+	ReturnURLKey []byte `json:"returnUrlKey,omitempty"`
 	// GoogleAppCredentialsPath is the path of the service account JSON key file
 	// downloaded from Google cloud console.
 	// Only specify it if you cannot use Google Application Default Credentials.
 	// See https://developers.google.com/identity/protocols/application-default-credentials
 	// for more details about Application Default Credentials.
-	GoogleAppCredentialsPath string `json:"googleAppCredentialsPath",omitempty"`
+	GoogleAppCredentialsPath string `json:"googleAppCredentialsPath,omitempty"`
+	// GoogleAppCredentialsJSON is the content of the service account JSON key
+	// file. It can be used instead of GoogleAppCredentialsPath when the key is
+	// kept in a secret manager rather than on disk. It is never loaded from or
+	// written to a configuration file.
+	//
+	// At most one of GoogleAppCredentialsPath, GoogleAppCredentialsJSON,
+	// JWTConfig and TokenSource can be set.
+	GoogleAppCredentialsJSON []byte `json:"-"`
+	// JWTConfig is a service account configuration already built by the
+	// caller. If set, it is used as is to access identitytoolkit API.
+	JWTConfig *jwt.Config `json:"-"`
+	// TokenSource provides the OAuth2 tokens used to access identitytoolkit
+	// API. It allows callers who manage Google credentials themselves, e.g.
+	// with custom token caching, to bypass the built-in credential loading.
+	// The tokens must carry the identitytoolkit scope.
+	TokenSource oauth2.TokenSource `json:"-"`
+	// HTTPClient, if set, is the HTTP client, e.g., with proxy settings or a
+	// custom TLS configuration, whose transport sends the identitytoolkit API
+	// requests, fetches the OAuth2 tokens and downloads the public
+	// certificates. Its timeout applies to the API requests. The middlewares
+	// and retries configured here wrap its transport.
+	HTTPClient *http.Client `json:"-"`
+	// MaxConcurrentRequests limits the number of identitytoolkit API requests
+	// the Client keeps in flight at the same time. Further requests wait until
+	// a previous one completes. Zero means no limit.
+	MaxConcurrentRequests int `json:"maxConcurrentRequests,omitempty"`
+	// Metrics, if set, receives the counters of the identitytoolkit API
+	// requests, retries and bytes transferred, and of the users listed,
+	// uploaded and updated, e.g., to monitor long running exports. See the
+	// Metric constants for the counter names.
+	Metrics Metrics `json:"-"`
+	// MaxRetries is the number of times an identitytoolkit API request is
+	// retried after a network error or a 429, 500, 502, 503 or 504 response.
+	// The Retry-After header of 429 and 503 responses is honored, up to
+	// MaxRetryWait. Zero disables retrying.
+	MaxRetries int `json:"maxRetries,omitempty"`
+	// MaxRetryWait bounds the wait before a retry. DefaultMaxRetryWait is used
+	// if it is zero.
+	MaxRetryWait time.Duration `json:"maxRetryWait,omitempty"`
+	// RetryPolicy, if set, controls the retries of the identitytoolkit API
+	// requests instead of MaxRetries and MaxRetryWait, e.g., to change the
+	// backoff or the retryable statuses.
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+	// TransportMiddlewares wrap the transport of the identitytoolkit API
+	// requests, the first one being the outermost. They run inside the retry
+	// and concurrency limit layers, so they see every attempt, and outside
+	// the user agent and auth layers. See also LoggingMiddleware.
+	TransportMiddlewares []TransportMiddleware `json:"-"`
+	// Endpoint, if set, selects where the identitytoolkit API requests are
+	// sent, e.g., {"baseUrl": IdentityPlatformAPIBaseURL} to migrate off the
+	// legacy host, with per method overrides. Its BaseURL becomes the
+	// APIClient.BaseURL of the Client, so each Client can target its own
+	// staging or fake server, instead of the package wide APIBaseURI.
+	Endpoint *Endpoint `json:"endpoint,omitempty"`
+	// OnDeprecation, if set, receives the first deprecation notice of each
+	// API method. Otherwise, the notices are reported to Logf, if set.
+	OnDeprecation func(*DeprecationNotice) `json:"-"`
+	// UnknownFields, if set, checks the API responses for fields the
+	// response types do not model, e.g., new user fields: UnknownFieldsLog
+	// reports them to Logf, UnknownFieldsReject fails the calls too. See
+	// UnknownFieldsMiddleware.
+	UnknownFields UnknownFieldsMode `json:"unknownFields,omitempty"`
+	// APIVersion selects the version of the identitytoolkit API the requests
+	// are sent to: APIv3, the default, or APIv1 to target the Identity
+	// Platform accounts API early, in which case Endpoint does not apply.
+	// See APIVersionMiddleware.
+	APIVersion string `json:"apiVersion,omitempty"`
+	// ProjectID is the ID of the Google cloud project, which the project
+	// methods of APIv1, e.g., the account uploads, need.
+	ProjectID string `json:"projectId,omitempty"`
+	// Preset, if set, configures the Client for the environment issuing the
+	// ID tokens, e.g., PresetFirebaseAuth: the issuers, the public
+	// certificates URL, and the API endpoint and version, unless set
+	// otherwise. All presets but PresetGitkit need the ProjectID.
+	Preset Preset `json:"preset,omitempty"`
+	// Issuers, if set, are the accepted issuers of the ID tokens validated by
+	// ValidateToken, e.g., GitkitIssuer. Otherwise, the issuer is not checked.
+	Issuers []string `json:"issuers,omitempty"`
+	// ClockSkew is the leeway of the expiration and issue times of the ID
+	// tokens validated by ValidateToken, DefaultClockSkew if zero. A negative
+	// value disables the leeway.
+	ClockSkew time.Duration `json:"clockSkew,omitempty"`
+	// CertsURLs are further public certificates URLs, e.g.,
+	// SecureTokenCertsURL or those of the session cookie keys, merged with the
+	// identitytoolkit certificates by key ID, so ValidateToken accepts the
+	// tokens signed by any of them. See Certificates.URLs.
+	CertsURLs []string `json:"certsUrls,omitempty"`
+	// CertsHedgeDelay, if positive, hedges the downloads of the public
+	// certificates: a second request is sent if the first one is not answered
+	// within CertsHedgeDelay, and the first successful response is used.
+	CertsHedgeDelay time.Duration `json:"certsHedgeDelay,omitempty"`
+	// CertsStaleGrace, if positive, is how long the expired public
+	// certificates keep validating tokens when they cannot be refreshed,
+	// while the refresh is retried in the background. See
+	// Certificates.StaleGrace.
+	CertsStaleGrace time.Duration `json:"certsStaleGrace,omitempty"`
+	// CertsRefreshMargin, if positive, refreshes the public certificates in
+	// the background when they expire within CertsRefreshMargin, so that no
+	// token validation waits for the download. See
+	// Certificates.RefreshMargin.
+	CertsRefreshMargin time.Duration `json:"certsRefreshMargin,omitempty"`
+	// ManualCertsRefresh disables the automatic download of the public
+	// certificates. They are only fetched when Certificates.Refresh is called
+	// on Client.Certificates, e.g., from a cron job on App Engine, and token
+	// validation fails until the first refresh succeeds.
+	ManualCertsRefresh bool `json:"manualCertsRefresh,omitempty"`
+	// PrewarmCerts makes New start downloading the public certificates in
+	// the background, so the first token validation does not wait for them.
+	// The download also serves as the first refresh if ManualCertsRefresh is
+	// set. See Client.WaitReady.
+	PrewarmCerts bool `json:"prewarmCerts,omitempty"`
+	// Logf, if set, receives the diagnostic messages of the Client, e.g., the
+	// wait before a request is retried.
+	Logf func(format string, args ...interface{}) `json:"-"`
+	// ActingAdmin identifies the admin on whose behalf the mutating calls of
+	// the Client are made, e.g., an email address. It can be overridden per
+	// call with WithActingAdmin.
+	ActingAdmin string `json:"actingAdmin,omitempty"`
+	// ActingAdminHeader, if set, is the name of the HTTP header which carries
+	// the acting admin in the mutating identitytoolkit API requests.
+	ActingAdminHeader string `json:"actingAdminHeader,omitempty"`
+	// EmailPolicy, if set, is checked before OOB codes are generated for an
+	// email address and before users are uploaded or created. The uploaded
+	// users it rejects are reported as failed with UploadErrorEmailPolicy.
+	// See DomainBlocklist.
+	EmailPolicy EmailPolicy `json:"-"`
+	// CaptchaProvider, if set, verifies the CAPTCHA responses of the reset
+	// password requests instead of identitytoolkit, e.g., a
+	// SiteVerifyProvider of hCaptcha or Turnstile. The responses it rejects
+	// fail with a *CaptchaError.
+	CaptchaProvider CaptchaProvider `json:"-"`
+	// RecordLinkHistory, if set, records the providers linked to and
+	// unlinked from the users retrieved by UserByToken in their custom
+	// attributes. See RecordProviderLinks.
+	RecordLinkHistory bool `json:"recordLinkHistory,omitempty"`
+	// OOBWebhook, if set, receives the OOB codes generated by GenerateOOBCode
+	// as signed OOBCodeEvents, e.g., a custom mailer sending the emails.
+	OOBWebhook *Webhook `json:"oobWebhook,omitempty"`
+	// EmailValidator, if set, checks the email addresses the reset password
+	// and verify email OOB codes, and the new address of the change email OOB
+	// codes, are generated for. The codes are not generated for rejected
+	// addresses, which saves quota and sender reputation. See
+	// SyntaxEmailValidator and MXEmailValidator.
+	EmailValidator EmailValidator `json:"-"`
+	// PasswordPolicy, if set, checks the new passwords set by ResetPassword
+	// and CreateUser before they are sent to identitytoolkit. See
+	// PasswordRules.
+	PasswordPolicy PasswordPolicy `json:"-"`
+	// PasswordLockout, if set, locks out the email addresses with too many
+	// consecutive failed SignInWithPassword attempts.
+	PasswordLockout *LockoutPolicy `json:"-"`
+	// RequestHeaders, if set, returns the headers stamped on every
+	// identitytoolkit API request made with the context, e.g., correlation
+	// IDs. See CorrelationHeaders.
+	RequestHeaders func(context.Context) http.Header `json:"-"`
+	// AuditHook, if set, is called after every mutating call of the Client.
+	AuditHook func(context.Context, *AuditRecord) `json:"-"`
+	// OnUserCreated, if set, is called with each user successfully uploaded by
+	// UploadUsers or created by CreateUser.
+	OnUserCreated func(context.Context, *User) `json:"-"`
+	// OnUserUpdated, if set, is called with the user after UpdateUser succeeds.
+	OnUserUpdated func(context.Context, *User) `json:"-"`
+	// OnUserDeleted, if set, is called with the user after DeleteUser succeeds.
+	OnUserDeleted func(context.Context, *User) `json:"-"`
+	// OnEmailChanged, if set, is called after ApplyEmailChange changes the
+	// email address of a user, e.g., to notify the old address.
+	OnEmailChanged func(context.Context, *EmailChange) `json:"-"`
+	// SessionRevoker, if set, revokes the sessions of the users whose email
+	// address is changed by ApplyEmailChange. See the session subpackage.
+	SessionRevoker SessionRevoker `json:"-"`
 }
 
 // LoadConfig loads the configuration from the config file specified by path.
@@ -53,9 +346,72 @@ func LoadConfig(path string) (*Config, error) {
 	return &c, nil
 }
 
+// Duration is a time.Duration encoded in JSON as a string accepted by
+// time.ParseDuration, e.g., "1m30s". An integer number of nanoseconds is
+// also accepted.
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes a duration string or a number of nanoseconds.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(b, &n); err != nil {
+			return fmt.Errorf("gitkit: invalid duration %s", b)
+		}
+		*d = Duration(n)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("gitkit: invalid duration %q: %v", s, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// configJSON has the fields of Config without its UnmarshalJSON method.
+type configJSON Config
+
+// UnmarshalJSON decodes the JSON configuration, whose durations are Duration
+// strings.
+func (conf *Config) UnmarshalJSON(b []byte) error {
+	aux := struct {
+		*configJSON
+		OOBCodeURLLifetime Duration `json:"oobCodeUrlLifetime,omitempty"`
+		LookupHedgeDelay   Duration `json:"lookupHedgeDelay,omitempty"`
+		LookupBatchWindow  Duration `json:"lookupBatchWindow,omitempty"`
+		TokenCacheTTL      Duration `json:"tokenCacheTtl,omitempty"`
+		MaxRetryWait       Duration `json:"maxRetryWait,omitempty"`
+		ClockSkew          Duration `json:"clockSkew,omitempty"`
+		CertsHedgeDelay    Duration `json:"certsHedgeDelay,omitempty"`
+		CertsStaleGrace    Duration `json:"certsStaleGrace,omitempty"`
+		CertsRefreshMargin Duration `json:"certsRefreshMargin,omitempty"`
+	}{configJSON: (*configJSON)(conf)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	conf.OOBCodeURLLifetime = time.Duration(aux.OOBCodeURLLifetime)
+	conf.LookupHedgeDelay = time.Duration(aux.LookupHedgeDelay)
+	conf.LookupBatchWindow = time.Duration(aux.LookupBatchWindow)
+	conf.TokenCacheTTL = time.Duration(aux.TokenCacheTTL)
+	conf.MaxRetryWait = time.Duration(aux.MaxRetryWait)
+	conf.ClockSkew = time.Duration(aux.ClockSkew)
+	conf.CertsHedgeDelay = time.Duration(aux.CertsHedgeDelay)
+	conf.CertsStaleGrace = time.Duration(aux.CertsStaleGrace)
+	conf.CertsRefreshMargin = time.Duration(aux.CertsRefreshMargin)
+	return nil
+}
+
 const (
 	DefaultWidgetModeParamName = "mode"
 	DefaultCookieName          = "gtoken"
+	DefaultMaxRetryWait        = 30 * time.Second
 )
 
 func (conf *Config) normalize() {
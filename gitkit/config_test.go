@@ -1,10 +1,12 @@
 package gitkit
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"reflect"
 	"testing"
+	"time"
 )
 
 const (
@@ -46,7 +48,7 @@ func TestLoadConfig(t *testing.T) {
 	tests := []struct {
 		config string
 	}{{config}, {configWithUnrecognized}}
-	conf := Config{"widget_url", "widget_mode_param_name", "cookie_name", "/some/path"}
+	conf := Config{WidgetURL: "widget_url", WidgetModeParamName: "widget_mode_param_name", CookieName: "cookie_name", GoogleAppCredentialsPath: "/some/path"}
 	for i, tt := range tests {
 		f, err := createConfigFile(tt.config)
 		if err != nil {
@@ -62,34 +64,86 @@ func TestLoadConfig(t *testing.T) {
 	}
 }
 
+func TestLoadConfig_durations(t *testing.T) {
+	f, err := createConfigFile(`{
+		"cookieName": "cookie_name",
+		"clockSkew": "2m",
+		"oobCodeUrlLifetime": "1h30m",
+		"tokenCacheTtl": 1000000000,
+		"certsRefreshMargin": "10s",
+		"retryPolicy": {"maxRetries": 3, "baseWait": "250ms", "maxWait": "5s"}
+	}`)
+	if err != nil {
+		t.Fatal("cannot create temp config file")
+	}
+	defer os.Remove(f)
+	c, err := LoadConfig(f)
+	if err != nil {
+		t.Fatalf("LoadConfig() returns error: %v", err)
+	}
+	want := Config{
+		CookieName:         "cookie_name",
+		ClockSkew:          2 * time.Minute,
+		OOBCodeURLLifetime: 90 * time.Minute,
+		TokenCacheTTL:      time.Second,
+		CertsRefreshMargin: 10 * time.Second,
+		RetryPolicy:        &RetryPolicy{MaxRetries: 3, BaseWait: 250 * time.Millisecond, MaxWait: 5 * time.Second},
+	}
+	if !reflect.DeepEqual(*c, want) {
+		t.Errorf("LoadConfig() = %+v; want %+v", c, want)
+	}
+
+	for _, config := range []string{`{"clockSkew": "2 minutes"}`, `{"retryPolicy": {"maxWait": true}}`} {
+		f, err := createConfigFile(config)
+		if err != nil {
+			t.Fatal("cannot create temp config file")
+		}
+		defer os.Remove(f)
+		if _, err := LoadConfig(f); err == nil {
+			t.Errorf("LoadConfig(%s) returns no error", config)
+		}
+	}
+}
+
+func TestDuration_MarshalJSON(t *testing.T) {
+	b, err := json.Marshal(Duration(90 * time.Second))
+	if err != nil || string(b) != `"1m30s"` {
+		t.Errorf("Marshal() = %s, %v; want \"1m30s\"", b, err)
+	}
+	var d Duration
+	if err := json.Unmarshal(b, &d); err != nil || d != Duration(90*time.Second) {
+		t.Errorf("Unmarshal(%s) = %v, %v; want 1m30s", b, time.Duration(d), err)
+	}
+}
+
 func TestConfig_normalize(t *testing.T) {
 	tests := []struct {
 		orig       *Config
 		normalized *Config
 	}{
 		{
-			&Config{"/", "mode", "gtoken", ""},
-			&Config{"/", "mode", "gtoken", ""},
+			&Config{WidgetURL: "/", WidgetModeParamName: "mode", CookieName: "gtoken"},
+			&Config{WidgetURL: "/", WidgetModeParamName: "mode", CookieName: "gtoken"},
 		},
 		{
-			&Config{"/", "", "", ""},
-			&Config{"/", "mode", "gtoken", ""},
+			&Config{WidgetURL: "/"},
+			&Config{WidgetURL: "/", WidgetModeParamName: "mode", CookieName: "gtoken"},
 		},
 		{
-			&Config{"/", "mode", "gtoken", "/some/path"},
-			&Config{"/", "mode", "gtoken", "/some/path"},
+			&Config{WidgetURL: "/", WidgetModeParamName: "mode", CookieName: "gtoken", GoogleAppCredentialsPath: "/some/path"},
+			&Config{WidgetURL: "/", WidgetModeParamName: "mode", CookieName: "gtoken", GoogleAppCredentialsPath: "/some/path"},
 		},
 		{
-			&Config{"/", "", "gitkittoken", ""},
-			&Config{"/", "mode", "gitkittoken", ""},
+			&Config{WidgetURL: "/", CookieName: "gitkittoken"},
+			&Config{WidgetURL: "/", WidgetModeParamName: "mode", CookieName: "gitkittoken"},
 		},
 		{
-			&Config{"/", "gitkitmode", "", ""},
-			&Config{"/", "gitkitmode", "gtoken", ""},
+			&Config{WidgetURL: "/", WidgetModeParamName: "gitkitmode"},
+			&Config{WidgetURL: "/", WidgetModeParamName: "gitkitmode", CookieName: "gtoken"},
 		},
 		{
-			&Config{"/", "gitkitmode", "gitkittoken", ""},
-			&Config{"/", "gitkitmode", "gitkittoken", ""},
+			&Config{WidgetURL: "/", WidgetModeParamName: "gitkitmode", CookieName: "gitkittoken"},
+			&Config{WidgetURL: "/", WidgetModeParamName: "gitkitmode", CookieName: "gitkittoken"},
 		},
 	}
 	for i, tt := range tests {
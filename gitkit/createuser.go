@@ -0,0 +1,55 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"golang.org/x/net/context"
+)
+
+// CreateUser creates a password account from the server, e.g., for a backend
+// which does not use the widget, and returns the local ID of the new user and
+// an ID token signing the user in, which the caller may ignore. The email
+// address must be allowed by Config.EmailPolicy and the password by
+// Config.PasswordPolicy, if set. Config.OnUserCreated is called with the new
+// user.
+func (c *Client) CreateUser(ctx context.Context, email, password, displayName string) (string, string, error) {
+	if err := c.allowEmail(ctx, email); err != nil {
+		return "", "", err
+	}
+	if err := c.checkPassword(ctx, password); err != nil {
+		return "", "", err
+	}
+	resp, err := c.mutatingAPIClient(ctx).SignupNewUser(ctx, &SignupNewUserRequest{
+		Email:       email,
+		Password:    password,
+		DisplayName: displayName,
+	})
+	var localIDs []string
+	if err == nil {
+		localIDs = []string{resp.LocalID}
+	}
+	c.audit(ctx, AuditOpCreateUser, localIDs, err)
+	if err != nil {
+		return "", "", err
+	}
+	if c.config.OnUserCreated != nil {
+		c.config.OnUserCreated(ctx, &User{
+			LocalID:     resp.LocalID,
+			Email:       resp.Email,
+			DisplayName: resp.DisplayName,
+		})
+	}
+	return resp.LocalID, resp.IDToken, nil
+}
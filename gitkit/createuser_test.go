@@ -0,0 +1,77 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestCreateUser(t *testing.T) {
+	var (
+		records []*AuditRecord
+		created []*User
+	)
+	rt := &recordingRoundTripper{roundTripper: roundTripper{http.StatusOK,
+		`{"localId":"123","email":"user@example.com","displayName":"User","idToken":"token"}`}}
+	c := &Client{
+		config: &Config{
+			EmailPolicy: EmailPolicyFunc(func(ctx context.Context, email string) error {
+				if email == "spam@example.com" {
+					return errors.New("blocked")
+				}
+				return nil
+			}),
+			PasswordPolicy: &PasswordRules{MinLength: 8},
+			AuditHook: func(ctx context.Context, r *AuditRecord) {
+				records = append(records, r)
+			},
+			OnUserCreated: func(ctx context.Context, u *User) {
+				created = append(created, u)
+			},
+		},
+		api: &APIClient{Client: http.Client{Transport: rt}},
+	}
+	ctx := context.Background()
+	if _, _, err := c.CreateUser(ctx, "spam@example.com", "long password", ""); err == nil {
+		t.Errorf("CreateUser() with a blocked email returns nil error")
+	}
+	if _, _, err := c.CreateUser(ctx, "user@example.com", "short", ""); err == nil {
+		t.Errorf("CreateUser() with a weak password returns nil error")
+	}
+	if len(rt.reqs) != 0 {
+		t.Fatalf("rejected users sent %d requests; want 0", len(rt.reqs))
+	}
+
+	localID, token, err := c.CreateUser(ctx, "user@example.com", "long password", "User")
+	if err != nil || localID != "123" || token != "token" {
+		t.Fatalf("CreateUser() = %q, %q, %v; want 123, token, nil", localID, token, err)
+	}
+	var sent SignupNewUserRequest
+	json.NewDecoder(rt.reqs[0].Body).Decode(&sent)
+	if want := (SignupNewUserRequest{Email: "user@example.com", Password: "long password", DisplayName: "User"}); sent != want {
+		t.Errorf("request = %+v; want %+v", sent, want)
+	}
+	if len(records) != 1 || records[0].Op != AuditOpCreateUser || len(records[0].LocalIDs) != 1 || records[0].LocalIDs[0] != "123" {
+		t.Errorf("audit records = %+v; want a CreateUser record of 123", records)
+	}
+	if len(created) != 1 || created[0].LocalID != "123" || created[0].Email != "user@example.com" {
+		t.Errorf("OnUserCreated called with %+v; want user 123", created)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxCursorLength bounds the length of the page tokens.
+const maxCursorLength = 4096
+
+// A Cursor is the opaque position of a page of users, i.e., the page token
+// of the identitytoolkit API. The zero Cursor is the first page. It is
+// encoded as its token in text and JSON, and validated when decoded, so that
+// it can be kept in checkpoints, e.g., with TransferProgress.
+type Cursor string
+
+// An InvalidCursorError is returned for a malformed Cursor, e.g., an email
+// address passed by mistake, or one that the API rejects, e.g., a stale one.
+type InvalidCursorError struct {
+	Cursor Cursor
+	Reason string
+}
+
+func (e *InvalidCursorError) Error() string {
+	return fmt.Sprintf("gitkit: invalid cursor %q: %s", string(e.Cursor), e.Reason)
+}
+
+// ParseCursor returns the Cursor of the page token s, or an
+// InvalidCursorError if it is malformed.
+func ParseCursor(s string) (Cursor, error) {
+	c := Cursor(s)
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+	return c, nil
+}
+
+// Validate checks that the Cursor can be a page token: page tokens are URL
+// safe, e.g., base64 encoded.
+func (c Cursor) Validate() error {
+	if len(c) > maxCursorLength {
+		return &InvalidCursorError{c, fmt.Sprintf("longer than %d bytes", maxCursorLength)}
+	}
+	if strings.ContainsRune(string(c), '@') {
+		return &InvalidCursorError{c, "an email address, not a page token"}
+	}
+	for _, r := range c {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case strings.ContainsRune("-_.~+/=", r):
+		default:
+			return &InvalidCursorError{c, fmt.Sprintf("invalid character %q", r)}
+		}
+	}
+	return nil
+}
+
+// String returns the page token of the Cursor.
+func (c Cursor) String() string {
+	return string(c)
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (c Cursor) MarshalText() ([]byte, error) {
+	return []byte(c), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface. It
+// rejects the malformed cursors.
+func (c *Cursor) UnmarshalText(b []byte) error {
+	v, err := ParseCursor(string(b))
+	if err != nil {
+		return err
+	}
+	*c = v
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestParseCursor(t *testing.T) {
+	tests := []struct {
+		s     string
+		valid bool
+	}{
+		{"", true},
+		{"AJ6aF-q_0x2=", true},
+		{"abc.def~+/", true},
+		{"user@example.com", false},
+		{"two words", false},
+		{"line\n", false},
+		{strings.Repeat("a", maxCursorLength+1), false},
+	}
+	for i, tt := range tests {
+		c, err := ParseCursor(tt.s)
+		if tt.valid && (err != nil || string(c) != tt.s) {
+			t.Errorf("[%d] ParseCursor(%q) = %q, %v; want the cursor", i, tt.s, c, err)
+		}
+		if _, ok := err.(*InvalidCursorError); !tt.valid && !ok {
+			t.Errorf("[%d] ParseCursor(%q) returns error %v; want InvalidCursorError", i, tt.s, err)
+		}
+	}
+}
+
+func TestCursor_json(t *testing.T) {
+	b, err := json.Marshal(&TransferProgress{Transferred: 2, PageToken: "next"})
+	if err != nil {
+		t.Fatalf("json.Marshal() returns error: %v", err)
+	}
+	var p TransferProgress
+	if err := json.Unmarshal(b, &p); err != nil || p.PageToken != "next" {
+		t.Errorf("json.Unmarshal(%s) = %+v, %v; want the page token next", b, p, err)
+	}
+	if err := json.Unmarshal([]byte(`{"PageToken":"user@example.com"}`), &p); err == nil {
+		t.Errorf("json.Unmarshal() of an email address as cursor returns no error")
+	}
+}
+
+func TestListUsersN_invalidCursor(t *testing.T) {
+	rt := &recordingRoundTripper{roundTripper: roundTripper{http.StatusBadRequest, `{"error":{"code":400,"message":"INVALID_PAGE_SELECTION"}}`}}
+	c := &Client{api: &APIClient{Client: http.Client{Transport: rt}}}
+	ctx := context.Background()
+	if _, _, err := c.ListUsersN(ctx, 10, "user@example.com"); err == nil {
+		t.Errorf("ListUsersN() with an email address returns no error")
+	}
+	if len(rt.reqs) != 0 {
+		t.Errorf("ListUsersN() with an invalid cursor sends %d requests; want 0", len(rt.reqs))
+	}
+	_, _, err := c.ListUsersN(ctx, 10, "stale")
+	if e, ok := err.(*InvalidCursorError); !ok || e.Cursor != "stale" || e.Reason != "INVALID_PAGE_SELECTION" {
+		t.Errorf("ListUsersN() with a rejected cursor returns error %v; want InvalidCursorError", err)
+	}
+}
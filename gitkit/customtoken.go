@@ -0,0 +1,150 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2/google"
+)
+
+// CustomTokenAudience is the audience of the custom tokens, which
+// identitytoolkit exchanges for ID tokens with verifyCustomToken.
+const CustomTokenAudience = "https://identitytoolkit.googleapis.com/google.identity.identitytoolkit.v1.IdentityToolkit"
+
+// customTokenTTL is the lifetime of the custom tokens, the maximum accepted by
+// verifyCustomToken.
+const customTokenTTL = time.Hour
+
+// reservedClaims can't be set as developer claims of custom tokens.
+var reservedClaims = []string{
+	"acr", "amr", "at_hash", "aud", "auth_time", "azp", "cnf", "c_hash",
+	"exp", "firebase", "iat", "iss", "jti", "nbf", "nonce", "sub",
+}
+
+// CustomToken mints a custom token signing in the user with the local ID, e.g.,
+// a user authenticated by a legacy system, which the widget or the
+// verifyCustomToken API exchanges for an ID token. The claims, if any, are
+// added to the ID tokens of the user and must not use the reserved JWT claim
+// names.
+//
+// The token is signed with Config.CustomTokenSigner if it is set, or else with
+// the private key of the service account credentials, from
+// Config.GoogleAppCredentialsPath, GoogleAppCredentialsJSON, JWTConfig or
+// Application Default Credentials.
+func (c *Client) CustomToken(ctx context.Context, localID string, claims map[string]interface{}) (string, error) {
+	if localID == "" || len(localID) > 128 {
+		return "", errors.New("gitkit: the local ID of a custom token must be 1 to 128 characters long")
+	}
+	for _, k := range reservedClaims {
+		if _, found := claims[k]; found {
+			return "", fmt.Errorf("gitkit: reserved claim %q in custom token", k)
+		}
+	}
+	// The signer may be the service account of the lazily loaded
+	// credentials.
+	if err := c.waitInit(ctx); err != nil {
+		return "", err
+	}
+	signer, issuer, err := c.customTokenSigner(ctx)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	payload := map[string]interface{}{
+		"iss": issuer,
+		"sub": issuer,
+		"aud": CustomTokenAudience,
+		"iat": now.Unix(),
+		"exp": now.Add(customTokenTTL).Unix(),
+		"uid": localID,
+	}
+	if len(claims) != 0 {
+		payload["claims"] = claims
+	}
+	return signJWT(ctx, signer, payload)
+}
+
+// customTokenSigner returns the signer of the custom tokens and the service
+// account issuing them, loaded on first use. A failed load, e.g., the metadata
+// server being unreachable, is retried by the next call.
+func (c *Client) customTokenSigner(ctx context.Context) (Signer, string, error) {
+	c.customMu.Lock()
+	defer c.customMu.Unlock()
+	if c.customSigner == nil {
+		signer, issuer, err := c.loadCustomSigner(ctx)
+		if err != nil {
+			return nil, "", err
+		}
+		c.customSigner, c.customIssuer = signer, issuer
+	}
+	return c.customSigner, c.customIssuer, nil
+}
+
+// loadCustomSigner returns the signer of the custom tokens and the service
+// account issuing them.
+func (c *Client) loadCustomSigner(ctx context.Context) (Signer, string, error) {
+	if c.config.CustomTokenSigner != nil {
+		if c.config.ServiceAccountEmail == "" {
+			return nil, "", errors.New("gitkit: CustomTokenSigner requires ServiceAccountEmail")
+		}
+		return c.config.CustomTokenSigner, c.config.ServiceAccountEmail, nil
+	}
+	jc := c.jc
+	if jc == nil && c.config.TokenSource == nil {
+		creds, err := google.FindDefaultCredentials(ctx, identitytoolkitScope)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(creds.JSON) != 0 {
+			jc, _ = google.JWTConfigFromJSON(creds.JSON, identitytoolkitScope)
+		}
+	}
+	if jc == nil || len(jc.PrivateKey) == 0 {
+		return nil, "", errors.New("gitkit: no service account private key to sign custom tokens; set Config.CustomTokenSigner")
+	}
+	key, err := parseRSAPrivateKey(jc.PrivateKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return NewRSASigner(key, jc.PrivateKeyID), jc.Email, nil
+}
+
+// parseRSAPrivateKey parses the PEM encoded PKCS #8 or PKCS #1 RSA private key
+// of a service account.
+func parseRSAPrivateKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("gitkit: invalid service account private key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("gitkit: invalid service account private key: %v", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("gitkit: service account private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2/jwt"
+)
+
+func TestCustomToken(t *testing.T) {
+	pemKey, err := ioutil.ReadFile("testdata/testkey.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{
+		config: &Config{},
+		jc:     &jwt.Config{Email: "gitkit@project.iam.gserviceaccount.com", PrivateKey: pemKey, PrivateKeyID: "key-1"},
+	}
+	ctx := context.Background()
+	s, err := c.CustomToken(ctx, "123", map[string]interface{}{"premium": true})
+	if err != nil {
+		t.Fatalf("CustomToken() returns error: %v", err)
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		t.Fatalf("CustomToken() = %q; want a JWT", s)
+	}
+	sig, _ := decodeSegment(parts[2])
+	if err := checkSignature(loadTestKey(t).Public(), "RS256", []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		t.Errorf("signature of the custom token: %v", err)
+	}
+	var header struct{ Alg, Kid string }
+	b, _ := decodeSegment(parts[0])
+	json.Unmarshal(b, &header)
+	if header.Alg != "RS256" || header.Kid != "key-1" {
+		t.Errorf("header = %+v; want RS256 and key-1", header)
+	}
+	var claims struct {
+		Iss, Sub, Aud, UID string
+		Iat, Exp           int64
+		Claims             map[string]interface{}
+	}
+	b, _ = decodeSegment(parts[1])
+	json.Unmarshal(b, &claims)
+	if claims.Iss != c.jc.Email || claims.Sub != c.jc.Email || claims.Aud != CustomTokenAudience || claims.UID != "123" {
+		t.Errorf("claims = %+v; want the custom token claims of 123", claims)
+	}
+	if claims.Exp-claims.Iat != 3600 || claims.Claims["premium"] != true {
+		t.Errorf("claims = %+v; want an hour lifetime and the premium claim", claims)
+	}
+
+	for _, tt := range []struct {
+		localID string
+		claims  map[string]interface{}
+	}{
+		{"", nil},
+		{strings.Repeat("x", 129), nil},
+		{"123", map[string]interface{}{"aud": "other"}},
+	} {
+		if _, err := c.CustomToken(ctx, tt.localID, tt.claims); err == nil {
+			t.Errorf("CustomToken(%q, %v) returns nil error; want non nil", tt.localID, tt.claims)
+		}
+	}
+
+	c = &Client{config: &Config{CustomTokenSigner: NewRSASigner(loadTestKey(t), "")}}
+	if _, err := c.CustomToken(ctx, "123", nil); err == nil {
+		t.Errorf("CustomToken() with a signer but no service account returns nil error; want non nil")
+	}
+	c = &Client{config: &Config{TokenSource: staticTokenSource{}}}
+	if _, err := c.CustomToken(ctx, "123", nil); err == nil {
+		t.Errorf("CustomToken() without a private key returns nil error; want non nil")
+	}
+}
+
+func TestCustomToken_retryLoad(t *testing.T) {
+	ctx := context.Background()
+	c := &Client{config: &Config{TokenSource: staticTokenSource{}}}
+	if _, err := c.CustomToken(ctx, "123", nil); err == nil {
+		t.Fatal("CustomToken() without a private key returns nil error; want non nil")
+	}
+	// The credentials become available.
+	pemKey, err := ioutil.ReadFile("testdata/testkey.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.jc = &jwt.Config{Email: "gitkit@project.iam.gserviceaccount.com", PrivateKey: pemKey}
+	if _, err := c.CustomToken(ctx, "123", nil); err != nil {
+		t.Errorf("CustomToken() after a failed load returns error: %v", err)
+	}
+	// The loaded signer is kept.
+	c.jc = nil
+	if _, err := c.CustomToken(ctx, "123", nil); err != nil {
+		t.Errorf("CustomToken() after a successful load returns error: %v", err)
+	}
+}
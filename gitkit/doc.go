@@ -124,6 +124,8 @@ See more about Google Application Default Credentials at
 https://developers.google.com/identity/protocols/application-default-credentials
 
 If Application Default Credentials doesn't work for your use case, you can
-set GoogleAppCredentialsPath in the config to the JSON key file path.
+set GoogleAppCredentialsPath in the config to the JSON key file path, or pass
+the content of the JSON key with the WithCredentialsJSON option if the key is
+not stored on disk.
 */
 package gitkit
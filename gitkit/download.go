@@ -0,0 +1,227 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// defaultDownloadParallelism is the number of concurrent page fetches of
+// DownloadAllUsers if DownloadOptions.Parallelism is not set.
+const defaultDownloadParallelism = 4
+
+// DownloadOptions controls DownloadAllUsers.
+type DownloadOptions struct {
+	// Parallelism is the maximum number of pages fetched concurrently. A
+	// default is used if it is not set.
+	Parallelism int
+	// PageSize is the number of users fetched per page, at most MaxPageSize.
+	// MaxPageSize is used if it is not set.
+	PageSize int
+	// Partitions, if set, are the page tokens the download is split at, e.g.,
+	// the page tokens recorded by a previous download with the same PageSize.
+	// The API only hands out the token of the next page, so without them the
+	// pages are fetched one after the other, only ahead of the delivery.
+	//
+	// Each partition is walked concurrently from its token until a page
+	// returns the token of the next partition. If it is never returned, e.g.,
+	// because users were added or deleted since it was recorded, the walk
+	// continues to the end, and the users of the following partitions are
+	// delivered twice.
+	Partitions []Cursor
+}
+
+// A Download delivers the users downloaded by DownloadAllUsers.
+type Download struct {
+	// C delivers the users. It is closed once the download is over.
+	C <-chan *User
+
+	done chan struct{}
+	err  error
+}
+
+// Err waits until the download is over and returns its error, a
+// *DownloadError if any partition failed.
+func (d *Download) Err() error {
+	<-d.done
+	return d.err
+}
+
+// A DownloadError reports the partitions of a download which failed.
+type DownloadError []*PartitionError
+
+func (e DownloadError) Error() string {
+	var msgs []string
+	for _, p := range e {
+		msgs = append(msgs, p.Error())
+	}
+	return "gitkit: download failed: " + strings.Join(msgs, "; ")
+}
+
+// A PartitionError reports the failure of a partition of a download.
+type PartitionError struct {
+	// Partition is the index of the partition in DownloadOptions.Partitions.
+	Partition int
+	// PageToken is the token of the page which failed, from which the
+	// partition can be resumed.
+	PageToken Cursor
+	Err       error
+}
+
+func (e *PartitionError) Error() string {
+	return fmt.Sprintf("partition %d at page %q: %v", e.Partition, e.PageToken, e.Err)
+}
+
+type byPartition DownloadError
+
+func (s byPartition) Len() int           { return len(s) }
+func (s byPartition) Less(i, j int) bool { return s[i].Partition < s[j].Partition }
+func (s byPartition) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// DownloadAllUsers downloads all the users, fetching up to
+// opts.Parallelism pages concurrently, and delivers them on the C channel of
+// the returned Download, in no particular order across partitions. The memory
+// is bounded: at most a page per partition, and opts.Parallelism more, are
+// held until the users are received.
+//
+// A partition which fails stops while the others continue, and the failures
+// are reported by Download.Err once C is closed. Canceling ctx stops the
+// download.
+//
+// For example,
+//
+//	d, err := c.DownloadAllUsers(ctx, nil)
+//	...
+//	for u := range d.C {
+//		...
+//	}
+//	if err := d.Err(); err != nil {
+//		...
+//	}
+func (c *Client) DownloadAllUsers(ctx context.Context, opts *DownloadOptions) (*Download, error) {
+	return downloadUsers(ctx, c, opts)
+}
+
+// downloadUsers implements DownloadAllUsers with the users listed by l.
+func downloadUsers(ctx context.Context, l UserLister, opts *DownloadOptions) (*Download, error) {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = defaultDownloadParallelism
+	}
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = MaxPageSize
+	}
+	if pageSize < 1 || pageSize > MaxPageSize {
+		return nil, fmt.Errorf("page size must be between 1 and %d, got %d", MaxPageSize, pageSize)
+	}
+	partitions := opts.Partitions
+	if len(partitions) == 0 {
+		partitions = []Cursor{""}
+	}
+	for _, p := range partitions {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	// The pages are fetched under the semaphore and handed to a single
+	// sender, through a buffer of as many pages as concurrent fetches.
+	pages := make(chan []*User, parallelism)
+	ch := make(chan *User, pageSize)
+	d := &Download{C: ch, done: make(chan struct{})}
+	sem := make(chan struct{}, parallelism)
+	var (
+		mu   sync.Mutex
+		errs DownloadError
+		wg   sync.WaitGroup
+	)
+	fail := func(i int, token Cursor, err error) {
+		mu.Lock()
+		errs = append(errs, &PartitionError{i, token, err})
+		mu.Unlock()
+	}
+	walk := func(i int) {
+		defer wg.Done()
+		var end Cursor
+		if i+1 < len(partitions) {
+			end = partitions[i+1]
+		}
+		token := partitions[i]
+		for {
+			if err := ctx.Err(); err != nil {
+				fail(i, token, err)
+				return
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				fail(i, token, ctx.Err())
+				return
+			}
+			users, next, err := l.ListUsersN(ctx, pageSize, token)
+			<-sem
+			if err != nil {
+				fail(i, token, err)
+				return
+			}
+			if len(users) > 0 {
+				select {
+				case pages <- users:
+				case <-ctx.Done():
+					fail(i, token, ctx.Err())
+					return
+				}
+			}
+			if len(users) == 0 || next == "" || next == end {
+				return
+			}
+			token = next
+		}
+	}
+	wg.Add(len(partitions))
+	for i := range partitions {
+		go walk(i)
+	}
+	go func() {
+		wg.Wait()
+		close(pages)
+	}()
+	go func() {
+		defer close(d.done)
+		defer close(ch)
+		for page := range pages {
+			for _, u := range page {
+				select {
+				case ch <- u:
+				case <-ctx.Done():
+				}
+			}
+		}
+		if len(errs) > 0 {
+			sort.Sort(byPartition(errs))
+			d.err = errs
+		}
+	}()
+	return d, nil
+}
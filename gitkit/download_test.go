@@ -0,0 +1,105 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestDownloadAllUsers(t *testing.T) {
+	var users []*User
+	for i := 0; i < 11; i++ {
+		users = append(users, &User{LocalID: strconv.Itoa(i)})
+	}
+	tests := []struct {
+		opts   *DownloadOptions
+		failAt Cursor
+		n      int
+		errs   []Cursor
+	}{
+		{&DownloadOptions{PageSize: 2}, "", 11, nil},
+		{&DownloadOptions{PageSize: 2, Parallelism: 2, Partitions: []Cursor{"", "4", "8"}}, "", 11, nil},
+		// The partition failing at page 6 stops, the others complete.
+		{&DownloadOptions{PageSize: 2, Partitions: []Cursor{"", "4", "8"}}, "6", 9, []Cursor{"6"}},
+		// The partition tokens not on the page boundaries are not reached.
+		{&DownloadOptions{PageSize: 3, Partitions: []Cursor{"", "4"}}, "", 18, nil},
+	}
+	for i, tt := range tests {
+		d, err := downloadUsers(context.Background(), &sliceLister{users: users, failAt: tt.failAt}, tt.opts)
+		if err != nil {
+			t.Fatalf("%d. DownloadAllUsers() returns error: %v", i, err)
+		}
+		var ids []string
+		for u := range d.C {
+			ids = append(ids, u.LocalID)
+		}
+		if len(ids) != tt.n {
+			t.Errorf("%d. DownloadAllUsers() delivers %d users; want %d", i, len(ids), tt.n)
+		}
+		if tt.errs == nil {
+			if err := d.Err(); err != nil {
+				t.Errorf("%d. Err() = %v", i, err)
+			}
+			if tt.n == len(users) {
+				sort.Strings(ids)
+				seen := map[string]bool{}
+				for _, id := range ids {
+					seen[id] = true
+				}
+				if len(seen) != len(users) {
+					t.Errorf("%d. DownloadAllUsers() delivers %v; want each user once", i, ids)
+				}
+			}
+			continue
+		}
+		e, ok := d.Err().(DownloadError)
+		if !ok || len(e) != len(tt.errs) {
+			t.Errorf("%d. Err() = %v; want failures at %v", i, d.Err(), tt.errs)
+			continue
+		}
+		for j, p := range e {
+			if p.PageToken != tt.errs[j] {
+				t.Errorf("%d. failure %d at page %q; want %q", i, j, p.PageToken, tt.errs[j])
+			}
+		}
+	}
+
+	if _, err := downloadUsers(context.Background(), &sliceLister{}, &DownloadOptions{PageSize: MaxPageSize + 1}); err == nil {
+		t.Error("DownloadAllUsers() with a page size too large returns no error")
+	}
+}
+
+func TestDownloadAllUsers_cancel(t *testing.T) {
+	var users []*User
+	for i := 0; i < 100; i++ {
+		users = append(users, &User{LocalID: strconv.Itoa(i)})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d, err := downloadUsers(ctx, &sliceLister{users: users}, &DownloadOptions{PageSize: 1, Parallelism: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-d.C
+	cancel()
+	for range d.C {
+	}
+	if e, ok := d.Err().(DownloadError); !ok || e[0].Err != context.Canceled {
+		t.Errorf("Err() after cancel = %v; want context.Canceled", d.Err())
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// An EmailValidator checks that an email address can receive emails before an
+// OOB code is generated for it. See Config.EmailValidator.
+type EmailValidator interface {
+	// ValidateEmail returns an error, typically an *UndeliverableEmailError,
+	// if no email should be sent to the address.
+	ValidateEmail(ctx context.Context, email string) error
+}
+
+// EmailValidatorFunc is an adapter to use a function as an EmailValidator.
+type EmailValidatorFunc func(ctx context.Context, email string) error
+
+// ValidateEmail implements the EmailValidator interface.
+func (f EmailValidatorFunc) ValidateEmail(ctx context.Context, email string) error {
+	return f(ctx, email)
+}
+
+// UndeliverableEmailError is returned when an email address is rejected by an
+// EmailValidator.
+type UndeliverableEmailError struct {
+	Email  string
+	Reason string
+}
+
+// Error implements the error interface.
+func (e *UndeliverableEmailError) Error() string {
+	return fmt.Sprintf("gitkit: undeliverable email address %q: %s", e.Email, e.Reason)
+}
+
+// SyntaxEmailValidator rejects the email addresses which are not a plain
+// addr-spec with a domain, e.g., "user@example.com".
+var SyntaxEmailValidator EmailValidator = EmailValidatorFunc(func(ctx context.Context, email string) error {
+	_, err := emailDomain(email)
+	return err
+})
+
+// emailDomain checks the syntax of the email address and returns its domain.
+func emailDomain(email string) (string, error) {
+	a, err := mail.ParseAddress(email)
+	if err != nil || a.Name != "" || a.Address != email {
+		return "", &UndeliverableEmailError{email, "invalid syntax"}
+	}
+	i := strings.LastIndex(email, "@")
+	domain := strings.TrimSuffix(email[i+1:], ".")
+	if !strings.Contains(domain, ".") {
+		return "", &UndeliverableEmailError{email, "invalid domain"}
+	}
+	return domain, nil
+}
+
+// MXEmailValidator checks the syntax of the email addresses and that their
+// domain accepts emails: it must have MX records, or an address record if it
+// has none. Domains publishing a null MX record (RFC 7505) are rejected.
+// Temporary DNS failures do not reject the address.
+type MXEmailValidator struct {
+	// LookupMX returns the MX records of the domain. net.LookupMX is used if
+	// nil.
+	LookupMX func(domain string) ([]*net.MX, error)
+	// LookupHost returns the addresses of the domain. net.LookupHost is used
+	// if nil.
+	LookupHost func(domain string) ([]string, error)
+}
+
+// ValidateEmail implements the EmailValidator interface.
+func (v *MXEmailValidator) ValidateEmail(ctx context.Context, email string) error {
+	domain, err := emailDomain(email)
+	if err != nil {
+		return err
+	}
+	lookupMX, lookupHost := v.LookupMX, v.LookupHost
+	if lookupMX == nil {
+		lookupMX = net.LookupMX
+	}
+	if lookupHost == nil {
+		lookupHost = net.LookupHost
+	}
+	mxs, err := lookupMX(domain)
+	if err != nil && !isNotFound(err) {
+		return nil
+	}
+	if len(mxs) == 1 && (mxs[0].Host == "." || mxs[0].Host == "") {
+		return &UndeliverableEmailError{email, "domain does not accept email"}
+	}
+	if len(mxs) > 0 {
+		return nil
+	}
+	// Without MX records, the address record is the implicit MX.
+	addrs, err := lookupHost(domain)
+	if err != nil && !isNotFound(err) {
+		return nil
+	}
+	if len(addrs) == 0 {
+		return &UndeliverableEmailError{email, "domain has no mail server"}
+	}
+	return nil
+}
+
+// isNotFound reports whether the DNS lookup error is final, i.e., the domain
+// or its records do not exist.
+func isNotFound(err error) bool {
+	e, ok := err.(*net.DNSError)
+	return ok && !e.Temporary() && !e.Timeout()
+}
+
+// validateEmail runs Config.EmailValidator, if set, on the email address.
+func (c *Client) validateEmail(ctx context.Context, email string) error {
+	if c.config == nil || c.config.EmailValidator == nil {
+		return nil
+	}
+	return c.config.EmailValidator.ValidateEmail(ctx, email)
+}
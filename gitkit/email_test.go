@@ -0,0 +1,100 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestMXEmailValidator(t *testing.T) {
+	notFound := &net.DNSError{Err: "no such host", Name: "example"}
+	temporary := &net.DNSError{Err: "server misbehaving", Name: "example", IsTemporary: true}
+	mx := map[string][]*net.MX{
+		"example.com":   {{Host: "mx.example.com.", Pref: 10}},
+		"nullmx.com":    {{Host: ".", Pref: 0}},
+		"flaky-dns.com": nil,
+	}
+	hosts := map[string][]string{
+		"a-only.com": {"192.0.2.1"},
+	}
+	v := &MXEmailValidator{
+		LookupMX: func(domain string) ([]*net.MX, error) {
+			if domain == "flaky-dns.com" {
+				return nil, temporary
+			}
+			if r, ok := mx[domain]; ok {
+				return r, nil
+			}
+			return nil, notFound
+		},
+		LookupHost: func(domain string) ([]string, error) {
+			if r, ok := hosts[domain]; ok {
+				return r, nil
+			}
+			return nil, notFound
+		},
+	}
+	tests := []struct {
+		email string
+		ok    bool
+	}{
+		{"user@example.com", true},
+		{"user@a-only.com", true},
+		{"user@flaky-dns.com", true},
+		{"user@nullmx.com", false},
+		{"user@nowhere.com", false},
+		{"user@localhost", false},
+		{"not an email", false},
+		{"John <user@example.com>", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		err := v.ValidateEmail(context.Background(), tt.email)
+		if tt.ok && err != nil {
+			t.Errorf("ValidateEmail(%q) returns error: %v", tt.email, err)
+		}
+		if !tt.ok {
+			if _, ok := err.(*UndeliverableEmailError); !ok {
+				t.Errorf("ValidateEmail(%q) returns error %v; want *UndeliverableEmailError", tt.email, err)
+			}
+		}
+	}
+}
+
+func TestGenerateOOBCode_emailValidator(t *testing.T) {
+	rt := &recordingRoundTripper{roundTripper: roundTripper{200, `{"oobCode": "code"}`}}
+	c := &Client{
+		config: &Config{EmailValidator: SyntaxEmailValidator},
+		api:    &APIClient{Client: http.Client{Transport: rt}},
+	}
+	req, _ := http.NewRequest("POST", "http://localhost/", nil)
+	ctx := context.Background()
+	if _, err := c.GenerateVerifyEmailOOBCode(ctx, req, "invalid@"); err == nil {
+		t.Errorf("GenerateVerifyEmailOOBCode() with an invalid email returns nil error; want non nil")
+	}
+	if _, err := c.GenerateResetPasswordOOBCode(ctx, req, "user", "challenge", "response"); err == nil {
+		t.Errorf("GenerateResetPasswordOOBCode() with an invalid email returns nil error; want non nil")
+	}
+	if len(rt.reqs) != 0 {
+		t.Errorf("%d requests sent for invalid emails; want none", len(rt.reqs))
+	}
+	if _, err := c.GenerateVerifyEmailOOBCode(ctx, req, "user@example.com"); err != nil {
+		t.Errorf("GenerateVerifyEmailOOBCode() returns error: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// An EmailChange describes the change of the email address of a user applied
+// by ApplyEmailChange.
+type EmailChange struct {
+	LocalID  string
+	OldEmail string
+	NewEmail string
+}
+
+// A SessionRevoker revokes the sessions of a user, e.g., after a change of
+// their email address. It is implemented by session.Manager.
+type SessionRevoker interface {
+	RevokeSessions(ctx context.Context, localID string) error
+}
+
+// SessionRevokerFunc is an adapter to use a function as a SessionRevoker.
+type SessionRevokerFunc func(ctx context.Context, localID string) error
+
+// RevokeSessions implements the SessionRevoker interface.
+func (f SessionRevokerFunc) RevokeSessions(ctx context.Context, localID string) error {
+	return f(ctx, localID)
+}
+
+// ApplyEmailChange applies the change email OOB code, i.e., changes the email
+// address of the user it was generated for to the new address. The change is
+// reported to Config.AuditHook and Config.OnEmailChanged, and the sessions of
+// the user are revoked by Config.SessionRevoker.
+//
+// If the revocation fails, the change is returned with the error: the email
+// address is changed but the sessions may still be valid.
+func (c *Client) ApplyEmailChange(ctx context.Context, oobCode string) (*EmailChange, error) {
+	resp, err := c.mutatingAPIClient(ctx).SetAccountInfo(ctx, &SetAccountInfoRequest{OOBCode: oobCode})
+	if err != nil {
+		c.audit(ctx, AuditOpChangeEmail, nil, err)
+		return nil, err
+	}
+	change := &EmailChange{LocalID: resp.LocalID, OldEmail: resp.Email, NewEmail: resp.NewEmail}
+	if change.LocalID == "" && change.NewEmail != "" {
+		if u, err := c.UserByEmail(ctx, change.NewEmail); err == nil {
+			change.LocalID = u.LocalID
+		}
+	}
+	var localIDs []string
+	if change.LocalID != "" {
+		localIDs = []string{change.LocalID}
+	}
+	c.audit(ctx, AuditOpChangeEmail, localIDs, nil)
+	if c.config.OnEmailChanged != nil {
+		c.config.OnEmailChanged(ctx, change)
+	}
+	if c.config.SessionRevoker == nil {
+		return change, nil
+	}
+	if change.LocalID == "" {
+		return change, fmt.Errorf("gitkit: cannot revoke the sessions of %s: unknown local ID", change.NewEmail)
+	}
+	if err := c.config.SessionRevoker.RevokeSessions(ctx, change.LocalID); err != nil {
+		return change, fmt.Errorf("gitkit: failed to revoke the sessions of user %s: %v", change.LocalID, err)
+	}
+	return change, nil
+}
+
+// A ChangeEmailHandler is an http.Handler which completes the change email
+// flow. It accepts the form posted by the widget with the OOB code of the
+// change email message in OOBCodeParam, and applies the change by
+// Client.ApplyEmailChange. The ID token cookies of the browser are cleared,
+// so that the user signs in again with the new address.
+//
+// The response is a JSON object, {"email": "old@example.com", "newEmail":
+// "new@example.com"} on success or {"error": {"code": 400, "message":
+// "INVALID_OOB_CODE"}} on failure, in the format of the identitytoolkit API.
+type ChangeEmailHandler struct {
+	client *Client
+
+	// Context returns the context of the API calls. If nil, the context of
+	// the request is used. On App Engine, it should be
+	// appengine.NewContext.
+	Context func(*http.Request) context.Context
+}
+
+// ChangeEmailHandler returns an http.Handler completing the change email flow.
+//
+// For example,
+//
+//	http.Handle("/changeEmail", c.ChangeEmailHandler())
+func (c *Client) ChangeEmailHandler() *ChangeEmailHandler {
+	return &ChangeEmailHandler{client: c}
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *ChangeEmailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, string(ErrorCodeMethodNotAllowed))
+		return
+	}
+	if err := h.client.CheckOrigin(r); err != nil {
+		writeJSONError(w, http.StatusForbidden, string(ErrorCodeForbiddenOrigin))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxWidgetPostBody)
+	oobCode := r.PostFormValue(OOBCodeParam)
+	if oobCode == "" {
+		writeJSONError(w, http.StatusBadRequest, string(ErrorCodeMissingOOBCode))
+		return
+	}
+	ctx := RequestContext(h.Context, r)
+	change, err := h.client.ApplyEmailChange(ctx, oobCode)
+	if change == nil {
+		writeAPIError(w, err)
+		return
+	}
+	h.client.ClearTokenCookie(w)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "SESSION_REVOCATION_FAILED")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"email": change.OldEmail, "newEmail": change.NewEmail})
+}
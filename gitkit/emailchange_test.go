@@ -0,0 +1,141 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestApplyEmailChange(t *testing.T) {
+	var (
+		records []*AuditRecord
+		changes []*EmailChange
+		revoked []string
+	)
+	rt := &recordingRoundTripper{roundTripper: roundTripper{http.StatusOK, `{"localId":"123","email":"old@example.com","newEmail":"new@example.com"}`}}
+	c := &Client{
+		config: &Config{
+			AuditHook: func(ctx context.Context, r *AuditRecord) { records = append(records, r) },
+			OnEmailChanged: func(ctx context.Context, c *EmailChange) {
+				changes = append(changes, c)
+			},
+			SessionRevoker: SessionRevokerFunc(func(ctx context.Context, localID string) error {
+				revoked = append(revoked, localID)
+				return nil
+			}),
+		},
+		api: &APIClient{Client: http.Client{Transport: rt}},
+	}
+	change, err := c.ApplyEmailChange(context.Background(), "code")
+	if err != nil {
+		t.Fatalf("ApplyEmailChange() returns error: %v", err)
+	}
+	want := EmailChange{"123", "old@example.com", "new@example.com"}
+	if *change != want {
+		t.Errorf("ApplyEmailChange() = %+v; want %+v", change, want)
+	}
+	var req SetAccountInfoRequest
+	json.NewDecoder(rt.reqs[0].Body).Decode(&req)
+	if req.OOBCode != "code" {
+		t.Errorf("setAccountInfo request has OOB code %q; want code", req.OOBCode)
+	}
+	if len(records) != 1 || records[0].Op != AuditOpChangeEmail || len(records[0].LocalIDs) != 1 || records[0].LocalIDs[0] != "123" {
+		t.Errorf("audit records = %+v; want a change email of user 123", records)
+	}
+	if len(changes) != 1 || *changes[0] != want {
+		t.Errorf("OnEmailChanged called with %v; want %+v", changes, want)
+	}
+	if len(revoked) != 1 || revoked[0] != "123" {
+		t.Errorf("revoked sessions of %v; want [123]", revoked)
+	}
+
+	c.config.SessionRevoker = SessionRevokerFunc(func(ctx context.Context, localID string) error {
+		return errors.New("store unavailable")
+	})
+	if change, err := c.ApplyEmailChange(context.Background(), "code"); change == nil || err == nil {
+		t.Errorf("ApplyEmailChange() with a failing revoker = %v, %v; want the change and an error", change, err)
+	}
+}
+
+func TestChangeEmailHandler(t *testing.T) {
+	tests := []struct {
+		form    url.Values
+		api     roundTripper
+		revoke  error
+		code    int
+		message string
+	}{
+		{url.Values{}, roundTripper{}, nil, http.StatusBadRequest, "MISSING_OOB_CODE"},
+		{
+			url.Values{"oobCode": {"code"}},
+			roundTripper{http.StatusBadRequest, `{"error":{"code":400,"message":"INVALID_OOB_CODE"}}`},
+			nil, http.StatusBadRequest, "INVALID_OOB_CODE",
+		},
+		{
+			url.Values{"oobCode": {"code"}},
+			roundTripper{http.StatusOK, `{"localId":"123","email":"old@example.com","newEmail":"new@example.com"}`},
+			errors.New("store unavailable"), http.StatusInternalServerError, "SESSION_REVOCATION_FAILED",
+		},
+		{
+			url.Values{"oobCode": {"code"}},
+			roundTripper{http.StatusOK, `{"localId":"123","email":"old@example.com","newEmail":"new@example.com"}`},
+			nil, http.StatusOK, "",
+		},
+	}
+	for i, tt := range tests {
+		revoke := tt.revoke
+		c := &Client{
+			config: &Config{
+				CookieName: "gtoken",
+				SessionRevoker: SessionRevokerFunc(func(ctx context.Context, localID string) error {
+					return revoke
+				}),
+			},
+			api: &APIClient{Client: http.Client{Transport: tt.api}},
+		}
+		req, _ := http.NewRequest("POST", "http://www.example.com/changeEmail", strings.NewReader(tt.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		c.ChangeEmailHandler().ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("[%d] status = %d; want %d", i, w.Code, tt.code)
+		}
+		var resp struct {
+			NewEmail string        `json:"newEmail"`
+			Error    *handlerError `json:"error"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if tt.message != "" {
+			if resp.Error == nil || resp.Error.Message != tt.message {
+				t.Errorf("[%d] error = %+v; want %q", i, resp.Error, tt.message)
+			}
+			continue
+		}
+		if resp.NewEmail != "new@example.com" {
+			t.Errorf("[%d] response = %s; want the new email address", i, w.Body)
+		}
+		if c := w.Header().Get("Set-Cookie"); !strings.HasPrefix(c, "gtoken=;") {
+			t.Errorf("[%d] Set-Cookie = %q; want the token cookie deleted", i, c)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// An EmailPolicy decides which email addresses may be used by new accounts
+// and receive OOB codes, e.g., to fight sign up abuse with throwaway
+// addresses. See Config.EmailPolicy.
+type EmailPolicy interface {
+	// AllowEmail returns an error, typically a *BlockedEmailError, if the
+	// address is not allowed.
+	AllowEmail(ctx context.Context, email string) error
+}
+
+// EmailPolicyFunc is an adapter to use a function as an EmailPolicy.
+type EmailPolicyFunc func(ctx context.Context, email string) error
+
+// AllowEmail implements the EmailPolicy interface.
+func (f EmailPolicyFunc) AllowEmail(ctx context.Context, email string) error {
+	return f(ctx, email)
+}
+
+// BlockedEmailError is returned when an email address is rejected by an
+// EmailPolicy.
+type BlockedEmailError struct {
+	Email  string
+	Domain string // The blocked domain matched by the address.
+}
+
+// Error implements the error interface.
+func (e *BlockedEmailError) Error() string {
+	return fmt.Sprintf("gitkit: email address %q is blocked: domain %s is not allowed", e.Email, e.Domain)
+}
+
+// DisposableEmailDomains are well known disposable email domains. The list is
+// short by design: services facing steady abuse should load a maintained list
+// with ReadDomainBlocklist.
+var DisposableEmailDomains = []string{
+	"10minutemail.com",
+	"discard.email",
+	"dispostable.com",
+	"fakeinbox.com",
+	"getnada.com",
+	"guerrillamail.com",
+	"mailinator.com",
+	"maildrop.cc",
+	"mintemail.com",
+	"mohmal.com",
+	"sharklasers.com",
+	"temp-mail.org",
+	"throwawaymail.com",
+	"trashmail.com",
+	"yopmail.com",
+}
+
+// A DomainBlocklist is an EmailPolicy which rejects the addresses of the
+// blocked domains and their subdomains. Domains are compared
+// case-insensitively. It is safe to use from multiple concurrent goroutines,
+// so it can be updated while in use.
+type DomainBlocklist struct {
+	mu      sync.RWMutex
+	domains map[string]bool
+}
+
+// NewDomainBlocklist creates a DomainBlocklist of the domains, e.g.,
+// DisposableEmailDomains.
+func NewDomainBlocklist(domains ...string) *DomainBlocklist {
+	b := &DomainBlocklist{domains: make(map[string]bool)}
+	b.Add(domains...)
+	return b
+}
+
+// ReadDomainBlocklist creates a DomainBlocklist from a list of domains, one
+// per line. Blank lines and lines starting with # are ignored.
+func ReadDomainBlocklist(r io.Reader) (*DomainBlocklist, error) {
+	b := NewDomainBlocklist()
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		b.Add(line)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Add blocks the domains.
+func (b *DomainBlocklist) Add(domains ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, d := range domains {
+		b.domains[normalizeDomain(d)] = true
+	}
+}
+
+// Domains returns the sorted blocked domains.
+func (b *DomainBlocklist) Domains() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	domains := make([]string, 0, len(b.domains))
+	for d := range b.domains {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// AllowEmail implements the EmailPolicy interface.
+func (b *DomainBlocklist) AllowEmail(ctx context.Context, email string) error {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return nil
+	}
+	domain := normalizeDomain(email[i+1:])
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	// Check the domain and its parent domains.
+	for d := domain; d != ""; {
+		if b.domains[d] {
+			return &BlockedEmailError{email, d}
+		}
+		j := strings.Index(d, ".")
+		if j < 0 {
+			break
+		}
+		d = d[j+1:]
+	}
+	return nil
+}
+
+func normalizeDomain(d string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(d), "."))
+}
+
+// NewEmailPolicyFailure returns the UploadFailure of the user at index whose
+// email address is rejected by the EmailPolicy with err. Its reason is
+// UploadErrorEmailPolicy.
+func NewEmailPolicyFailure(index int, err error) *UploadFailure {
+	return &UploadFailure{Index: index, Message: "EMAIL_POLICY: " + err.Error()}
+}
+
+// allowEmail checks the email address against Config.EmailPolicy, if set.
+func (c *Client) allowEmail(ctx context.Context, email string) error {
+	if c.config == nil || c.config.EmailPolicy == nil {
+		return nil
+	}
+	return c.config.EmailPolicy.AllowEmail(ctx, email)
+}
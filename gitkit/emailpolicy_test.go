@@ -0,0 +1,108 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestDomainBlocklist(t *testing.T) {
+	b, err := ReadDomainBlocklist(strings.NewReader("# Disposable domains\nmailinator.com\n\n  Trash.Example.\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := b.Domains(); !reflect.DeepEqual(d, []string{"mailinator.com", "trash.example"}) {
+		t.Errorf("Domains() = %v", d)
+	}
+	tests := []struct {
+		email   string
+		blocked string
+	}{
+		{"user@mailinator.com", "mailinator.com"},
+		{"user@MAILINATOR.com", "mailinator.com"},
+		{"user@eu.mailinator.com", "mailinator.com"},
+		{"user@trash.example", "trash.example"},
+		{"user@notmailinator.com", ""},
+		{"user@example.com", ""},
+		{"invalid", ""},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		err := b.AllowEmail(ctx, tt.email)
+		if tt.blocked == "" {
+			if err != nil {
+				t.Errorf("AllowEmail(%q) returns error: %v", tt.email, err)
+			}
+			continue
+		}
+		if be, ok := err.(*BlockedEmailError); !ok || be.Domain != tt.blocked {
+			t.Errorf("AllowEmail(%q) returns error %v; want domain %s blocked", tt.email, err, tt.blocked)
+		}
+	}
+	if err := NewDomainBlocklist(DisposableEmailDomains...).AllowEmail(ctx, "user@yopmail.com"); err == nil {
+		t.Errorf("the default list does not block yopmail.com")
+	}
+}
+
+func TestUploadUsers_emailPolicy(t *testing.T) {
+	// The API reports the second uploaded user, i.e., user 3, as failed.
+	rt := &recordingRoundTripper{roundTripper: roundTripper{200, `{"error": [{"index": 1, "message": "email exists"}]}`}}
+	c := &Client{
+		config: &Config{EmailPolicy: NewDomainBlocklist("mailinator.com")},
+		api:    &APIClient{Client: http.Client{Transport: rt}},
+	}
+	users := []*User{
+		{LocalID: "0", Email: "user0@mailinator.com"},
+		{LocalID: "1", Email: "user1@example.com"},
+		{LocalID: "2", Email: "user2@mailinator.com"},
+		{LocalID: "3", Email: "user3@example.com"},
+	}
+	err := c.UploadUsers(context.Background(), users, "HMAC_SHA256", []byte("key"), nil)
+	ue, ok := err.(UploadError)
+	if !ok {
+		t.Fatalf("UploadUsers() returns error %v; want UploadError", err)
+	}
+	want := []struct {
+		index  int
+		reason UploadErrorReason
+	}{{0, UploadErrorEmailPolicy}, {2, UploadErrorEmailPolicy}, {3, UploadErrorDuplicateEmail}}
+	if len(ue) != len(want) {
+		t.Fatalf("UploadUsers() returns %d failures; want %d", len(ue), len(want))
+	}
+	for i, w := range want {
+		if ue[i].Index != w.index || ue[i].Reason() != w.reason {
+			t.Errorf("failure %d = %d %s; want %d %s", i, ue[i].Index, ue[i].Reason(), w.index, w.reason)
+		}
+		if ue[i].User != users[w.index] {
+			t.Errorf("failure %d refers to user %v; want %v", i, ue[i].User, users[w.index])
+		}
+	}
+	if got, want := ue.Summary(), "3 users failed to upload: 2 EMAIL_POLICY, 1 DUPLICATE_EMAIL"; got != want {
+		t.Errorf("Summary() = %q; want %q", got, want)
+	}
+	var req UploadAccountRequest
+	b, _ := ioutil.ReadAll(rt.reqs[0].Body)
+	json.Unmarshal(b, &req)
+	if len(req.Users) != 2 || req.Users[0].LocalID != "1" || req.Users[1].LocalID != "3" {
+		t.Errorf("uploaded users %v; want 1 and 3", req.Users)
+	}
+}
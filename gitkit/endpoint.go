@@ -0,0 +1,177 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Base URLs of the identitytoolkit v3 API methods.
+const (
+	// LegacyAPIBaseURL is where the API has been served from originally.
+	LegacyAPIBaseURL = "https://www.googleapis.com/identitytoolkit/v3/relyingparty"
+	// IdentityPlatformAPIBaseURL is the Identity Platform host of the same
+	// API, which the legacy one is folded into.
+	IdentityPlatformAPIBaseURL = "https://identitytoolkit.googleapis.com/v3/relyingparty"
+)
+
+// An Endpoint selects where the identitytoolkit API requests are sent, so that
+// the API can be moved, e.g., from LegacyAPIBaseURL to
+// IdentityPlatformAPIBaseURL, by configuration. See Config.Endpoint.
+type Endpoint struct {
+	// BaseURL replaces the base URL of the API methods, i.e., the URL the
+	// method names are appended to. The legacy one is kept if empty.
+	BaseURL string `json:"baseUrl,omitempty"`
+	// Overrides are the full URLs of the methods served elsewhere, by method
+	// name, e.g., "getAccountInfo". They take precedence over BaseURL.
+	Overrides map[string]string `json:"overrides,omitempty"`
+}
+
+// URL returns the URL the API method is sent to.
+func (e *Endpoint) URL(method string) string {
+	if u, ok := e.Overrides[method]; ok {
+		return u
+	}
+	if e.BaseURL != "" {
+		return strings.TrimRight(e.BaseURL, "/") + "/" + method
+	}
+	return apiMethod(method).url()
+}
+
+// A DeprecationNotice is reported by Config.OnDeprecation when an API response
+// warns that its method is deprecated, with a Warning header of code 299, or
+// a Deprecation or Sunset header.
+type DeprecationNotice struct {
+	// Method is the name of the API method, e.g., "getAccountInfo".
+	Method string
+	// URL is the URL the request was sent to.
+	URL string
+	// Warning is the text of the warning, if any.
+	Warning string
+	// Sunset is when the method is turned down, or zero if it is unknown.
+	Sunset time.Time
+}
+
+// EndpointMiddleware returns a TransportMiddleware sending the identitytoolkit
+// API requests to the endpoint instead of the URLs built from APIBaseURI, e.g.,
+// for an APIClient created without a Client. onDeprecation, if not nil,
+// receives the first deprecation notice of every method.
+func EndpointMiddleware(e *Endpoint, onDeprecation func(*DeprecationNotice)) TransportMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &endpointTransport{RoundTripper: next, endpoint: e, onDeprecation: onDeprecation}
+	}
+}
+
+// endpointTransport rewrites the URLs of the API requests to the endpoint and
+// reports the deprecation notices of the responses.
+type endpointTransport struct {
+	http.RoundTripper
+	endpoint      *Endpoint
+	onDeprecation func(*DeprecationNotice)
+
+	mu       sync.Mutex
+	reported map[string]bool // Methods whose deprecation was reported.
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	method, ok := apiMethodOf(req)
+	if !ok {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	r := req
+	if _, ok := t.endpoint.Overrides[method]; ok || t.endpoint.BaseURL != "" {
+		target, err := url.Parse(t.endpoint.URL(method))
+		if err != nil {
+			return nil, err
+		}
+		if target.RawQuery == "" {
+			target.RawQuery = req.URL.RawQuery
+		}
+		r = new(http.Request)
+		*r = *req
+		r.URL = target
+		r.Host = ""
+	}
+	resp, err := t.RoundTripper.RoundTrip(r)
+	if err == nil && t.onDeprecation != nil {
+		if n := deprecationNotice(resp); n != nil {
+			n.Method, n.URL = method, r.URL.String()
+			t.report(n)
+		}
+	}
+	return resp, err
+}
+
+// report passes the first notice of each method to onDeprecation.
+func (t *endpointTransport) report(n *DeprecationNotice) {
+	t.mu.Lock()
+	if t.reported[n.Method] {
+		t.mu.Unlock()
+		return
+	}
+	if t.reported == nil {
+		t.reported = make(map[string]bool)
+	}
+	t.reported[n.Method] = true
+	t.mu.Unlock()
+	t.onDeprecation(n)
+}
+
+// apiMethodOf returns the name of the API method of the request: the one
+// carried by its context if sent by APIClient, or else the one of a URL built
+// by apiMethod.url.
+func apiMethodOf(req *http.Request) (string, bool) {
+	if m, ok := req.Context().Value(apiMethodKey{}).(apiMethod); ok {
+		return string(m), m != ""
+	}
+	u := req.URL
+	prefix := apiMethod("").url()
+	s := u.Scheme + "://" + u.Host + u.Path
+	if !strings.HasPrefix(s, prefix) || strings.Contains(s[len(prefix):], "/") {
+		return "", false
+	}
+	return s[len(prefix):], len(s) > len(prefix)
+}
+
+// deprecationNotice returns the notice of the response headers, or nil if
+// there is none.
+func deprecationNotice(resp *http.Response) *DeprecationNotice {
+	var n DeprecationNotice
+	found := resp.Header.Get("Deprecation") != ""
+	for _, w := range resp.Header["Warning"] {
+		if strings.HasPrefix(w, "299 ") {
+			found = true
+			n.Warning = w
+			// The text is quoted after the code and the agent.
+			if i := strings.IndexByte(w, '"'); i >= 0 {
+				n.Warning = strings.Trim(w[i:], `"`)
+			}
+			break
+		}
+	}
+	if s := resp.Header.Get("Sunset"); s != "" {
+		found = true
+		n.Sunset, _ = http.ParseTime(s)
+	}
+	if !found {
+		return nil
+	}
+	return &n
+}
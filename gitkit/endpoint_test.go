@@ -0,0 +1,144 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestEndpointMiddleware(t *testing.T) {
+	e := &Endpoint{
+		BaseURL:   IdentityPlatformAPIBaseURL,
+		Overrides: map[string]string{"getProjectConfig": "https://config.example.com/v3/getProjectConfig"},
+	}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{getAccountInfo.url(), IdentityPlatformAPIBaseURL + "/getAccountInfo"},
+		{getProjectConfig.url() + "?delegatedProjectNumber=123", "https://config.example.com/v3/getProjectConfig?delegatedProjectNumber=123"},
+		{"https://www.googleapis.com/oauth2/v3/token", "https://www.googleapis.com/oauth2/v3/token"},
+		{apiMethod("").url() + "a/b", apiMethod("").url() + "a/b"},
+	}
+	for i, tt := range tests {
+		rt := &recordingRoundTripper{roundTripper: roundTripper{http.StatusOK, "{}"}}
+		req, _ := http.NewRequest("POST", tt.url, nil)
+		if _, err := EndpointMiddleware(e, nil)(rt).RoundTrip(req); err != nil {
+			t.Errorf("[%d] RoundTrip() returns error: %v", i, err)
+			continue
+		}
+		if got := rt.reqs[0].URL.String(); got != tt.want {
+			t.Errorf("[%d] request sent to %s; want %s", i, got, tt.want)
+		}
+		if req.URL.String() != tt.url {
+			t.Errorf("[%d] the original request is modified: %s", i, req.URL)
+		}
+	}
+}
+
+// headerRoundTripper responds with the headers.
+type headerRoundTripper http.Header
+
+func (h headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := roundTripper{http.StatusOK, "{}"}.RoundTrip(req)
+	if err == nil {
+		resp.Header = http.Header(h)
+	}
+	return resp, err
+}
+
+func TestEndpointMiddleware_deprecation(t *testing.T) {
+	sunset := time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)
+	rt := headerRoundTripper{
+		"Warning": {`299 - "getAccountInfo is deprecated, use accounts:lookup"`},
+		"Sunset":  {sunset.Format(http.TimeFormat)},
+	}
+	var notices []*DeprecationNotice
+	api := &APIClient{Client: http.Client{Transport: EndpointMiddleware(&Endpoint{}, func(n *DeprecationNotice) {
+		notices = append(notices, n)
+	})(rt)}}
+	for i := 0; i < 2; i++ {
+		if _, err := api.GetAccountInfo(context.Background(), &GetAccountInfoRequest{LocalIDs: []string{"1"}}); err != nil {
+			t.Fatalf("GetAccountInfo() returns error: %v", err)
+		}
+	}
+	if len(notices) != 1 {
+		t.Fatalf("%d notices reported; want 1", len(notices))
+	}
+	want := DeprecationNotice{"getAccountInfo", getAccountInfo.url(), "getAccountInfo is deprecated, use accounts:lookup", sunset}
+	if n := *notices[0]; n.Method != want.Method || n.URL != want.URL || n.Warning != want.Warning || !n.Sunset.Equal(want.Sunset) {
+		t.Errorf("notice = %+v; want %+v", n, want)
+	}
+
+	// The responses without notices are not reported.
+	notices = nil
+	api = &APIClient{Client: http.Client{Transport: EndpointMiddleware(&Endpoint{}, func(n *DeprecationNotice) {
+		notices = append(notices, n)
+	})(headerRoundTripper{"Warning": {`199 - "miscellaneous"`}})}}
+	api.GetAccountInfo(context.Background(), &GetAccountInfoRequest{LocalIDs: []string{"1"}})
+	if len(notices) != 0 {
+		t.Errorf("notices = %v; want none", notices)
+	}
+}
+
+func TestAPIClient_baseURL(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		paths []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.Write([]byte(`{"users":[{"localId":"123"}]}`))
+	}))
+	defer srv.Close()
+
+	api := &APIClient{BaseURL: srv.URL + "/v3/relyingparty/"}
+	if _, err := api.GetAccountInfo(context.Background(), &GetAccountInfoRequest{LocalIDs: []string{"123"}}); err != nil {
+		t.Fatalf("GetAccountInfo() returns error: %v", err)
+	}
+
+	// Each Client targets its own endpoint, with the overrides still applied.
+	c, err := New(context.Background(), &Config{
+		Endpoint: &Endpoint{Overrides: map[string]string{"getProjectConfig": srv.URL + "/config"}},
+	}, WithTokenSource(staticTokenSource{}), WithAPIEndpoint(srv.URL+"/staging"))
+	if err != nil {
+		t.Fatalf("New() returns error: %v", err)
+	}
+	if _, err := c.UserByLocalID(context.Background(), "123"); err != nil {
+		t.Fatalf("UserByLocalID() returns error: %v", err)
+	}
+	if _, err := c.GetProjectConfig(context.Background()); err != nil {
+		t.Fatalf("GetProjectConfig() returns error: %v", err)
+	}
+	want := []string{"/v3/relyingparty/getAccountInfo", "/staging/getAccountInfo", "/config"}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != len(want) {
+		t.Fatalf("requests sent to %v; want %v", paths, want)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("request %d sent to %s; want %s", i, paths[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultExchangeTTL is the default lifetime of the tokens minted by a
+// TokenExchanger.
+const DefaultExchangeTTL = time.Hour
+
+// A TokenExchanger mints first-party JWTs from the validated gitkit ID tokens,
+// so that internal services trust a token signed by the application rather
+// than the Google issued one.
+type TokenExchanger struct {
+	// Signer signs the minted tokens.
+	Signer Signer
+	// Issuer and Audience are the iss and aud claims of the minted tokens.
+	Issuer   string
+	Audience string
+	// TTL is the lifetime of the minted tokens. DefaultExchangeTTL is used if
+	// zero. The minted tokens never outlive the gitkit token.
+	TTL time.Duration
+	// Claims maps the gitkit token to the claims of the minted token, in
+	// addition to iss, aud, iat and exp, which cannot be overridden. If nil,
+	// DefaultClaims is used.
+	Claims func(*Token) map[string]interface{}
+}
+
+// DefaultClaims maps the gitkit token to the sub, email, email_verified,
+// provider_id, name and picture claims.
+func DefaultClaims(t *Token) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub":            t.LocalID,
+		"email":          t.Email,
+		"email_verified": t.EmailVerified,
+		"provider_id":    t.ProviderID,
+	}
+	if t.DisplayName != "" {
+		claims["name"] = t.DisplayName
+	}
+	if t.PhotoURL != "" {
+		claims["picture"] = t.PhotoURL
+	}
+	return claims
+}
+
+// Exchange mints a token for the user of t, which must have been validated,
+// e.g., by Client.ValidateToken.
+func (e *TokenExchanger) Exchange(ctx context.Context, t *Token) (string, error) {
+	if e.Signer == nil {
+		return "", errors.New("TokenExchanger: missing Signer")
+	}
+	now := time.Now()
+	ttl := e.TTL
+	if ttl == 0 {
+		ttl = DefaultExchangeTTL
+	}
+	exp := now.Add(ttl)
+	if !t.ExpireAt.IsZero() && t.ExpireAt.Before(exp) {
+		exp = t.ExpireAt
+	}
+	if !exp.After(now) {
+		return "", ErrExpired
+	}
+	mapping := e.Claims
+	if mapping == nil {
+		mapping = DefaultClaims
+	}
+	claims := mapping(t)
+	if claims == nil {
+		claims = make(map[string]interface{})
+	}
+	claims["iss"] = e.Issuer
+	claims["aud"] = e.Audience
+	claims["iat"] = now.Unix()
+	claims["exp"] = exp.Unix()
+	return signJWT(ctx, e.Signer, claims)
+}
+
+// signJWT encodes the claims into a JWT signed by the signer.
+func signJWT(ctx context.Context, s Signer, claims interface{}) (string, error) {
+	header := map[string]string{"alg": s.Algorithm(), "typ": "JWT"}
+	if kid := s.KeyID(); kid != "" {
+		header["kid"] = kid
+	}
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := encodeSegment(h) + "." + encodeSegment(c)
+	sig, err := s.Sign(ctx, []byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + encodeSegment(sig), nil
+}
+
+func encodeSegment(b []byte) string {
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(b), "=")
+}
@@ -0,0 +1,111 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func loadTestKey(t *testing.T) *rsa.PrivateKey {
+	b, err := ioutil.ReadFile("testdata/testkey.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(b)
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// decodeJWT checks the signature of the JWT with the public key and decodes
+// its header and claims.
+func decodeJWT(t *testing.T, jwt string, pub *rsa.PublicKey) (header, claims map[string]interface{}) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed JWT %q", jwt)
+	}
+	sig, _ := decodeSegment(parts[2])
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig); err != nil {
+		t.Fatalf("invalid signature: %v", err)
+	}
+	b, _ := decodeSegment(parts[0])
+	json.Unmarshal(b, &header)
+	b, _ = decodeSegment(parts[1])
+	json.Unmarshal(b, &claims)
+	return header, claims
+}
+
+func TestTokenExchanger(t *testing.T) {
+	key := loadTestKey(t)
+	e := &TokenExchanger{
+		Signer:   NewRSASigner(key, "k1"),
+		Issuer:   "https://example.com",
+		Audience: "internal",
+		TTL:      10 * time.Minute,
+	}
+	token := &Token{LocalID: "123", Email: "user@example.com", EmailVerified: true, ProviderID: "google.com", ExpireAt: time.Now().Add(time.Hour)}
+	s, err := e.Exchange(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, claims := decodeJWT(t, s, &key.PublicKey)
+	if header["alg"] != "RS256" || header["kid"] != "k1" {
+		t.Errorf("header = %v; want RS256 with key ID k1", header)
+	}
+	if claims["iss"] != "https://example.com" || claims["aud"] != "internal" || claims["sub"] != "123" || claims["email"] != "user@example.com" || claims["email_verified"] != true {
+		t.Errorf("claims = %v", claims)
+	}
+	if ttl := claims["exp"].(float64) - claims["iat"].(float64); ttl != 600 {
+		t.Errorf("token lifetime = %vs; want 600s", ttl)
+	}
+
+	// The minted token does not outlive the gitkit token, and the claims
+	// mapping is configurable.
+	token.ExpireAt = time.Now().Add(time.Minute)
+	e.TTL = 0
+	e.Claims = func(t *Token) map[string]interface{} {
+		return map[string]interface{}{"uid": t.LocalID, "iss": "overridden"}
+	}
+	s, err = e.Exchange(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, claims = decodeJWT(t, s, &key.PublicKey)
+	if claims["uid"] != "123" || claims["sub"] != nil || claims["iss"] != "https://example.com" {
+		t.Errorf("claims = %v; want mapped claims with the exchanger issuer", claims)
+	}
+	if exp := int64(claims["exp"].(float64)); exp != token.ExpireAt.Unix() {
+		t.Errorf("exp = %d; want the gitkit token expiration %d", exp, token.ExpireAt.Unix())
+	}
+
+	token.ExpireAt = time.Now().Add(-time.Minute)
+	if _, err := e.Exchange(context.Background(), token); err != ErrExpired {
+		t.Errorf("Exchange() of an expired token returns error %v; want ErrExpired", err)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"strings"
+)
+
+// A TokenExtractor finds the ID token in a request. See
+// Config.TokenExtractor.
+type TokenExtractor interface {
+	// ExtractToken returns the ID token of the request, or an empty string
+	// if it has none.
+	ExtractToken(req *http.Request) string
+}
+
+// TokenExtractorFunc is an adapter to use a function as a TokenExtractor.
+type TokenExtractorFunc func(req *http.Request) string
+
+// ExtractToken implements the TokenExtractor interface.
+func (f TokenExtractorFunc) ExtractToken(req *http.Request) string {
+	return f(req)
+}
+
+// CookieExtractor extracts the ID token from the cookie of that name.
+type CookieExtractor string
+
+// ExtractToken implements the TokenExtractor interface.
+func (e CookieExtractor) ExtractToken(req *http.Request) string {
+	cookie, _ := req.Cookie(string(e))
+	if cookie == nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// BearerExtractor extracts the ID token from the "Authorization: Bearer"
+// header, e.g., as sent by mobile apps.
+type BearerExtractor struct{}
+
+// ExtractToken implements the TokenExtractor interface.
+func (BearerExtractor) ExtractToken(req *http.Request) string {
+	h := req.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// HeaderExtractor extracts the ID token from the header of that name, e.g.,
+// set by a proxy.
+type HeaderExtractor string
+
+// ExtractToken implements the TokenExtractor interface.
+func (e HeaderExtractor) ExtractToken(req *http.Request) string {
+	return req.Header.Get(string(e))
+}
+
+// QueryExtractor extracts the ID token from the query parameter of that name.
+// As URLs end up in logs and browser histories, it should be limited to the
+// requests which cannot carry a header, e.g., WebSocket handshakes.
+type QueryExtractor string
+
+// ExtractToken implements the TokenExtractor interface.
+func (e QueryExtractor) ExtractToken(req *http.Request) string {
+	return req.URL.Query().Get(string(e))
+}
+
+// TokenExtractors tries each extractor in order and returns the first token
+// found.
+type TokenExtractors []TokenExtractor
+
+// ExtractToken implements the TokenExtractor interface.
+func (es TokenExtractors) ExtractToken(req *http.Request) string {
+	for _, e := range es {
+		if t := e.ExtractToken(req); t != "" {
+			return t
+		}
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenExtractors(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/ws?access_token=query", nil)
+	req.AddCookie(&http.Cookie{Name: "gtoken", Value: "cookie"})
+	req.Header.Set("Authorization", "bearer  bearer")
+	req.Header.Set("X-Gitkit-Token", "header")
+
+	tests := []struct {
+		e    TokenExtractor
+		want string
+	}{
+		{CookieExtractor("gtoken"), "cookie"},
+		{CookieExtractor("other"), ""},
+		{BearerExtractor{}, "bearer"},
+		{HeaderExtractor("X-Gitkit-Token"), "header"},
+		{HeaderExtractor("X-Other"), ""},
+		{QueryExtractor("access_token"), "query"},
+		{TokenExtractors{CookieExtractor("other"), HeaderExtractor("X-Gitkit-Token"), BearerExtractor{}}, "header"},
+		{TokenExtractors{}, ""},
+		{TokenExtractorFunc(func(*http.Request) string { return "func" }), "func"},
+	}
+	for i, tt := range tests {
+		if got := tt.e.ExtractToken(req); got != tt.want {
+			t.Errorf("%d. ExtractToken() = %q; want %q", i, got, tt.want)
+		}
+	}
+
+	basic, _ := http.NewRequest("GET", "http://example.com/", nil)
+	basic.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	if got := (BearerExtractor{}).ExtractToken(basic); got != "" {
+		t.Errorf("ExtractToken() of basic authorization = %q; want empty", got)
+	}
+}
+
+func TestTokenFromRequest(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.AddCookie(&http.Cookie{Name: "gtoken", Value: "cookie"})
+	req.Header.Set("Authorization", "Bearer bearer")
+	c := &Client{config: &Config{CookieName: "gtoken"}}
+	if got := c.TokenFromRequest(req); got != "cookie" {
+		t.Errorf("TokenFromRequest() = %q; want %q", got, "cookie")
+	}
+	c.config.TokenExtractor = BearerExtractor{}
+	if got := c.TokenFromRequest(req); got != "bearer" {
+		t.Errorf("TokenFromRequest() with a bearer extractor = %q; want %q", got, "bearer")
+	}
+}
+
+func TestTokenFromRequest_legacyCookies(t *testing.T) {
+	c := &Client{config: &Config{CookieName: "gtoken", LegacyCookieNames: []string{"old", "older"}}}
+	tests := []struct {
+		cookies []*http.Cookie
+		want    string
+	}{
+		{[]*http.Cookie{{Name: "older", Value: "older"}}, "older"},
+		{[]*http.Cookie{{Name: "older", Value: "older"}, {Name: "old", Value: "old"}}, "old"},
+		{[]*http.Cookie{{Name: "old", Value: "old"}, {Name: "gtoken", Value: "new"}}, "new"},
+		{[]*http.Cookie{{Name: "other", Value: "other"}}, ""},
+	}
+	for i, tt := range tests {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		for _, cookie := range tt.cookies {
+			req.AddCookie(cookie)
+		}
+		if got := c.TokenFromRequest(req); got != tt.want {
+			t.Errorf("%d. TokenFromRequest() = %q; want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestSetTokenCookie(t *testing.T) {
+	c := &Client{config: &Config{CookieName: "gtoken", LegacyCookieNames: []string{"old"}}}
+	w := httptest.NewRecorder()
+	exp := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.SetTokenCookie(w, &Token{TokenString: "token", ExpireAt: exp})
+	resp := http.Response{Header: w.Header()}
+	cookies := resp.Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("SetTokenCookie() sets %d cookies; want 2", len(cookies))
+	}
+	if c := cookies[0]; c.Name != "gtoken" || c.Value != "token" || !c.Expires.Equal(exp) {
+		t.Errorf("SetTokenCookie() sets %v; want gtoken=token expiring at %v", c, exp)
+	}
+	if c := cookies[1]; c.Name != "old" || c.MaxAge >= 0 {
+		t.Errorf("SetTokenCookie() sets %v; want old deleted", c)
+	}
+}
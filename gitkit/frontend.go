@@ -0,0 +1,66 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"html/template"
+
+	"golang.org/x/net/context"
+)
+
+// FrontendConfig is the configuration the browser side of the sign in flow
+// needs, built from the Client configuration and the project configuration.
+//
+// It is rendered into pages by JS, e.g., with html/template,
+//
+//	<script>var gitkitConfig = {{.Frontend.JS}};</script>
+type FrontendConfig struct {
+	// APIKey is the API key used to call Google API in web browser.
+	APIKey string `json:"apiKey,omitempty"`
+	// SignInOptions are the sign in methods provided to users for sign in.
+	SignInOptions []string `json:"signInOptions,omitempty"`
+	// WidgetURL is the URL of the sign in widget, see Config.WidgetURL.
+	WidgetURL string `json:"widgetUrl,omitempty"`
+	// CookieName is the name of the ID token cookie, see Config.CookieName.
+	CookieName string `json:"cookieName,omitempty"`
+	// ModeParam is the query parameter selecting the widget mode, see
+	// Config.WidgetModeParamName.
+	ModeParam string `json:"modeParam,omitempty"`
+}
+
+// FrontendConfig returns the frontend configuration of the project.
+func (c *Client) FrontendConfig(ctx context.Context) (*FrontendConfig, error) {
+	pc, err := c.ProjectConfig(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &FrontendConfig{
+		APIKey:        pc.BrowserAPIKey,
+		SignInOptions: pc.SignInOptions,
+		WidgetURL:     c.config.WidgetURL,
+		CookieName:    c.config.CookieName,
+		ModeParam:     c.config.WidgetModeParamName,
+	}, nil
+}
+
+// JS renders the configuration as a JSON object whose <, > and & characters
+// are escaped, so that it can be embedded as is in the script elements of
+// html/template pages.
+func (f *FrontendConfig) JS() template.JS {
+	// The marshaling of strings cannot fail.
+	b, _ := json.Marshal(f)
+	return template.JS(b)
+}
@@ -0,0 +1,54 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestClient_FrontendConfig(t *testing.T) {
+	c := newMiddlewareClient()
+	c.config.WidgetURL = "/widget"
+	c.api = prepareClient(false, projectConfigJSON)
+	f, err := c.FrontendConfig(context.Background())
+	if err != nil {
+		t.Fatalf("FrontendConfig() returns error: %v", err)
+	}
+	want := `{"apiKey":"browser-key","signInOptions":["google","password"],"widgetUrl":"/widget","cookieName":"gtoken","modeParam":"mode"}`
+	if got := string(f.JS()); got != want {
+		t.Errorf("JS() = %s; want %s", got, want)
+	}
+}
+
+func TestFrontendConfig_JS(t *testing.T) {
+	f := &FrontendConfig{APIKey: "k", WidgetURL: "/widget?a=1&b=</script><script>alert(1)"}
+	tmpl := template.Must(template.New("").Parse(`<script>var c = {{.JS}};</script>`))
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, f); err != nil {
+		t.Fatal(err)
+	}
+	s := b.String()
+	if strings.Count(s, "</script>") != 1 || strings.Contains(s, "&b") {
+		t.Errorf("page is not escaped: %s", s)
+	}
+	if !strings.Contains(s, `"widgetUrl":"/widget?a=1\u0026b=\u003c/script\u003e\u003cscript\u003ealert(1)"`) {
+		t.Errorf("page = %s; want the escaped JSON configuration", s)
+	}
+}
@@ -31,7 +31,10 @@ func defaultTransport(ctx context.Context) http.RoundTripper {
 
 // apiClient creates a new APIClient based on the current context.
 func (c *Client) apiClient(ctx context.Context) *APIClient {
+	if err := c.waitInit(ctx); err != nil {
+		return failedAPIClient(err)
+	}
 	// newAPIClient should never return error on App Engine.
-	api, _ := newAPIClient(ctx, c.jc)
+	api, _ := c.newAPIClient(ctx)
 	return api
 }
@@ -15,16 +15,23 @@
 package gitkit
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"golang.org/x/oauth2/jwt"
+	"google.golang.org/api/googleapi"
 )
 
 const (
@@ -41,154 +48,674 @@ type Client struct {
 	certs     *Certificates
 	api       *APIClient // Don't use this field directly. Use apiClient() instead.
 	jc        *jwt.Config
+	sem       chan struct{}  // Limits in-flight API requests if not nil.
+	ready     chan struct{}  // Closed when the prewarmed certificates are downloaded.
+	readyErr  error          // Error of the prewarm download.
+	lookups   *lookupBatcher // Coalesces the lookups if not nil.
+	tokens    *tokenCache    // Caches the verified tokens if not nil.
+	proxies   []*net.IPNet   // Parsed Config.TrustedProxies.
+	init      *clientInit    // Initialization in progress if not nil, see NewLazy.
+
+	customMu     sync.Mutex // Lock for loading the custom token signer.
+	customSigner Signer     // Custom token signer once loaded, or nil.
+	customIssuer string
 }
 
 // ProjectConfig contains the Gitkit configurations of the project.
 type ProjectConfig struct {
 	// BrowserAPIKey is the API key used to call Google API in web browser.
+	//
+	// Deprecated: Use Client.FrontendConfig, which bundles the settings of
+	// the browser.
 	BrowserAPIKey string `json:"browserApiKey,omitempty"`
 	// ClientID is the Google OAuth2 client ID for the server.
 	ClientID string `json:"clientId,omitempty"`
 	// SignInOptions are the sign in methods provided to users for sign in.
+	//
+	// Deprecated: Use Client.FrontendConfig.
 	SignInOptions []string `json:"signInOptions,omitempty"`
+	// ProjectID is the ID of the Google cloud project.
+	ProjectID string `json:"projectId,omitempty"`
+	// AllowPasswordUser indicates if the users can sign in with a password.
+	AllowPasswordUser bool `json:"allowPasswordUser,omitempty"`
+	// EnableAnonymousUser indicates if anonymous users are allowed.
+	EnableAnonymousUser bool `json:"enableAnonymousUser,omitempty"`
+	// IdpConfigs are the configurations of the identity providers.
+	IdpConfigs []*IdpConfig `json:"idpConfigs,omitempty"`
+	// AuthorizedDomains are the domains the widget may be served from.
+	AuthorizedDomains []string `json:"authorizedDomains,omitempty"`
+	// UseEmailSending indicates if identitytoolkit sends the emails of the
+	// out-of-band actions with the templates below.
+	UseEmailSending bool `json:"useEmailSending,omitempty"`
+	// ResetPasswordTemplate, ChangeEmailTemplate and VerifyEmailTemplate are
+	// the templates of the emails of the out-of-band actions.
+	ResetPasswordTemplate *EmailTemplate `json:"resetPasswordTemplate,omitempty"`
+	ChangeEmailTemplate   *EmailTemplate `json:"changeEmailTemplate,omitempty"`
+	VerifyEmailTemplate   *EmailTemplate `json:"verifyEmailTemplate,omitempty"`
+}
+
+// ProjectConfigOptions selects the project whose configuration
+// Client.ProjectConfig retrieves. The zero value selects the project of the
+// credentials of the Client.
+type ProjectConfigOptions struct {
+	// ProjectNumber is the number of the project.
+	ProjectNumber string
+	// DelegatedProjectNumber is the number of the project delegating its
+	// authentication to the project of the credentials.
+	DelegatedProjectNumber string
+}
+
+// EmailTemplates are the templates of the emails of the out-of-band actions
+// updated by Client.UpdateEmailTemplates. The nil templates are left
+// unchanged.
+type EmailTemplates struct {
+	ResetPassword *EmailTemplate `json:"resetPassword,omitempty"`
+	ChangeEmail   *EmailTemplate `json:"changeEmail,omitempty"`
+	VerifyEmail   *EmailTemplate `json:"verifyEmail,omitempty"`
+	// UseEmailSending, if not nil, enables or disables the sending of the
+	// emails by identitytoolkit.
+	UseEmailSending *bool `json:"useEmailSending,omitempty"`
+}
+
+// New creates a Client from the configuration. The options, if any, are
+// applied to a copy of the configuration before the Client is created.
+//
+// New loads the credentials before it returns, which may block on the
+// network, e.g., for Application Default Credentials. See NewLazy for a Client
+// initialized in the background.
+func New(ctx context.Context, config *Config, opts ...Option) (*Client, error) {
+	c, err := newClient(config, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.initialize(ctx); err != nil {
+		return nil, err
+	}
+	if c.config.PrewarmCerts {
+		c.prewarmCerts(ctx)
+	}
+	return c, nil
 }
 
-// New creates a Client from the configuration.
-func New(ctx context.Context, config *Config) (*Client, error) {
+// newClient creates a Client from the validated configuration, without its
+// credentials and API client, see initialize.
+func newClient(config *Config, opts []Option) (*Client, error) {
 	conf := *config
-	certs := &Certificates{URL: publicCertsURL}
+	for _, opt := range opts {
+		opt(&conf)
+	}
+	certsURL, err := applyPreset(&conf)
+	if err != nil {
+		return nil, err
+	}
+	certs := &Certificates{
+		URL:           certsURL,
+		URLs:          conf.CertsURLs,
+		HedgeDelay:    conf.CertsHedgeDelay,
+		StaleGrace:    conf.CertsStaleGrace,
+		RefreshMargin: conf.CertsRefreshMargin,
+		Manual:        conf.ManualCertsRefresh,
+		Logf:          conf.Logf,
+	}
+	if conf.HTTPClient != nil {
+		certs.Transport = conf.HTTPClient.Transport
+		if certs.Transport == nil {
+			certs.Transport = http.DefaultTransport
+		}
+	}
 	var widgetURL *url.URL
 	if conf.WidgetURL != "" {
-		var err error
 		widgetURL, err = url.Parse(conf.WidgetURL)
 		if err != nil {
 			return nil, fmt.Errorf("invalid WidgetURL: %s", conf.WidgetURL)
 		}
 	}
-	var jc *jwt.Config
-	if config.GoogleAppCredentialsPath != "" {
-		b, err := ioutil.ReadFile(config.GoogleAppCredentialsPath)
-		if err != nil {
-			return nil, fmt.Errorf("invalid GoogleAppCredentialsPath: %v", err)
-		}
-		jc, err = google.JWTConfigFromJSON(b, identitytoolkitScope)
-		if err != nil {
-			return nil, err
+	if conf.BaseURL != "" {
+		if u, err := url.Parse(conf.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid BaseURL: %s", conf.BaseURL)
 		}
 	}
-	api, err := newAPIClient(ctx, jc)
+	switch conf.UnknownFields {
+	case UnknownFieldsIgnore, UnknownFieldsLog, UnknownFieldsReject:
+	default:
+		return nil, fmt.Errorf("unsupported UnknownFields: %s", conf.UnknownFields)
+	}
+	switch conf.APIVersion {
+	case "", APIv3, APIv1:
+	default:
+		return nil, fmt.Errorf("unsupported APIVersion: %s", conf.APIVersion)
+	}
+	proxies, err := parseTrustedProxies(conf.TrustedProxies)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkCredentials(&conf); err != nil {
+		return nil, err
+	}
 	conf.normalize()
-	return &Client{
+	c := &Client{
 		config:    &conf,
 		widgetURL: widgetURL,
 		certs:     certs,
-		api:       api,
-		jc:        jc,
-	}, nil
+		proxies:   proxies,
+	}
+	if conf.MaxConcurrentRequests > 0 {
+		c.sem = make(chan struct{}, conf.MaxConcurrentRequests)
+	}
+	if conf.LookupBatchWindow > 0 {
+		c.lookups = newLookupBatcher(c, conf.LookupBatchWindow, conf.LookupBatchSize)
+	}
+	if conf.TokenCacheSize > 0 {
+		c.tokens = newTokenCache(conf.TokenCacheSize, conf.TokenCacheTTL)
+	}
+	return c, nil
+}
+
+// initialize loads the credentials of the Client and creates its API client.
+func (c *Client) initialize(ctx context.Context) error {
+	jc, err := loadJWTConfig(c.config)
+	if err != nil {
+		return err
+	}
+	c.jc = jc
+	api, err := c.newAPIClient(ctx)
+	if err != nil {
+		return err
+	}
+	c.api = api
+	return nil
 }
 
-func newAPIClient(ctx context.Context, jc *jwt.Config) (*APIClient, error) {
+// checkCredentials checks that at most one kind of credentials is configured.
+func checkCredentials(conf *Config) error {
+	n := 0
+	for _, set := range []bool{
+		conf.GoogleAppCredentialsPath != "",
+		len(conf.GoogleAppCredentialsJSON) != 0,
+		conf.JWTConfig != nil,
+		conf.TokenSource != nil,
+	} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		return fmt.Errorf("only one of GoogleAppCredentialsPath, GoogleAppCredentialsJSON, JWTConfig and TokenSource can be set")
+	}
+	return nil
+}
+
+// loadJWTConfig returns the service account JWT config, either provided
+// directly or created from the JSON key file or the JSON key content in the
+// configuration. It returns nil if none of them is provided, in which case the
+// TokenSource or Application Default Credentials are used.
+func loadJWTConfig(conf *Config) (*jwt.Config, error) {
+	if conf.JWTConfig != nil {
+		return conf.JWTConfig, nil
+	}
+	b := conf.GoogleAppCredentialsJSON
+	if conf.GoogleAppCredentialsPath != "" {
+		var err error
+		b, err = ioutil.ReadFile(conf.GoogleAppCredentialsPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GoogleAppCredentialsPath: %v", err)
+		}
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	return google.JWTConfigFromJSON(b, identitytoolkitScope)
+}
+
+func (c *Client) newAPIClient(ctx context.Context) (*APIClient, error) {
+	if c.config.HTTPClient != nil {
+		// The OAuth2 clients send their requests and fetch their tokens
+		// through the client of the context.
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, c.config.HTTPClient)
+	}
 	var hc *http.Client
-	if jc != nil {
-		hc = jc.Client(ctx)
-	} else {
+	switch {
+	case c.config.TokenSource != nil:
+		hc = oauth2.NewClient(ctx, c.config.TokenSource)
+	case c.jc != nil:
+		hc = c.jc.Client(ctx)
+	default:
 		var err error
 		hc, err = google.DefaultClient(ctx, identitytoolkitScope)
 		if err != nil {
 			return nil, err
 		}
 	}
-	return &APIClient{
-		http.Client{
-			Transport: &transport{hc.Transport},
+	// The chain from the outermost: unknown fields check, API version,
+	// endpoint, retry, metrics, concurrency limit, the middlewares of the
+	// configuration, user agent and auth.
+	var mws []TransportMiddleware
+	if c.config.UnknownFields != UnknownFieldsIgnore {
+		mws = append(mws, UnknownFieldsMiddleware(c.config.UnknownFields, c.config.Logf))
+	}
+	if v := c.config.APIVersion; v != "" && v != APIv3 {
+		mws = append(mws, APIVersionMiddleware(v, c.config.ProjectID))
+	}
+	var baseURL string
+	if onDeprecation := c.onDeprecation(); c.config.Endpoint != nil || onDeprecation != nil {
+		// The base URL is the APIClient's, only the overrides are left to
+		// the middleware.
+		e := &Endpoint{}
+		if c.config.Endpoint != nil {
+			baseURL = c.config.Endpoint.BaseURL
+			e.Overrides = c.config.Endpoint.Overrides
+		}
+		mws = append(mws, EndpointMiddleware(e, onDeprecation))
+	}
+	retry := c.config.RetryPolicy
+	if retry == nil {
+		retry = &RetryPolicy{MaxRetries: c.config.MaxRetries, MaxWait: c.config.MaxRetryWait}
+	}
+	if retry.MaxRetries > 0 {
+		mws = append(mws, retryMiddleware(retry, c.config.Logf, c.config.Metrics))
+	}
+	if c.config.Metrics != nil {
+		mws = append(mws, func(next http.RoundTripper) http.RoundTripper {
+			return &metricsTransport{next, c.config.Metrics}
+		})
+	}
+	if c.sem != nil {
+		mws = append(mws, func(next http.RoundTripper) http.RoundTripper {
+			return &limitTransport{next, c.sem}
+		})
+	}
+	mws = append(mws, c.config.TransportMiddlewares...)
+	mws = append(mws, UserAgentMiddleware)
+	t := ChainTransport(hc.Transport, mws...)
+	api := &APIClient{
+		Client: http.Client{
+			Transport: t,
 		},
-	}, nil
+		BaseURL: baseURL,
+	}
+	if c.config.HTTPClient != nil {
+		api.Client.Timeout = c.config.HTTPClient.Timeout
+	}
+	return api, nil
+}
+
+// onDeprecation returns the receiver of the deprecation notices of the API
+// responses: Config.OnDeprecation, or else a logger to Config.Logf, if set.
+func (c *Client) onDeprecation() func(*DeprecationNotice) {
+	if c.config.OnDeprecation != nil {
+		return c.config.OnDeprecation
+	}
+	if logf := c.config.Logf; logf != nil {
+		return func(n *DeprecationNotice) {
+			if n.Sunset.IsZero() {
+				logf("gitkit: API method %s at %s is deprecated: %s", n.Method, n.URL, n.Warning)
+			} else {
+				logf("gitkit: API method %s at %s is deprecated, sunset on %v: %s", n.Method, n.URL, n.Sunset, n.Warning)
+			}
+		}
+	}
+	return nil
 }
 
-// TokenFromRequest extracts the ID token from the HTTP request if present.
+// Certificates returns the public certificates which verify the ID tokens.
+func (c *Client) Certificates() *Certificates {
+	return c.certs
+}
+
+// Close stops the background work of the Client, i.e., the refreshes of the
+// public certificates. See Certificates.Close.
+func (c *Client) Close() error {
+	return c.certs.Close()
+}
+
+// prewarmCerts downloads the certificates in the background.
+func (c *Client) prewarmCerts(ctx context.Context) {
+	c.ready = make(chan struct{})
+	go func() {
+		defer close(c.ready)
+		c.readyErr = c.certs.Refresh(ctx)
+		if c.readyErr != nil && c.config.Logf != nil {
+			c.config.Logf("gitkit: prewarming certificates: %v", c.readyErr)
+		}
+	}()
+}
+
+// WaitReady waits until the certificates prewarmed by New, see
+// Config.PrewarmCerts, are downloaded or ctx is done, e.g., in a readiness
+// check, and returns the download error. Without prewarming, it loads the
+// certificates if necessary. For a Client created by NewLazy, it waits for the
+// whole initialization.
+func (c *Client) WaitReady(ctx context.Context) error {
+	if c.ready == nil {
+		return c.certs.LoadIfNecessary(defaultTransport(ctx))
+	}
+	select {
+	case <-c.ready:
+		return c.readyErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TokenFromRequest extracts the ID token from the HTTP request if present,
+// with Config.TokenExtractor or from the Config.CookieName cookie, then the
+// Config.LegacyCookieNames ones.
 func (c *Client) TokenFromRequest(req *http.Request) string {
-	cookie, _ := req.Cookie(c.config.CookieName)
-	if cookie == nil {
-		return ""
+	if c.config.TokenExtractor != nil {
+		return c.config.TokenExtractor.ExtractToken(req)
+	}
+	if t := CookieExtractor(c.config.CookieName).ExtractToken(req); t != "" {
+		return t
+	}
+	for _, name := range c.config.LegacyCookieNames {
+		if t := CookieExtractor(name).ExtractToken(req); t != "" {
+			return t
+		}
+	}
+	return ""
+}
+
+// SetTokenCookie writes the ID token to the Config.CookieName cookie, which
+// expires with the token, and deletes the Config.LegacyCookieNames cookies,
+// so that the browsers migrate to the new cookie name as the users sign in.
+func (c *Client) SetTokenCookie(w http.ResponseWriter, t *Token) {
+	http.SetCookie(w, &http.Cookie{
+		Name:    c.config.CookieName,
+		Value:   t.TokenString,
+		Path:    "/",
+		Expires: t.ExpireAt,
+	})
+	for _, name := range c.config.LegacyCookieNames {
+		http.SetCookie(w, &http.Cookie{Name: name, Path: "/", MaxAge: -1})
+	}
+}
+
+// ClearTokenCookie deletes the Config.CookieName and Config.LegacyCookieNames
+// cookies, e.g., to sign the user out.
+func (c *Client) ClearTokenCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: c.config.CookieName, Path: "/", MaxAge: -1})
+	for _, name := range c.config.LegacyCookieNames {
+		http.SetCookie(w, &http.Cookie{Name: name, Path: "/", MaxAge: -1})
 	}
-	return cookie.Value
 }
 
 // ValidateToken validates the ID token and returns a Token.
 //
 // Beside verifying the token is a valid JWT, it also validates that the token
-// is not expired and is issued to the client with the given audiences.
+// is not expired and is issued to the client with the given audiences. If no
+// audiences are given, those Config.AudiencesByHost maps the host carried by
+// ctx to are used, see WithRequestHost, or else Config.Audiences. The token must also meet
+// Config.RequiredClaims, or a *ClaimError is returned. If
+// Config.RequireVerifiedEmail is set, the tokens whose email address is not
+// verified are rejected with ErrEmailNotVerified. The roles of the token are
+// mapped by Config.RoleMapper, if set. The signature is verified
+// with the keys of Config.KeyResolver if set, or else with the certificates of
+// Config.CertificateSource if set, or else with the identitytoolkit
+// certificates. The expiration and issue times are checked with the
+// Config.ClockSkew leeway. The verified tokens are cached if Config.TokenCacheSize is
+// set.
 func (c *Client) ValidateToken(ctx context.Context, token string, audiences []string) (*Token, error) {
-	if err := c.certs.LoadIfNecessary(defaultTransport(ctx)); err != nil {
+	t, err := c.verifyToken(ctx, token, c.audiences(ctx, audiences))
+	if err != nil {
 		return nil, err
 	}
-	t, err := VerifyToken(token, audiences, nil, c.certs)
+	if c.config.RequireVerifiedEmail && !t.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+	if err := CheckClaims(t, c.config.RequiredClaims...); err != nil {
+		return nil, err
+	}
+	if c.config.RoleMapper != nil {
+		if err := c.config.RoleMapper.MapRoles(t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// verifyToken verifies the signature, the issuer, the audience and the
+// expiration of the token, unless it is cached by a previous verification.
+func (c *Client) verifyToken(ctx context.Context, token string, audiences []string) (*Token, error) {
+	if c.tokens != nil {
+		if t := c.tokens.get(token, time.Now()); t != nil {
+			if len(audiences) == 0 {
+				return nil, ErrMissingAudience
+			}
+			if !inArray(audiences, t.Audience) {
+				return nil, ErrInvalidAudience
+			}
+			c.count(MetricTokenCacheHits, 1)
+			return t, nil
+		}
+	}
+	var r KeyResolver = c.certs
+	switch {
+	case c.config.KeyResolver != nil:
+		r = c.config.KeyResolver
+	case c.config.CertificateSource != nil:
+		r = sourceKeyResolver{c.config.CertificateSource}
+	default:
+		if c.ready != nil {
+			// Don't download the certificates being prewarmed twice.
+			c.WaitReady(ctx)
+		}
+		if err := c.certs.loadIfNecessary(ctx, defaultTransport(ctx)); err != nil {
+			return nil, err
+		}
+	}
+	t, err := VerifyTokenWithOptions(token, r, &VerifyOptions{audiences, c.config.Issuers, c.config.ClockSkew})
 	if err != nil {
 		return nil, err
 	}
+	if c.tokens != nil {
+		c.tokens.add(t, time.Now())
+	}
 	return t, nil
 }
 
 // UserByToken retrieves the account information of the user specified by the ID
-// token.
+// token. If Config.UserFromTokenFallback is set and the account information
+// can't be retrieved, e.g., during an API outage, the user built from the
+// token by UserFromToken is returned instead.
 func (c *Client) UserByToken(ctx context.Context, token string, audiences []string) (*User, error) {
 	t, err := c.ValidateToken(ctx, token, audiences)
 	if err != nil {
 		return nil, err
 	}
-	localID := t.LocalID
-	providerID := t.ProviderID
-	u, err := c.UserByLocalID(ctx, localID)
+	return c.userOfToken(ctx, t)
+}
+
+// userOfToken retrieves the account information of the user of the validated
+// token, or builds it from the token if Config.UserFromTokenFallback is set
+// and the retrieval fails.
+func (c *Client) userOfToken(ctx context.Context, t *Token) (*User, error) {
+	u, err := c.UserByLocalID(ctx, t.LocalID)
 	if err != nil {
-		return nil, err
+		if _, notFound := err.(UserNotFoundError); notFound || !c.config.UserFromTokenFallback {
+			return nil, err
+		}
+		c.count(MetricUserFromTokenFallbacks, 1)
+		return c.UserFromToken(t), nil
+	}
+	u.ProviderID = t.ProviderID
+	if c.config.RecordLinkHistory {
+		if _, err := c.RecordProviderLinks(ctx, u); err != nil && c.config.Logf != nil {
+			c.config.Logf("gitkit: recording the provider links of user %s: %v", u.LocalID, err)
+		}
 	}
-	u.ProviderID = providerID
 	return u, nil
 }
 
+// UserFromToken builds the user of the validated ID token from its claims,
+// without any API call. Only the fields carried by the token are set: the
+// password, the other providers and the custom attributes are missing.
+func (c *Client) UserFromToken(t *Token) *User {
+	u := &User{
+		LocalID:       t.LocalID,
+		Email:         t.Email,
+		EmailVerified: t.EmailVerified,
+		DisplayName:   t.DisplayName,
+		PhotoURL:      t.PhotoURL,
+		ProviderID:    t.ProviderID,
+	}
+	if t.ProviderID != "" {
+		u.ProviderUserInfo = []ProviderUserInfo{{
+			ProviderID:  t.ProviderID,
+			Email:       t.Email,
+			DisplayName: t.DisplayName,
+			PhotoURL:    t.PhotoURL,
+		}}
+	}
+	return u
+}
+
+// ErrEmailNotVerified is returned by ValidateToken for a valid token whose
+// email address is not verified when Config.RequireVerifiedEmail is set.
+var ErrEmailNotVerified = errors.New("email address not verified")
+
+// UserNotFoundError is returned when the requested user does not exist. Its
+// value is the email address or local ID used to look up the user.
+type UserNotFoundError string
+
+// Error implements error interface.
+func (e UserNotFoundError) Error() string {
+	return fmt.Sprintf("user %s not found", string(e))
+}
+
 // UserByEmail retrieves the account information of the user specified by the
 // email address.
 func (c *Client) UserByEmail(ctx context.Context, email string) (*User, error) {
-	resp, err := c.apiClient(ctx).GetAccountInfo(&GetAccountInfoRequest{Emails: []string{email}})
+	resp, err := c.lookupAccountInfo(ctx, &GetAccountInfoRequest{Emails: []string{email}})
 	if err != nil {
 		return nil, err
 	}
 	if len(resp.Users) == 0 {
-		return nil, fmt.Errorf("user %s not found", email)
+		return nil, UserNotFoundError(email)
 	}
 	return resp.Users[0], nil
 }
 
 // UserByLocalID retrieves the account information of the user specified by the
-// local ID.
+// local ID. Concurrent lookups are coalesced if Config.LookupBatchWindow is
+// set.
 func (c *Client) UserByLocalID(ctx context.Context, localID string) (*User, error) {
-	resp, err := c.apiClient(ctx).GetAccountInfo(&GetAccountInfoRequest{LocalIDs: []string{localID}})
+	if c.lookups != nil {
+		return c.lookups.lookup(ctx, localID)
+	}
+	resp, err := c.lookupAccountInfo(ctx, &GetAccountInfoRequest{LocalIDs: []string{localID}})
 	if err != nil {
 		return nil, err
 	}
 	if len(resp.Users) == 0 {
-		return nil, fmt.Errorf("user %s not found", localID)
+		return nil, UserNotFoundError(localID)
 	}
 	return resp.Users[0], nil
 }
 
+// PasswordProviderID is the provider ID of email and password accounts.
+const PasswordProviderID = "password"
+
+// Providers returns the IDs of the providers the user can sign in with. It
+// includes PasswordProviderID if the user has a password.
+func (u *User) Providers() []string {
+	var providers []string
+	if len(u.PasswordHash) != 0 {
+		providers = append(providers, PasswordProviderID)
+	}
+	for _, p := range u.ProviderUserInfo {
+		if p.ProviderID != "" {
+			providers = append(providers, p.ProviderID)
+		}
+	}
+	return providers
+}
+
+// AccountConflict describes an existing account with an email address.
+type AccountConflict struct {
+	// Email is the email address checked.
+	Email string
+	// Registered indicates if an account with the email address exists.
+	Registered bool
+	// Providers are the IDs of the providers the existing account can sign in
+	// with.
+	Providers []string
+	// LinkRequired indicates if the account exists but cannot be signed in with
+	// the requested provider, so that the user needs to sign in with one of
+	// Providers first and link the accounts.
+	LinkRequired bool
+}
+
+// NewAccountConflict describes the conflict of signing up with the provider
+// when the user, which may be nil, exists with the email address.
+func NewAccountConflict(email, providerID string, u *User) *AccountConflict {
+	conflict := &AccountConflict{Email: email}
+	if u == nil {
+		return conflict
+	}
+	conflict.Registered = true
+	conflict.Providers = u.Providers()
+	conflict.LinkRequired = true
+	for _, p := range conflict.Providers {
+		if p == providerID {
+			conflict.LinkRequired = false
+		}
+	}
+	return conflict
+}
+
+// CheckAccountConflict reports whether an account with the email address is
+// already registered and whether signing up with the provider requires linking
+// to it. The provider ID is PasswordProviderID for email and password sign up.
+func (c *Client) CheckAccountConflict(ctx context.Context, email, providerID string) (*AccountConflict, error) {
+	u, err := c.UserByEmail(ctx, email)
+	if _, ok := err.(UserNotFoundError); ok {
+		return NewAccountConflict(email, providerID, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewAccountConflict(email, providerID, u), nil
+}
+
 // UpdateUser updates the account information of the user.
 func (c *Client) UpdateUser(ctx context.Context, user *User) error {
-	_, err := c.apiClient(ctx).SetAccountInfo(&SetAccountInfoRequest{
+	_, err := c.mutatingAPIClient(ctx).SetAccountInfo(ctx, &SetAccountInfoRequest{
 		LocalID:       user.LocalID,
 		Email:         user.Email,
 		DisplayName:   user.DisplayName,
 		Password:      user.Password,
 		EmailVerified: user.EmailVerified})
+	c.audit(ctx, AuditOpUpdateUser, []string{user.LocalID}, err)
+	if err == nil && c.config.OnUserUpdated != nil {
+		c.config.OnUserUpdated(ctx, user)
+	}
+	return err
+}
+
+// SetUserDisabled disables or enables the account of the user specified by the
+// local ID. A disabled user can't sign in. UpdateUser leaves the account
+// enabled or disabled.
+func (c *Client) SetUserDisabled(ctx context.Context, user *User, disabled bool) error {
+	_, err := c.mutatingAPIClient(ctx).SetAccountInfo(ctx, &SetAccountInfoRequest{
+		LocalID:     user.LocalID,
+		DisableUser: &disabled,
+	})
+	c.audit(ctx, AuditOpUpdateUser, []string{user.LocalID}, err)
+	if err == nil && c.config.OnUserUpdated != nil {
+		u := *user
+		u.Disabled = disabled
+		c.config.OnUserUpdated(ctx, &u)
+	}
 	return err
 }
 
 // DeleteUser deletes a user specified by the local ID.
 func (c *Client) DeleteUser(ctx context.Context, user *User) error {
-	_, err := c.apiClient(ctx).DeleteAccount(&DeleteAccountRequest{LocalID: user.LocalID})
+	_, err := c.mutatingAPIClient(ctx).DeleteAccount(ctx, &DeleteAccountRequest{LocalID: user.LocalID})
+	c.audit(ctx, AuditOpDeleteUser, []string{user.LocalID}, err)
+	if err == nil && c.config.OnUserDeleted != nil {
+		c.config.OnUserDeleted(ctx, user)
+	}
 	return err
 }
 
@@ -196,25 +723,312 @@ func (c *Client) DeleteUser(ctx context.Context, user *User) error {
 // algorithm, key, saltSeparator specify the password hash algorithm, signer key
 // and separator between password and salt accordingly.
 func (c *Client) UploadUsers(ctx context.Context, users []*User, algorithm string, key, saltSeparator []byte) error {
-	resp, err := c.apiClient(ctx).UploadAccount(&UploadAccountRequest{users, algorithm, key, saltSeparator})
+	return c.UploadUsersWithOptions(ctx, users, &UploadOptions{
+		HashAlgorithm: algorithm,
+		SignerKey:     key,
+		SaltSeparator: saltSeparator,
+	})
+}
+
+// UploadOptions describes how the passwords of the uploaded users are hashed.
+// See the hash subpackage for the options of common password hash schemes.
+type UploadOptions struct {
+	// HashAlgorithm is the password hash algorithm, e.g., HMAC_SHA256, MD5 or
+	// PBKDF2_SHA256.
+	HashAlgorithm string
+	// SignerKey is the key of the HMAC and SCRYPT algorithms.
+	SignerKey []byte
+	// SaltSeparator is inserted between the password and the salt.
+	SaltSeparator []byte
+	// Rounds is the number of iterations of the hash.
+	Rounds int
+	// MemoryCost is the memory cost of the SCRYPT algorithm.
+	MemoryCost int
+	// PasswordHashOrder is SaltAndPassword or PasswordAndSalt for the salted
+	// hashes.
+	PasswordHashOrder string
+	// Argon2Parameters are the parameters of the ARGON2 algorithm.
+	Argon2Parameters *Argon2Parameters
+	// BatchSize is the maximum number of users uploaded per request, at most
+	// MaxUploadChunk, which is used if it is not set. Larger uploads are
+	// split into batches.
+	BatchSize int
+	// Concurrency is the maximum number of batches uploaded concurrently. If
+	// it is not set, Config.MaxConcurrentRequests or a default is used.
+	Concurrency int
+	// RetryFailed is the number of times the users which failed with a
+	// retryable reason, see UploadFailure.IsRetryable, are uploaded again.
+	RetryFailed int
+}
+
+// UploadUsersWithOptions uploads the users whose passwords are hashed as
+// described by opts, in batches of opts.BatchSize users. The failures of all
+// the batches are returned in a single UploadError whose indexes refer to
+// users; the users of a batch failing as a whole are reported with its error.
+// If all the batches fail as a whole, the error is returned as is.
+func (c *Client) UploadUsersWithOptions(ctx context.Context, users []*User, opts *UploadOptions) error {
+	err := c.uploadUsers(ctx, users, opts)
+	c.countUploads(len(users), err)
+	localIDs := make([]string, len(users))
+	for i, u := range users {
+		localIDs[i] = u.LocalID
+	}
+	c.audit(ctx, AuditOpUploadUsers, localIDs, err)
+	if c.config.OnUserCreated != nil {
+		c.usersCreated(ctx, users, err)
+	}
+	return err
+}
+
+// countUploads reports the users uploaded and failed by UploadUsers to
+// Config.Metrics.
+func (c *Client) countUploads(n int, err error) {
+	failed := n
+	if err == nil {
+		failed = 0
+	} else if uploadErr, ok := err.(UploadError); ok {
+		failed = len(uploadErr)
+	}
+	c.count(MetricUsersUploaded, int64(n-failed))
+	c.count(MetricUploadFailures, int64(failed))
+}
+
+// usersCreated calls Config.OnUserCreated with the users uploaded by
+// UploadUsers, skipping the ones reported as failed by err.
+func (c *Client) usersCreated(ctx context.Context, users []*User, err error) {
+	failed := make(map[int]bool)
+	if err != nil {
+		uploadErr, ok := err.(UploadError)
+		if !ok {
+			return
+		}
+		for _, e := range uploadErr {
+			failed[e.Index] = true
+		}
+	}
+	for i, u := range users {
+		if !failed[i] {
+			c.config.OnUserCreated(ctx, u)
+		}
+	}
+}
+
+func (c *Client) uploadUsers(ctx context.Context, users []*User, opts *UploadOptions) error {
+	err := c.uploadBatches(ctx, users, opts)
+	for i := 0; i < opts.RetryFailed; i++ {
+		uploadErr, ok := err.(UploadError)
+		if !ok {
+			break
+		}
+		// Upload the users failing with a retryable reason again, and keep the
+		// other failures.
+		var (
+			indexes  []int
+			retry    []*User
+			failures UploadError
+		)
+		for _, f := range uploadErr {
+			if f.IsRetryable() && f.Index >= 0 && f.Index < len(users) {
+				indexes = append(indexes, f.Index)
+				retry = append(retry, users[f.Index])
+			} else {
+				failures = append(failures, f)
+			}
+		}
+		if len(retry) == 0 {
+			break
+		}
+		retryErr := c.uploadBatches(ctx, retry, opts)
+		if retryErr == nil {
+			err = nil
+			if len(failures) != 0 {
+				err = failures
+			}
+			break
+		}
+		retryFailures, ok := retryErr.(UploadError)
+		if !ok {
+			// The previous failures of the users stand.
+			break
+		}
+		for _, f := range retryFailures {
+			if f.Index >= 0 && f.Index < len(indexes) {
+				f.Index = indexes[f.Index]
+			}
+		}
+		failures = append(failures, retryFailures...)
+		sort.Sort(byIndex(failures))
+		failures.setUsers(users)
+		err = failures
+	}
+	return err
+}
+
+// uploadBatches uploads the users in batches of at most opts.BatchSize users,
+// concurrently, and merges the failures of the batches.
+func (c *Client) uploadBatches(ctx context.Context, users []*User, opts *UploadOptions) error {
+	size := opts.BatchSize
+	if size <= 0 || size > MaxUploadChunk {
+		size = MaxUploadChunk
+	}
+	if len(users) <= size {
+		return c.uploadBatch(ctx, users, opts)
+	}
+	n := opts.Concurrency
+	if n <= 0 {
+		n = defaultBatchConcurrency
+		if c.config != nil && c.config.MaxConcurrentRequests > 0 {
+			n = c.config.MaxConcurrentRequests
+		}
+	}
+	batches := (len(users) + size - 1) / size
+	if n > batches {
+		n = batches
+	}
+	errs := make([]error, batches)
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range indexes {
+				end := (b + 1) * size
+				if end > len(users) {
+					end = len(users)
+				}
+				errs[b] = c.uploadBatch(ctx, users[b*size:end], opts)
+			}
+		}()
+	}
+	for b := 0; b < batches; b++ {
+		indexes <- b
+	}
+	close(indexes)
+	wg.Wait()
+
+	var failures UploadError
+	failed := 0
+	for b, err := range errs {
+		if err == nil {
+			continue
+		}
+		start := b * size
+		if uploadErr, ok := err.(UploadError); ok {
+			for _, f := range uploadErr {
+				f.Index += start
+			}
+			failures = append(failures, uploadErr...)
+			continue
+		}
+		failed++
+		end := start + size
+		if end > len(users) {
+			end = len(users)
+		}
+		for i := start; i < end; i++ {
+			failures = append(failures, &UploadFailure{Index: i, Message: err.Error()})
+		}
+	}
+	if failed == batches {
+		return errs[0]
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	sort.Sort(byIndex(failures))
+	failures.setUsers(users)
+	return failures
+}
+
+// uploadBatch uploads the users in a single request.
+func (c *Client) uploadBatch(ctx context.Context, users []*User, opts *UploadOptions) error {
+	// Report the users rejected by the email policy as failed and upload the
+	// other ones.
+	var rejected UploadError
+	var indexes []int
+	allowed := users
+	if c.config != nil && c.config.EmailPolicy != nil {
+		allowed = nil
+		for i, u := range users {
+			if u.Email != "" {
+				if err := c.allowEmail(ctx, u.Email); err != nil {
+					rejected = append(rejected, NewEmailPolicyFailure(i, err))
+					continue
+				}
+			}
+			indexes = append(indexes, i)
+			allowed = append(allowed, u)
+		}
+		if len(allowed) == 0 {
+			rejected.setUsers(users)
+			return rejected
+		}
+	}
+	resp, err := c.mutatingAPIClient(ctx).UploadAccount(ctx, &UploadAccountRequest{
+		Users:             allowed,
+		HashAlgorithm:     opts.HashAlgorithm,
+		SignerKey:         opts.SignerKey,
+		SaltSeparator:     opts.SaltSeparator,
+		Rounds:            opts.Rounds,
+		MemoryCost:        opts.MemoryCost,
+		PasswordHashOrder: opts.PasswordHashOrder,
+		Argon2Parameters:  opts.Argon2Parameters,
+	})
 	if err != nil {
 		return err
 	}
-	if len(resp.Error) != 0 {
-		return resp.Error
+	if indexes != nil {
+		for _, f := range resp.Error {
+			if f.Index >= 0 && f.Index < len(indexes) {
+				f.Index = indexes[f.Index]
+			}
+		}
+	}
+	failures := append(rejected, resp.Error...)
+	if len(failures) != 0 {
+		sort.Sort(byIndex(failures))
+		failures.setUsers(users)
+		return failures
 	}
 	return nil
 }
 
+// setUsers sets the User field of the failures to the uploaded users they
+// refer to.
+func (e UploadError) setUsers(users []*User) {
+	for _, f := range e {
+		if f.Index >= 0 && f.Index < len(users) {
+			f.User = users[f.Index]
+		}
+	}
+}
+
+// byIndex sorts upload failures by index.
+type byIndex UploadError
+
+func (s byIndex) Len() int           { return len(s) }
+func (s byIndex) Less(i, j int) bool { return s[i].Index < s[j].Index }
+func (s byIndex) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 // ListUsersN lists the next n users.
-// For the first n users, the pageToken should be empty. Upon success, the users
-// and pageToken for next n users are returned.
-func (c *Client) ListUsersN(ctx context.Context, n int, pageToken string) ([]*User, string, error) {
-	resp, err := c.apiClient(ctx).DownloadAccount(&DownloadAccountRequest{n, pageToken})
+// For the first n users, the cursor should be empty. Upon success, the users
+// and the cursor of the next n users are returned. A malformed cursor, or one
+// that the API rejects, e.g., a stale one, fails with an InvalidCursorError.
+func (c *Client) ListUsersN(ctx context.Context, n int, cursor Cursor) ([]*User, Cursor, error) {
+	if err := cursor.Validate(); err != nil {
+		return nil, "", err
+	}
+	resp, err := c.callAPIClient(ctx).DownloadAccount(ctx, &DownloadAccountRequest{n, string(cursor)})
 	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusBadRequest && cursor != "" {
+			return nil, "", &InvalidCursorError{cursor, e.Message}
+		}
 		return nil, "", err
 	}
-	return resp.Users, resp.NextPageToken, nil
+	c.count(MetricPagesFetched, 1)
+	c.count(MetricUsersListed, int64(len(resp.Users)))
+	return resp.Users, Cursor(resp.NextPageToken), nil
 }
 
 const maxResultsPerPage = 50
@@ -225,7 +1039,7 @@ type UserList struct {
 	Error error        // Indicates an error occurs when listing the users.
 
 	client    *Client
-	pageToken string
+	pageToken Cursor
 }
 
 func (l *UserList) start(ctx context.Context) {
@@ -239,14 +1053,20 @@ func (l *UserList) start(ctx context.Context) {
 				close(ch)
 				return
 			}
+			for _, u := range users {
+				select {
+				case ch <- u:
+				case <-ctx.Done():
+					l.Error = ctx.Err()
+					close(ch)
+					return
+				}
+			}
 			if len(users) == 0 || pageToken == "" {
 				close(ch)
-			} else {
-				l.pageToken = pageToken
-				for _, u := range users {
-					ch <- u
-				}
+				return
 			}
+			l.pageToken = pageToken
 		}
 	}()
 }
@@ -259,7 +1079,8 @@ func (l *UserList) Retry(ctx context.Context) {
 	}
 }
 
-// ListUsers lists all the users.
+// ListUsers lists all the users. See ListUsersIterator for an iteration
+// stopping with its context.
 //
 // For example,
 //	l := c.ListUsers()
@@ -288,6 +1109,7 @@ const (
 	OOBOldEmailParam         = "oldEmail"
 	OOBNewEmailParam         = "newEmail"
 	OOBCodeParam             = "oobCode"
+	OOBNewPasswordParam      = "newPassword"
 )
 
 // Acceptable OOB code request types.
@@ -316,7 +1138,27 @@ type OOBCodeResponse struct {
 }
 
 // GenerateOOBCode generates an OOB code based on the request.
+//
+// If Config.AllowedOrigins is set, requests from other origins are rejected
+// with a *ForbiddenOriginError. See CheckOrigin. If Config.OOBWebhook is set,
+// the OOB code is posted to it, e.g., for a custom mailer, and the call fails
+// if the webhook does.
 func (c *Client) GenerateOOBCode(ctx context.Context, req *http.Request) (*OOBCodeResponse, error) {
+	if err := c.CheckOrigin(req); err != nil {
+		return nil, err
+	}
+	resp, err := c.generateOOBCode(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.postOOBCode(ctx, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// generateOOBCode generates the OOB code of the action of the request.
+func (c *Client) generateOOBCode(ctx context.Context, req *http.Request) (*OOBCodeResponse, error) {
 	switch action := req.PostFormValue(OOBActionParam); action {
 	case OOBActionResetPassword:
 		return c.GenerateResetPasswordOOBCode(
@@ -340,19 +1182,34 @@ func (c *Client) GenerateOOBCode(ctx context.Context, req *http.Request) (*OOBCo
 }
 
 // GenerateResetPasswordOOBCode generates an OOB code for resetting password.
+// The CAPTCHA response is verified by Config.CaptchaProvider, if set, or else
+// by identitytoolkit.
 //
 // If WidgetURL is not provided in the configuration, the OOBCodeURL field in
 // the returned OOBCodeResponse is nil.
 func (c *Client) GenerateResetPasswordOOBCode(
 	ctx context.Context, req *http.Request, email, captchaChallenge, captchaResponse string) (*OOBCodeResponse, error) {
+	if err := c.allowEmail(ctx, email); err != nil {
+		return nil, err
+	}
+	if err := c.validateEmail(ctx, email); err != nil {
+		return nil, err
+	}
 	r := &GetOOBCodeRequest{
 		RequestType:      ResetPasswordRequestType,
 		Email:            email,
 		CAPTCHAChallenge: captchaChallenge,
 		CAPTCHAResponse:  captchaResponse,
-		UserIP:           extractRemoteIP(req),
+		UserIP:           c.remoteIP(req),
 	}
-	resp, err := c.apiClient(ctx).GetOOBCode(r)
+	if c.config.CaptchaProvider != nil {
+		if err := c.config.CaptchaProvider.VerifyCaptcha(ctx, captchaChallenge, captchaResponse, r.UserIP); err != nil {
+			return nil, err
+		}
+		// identitytoolkit would check the response against reCAPTCHA.
+		r.CAPTCHAChallenge, r.CAPTCHAResponse, r.CAPTCHAVerified = "", "", true
+	}
+	resp, err := c.callAPIClient(ctx).GetOOBCode(ctx, r)
 	if err != nil {
 		return nil, err
 	}
@@ -370,14 +1227,20 @@ func (c *Client) GenerateResetPasswordOOBCode(
 // the returned OOBCodeResponse is nil.
 func (c *Client) GenerateChangeEmailOOBCode(
 	ctx context.Context, req *http.Request, email, newEmail, token string) (*OOBCodeResponse, error) {
+	if err := c.allowEmail(ctx, newEmail); err != nil {
+		return nil, err
+	}
+	if err := c.validateEmail(ctx, newEmail); err != nil {
+		return nil, err
+	}
 	r := &GetOOBCodeRequest{
 		RequestType: ChangeEmailRequestType,
 		Email:       email,
 		NewEmail:    newEmail,
 		Token:       token,
-		UserIP:      extractRemoteIP(req),
+		UserIP:      c.remoteIP(req),
 	}
-	resp, err := c.apiClient(ctx).GetOOBCode(r)
+	resp, err := c.callAPIClient(ctx).GetOOBCode(ctx, r)
 	if err != nil {
 		return nil, err
 	}
@@ -396,12 +1259,18 @@ func (c *Client) GenerateChangeEmailOOBCode(
 // the returned OOBCodeResponse is nil.
 func (c *Client) GenerateVerifyEmailOOBCode(
 	ctx context.Context, req *http.Request, email string) (*OOBCodeResponse, error) {
+	if err := c.allowEmail(ctx, email); err != nil {
+		return nil, err
+	}
+	if err := c.validateEmail(ctx, email); err != nil {
+		return nil, err
+	}
 	r := &GetOOBCodeRequest{
 		RequestType: VerifyEmailRequestType,
 		Email:       email,
-		UserIP:      extractRemoteIP(req),
+		UserIP:      c.remoteIP(req),
 	}
-	resp, err := c.apiClient(ctx).GetOOBCode(r)
+	resp, err := c.callAPIClient(ctx).GetOOBCode(ctx, r)
 	if err != nil {
 		return nil, err
 	}
@@ -418,10 +1287,13 @@ func (c *Client) buildOOBCodeURL(req *http.Request, action, oobCode string) *url
 	if c.widgetURL == nil {
 		return nil
 	}
-	url := extractRequestURL(req).ResolveReference(c.widgetURL)
+	url := c.requestURL(req).ResolveReference(c.widgetURL)
 	q := url.Query()
 	q.Set(c.config.WidgetModeParamName, action)
 	q.Set(OOBCodeParam, oobCode)
+	if len(c.config.OOBCodeURLKey) != 0 {
+		c.signOOBCodeURL(q, action, oobCode)
+	}
 	url.RawQuery = q.Encode()
 	return url
 }
@@ -432,9 +1304,12 @@ func SuccessResponse() string {
 	return `{"success": true}`
 }
 
-// ErrorResponse generates a JSON error response from the given error.
+// ErrorResponse generates a JSON error response from the given error. Besides
+// the error message, it includes the ErrorCode of the error for the widget,
+// e.g., {"error": "...", "errorCode": "EXPIRED_OOB_CODE"}. See also WriteError.
 func ErrorResponse(err error) string {
-	return fmt.Sprintf(`{"error": "%s"}`, err)
+	b, _ := json.Marshal(newWidgetError(err))
+	return string(b)
 }
 
 func extractRequestURL(req *http.Request) *url.URL {
@@ -457,25 +1332,66 @@ func extractRemoteIP(req *http.Request) string {
 }
 
 // GetProjectConfig gets the Gitkit configuration of this project.
+//
+// Deprecated: Use ProjectConfig, which also selects the project.
 func (c *Client) GetProjectConfig(ctx context.Context) (*ProjectConfig, error) {
-	resp, err := c.apiClient(ctx).GetProjectConfig()
+	return c.ProjectConfig(ctx, nil)
+}
+
+// ProjectConfig retrieves the configuration of the project selected by opts,
+// or of the project of the Client credentials if opts is nil.
+func (c *Client) ProjectConfig(ctx context.Context, opts *ProjectConfigOptions) (*ProjectConfig, error) {
+	var req *GetProjectConfigRequest
+	if opts != nil {
+		req = &GetProjectConfigRequest{opts.ProjectNumber, opts.DelegatedProjectNumber}
+	}
+	resp, err := c.callAPIClient(ctx).GetProjectConfigWithRequest(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	pc := &ProjectConfig{}
-	pc.BrowserAPIKey = resp.APIKey
-	var opts []string
+	pc := &ProjectConfig{
+		BrowserAPIKey:         resp.APIKey,
+		ProjectID:             resp.ProjectID,
+		AllowPasswordUser:     resp.AllowPasswordUser,
+		EnableAnonymousUser:   resp.EnableAnonymousUser,
+		IdpConfigs:            resp.IdpConfigs,
+		AuthorizedDomains:     resp.AuthorizedDomains,
+		UseEmailSending:       resp.UseEmailSending,
+		ResetPasswordTemplate: resp.ResetPasswordTemplate,
+		ChangeEmailTemplate:   resp.ChangeEmailTemplate,
+		VerifyEmailTemplate:   resp.VerifyEmailTemplate,
+	}
+	var signInOpts []string
 	for _, element := range resp.IdpConfigs {
 		if element.Provider == "GOOGLE" {
 			pc.ClientID = element.ClientID
 		}
 		if element.Enabled {
-			opts = append(opts, strings.ToLower(element.Provider))
+			signInOpts = append(signInOpts, strings.ToLower(element.Provider))
 		}
 	}
 	if resp.AllowPasswordUser {
-		opts = append(opts, "password")
+		signInOpts = append(signInOpts, "password")
 	}
-	pc.SignInOptions = opts
+	pc.SignInOptions = signInOpts
 	return pc, nil
 }
+
+// UpdateEmailTemplates updates the templates of the emails of the out-of-band
+// actions of the project selected by opts, or of the project of the Client
+// credentials if opts is nil. Only the DelegatedProjectNumber of opts is used:
+// the API does not select projects by number. The current templates are
+// returned by ProjectConfig.
+func (c *Client) UpdateEmailTemplates(ctx context.Context, t *EmailTemplates, opts *ProjectConfigOptions) error {
+	req := &SetProjectConfigRequest{
+		UseEmailSending:       t.UseEmailSending,
+		ResetPasswordTemplate: t.ResetPassword,
+		ChangeEmailTemplate:   t.ChangeEmail,
+		VerifyEmailTemplate:   t.VerifyEmail,
+	}
+	if opts != nil {
+		req.DelegatedProjectNumber = opts.DelegatedProjectNumber
+	}
+	_, err := c.mutatingAPIClient(ctx).SetProjectConfig(ctx, req)
+	return err
+}
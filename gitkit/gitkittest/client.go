@@ -0,0 +1,954 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gitkittest provides utilities for testing code that uses the gitkit
+// package.
+package gitkittest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// Op identifies the kind of a mutation made through a Client.
+type Op string
+
+// Mutation operations recorded by Client.
+const (
+	OpUpdate     Op = "update"
+	OpDelete     Op = "delete"
+	OpUpload     Op = "upload"
+	OpQuarantine Op = "quarantine"
+	OpRestore    Op = "restore"
+	OpCreate     Op = "create"
+)
+
+// A Mutation records a change made to the user store through a Client.
+type Mutation struct {
+	Op   Op
+	User *gitkit.User // A copy of the user passed to the mutating method.
+}
+
+// Client is an in-memory fake of gitkit.Client over a map of users, so
+// handlers that depend on an interface satisfied by *gitkit.Client can be unit
+// tested without any HTTP traffic. It fakes the methods which validate tokens,
+// look up, update, upload, list and delete users, generate and apply the OOB
+// codes, sign users in, mint custom tokens and read or update the project
+// configuration, with the signatures of gitkit.Client.
+//
+// The other methods of gitkit.Client are not faked, e.g., the HTTP handlers
+// and middlewares such as RequireToken and WidgetHandler, FrontendConfig,
+// VerifyAssertion, CreateAuthURI, DownloadAllUsers and UploadUsersChunked:
+// the interfaces of the code under test should not include them.
+//
+// A Client is safe to use from multiple concurrent goroutines.
+type Client struct {
+	// CookieName is the name of the cookie TokenFromRequest reads.
+	CookieName string
+	// LegacyCookieNames are checked by TokenFromRequest after CookieName,
+	// like gitkit.Config.LegacyCookieNames.
+	LegacyCookieNames []string
+	// TokenExtractor, if set, is used by TokenFromRequest instead of
+	// CookieName, like gitkit.Config.TokenExtractor.
+	TokenExtractor gitkit.TokenExtractor
+	// Audiences are used by ValidateToken when it is given no audiences and
+	// AudiencesByHost has none for the host, like gitkit.Config.Audiences.
+	Audiences []string
+	// AudiencesByHost is used by ValidateToken when it is given no audiences,
+	// like gitkit.Config.AudiencesByHost.
+	AudiencesByHost map[string][]string
+	// RequireVerifiedEmail makes ValidateToken reject the tokens whose email
+	// address is not verified, like gitkit.Config.RequireVerifiedEmail.
+	RequireVerifiedEmail bool
+	// EmailPolicy, if set, is checked like gitkit.Config.EmailPolicy.
+	EmailPolicy gitkit.EmailPolicy
+	// EmailValidator, if set, checks the email addresses of the OOB codes
+	// like gitkit.Config.EmailValidator.
+	EmailValidator gitkit.EmailValidator
+	// PasswordPolicy, if set, checks the new passwords of ResetPassword like
+	// gitkit.Config.PasswordPolicy.
+	PasswordPolicy gitkit.PasswordPolicy
+	// PasswordLockout, if set, counts the failed SignInWithPassword attempts
+	// like gitkit.Config.PasswordLockout.
+	PasswordLockout *gitkit.LockoutPolicy
+	// SessionRevoker, if set, is called by ApplyEmailChange like
+	// gitkit.Config.SessionRevoker.
+	SessionRevoker gitkit.SessionRevoker
+	// Project is returned by ProjectConfig and GetProjectConfig.
+	Project gitkit.ProjectConfig
+
+	mu        sync.Mutex
+	users     map[string]*gitkit.User // Indexed by local ID.
+	tokens    map[string]*gitkit.Token
+	mutations []Mutation
+	oobCodes  []*gitkit.OOBCodeResponse
+	usedCodes map[string]bool
+	nextID    int
+}
+
+// NewClient creates an empty fake Client.
+func NewClient() *Client {
+	return &Client{
+		CookieName: gitkit.DefaultCookieName,
+		users:      make(map[string]*gitkit.User),
+		tokens:     make(map[string]*gitkit.Token),
+		usedCodes:  make(map[string]bool),
+	}
+}
+
+// AddUser seeds a user into the store and returns a copy of the stored user.
+// A local ID is assigned if the user does not have one.
+func (c *Client) AddUser(u *gitkit.User) *gitkit.User {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u = copyUser(u)
+	if u.LocalID == "" {
+		u.LocalID = c.newLocalID()
+	}
+	c.users[u.LocalID] = u
+	return copyUser(u)
+}
+
+// AddToken registers an ID token string which ValidateToken accepts and
+// returns t for. TokenString is set to s if it is empty.
+func (c *Client) AddToken(s string, t *gitkit.Token) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tok := *t
+	if tok.TokenString == "" {
+		tok.TokenString = s
+	}
+	c.tokens[s] = &tok
+}
+
+// User returns a copy of the stored user with the local ID.
+func (c *Client) User(localID string) (*gitkit.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, ok := c.users[localID]
+	if !ok {
+		return nil, false
+	}
+	return copyUser(u), true
+}
+
+// Users returns copies of all the stored users ordered by local ID.
+func (c *Client) Users() []*gitkit.User {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sortedUsers()
+}
+
+// Mutations returns the mutations made so far in the order they happened.
+func (c *Client) Mutations() []Mutation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := make([]Mutation, len(c.mutations))
+	copy(m, c.mutations)
+	return m
+}
+
+// OOBCodes returns the OOB codes generated so far in the order they were
+// generated.
+func (c *Client) OOBCodes() []*gitkit.OOBCodeResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := make([]*gitkit.OOBCodeResponse, len(c.oobCodes))
+	copy(r, c.oobCodes)
+	return r
+}
+
+// TokenFromRequest extracts the ID token with TokenExtractor or from the cookie
+// named CookieName, then the LegacyCookieNames ones.
+func (c *Client) TokenFromRequest(req *http.Request) string {
+	if c.TokenExtractor != nil {
+		return c.TokenExtractor.ExtractToken(req)
+	}
+	if t := gitkit.CookieExtractor(c.CookieName).ExtractToken(req); t != "" {
+		return t
+	}
+	for _, name := range c.LegacyCookieNames {
+		if t := gitkit.CookieExtractor(name).ExtractToken(req); t != "" {
+			return t
+		}
+	}
+	return ""
+}
+
+// ValidateToken returns the token registered with AddToken. It fails with the
+// same errors as gitkit.VerifyToken for unknown, expired or mismatched
+// audience tokens. Without audiences, those AudiencesByHost maps the host set
+// by gitkit.WithRequestHost to are used, or else Audiences.
+func (c *Client) ValidateToken(ctx context.Context, token string, audiences []string) (*gitkit.Token, error) {
+	if len(audiences) == 0 && len(c.AudiencesByHost) != 0 {
+		audiences = gitkit.HostAudiences(c.AudiencesByHost, gitkit.RequestHost(ctx))
+	}
+	if len(audiences) == 0 {
+		audiences = c.Audiences
+	}
+	if len(audiences) == 0 {
+		return nil, gitkit.ErrMissingAudience
+	}
+	c.mu.Lock()
+	t, ok := c.tokens[token]
+	c.mu.Unlock()
+	if !ok {
+		return nil, gitkit.ErrMalformed
+	}
+	found := false
+	for _, aud := range audiences {
+		if aud == t.Audience {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, gitkit.ErrInvalidAudience
+	}
+	if t.Expired() {
+		return nil, gitkit.ErrExpired
+	}
+	if c.RequireVerifiedEmail && !t.EmailVerified {
+		return nil, gitkit.ErrEmailNotVerified
+	}
+	tok := *t
+	return &tok, nil
+}
+
+// UserByToken validates the token and returns the user it identifies.
+func (c *Client) UserByToken(ctx context.Context, token string, audiences []string) (*gitkit.User, error) {
+	t, err := c.ValidateToken(ctx, token, audiences)
+	if err != nil {
+		return nil, err
+	}
+	u, err := c.UserByLocalID(ctx, t.LocalID)
+	if err != nil {
+		return nil, err
+	}
+	u.ProviderID = t.ProviderID
+	return u, nil
+}
+
+// UserFromToken builds the user of the token like gitkit.Client.UserFromToken.
+func (c *Client) UserFromToken(t *gitkit.Token) *gitkit.User {
+	u := &gitkit.User{
+		LocalID:       t.LocalID,
+		Email:         t.Email,
+		EmailVerified: t.EmailVerified,
+		DisplayName:   t.DisplayName,
+		PhotoURL:      t.PhotoURL,
+		ProviderID:    t.ProviderID,
+	}
+	if t.ProviderID != "" {
+		u.ProviderUserInfo = []gitkit.ProviderUserInfo{{
+			ProviderID:  t.ProviderID,
+			Email:       t.Email,
+			DisplayName: t.DisplayName,
+			PhotoURL:    t.PhotoURL,
+		}}
+	}
+	return u
+}
+
+// UserByEmail returns the user with the email address.
+func (c *Client) UserByEmail(ctx context.Context, email string) (*gitkit.User, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, u := range c.users {
+		if u.Email == email {
+			return copyUser(u), nil
+		}
+	}
+	return nil, gitkit.UserNotFoundError(email)
+}
+
+// CheckAccountConflict reports the conflict with the stored user with the
+// email address, if any.
+func (c *Client) CheckAccountConflict(ctx context.Context, email, providerID string) (*gitkit.AccountConflict, error) {
+	u, err := c.UserByEmail(ctx, email)
+	if err != nil {
+		u = nil
+	}
+	return gitkit.NewAccountConflict(email, providerID, u), nil
+}
+
+// UserByLocalID returns the user with the local ID.
+func (c *Client) UserByLocalID(ctx context.Context, localID string) (*gitkit.User, error) {
+	if u, ok := c.User(localID); ok {
+		return u, nil
+	}
+	return nil, gitkit.UserNotFoundError(localID)
+}
+
+// UsersByEmails returns the users with the email addresses, nil for the
+// addresses without account, and the addresses without account.
+func (c *Client) UsersByEmails(ctx context.Context, emails []string) ([]*gitkit.User, []string, error) {
+	users := make([]*gitkit.User, len(emails))
+	var notFound []string
+	for i, e := range emails {
+		u, err := c.UserByEmail(ctx, e)
+		if err != nil {
+			notFound = append(notFound, e)
+			continue
+		}
+		users[i] = u
+	}
+	return users, notFound, nil
+}
+
+// UsersByLocalIDs returns the users with the local IDs, nil for the unknown
+// local IDs, and the unknown local IDs.
+func (c *Client) UsersByLocalIDs(ctx context.Context, localIDs []string) ([]*gitkit.User, []string, error) {
+	users := make([]*gitkit.User, len(localIDs))
+	var notFound []string
+	for i, id := range localIDs {
+		u, ok := c.User(id)
+		if !ok {
+			notFound = append(notFound, id)
+			continue
+		}
+		users[i] = u
+	}
+	return users, notFound, nil
+}
+
+// UpdateUser updates the email, display name, password and email verification
+// status of an existing user.
+func (c *Client) UpdateUser(ctx context.Context, user *gitkit.User) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, ok := c.users[user.LocalID]
+	if !ok {
+		return gitkit.UserNotFoundError(user.LocalID)
+	}
+	if user.Email != "" {
+		u.Email = user.Email
+	}
+	if user.DisplayName != "" {
+		u.DisplayName = user.DisplayName
+	}
+	if user.Password != "" {
+		u.Password = user.Password
+	}
+	if user.EmailVerified {
+		u.EmailVerified = true
+	}
+	c.mutations = append(c.mutations, Mutation{OpUpdate, copyUser(user)})
+	return nil
+}
+
+// UpdateUsers updates the users one after the other.
+func (c *Client) UpdateUsers(ctx context.Context, updates []*gitkit.UserUpdate) []error {
+	errs := make([]error, len(updates))
+	failed := false
+	for i, u := range updates {
+		errs[i] = c.UpdateUser(ctx, &gitkit.User{
+			LocalID:       u.LocalID,
+			Email:         u.Email,
+			DisplayName:   u.DisplayName,
+			Password:      u.Password,
+			EmailVerified: u.EmailVerified,
+		})
+		failed = failed || errs[i] != nil
+	}
+	if !failed {
+		return nil
+	}
+	return errs
+}
+
+// SetUserDisabled disables or enables the user with the local ID.
+func (c *Client) SetUserDisabled(ctx context.Context, user *gitkit.User, disabled bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, ok := c.users[user.LocalID]
+	if !ok {
+		return gitkit.UserNotFoundError(user.LocalID)
+	}
+	u.Disabled = disabled
+	m := Mutation{OpUpdate, copyUser(user)}
+	m.User.Disabled = disabled
+	c.mutations = append(c.mutations, m)
+	return nil
+}
+
+// DeleteUser deletes the user with the local ID.
+func (c *Client) DeleteUser(ctx context.Context, user *gitkit.User) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.users[user.LocalID]; !ok {
+		return gitkit.UserNotFoundError(user.LocalID)
+	}
+	delete(c.users, user.LocalID)
+	c.mutations = append(c.mutations, Mutation{OpDelete, copyUser(user)})
+	return nil
+}
+
+// QuarantineUser disables the user and records the quarantine time in its
+// custom attributes.
+func (c *Client) QuarantineUser(ctx context.Context, user *gitkit.User) error {
+	return c.setQuarantine(user, OpQuarantine, time.Now())
+}
+
+// RestoreUser enables the user and removes its quarantine time.
+func (c *Client) RestoreUser(ctx context.Context, user *gitkit.User) error {
+	return c.setQuarantine(user, OpRestore, time.Time{})
+}
+
+func (c *Client) setQuarantine(user *gitkit.User, op Op, t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, ok := c.users[user.LocalID]
+	if !ok {
+		return gitkit.UserNotFoundError(user.LocalID)
+	}
+	attrs := make(map[string]interface{})
+	if u.CustomAttributes != "" {
+		if err := json.Unmarshal([]byte(u.CustomAttributes), &attrs); err != nil {
+			return err
+		}
+	}
+	if t.IsZero() {
+		delete(attrs, gitkit.QuarantineAttribute)
+	} else {
+		attrs[gitkit.QuarantineAttribute] = t.Unix()
+	}
+	b, _ := json.Marshal(attrs)
+	u.CustomAttributes = string(b)
+	u.Disabled = !t.IsZero()
+	c.mutations = append(c.mutations, Mutation{op, copyUser(user)})
+	return nil
+}
+
+// SweepQuarantinedUsers deletes the users quarantined for longer than the grace
+// period.
+func (c *Client) SweepQuarantinedUsers(ctx context.Context, gracePeriod time.Duration) ([]string, error) {
+	deadline := time.Now().Add(-gracePeriod)
+	var deleted []string
+	for _, u := range c.Users() {
+		if t, ok := u.QuarantinedAt(); !ok || t.After(deadline) {
+			continue
+		}
+		if err := c.DeleteUser(ctx, u); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, u.LocalID)
+	}
+	return deleted, nil
+}
+
+// UploadUsers adds or replaces the users. The hash parameters are only checked
+// for presence.
+func (c *Client) UploadUsers(ctx context.Context, users []*gitkit.User, algorithm string, key, saltSeparator []byte) error {
+	return c.UploadUsersWithOptions(ctx, users, &gitkit.UploadOptions{
+		HashAlgorithm: algorithm,
+		SignerKey:     key,
+		SaltSeparator: saltSeparator,
+	})
+}
+
+// UploadUsersWithOptions adds or replaces the users. The hash algorithm and,
+// for the HMAC and SCRYPT algorithms, the signer key are checked for presence,
+// and so are the parameters of the ARGON2 algorithm.
+func (c *Client) UploadUsersWithOptions(ctx context.Context, users []*gitkit.User, opts *gitkit.UploadOptions) error {
+	if len(users) == 0 {
+		return fmt.Errorf("UploadAccount: must provide at lease one account")
+	}
+	if opts.HashAlgorithm == "" {
+		return fmt.Errorf("UploadAccount: must provide the hash algorithm")
+	}
+	if len(opts.SignerKey) == 0 && (strings.HasPrefix(opts.HashAlgorithm, "HMAC_") || opts.HashAlgorithm == "SCRYPT") {
+		return fmt.Errorf("UploadAccount: must provide the signer key")
+	}
+	if opts.HashAlgorithm == "ARGON2" && opts.Argon2Parameters == nil {
+		return fmt.Errorf("UploadAccount: must provide the Argon2 parameters")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var failures gitkit.UploadError
+	for i, u := range users {
+		if c.EmailPolicy != nil && u.Email != "" {
+			if err := c.EmailPolicy.AllowEmail(ctx, u.Email); err != nil {
+				f := gitkit.NewEmailPolicyFailure(i, err)
+				f.User = u
+				failures = append(failures, f)
+				continue
+			}
+		}
+		u = copyUser(u)
+		if u.LocalID == "" {
+			u.LocalID = c.newLocalID()
+		}
+		c.users[u.LocalID] = u
+		c.mutations = append(c.mutations, Mutation{OpUpload, copyUser(u)})
+	}
+	if len(failures) != 0 {
+		return failures
+	}
+	return nil
+}
+
+// RetryFailedUploads uploads again the users which failed with err, if err is
+// a gitkit.UploadError. Otherwise, it returns err.
+func (c *Client) RetryFailedUploads(ctx context.Context, users []*gitkit.User, err error, algorithm string, key, saltSeparator []byte) error {
+	uploadErr, ok := err.(gitkit.UploadError)
+	if !ok || len(uploadErr) == 0 {
+		return err
+	}
+	return c.UploadUsers(ctx, uploadErr.FailedUsers(users), algorithm, key, saltSeparator)
+}
+
+// ListUsersN lists the next n users ordered by local ID. The cursor is the
+// offset of the next page; others fail with a *gitkit.InvalidCursorError.
+func (c *Client) ListUsersN(ctx context.Context, n int, cursor gitkit.Cursor) ([]*gitkit.User, gitkit.Cursor, error) {
+	offset := 0
+	if cursor != "" {
+		var err error
+		if offset, err = strconv.Atoi(string(cursor)); err != nil || offset < 0 {
+			return nil, "", &gitkit.InvalidCursorError{Cursor: cursor, Reason: "not an offset"}
+		}
+	}
+	c.mu.Lock()
+	users := c.sortedUsers()
+	c.mu.Unlock()
+	if offset >= len(users) {
+		return nil, "", nil
+	}
+	end := offset + n
+	if n <= 0 || end > len(users) {
+		end = len(users)
+	}
+	var next gitkit.Cursor
+	if end < len(users) {
+		next = gitkit.Cursor(strconv.Itoa(end))
+	}
+	return users[offset:end], next, nil
+}
+
+// ListUsers lists all the users. The returned UserList never fails.
+func (c *Client) ListUsers(ctx context.Context) *gitkit.UserList {
+	users := c.Users()
+	ch := make(chan *gitkit.User, len(users))
+	for _, u := range users {
+		ch <- u
+	}
+	close(ch)
+	return &gitkit.UserList{C: ch}
+}
+
+// ListUsersIterator returns a UserIterator listing all the users.
+func (c *Client) ListUsersIterator(ctx context.Context) *gitkit.UserIterator {
+	return gitkit.NewUserIterator(ctx, c)
+}
+
+// GenerateOOBCode generates an OOB code based on the request.
+func (c *Client) GenerateOOBCode(ctx context.Context, req *http.Request) (*gitkit.OOBCodeResponse, error) {
+	switch action := req.PostFormValue(gitkit.OOBActionParam); action {
+	case gitkit.OOBActionResetPassword:
+		return c.GenerateResetPasswordOOBCode(
+			ctx,
+			req,
+			req.PostFormValue(gitkit.OOBEmailParam),
+			req.PostFormValue(gitkit.OOBCAPTCHAChallengeParam),
+			req.PostFormValue(gitkit.OOBCAPTCHAResponseParam))
+	case gitkit.OOBActionChangeEmail:
+		return c.GenerateChangeEmailOOBCode(
+			ctx,
+			req,
+			req.PostFormValue(gitkit.OOBOldEmailParam),
+			req.PostFormValue(gitkit.OOBNewEmailParam),
+			c.TokenFromRequest(req))
+	case gitkit.OOBActionVerifyEmail:
+		return c.GenerateVerifyEmailOOBCode(ctx, req, req.PostFormValue(gitkit.OOBEmailParam))
+	default:
+		return nil, fmt.Errorf("unrecognized action: %s", action)
+	}
+}
+
+// GenerateResetPasswordOOBCode records and returns a fake OOB code for
+// resetting password.
+func (c *Client) GenerateResetPasswordOOBCode(
+	ctx context.Context, req *http.Request, email, captchaChallenge, captchaResponse string) (*gitkit.OOBCodeResponse, error) {
+	if email == "" {
+		return nil, fmt.Errorf("GetOOBCode: must provide an email")
+	}
+	if captchaResponse == "" {
+		return nil, fmt.Errorf("GetOOBCode: must provide CAPTCHA response")
+	}
+	if err := c.validateEmail(ctx, email); err != nil {
+		return nil, err
+	}
+	return c.addOOBCode(&gitkit.OOBCodeResponse{Action: gitkit.OOBActionResetPassword, Email: email}), nil
+}
+
+// GenerateChangeEmailOOBCode records and returns a fake OOB code for changing
+// email address.
+func (c *Client) GenerateChangeEmailOOBCode(
+	ctx context.Context, req *http.Request, email, newEmail, token string) (*gitkit.OOBCodeResponse, error) {
+	if email == "" || newEmail == "" || token == "" {
+		return nil, fmt.Errorf("GetOOBCode: must provide the old email, the new email and the Gitkit token")
+	}
+	if err := c.validateEmail(ctx, newEmail); err != nil {
+		return nil, err
+	}
+	return c.addOOBCode(&gitkit.OOBCodeResponse{Action: gitkit.OOBActionChangeEmail, Email: email, NewEmail: newEmail}), nil
+}
+
+// GenerateVerifyEmailOOBCode records and returns a fake OOB code for verifying
+// email address.
+func (c *Client) GenerateVerifyEmailOOBCode(
+	ctx context.Context, req *http.Request, email string) (*gitkit.OOBCodeResponse, error) {
+	if email == "" {
+		return nil, fmt.Errorf("GetOOBCode: must provide an email")
+	}
+	if err := c.validateEmail(ctx, email); err != nil {
+		return nil, err
+	}
+	return c.addOOBCode(&gitkit.OOBCodeResponse{Action: gitkit.OOBActionVerifyEmail, Email: email}), nil
+}
+
+// ResetPassword sets the password of the user the reset password OOB code was
+// generated for. Each code can be used once; unknown or used codes are
+// rejected with a 400 *googleapi.Error, like identitytoolkit does.
+func (c *Client) ResetPassword(ctx context.Context, oobCode, newPassword string) (string, error) {
+	if c.PasswordPolicy != nil {
+		if err := c.PasswordPolicy.CheckPassword(ctx, newPassword); err != nil {
+			return "", err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var email string
+	for _, r := range c.oobCodes {
+		if r.OOBCode == oobCode && r.Action == gitkit.OOBActionResetPassword && !c.usedCodes[oobCode] {
+			email = r.Email
+		}
+	}
+	if email == "" {
+		return "", &googleapi.Error{Code: http.StatusBadRequest, Message: "INVALID_OOB_CODE"}
+	}
+	for _, u := range c.users {
+		if u.Email == email {
+			c.usedCodes[oobCode] = true
+			u.Password = newPassword
+			c.mutations = append(c.mutations, Mutation{OpUpdate, copyUser(u)})
+			return email, nil
+		}
+	}
+	return "", &googleapi.Error{Code: http.StatusBadRequest, Message: "EMAIL_NOT_FOUND"}
+}
+
+// ConfirmEmailVerification marks the email address of the user the verify
+// email OOB code was generated for as verified. Each code can be used once;
+// unknown or used codes are rejected with a 400 *googleapi.Error, like
+// identitytoolkit does.
+func (c *Client) ConfirmEmailVerification(ctx context.Context, oobCode string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var email string
+	for _, r := range c.oobCodes {
+		if r.OOBCode == oobCode && r.Action == gitkit.OOBActionVerifyEmail && !c.usedCodes[oobCode] {
+			email = r.Email
+		}
+	}
+	if email == "" {
+		return "", &googleapi.Error{Code: http.StatusBadRequest, Message: "INVALID_OOB_CODE"}
+	}
+	for _, u := range c.users {
+		if u.Email == email {
+			c.usedCodes[oobCode] = true
+			u.EmailVerified = true
+			c.mutations = append(c.mutations, Mutation{OpUpdate, copyUser(u)})
+			return email, nil
+		}
+	}
+	return "", &googleapi.Error{Code: http.StatusBadRequest, Message: "EMAIL_NOT_FOUND"}
+}
+
+// SignInWithPassword signs in the stored user with the email address and
+// password. The returned ID token is registered like AddToken, issued to the
+// first of Audiences for an hour. Wrong credentials are rejected with
+// gitkit.ErrInvalidCredentials.
+func (c *Client) SignInWithPassword(ctx context.Context, email, password string) (string, *gitkit.User, error) {
+	var u *gitkit.User
+	try := func() (bool, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, v := range c.users {
+			if strings.EqualFold(v.Email, email) && v.Password != "" && v.Password == password {
+				u = copyUser(v)
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	var (
+		ok  bool
+		err error
+	)
+	if c.PasswordLockout != nil {
+		ok, err = c.PasswordLockout.Attempt(ctx, strings.ToLower(email), try)
+	} else {
+		ok, err = try()
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if !ok {
+		return "", nil, gitkit.ErrInvalidCredentials
+	}
+	return c.issueToken(u), u, nil
+}
+
+// issueToken registers an ID token of the user like AddToken, issued to the
+// first of Audiences for an hour, and returns it.
+func (c *Client) issueToken(u *gitkit.User) string {
+	now := time.Now()
+	t := &gitkit.Token{
+		IssueAt:       now,
+		ExpireAt:      now.Add(time.Hour),
+		LocalID:       u.LocalID,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		ProviderID:    gitkit.PasswordProviderID,
+		DisplayName:   u.DisplayName,
+		PhotoURL:      u.PhotoURL,
+	}
+	if len(c.Audiences) != 0 {
+		t.Audience = c.Audiences[0]
+	}
+	c.mu.Lock()
+	s := fmt.Sprintf("fake-id-token-%s-%d", u.LocalID, len(c.tokens))
+	c.mu.Unlock()
+	c.AddToken(s, t)
+	return s
+}
+
+// CustomToken returns a fake custom token of the local ID. It is not signed
+// and only identifies the user in tests.
+func (c *Client) CustomToken(ctx context.Context, localID string, claims map[string]interface{}) (string, error) {
+	if localID == "" {
+		return "", fmt.Errorf("gitkit: the local ID of a custom token must be 1 to 128 characters long")
+	}
+	return "fake-custom-token-" + localID, nil
+}
+
+// CreateUser stores a new password user after checking EmailPolicy,
+// EmailValidator and PasswordPolicy, and returns its local ID and an ID token
+// registered like AddToken. An email address already in use is rejected with
+// a 400 *googleapi.Error, like identitytoolkit does.
+func (c *Client) CreateUser(ctx context.Context, email, password, displayName string) (string, string, error) {
+	if err := c.validateEmail(ctx, email); err != nil {
+		return "", "", err
+	}
+	if c.PasswordPolicy != nil {
+		if err := c.PasswordPolicy.CheckPassword(ctx, password); err != nil {
+			return "", "", err
+		}
+	}
+	c.mu.Lock()
+	for _, u := range c.users {
+		if strings.EqualFold(u.Email, email) {
+			c.mu.Unlock()
+			return "", "", &googleapi.Error{Code: http.StatusBadRequest, Message: "EMAIL_EXISTS"}
+		}
+	}
+	u := &gitkit.User{LocalID: c.newLocalID(), Email: email, Password: password, DisplayName: displayName}
+	c.users[u.LocalID] = u
+	c.mutations = append(c.mutations, Mutation{OpCreate, copyUser(u)})
+	u = copyUser(u)
+	c.mu.Unlock()
+	return u.LocalID, c.issueToken(u), nil
+}
+
+// ApplyEmailChange changes the email address of the user the change email OOB
+// code was generated for. Each code can be used once; unknown or used codes
+// are rejected with a 400 *googleapi.Error, like identitytoolkit does.
+func (c *Client) ApplyEmailChange(ctx context.Context, oobCode string) (*gitkit.EmailChange, error) {
+	change, err := c.applyEmailChange(oobCode)
+	if err != nil || c.SessionRevoker == nil {
+		return change, err
+	}
+	return change, c.SessionRevoker.RevokeSessions(ctx, change.LocalID)
+}
+
+func (c *Client) applyEmailChange(oobCode string) (*gitkit.EmailChange, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var code *gitkit.OOBCodeResponse
+	for _, r := range c.oobCodes {
+		if r.OOBCode == oobCode && r.Action == gitkit.OOBActionChangeEmail && !c.usedCodes[oobCode] {
+			code = r
+		}
+	}
+	if code == nil {
+		return nil, &googleapi.Error{Code: http.StatusBadRequest, Message: "INVALID_OOB_CODE"}
+	}
+	for _, u := range c.users {
+		if u.Email == code.Email {
+			c.usedCodes[oobCode] = true
+			u.Email = code.NewEmail
+			c.mutations = append(c.mutations, Mutation{OpUpdate, copyUser(u)})
+			return &gitkit.EmailChange{LocalID: u.LocalID, OldEmail: code.Email, NewEmail: code.NewEmail}, nil
+		}
+	}
+	return nil, &googleapi.Error{Code: http.StatusBadRequest, Message: "EMAIL_NOT_FOUND"}
+}
+
+// Close does nothing: a fake Client has no background work.
+func (c *Client) Close() error {
+	return nil
+}
+
+// GetProjectConfig returns a copy of Project.
+func (c *Client) GetProjectConfig(ctx context.Context) (*gitkit.ProjectConfig, error) {
+	return c.ProjectConfig(ctx, nil)
+}
+
+// ProjectConfig returns a copy of Project, whatever the options.
+func (c *Client) ProjectConfig(ctx context.Context, opts *gitkit.ProjectConfigOptions) (*gitkit.ProjectConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pc := c.Project
+	return &pc, nil
+}
+
+// UpdateEmailTemplates replaces the templates of Project which are not nil in
+// t, whatever the options.
+func (c *Client) UpdateEmailTemplates(ctx context.Context, t *gitkit.EmailTemplates, opts *gitkit.ProjectConfigOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.ResetPassword != nil {
+		tmpl := *t.ResetPassword
+		c.Project.ResetPasswordTemplate = &tmpl
+	}
+	if t.ChangeEmail != nil {
+		tmpl := *t.ChangeEmail
+		c.Project.ChangeEmailTemplate = &tmpl
+	}
+	if t.VerifyEmail != nil {
+		tmpl := *t.VerifyEmail
+		c.Project.VerifyEmailTemplate = &tmpl
+	}
+	if t.UseEmailSending != nil {
+		c.Project.UseEmailSending = *t.UseEmailSending
+	}
+	return nil
+}
+
+// ExportProjectConfig writes the document of Project to w in the format.
+func (c *Client) ExportProjectConfig(ctx context.Context, w io.Writer, format gitkit.ConfigFormat) error {
+	pc, _ := c.ProjectConfig(ctx, nil)
+	return pc.Document().Encode(w, format)
+}
+
+// ApplyProjectConfig applies the document read from r to Project, leaving the
+// fields which are nil or empty in the document unchanged.
+func (c *Client) ApplyProjectConfig(ctx context.Context, r io.Reader) error {
+	d, err := gitkit.DecodeProjectConfigDocument(r)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d.AllowPasswordUser != nil {
+		c.Project.AllowPasswordUser = *d.AllowPasswordUser
+	}
+	if d.EnableAnonymousUser != nil {
+		c.Project.EnableAnonymousUser = *d.EnableAnonymousUser
+	}
+	if len(d.IdpConfigs) > 0 {
+		c.Project.IdpConfigs = d.IdpConfigs
+	}
+	if len(d.AuthorizedDomains) > 0 {
+		c.Project.AuthorizedDomains = d.AuthorizedDomains
+	}
+	if d.UseEmailSending != nil {
+		c.Project.UseEmailSending = *d.UseEmailSending
+	}
+	if d.ResetPasswordTemplate != nil {
+		c.Project.ResetPasswordTemplate = d.ResetPasswordTemplate
+	}
+	if d.ChangeEmailTemplate != nil {
+		c.Project.ChangeEmailTemplate = d.ChangeEmailTemplate
+	}
+	if d.VerifyEmailTemplate != nil {
+		c.Project.VerifyEmailTemplate = d.VerifyEmailTemplate
+	}
+	return nil
+}
+
+// validateEmail checks the email address with EmailPolicy and EmailValidator.
+func (c *Client) validateEmail(ctx context.Context, email string) error {
+	if c.EmailPolicy != nil {
+		if err := c.EmailPolicy.AllowEmail(ctx, email); err != nil {
+			return err
+		}
+	}
+	if c.EmailValidator == nil {
+		return nil
+	}
+	return c.EmailValidator.ValidateEmail(ctx, email)
+}
+
+func (c *Client) addOOBCode(r *gitkit.OOBCodeResponse) *gitkit.OOBCodeResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r.OOBCode = fmt.Sprintf("oob-code-%d", len(c.oobCodes)+1)
+	c.oobCodes = append(c.oobCodes, r)
+	return r
+}
+
+// newLocalID returns an unused local ID. c.mu must be held.
+func (c *Client) newLocalID() string {
+	for {
+		c.nextID++
+		id := strconv.Itoa(c.nextID)
+		if _, found := c.users[id]; !found {
+			return id
+		}
+	}
+}
+
+// sortedUsers returns copies of all users ordered by local ID. c.mu must be
+// held.
+func (c *Client) sortedUsers() []*gitkit.User {
+	ids := make([]string, 0, len(c.users))
+	for id := range c.users {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	users := make([]*gitkit.User, len(ids))
+	for i, id := range ids {
+		users[i] = copyUser(c.users[id])
+	}
+	return users
+}
+
+func copyUser(u *gitkit.User) *gitkit.User {
+	cp := *u
+	cp.ProviderUserInfo = append([]gitkit.ProviderUserInfo(nil), u.ProviderUserInfo...)
+	return &cp
+}
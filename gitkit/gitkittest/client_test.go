@@ -0,0 +1,430 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkittest
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+	"golang.org/x/net/context"
+)
+
+// client is the method set shared by gitkit.Client and the fake Client.
+type client interface {
+	TokenFromRequest(*http.Request) string
+	ValidateToken(context.Context, string, []string) (*gitkit.Token, error)
+	UserByToken(context.Context, string, []string) (*gitkit.User, error)
+	UserFromToken(*gitkit.Token) *gitkit.User
+	UserByEmail(context.Context, string) (*gitkit.User, error)
+	UserByLocalID(context.Context, string) (*gitkit.User, error)
+	UsersByEmails(context.Context, []string) ([]*gitkit.User, []string, error)
+	UsersByLocalIDs(context.Context, []string) ([]*gitkit.User, []string, error)
+	CheckAccountConflict(context.Context, string, string) (*gitkit.AccountConflict, error)
+	UpdateUser(context.Context, *gitkit.User) error
+	UpdateUsers(context.Context, []*gitkit.UserUpdate) []error
+	SetUserDisabled(context.Context, *gitkit.User, bool) error
+	DeleteUser(context.Context, *gitkit.User) error
+	QuarantineUser(context.Context, *gitkit.User) error
+	RestoreUser(context.Context, *gitkit.User) error
+	SweepQuarantinedUsers(context.Context, time.Duration) ([]string, error)
+	UploadUsers(context.Context, []*gitkit.User, string, []byte, []byte) error
+	UploadUsersWithOptions(context.Context, []*gitkit.User, *gitkit.UploadOptions) error
+	RetryFailedUploads(context.Context, []*gitkit.User, error, string, []byte, []byte) error
+	ListUsersN(context.Context, int, gitkit.Cursor) ([]*gitkit.User, gitkit.Cursor, error)
+	ListUsers(context.Context) *gitkit.UserList
+	ListUsersIterator(context.Context) *gitkit.UserIterator
+	GenerateOOBCode(context.Context, *http.Request) (*gitkit.OOBCodeResponse, error)
+	GenerateResetPasswordOOBCode(context.Context, *http.Request, string, string, string) (*gitkit.OOBCodeResponse, error)
+	GenerateChangeEmailOOBCode(context.Context, *http.Request, string, string, string) (*gitkit.OOBCodeResponse, error)
+	GenerateVerifyEmailOOBCode(context.Context, *http.Request, string) (*gitkit.OOBCodeResponse, error)
+	GetProjectConfig(context.Context) (*gitkit.ProjectConfig, error)
+	ProjectConfig(context.Context, *gitkit.ProjectConfigOptions) (*gitkit.ProjectConfig, error)
+	UpdateEmailTemplates(context.Context, *gitkit.EmailTemplates, *gitkit.ProjectConfigOptions) error
+	ExportProjectConfig(context.Context, io.Writer, gitkit.ConfigFormat) error
+	ApplyProjectConfig(context.Context, io.Reader) error
+	Close() error
+	ResetPassword(context.Context, string, string) (string, error)
+	ConfirmEmailVerification(context.Context, string) (string, error)
+	ApplyEmailChange(context.Context, string) (*gitkit.EmailChange, error)
+	SignInWithPassword(context.Context, string, string) (string, *gitkit.User, error)
+	CreateUser(context.Context, string, string, string) (string, string, error)
+	CustomToken(context.Context, string, map[string]interface{}) (string, error)
+}
+
+var (
+	_ client = (*gitkit.Client)(nil)
+	_ client = (*Client)(nil)
+)
+
+func TestClient_users(t *testing.T) {
+	ctx := context.Background()
+	c := NewClient()
+	u := c.AddUser(&gitkit.User{Email: "user@example.com"})
+	if u.LocalID == "" {
+		t.Fatalf("AddUser() does not assign a local ID")
+	}
+	if got, err := c.UserByEmail(ctx, "user@example.com"); err != nil || got.LocalID != u.LocalID {
+		t.Errorf("UserByEmail() = %v, %v; want %v, nil", got, err, u)
+	}
+	if err := c.UpdateUser(ctx, &gitkit.User{LocalID: u.LocalID, DisplayName: "John"}); err != nil {
+		t.Fatalf("UpdateUser() returns error: %v", err)
+	}
+	if got, _ := c.UserByLocalID(ctx, u.LocalID); got.DisplayName != "John" || got.Email != "user@example.com" {
+		t.Errorf("UserByLocalID() after update = %+v; want display name John and unchanged email", got)
+	}
+	if err := c.DeleteUser(ctx, u); err != nil {
+		t.Fatalf("DeleteUser() returns error: %v", err)
+	}
+	if _, err := c.UserByLocalID(ctx, u.LocalID); err == nil {
+		t.Errorf("UserByLocalID() after delete returns nil error; want non nil")
+	}
+	m := c.Mutations()
+	if len(m) != 2 || m[0].Op != OpUpdate || m[1].Op != OpDelete {
+		t.Errorf("Mutations() = %+v; want an update and a delete", m)
+	}
+}
+
+func TestClient_batchLookup(t *testing.T) {
+	ctx := context.Background()
+	c := NewClient()
+	u := c.AddUser(&gitkit.User{Email: "user@example.com"})
+	users, notFound, err := c.UsersByEmails(ctx, []string{"nobody@example.com", "user@example.com"})
+	if err != nil || len(users) != 2 || users[0] != nil || users[1] == nil || users[1].LocalID != u.LocalID {
+		t.Errorf("UsersByEmails() = %v, %v; want nil and the user", users, err)
+	}
+	if len(notFound) != 1 || notFound[0] != "nobody@example.com" {
+		t.Errorf("UsersByEmails() reports %v not found; want [nobody@example.com]", notFound)
+	}
+	users, notFound, err = c.UsersByLocalIDs(ctx, []string{u.LocalID, "unknown"})
+	if err != nil || len(users) != 2 || users[0] == nil || users[1] != nil {
+		t.Errorf("UsersByLocalIDs() = %v, %v; want the user and nil", users, err)
+	}
+	if len(notFound) != 1 || notFound[0] != "unknown" {
+		t.Errorf("UsersByLocalIDs() reports %v not found; want [unknown]", notFound)
+	}
+}
+
+func TestClient_resetPassword(t *testing.T) {
+	ctx := context.Background()
+	c := NewClient()
+	c.PasswordPolicy = &gitkit.PasswordRules{MinLength: 8}
+	u := c.AddUser(&gitkit.User{Email: "user@example.com"})
+	r, err := c.GenerateResetPasswordOOBCode(ctx, nil, "user@example.com", "", "captcha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ResetPassword(ctx, r.OOBCode, "short"); err == nil {
+		t.Errorf("ResetPassword() with a weak password returns no error")
+	}
+	if email, err := c.ResetPassword(ctx, r.OOBCode, "new password"); err != nil || email != u.Email {
+		t.Errorf("ResetPassword() = %q, %v; want %q, nil", email, err, u.Email)
+	}
+	if got, _ := c.User(u.LocalID); got.Password != "new password" {
+		t.Errorf("password after ResetPassword() = %q; want new password", got.Password)
+	}
+	if _, err := c.ResetPassword(ctx, r.OOBCode, "new password"); err == nil {
+		t.Errorf("ResetPassword() with a used OOB code returns no error")
+	}
+}
+
+func TestClient_confirmEmailVerification(t *testing.T) {
+	ctx := context.Background()
+	c := NewClient()
+	u := c.AddUser(&gitkit.User{Email: "user@example.com"})
+	r, err := c.GenerateVerifyEmailOOBCode(ctx, nil, "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email, err := c.ConfirmEmailVerification(ctx, r.OOBCode); err != nil || email != u.Email {
+		t.Errorf("ConfirmEmailVerification() = %q, %v; want %q, nil", email, err, u.Email)
+	}
+	if got, _ := c.User(u.LocalID); !got.EmailVerified {
+		t.Errorf("email not verified after ConfirmEmailVerification()")
+	}
+	if _, err := c.ConfirmEmailVerification(ctx, r.OOBCode); err == nil {
+		t.Errorf("ConfirmEmailVerification() with a used OOB code returns no error")
+	}
+}
+
+func TestClient_signInWithPassword(t *testing.T) {
+	ctx := context.Background()
+	c := NewClient()
+	c.Audiences = []string{"client-id"}
+	u := c.AddUser(&gitkit.User{Email: "user@example.com", Password: "secret"})
+	if _, _, err := c.SignInWithPassword(ctx, "user@example.com", "wrong"); err != gitkit.ErrInvalidCredentials {
+		t.Errorf("SignInWithPassword() with a wrong password returns error %v; want ErrInvalidCredentials", err)
+	}
+	token, got, err := c.SignInWithPassword(ctx, "User@example.com", "secret")
+	if err != nil || got.LocalID != u.LocalID {
+		t.Fatalf("SignInWithPassword() = %v, %v; want the user", got, err)
+	}
+	if tok, err := c.ValidateToken(ctx, token, nil); err != nil || tok.LocalID != u.LocalID {
+		t.Errorf("ValidateToken() of the signed in token = %v, %v; want the token of the user", tok, err)
+	}
+}
+
+func TestClient_createUser(t *testing.T) {
+	ctx := context.Background()
+	c := NewClient()
+	c.Audiences = []string{"client-id"}
+	c.PasswordPolicy = &gitkit.PasswordRules{MinLength: 8}
+	if _, _, err := c.CreateUser(ctx, "user@example.com", "short", ""); err == nil {
+		t.Errorf("CreateUser() with a weak password returns no error")
+	}
+	localID, token, err := c.CreateUser(ctx, "user@example.com", "long password", "User")
+	if err != nil {
+		t.Fatalf("CreateUser() returns error: %v", err)
+	}
+	if u, ok := c.User(localID); !ok || u.Email != "user@example.com" || u.DisplayName != "User" {
+		t.Errorf("created user = %+v; want user@example.com", u)
+	}
+	if tok, err := c.ValidateToken(ctx, token, nil); err != nil || tok.LocalID != localID {
+		t.Errorf("ValidateToken() of the new user token = %v, %v; want the token of %s", tok, err, localID)
+	}
+	if _, _, err := c.CreateUser(ctx, "User@example.com", "long password", ""); err == nil {
+		t.Errorf("CreateUser() with an email in use returns no error")
+	}
+	if m := c.Mutations(); len(m) != 1 || m[0].Op != OpCreate {
+		t.Errorf("mutations = %v; want one create", m)
+	}
+}
+
+func TestClient_applyEmailChange(t *testing.T) {
+	ctx := context.Background()
+	c := NewClient()
+	var revoked []string
+	c.SessionRevoker = gitkit.SessionRevokerFunc(func(ctx context.Context, localID string) error {
+		revoked = append(revoked, localID)
+		return nil
+	})
+	u := c.AddUser(&gitkit.User{Email: "old@example.com"})
+	r, err := c.GenerateChangeEmailOOBCode(ctx, nil, "old@example.com", "new@example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	change, err := c.ApplyEmailChange(ctx, r.OOBCode)
+	if err != nil {
+		t.Fatalf("ApplyEmailChange() returns error: %v", err)
+	}
+	if want := (gitkit.EmailChange{LocalID: u.LocalID, OldEmail: "old@example.com", NewEmail: "new@example.com"}); *change != want {
+		t.Errorf("ApplyEmailChange() = %+v; want %+v", change, want)
+	}
+	if got, _ := c.User(u.LocalID); got.Email != "new@example.com" {
+		t.Errorf("email after ApplyEmailChange() = %q; want new@example.com", got.Email)
+	}
+	if len(revoked) != 1 || revoked[0] != u.LocalID {
+		t.Errorf("revoked sessions of %v; want [%s]", revoked, u.LocalID)
+	}
+	if _, err := c.ApplyEmailChange(ctx, r.OOBCode); err == nil {
+		t.Errorf("ApplyEmailChange() with a used OOB code returns no error")
+	}
+}
+
+func TestClient_updateEmailTemplates(t *testing.T) {
+	ctx := context.Background()
+	c := NewClient()
+	c.Project.VerifyEmailTemplate = &gitkit.EmailTemplate{Subject: "Verify"}
+	enabled := true
+	err := c.UpdateEmailTemplates(ctx, &gitkit.EmailTemplates{
+		ResetPassword:   &gitkit.EmailTemplate{Subject: "Reset"},
+		UseEmailSending: &enabled,
+	}, nil)
+	if err != nil {
+		t.Fatalf("UpdateEmailTemplates() returns error: %v", err)
+	}
+	pc, _ := c.ProjectConfig(ctx, nil)
+	if pc.ResetPasswordTemplate == nil || pc.ResetPasswordTemplate.Subject != "Reset" {
+		t.Errorf("ResetPasswordTemplate = %+v; want subject Reset", pc.ResetPasswordTemplate)
+	}
+	if pc.VerifyEmailTemplate == nil || pc.VerifyEmailTemplate.Subject != "Verify" {
+		t.Errorf("VerifyEmailTemplate = %+v; want it unchanged", pc.VerifyEmailTemplate)
+	}
+	if !pc.UseEmailSending {
+		t.Error("UseEmailSending = false; want true")
+	}
+}
+
+func TestClient_projectConfigDocument(t *testing.T) {
+	ctx := context.Background()
+	c := NewClient()
+	c.Project.AllowPasswordUser = true
+	c.Project.AuthorizedDomains = []string{"example.com"}
+	var buf bytes.Buffer
+	if err := c.ExportProjectConfig(ctx, &buf, gitkit.ConfigFormatYAML); err != nil {
+		t.Fatalf("ExportProjectConfig() returns error: %v", err)
+	}
+	other := NewClient()
+	if err := other.ApplyProjectConfig(ctx, &buf); err != nil {
+		t.Fatalf("ApplyProjectConfig() returns error: %v", err)
+	}
+	pc, _ := other.ProjectConfig(ctx, nil)
+	if !pc.AllowPasswordUser || !reflect.DeepEqual(pc.AuthorizedDomains, []string{"example.com"}) {
+		t.Errorf("ProjectConfig() = %+v; want the exported configuration", pc)
+	}
+	if err := other.ApplyProjectConfig(ctx, strings.NewReader("allowPasswordUser: false")); err != nil {
+		t.Fatalf("ApplyProjectConfig() returns error: %v", err)
+	}
+	pc, _ = other.ProjectConfig(ctx, nil)
+	if pc.AllowPasswordUser || len(pc.AuthorizedDomains) != 1 {
+		t.Errorf("ProjectConfig() = %+v; want only AllowPasswordUser changed", pc)
+	}
+	if err := other.ApplyProjectConfig(ctx, strings.NewReader("unknown: 1")); err == nil {
+		t.Error("ApplyProjectConfig() of an invalid document returns no error")
+	}
+}
+
+func TestClient_quarantine(t *testing.T) {
+	ctx := context.Background()
+	c := NewClient()
+	u := c.AddUser(&gitkit.User{Email: "user@example.com"})
+	if err := c.QuarantineUser(ctx, u); err != nil {
+		t.Fatalf("QuarantineUser() returns error: %v", err)
+	}
+	if got, _ := c.User(u.LocalID); !got.Disabled {
+		t.Errorf("user is not disabled after QuarantineUser()")
+	} else if _, ok := got.QuarantinedAt(); !ok {
+		t.Errorf("user is not quarantined after QuarantineUser()")
+	}
+	if deleted, err := c.SweepQuarantinedUsers(ctx, time.Hour); err != nil || len(deleted) != 0 {
+		t.Errorf("SweepQuarantinedUsers() within grace period = %v, %v; want none", deleted, err)
+	}
+	if err := c.RestoreUser(ctx, u); err != nil {
+		t.Fatalf("RestoreUser() returns error: %v", err)
+	}
+	if got, _ := c.User(u.LocalID); got.Disabled {
+		t.Errorf("user is disabled after RestoreUser()")
+	}
+	c.QuarantineUser(ctx, u)
+	if deleted, err := c.SweepQuarantinedUsers(ctx, -time.Second); err != nil || len(deleted) != 1 {
+		t.Errorf("SweepQuarantinedUsers() = %v, %v; want [%s]", deleted, err, u.LocalID)
+	}
+	if _, ok := c.User(u.LocalID); ok {
+		t.Errorf("user is not deleted by SweepQuarantinedUsers()")
+	}
+}
+
+func TestClient_listUsers(t *testing.T) {
+	ctx := context.Background()
+	c := NewClient()
+	if err := c.UploadUsers(ctx, []*gitkit.User{{LocalID: "1"}, {LocalID: "2"}, {LocalID: "3"}}, "HMAC_SHA256", []byte("key"), nil); err != nil {
+		t.Fatalf("UploadUsers() returns error: %v", err)
+	}
+	var ids []string
+	var token gitkit.Cursor
+	for {
+		users, next, err := c.ListUsersN(ctx, 2, token)
+		if err != nil {
+			t.Fatalf("ListUsersN() returns error: %v", err)
+		}
+		for _, u := range users {
+			ids = append(ids, u.LocalID)
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	if strings.Join(ids, ",") != "1,2,3" {
+		t.Errorf("ListUsersN() lists %v; want [1 2 3]", ids)
+	}
+	n := 0
+	for range c.ListUsers(ctx).C {
+		n++
+	}
+	if n != 3 {
+		t.Errorf("ListUsers() delivers %d users; want 3", n)
+	}
+	it := c.ListUsersIterator(ctx)
+	for n = 0; ; n++ {
+		if _, err := it.Next(); err == gitkit.ErrIteratorDone {
+			break
+		} else if err != nil {
+			t.Fatalf("Next() returns error: %v", err)
+		}
+	}
+	if n != 3 {
+		t.Errorf("ListUsersIterator() delivers %d users; want 3", n)
+	}
+}
+
+func TestClient_token(t *testing.T) {
+	ctx := context.Background()
+	c := NewClient()
+	u := c.AddUser(&gitkit.User{Email: "user@example.com"})
+	c.AddToken("valid", &gitkit.Token{Audience: "aud", LocalID: u.LocalID, ProviderID: "google.com", ExpireAt: time.Now().Add(time.Hour)})
+	c.AddToken("expired", &gitkit.Token{Audience: "aud", LocalID: u.LocalID, ExpireAt: time.Now().Add(-time.Hour)})
+
+	req := &http.Request{Header: http.Header{"Cookie": {gitkit.DefaultCookieName + "=valid"}}}
+	if s := c.TokenFromRequest(req); s != "valid" {
+		t.Errorf("TokenFromRequest() = %q; want %q", s, "valid")
+	}
+	got, err := c.UserByToken(ctx, "valid", []string{"aud"})
+	if err != nil || got.LocalID != u.LocalID || got.ProviderID != "google.com" {
+		t.Errorf("UserByToken() = %+v, %v; want user %s signed in with google.com", got, err, u.LocalID)
+	}
+	tokenTests := []struct {
+		token     string
+		audiences []string
+		err       error
+	}{
+		{"valid", nil, gitkit.ErrMissingAudience},
+		{"unknown", []string{"aud"}, gitkit.ErrMalformed},
+		{"valid", []string{"other"}, gitkit.ErrInvalidAudience},
+		{"expired", []string{"aud"}, gitkit.ErrExpired},
+	}
+	for i, tt := range tokenTests {
+		if _, err := c.ValidateToken(ctx, tt.token, tt.audiences); err != tt.err {
+			t.Errorf("%d. ValidateToken(%q) returns error %v; want %v", i, tt.token, err, tt.err)
+		}
+	}
+
+	c.AudiencesByHost = map[string][]string{"app.example.com": {"aud"}, "other.example.com": {"other"}}
+	if _, err := c.ValidateToken(gitkit.WithRequestHost(ctx, "app.example.com:8080"), "valid", nil); err != nil {
+		t.Errorf("ValidateToken() for the host of the audience returns error %v", err)
+	}
+	if _, err := c.ValidateToken(gitkit.WithRequestHost(ctx, "other.example.com"), "valid", nil); err != gitkit.ErrInvalidAudience {
+		t.Errorf("ValidateToken() for another host returns error %v; want %v", err, gitkit.ErrInvalidAudience)
+	}
+
+	c.RequireVerifiedEmail = true
+	if _, err := c.ValidateToken(ctx, "valid", []string{"aud"}); err != gitkit.ErrEmailNotVerified {
+		t.Errorf("ValidateToken() of an unverified email returns error %v; want %v", err, gitkit.ErrEmailNotVerified)
+	}
+}
+
+func TestClient_oobCode(t *testing.T) {
+	c := NewClient()
+	form := url.Values{
+		gitkit.OOBActionParam:           {gitkit.OOBActionResetPassword},
+		gitkit.OOBEmailParam:            {"user@example.com"},
+		gitkit.OOBCAPTCHAResponseParam:  {"response"},
+		gitkit.OOBCAPTCHAChallengeParam: {"challenge"},
+	}
+	req := &http.Request{Method: "POST", PostForm: form}
+	resp, err := c.GenerateOOBCode(context.Background(), req)
+	if err != nil {
+		t.Fatalf("GenerateOOBCode() returns error: %v", err)
+	}
+	if codes := c.OOBCodes(); len(codes) != 1 || codes[0].OOBCode != resp.OOBCode || codes[0].Email != "user@example.com" {
+		t.Errorf("OOBCodes() = %v; want [%v]", codes, resp)
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkittest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// A Server is a fake identitytoolkit API server for end-to-end tests of code
+// using a real gitkit.Client. It serves getAccountInfo, setAccountInfo,
+// deleteAccount, uploadAccount, downloadAccount, getOobConfirmationCode and
+// resetPassword over the users of Store, and its Signer signs the ID tokens
+// the Client accepts:
+//
+//	s, _ := gitkittest.NewServer()
+//	defer s.Close()
+//	c, _ := gitkit.New(ctx, &gitkit.Config{Audiences: []string{clientID}}, s.Options()...)
+//	s.Store.AddUser(&gitkit.User{LocalID: "1234", Email: "user@example.com"})
+//	token, _ := s.Token(&gitkit.Token{Audience: clientID, LocalID: "1234"})
+type Server struct {
+	*httptest.Server
+	// Store holds the users, the OOB codes and the mutations of the API
+	// calls. It can be seeded and inspected directly.
+	Store *Client
+	// Signer signs the ID tokens of Token.
+	Signer *TokenSigner
+}
+
+// NewServer starts a Server with an empty store. It should be closed when
+// done.
+func NewServer() (*Server, error) {
+	signer, err := NewTokenSigner()
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Store: NewClient(), Signer: signer}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s, nil
+}
+
+// BaseURL returns the base URL of the API methods of the server.
+func (s *Server) BaseURL() string {
+	return s.URL + "/identitytoolkit/v3/relyingparty"
+}
+
+// Options returns the options of a gitkit.Client sending its API requests to
+// the server with fake credentials, and verifying the tokens of Signer.
+func (s *Server) Options() []gitkit.Option {
+	return []gitkit.Option{
+		gitkit.WithAPIEndpoint(s.BaseURL()),
+		gitkit.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "gitkittest"})),
+		gitkit.WithCertificateSource(s.Signer),
+	}
+}
+
+// Token returns an ID token with the claims of t signed by Signer, see
+// TokenSigner.Sign. It is registered in Store too, like AddToken.
+func (s *Server) Token(t *gitkit.Token) (string, error) {
+	token, err := s.Signer.Sign(t)
+	if err != nil {
+		return "", err
+	}
+	s.Store.AddToken(token, t)
+	return token, nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.TrimPrefix(s.BaseURL(), s.URL) + "/"
+	if r.Method != "POST" || !strings.HasPrefix(r.URL.Path, prefix) {
+		writeError(w, &googleapi.Error{Code: http.StatusNotFound, Message: "NOT_FOUND"})
+		return
+	}
+	handlers := map[string]func(context.Context, *json.Decoder) (interface{}, error){
+		"getAccountInfo":         s.getAccountInfo,
+		"setAccountInfo":         s.setAccountInfo,
+		"deleteAccount":          s.deleteAccount,
+		"uploadAccount":          s.uploadAccount,
+		"downloadAccount":        s.downloadAccount,
+		"getOobConfirmationCode": s.getOOBCode,
+		"resetPassword":          s.resetPassword,
+	}
+	h, ok := handlers[strings.TrimPrefix(r.URL.Path, prefix)]
+	if !ok {
+		writeError(w, &googleapi.Error{Code: http.StatusNotFound, Message: "NOT_FOUND"})
+		return
+	}
+	resp, err := h(context.Background(), json.NewDecoder(r.Body))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeError writes the error in the format of the identitytoolkit API.
+func writeError(w http.ResponseWriter, err error) {
+	e, ok := err.(*googleapi.Error)
+	if !ok {
+		e = &googleapi.Error{Code: http.StatusBadRequest, Message: err.Error()}
+		if _, ok := err.(gitkit.UserNotFoundError); ok {
+			e.Message = "USER_NOT_FOUND"
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": e.Code, "message": e.Message},
+	})
+}
+
+func (s *Server) getAccountInfo(ctx context.Context, dec *json.Decoder) (interface{}, error) {
+	var req gitkit.GetAccountInfoRequest
+	if err := dec.Decode(&req); err != nil {
+		return nil, err
+	}
+	resp := &gitkit.GetAccountInfoResponse{}
+	for _, id := range req.LocalIDs {
+		if u, ok := s.Store.User(id); ok {
+			resp.Users = append(resp.Users, u)
+		}
+	}
+	for _, e := range req.Emails {
+		if u, err := s.Store.UserByEmail(ctx, e); err == nil {
+			resp.Users = append(resp.Users, u)
+		}
+	}
+	return resp, nil
+}
+
+func (s *Server) setAccountInfo(ctx context.Context, dec *json.Decoder) (interface{}, error) {
+	var req gitkit.SetAccountInfoRequest
+	if err := dec.Decode(&req); err != nil {
+		return nil, err
+	}
+	if req.OOBCode != "" {
+		return s.applyOOBCode(ctx, req.OOBCode)
+	}
+	c := s.Store
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var u *gitkit.User
+	if req.LocalID != "" {
+		u = c.users[req.LocalID]
+	} else {
+		for _, v := range c.users {
+			if strings.EqualFold(v.Email, req.Email) {
+				u = v
+			}
+		}
+	}
+	if u == nil {
+		return nil, &googleapi.Error{Code: http.StatusBadRequest, Message: "USER_NOT_FOUND"}
+	}
+	if req.LocalID != "" && req.Email != "" {
+		u.Email = req.Email
+	}
+	if req.DisplayName != "" {
+		u.DisplayName = req.DisplayName
+	}
+	if req.Password != "" {
+		u.Password = req.Password
+	}
+	if req.EmailVerified {
+		u.EmailVerified = true
+	}
+	if req.DisableUser != nil {
+		u.Disabled = *req.DisableUser
+	}
+	if req.CustomAttributes != "" {
+		u.CustomAttributes = req.CustomAttributes
+	}
+	c.mutations = append(c.mutations, Mutation{OpUpdate, copyUser(u)})
+	return &gitkit.SetAccountInfoResponse{LocalID: u.LocalID, Email: u.Email}, nil
+}
+
+// applyOOBCode applies the change email or verify email OOB code.
+func (s *Server) applyOOBCode(ctx context.Context, oobCode string) (interface{}, error) {
+	for _, r := range s.Store.OOBCodes() {
+		if r.OOBCode != oobCode {
+			continue
+		}
+		switch r.Action {
+		case gitkit.OOBActionChangeEmail:
+			change, err := s.Store.applyEmailChange(oobCode)
+			if err != nil {
+				return nil, err
+			}
+			return &gitkit.SetAccountInfoResponse{LocalID: change.LocalID, Email: change.OldEmail, NewEmail: change.NewEmail}, nil
+		case gitkit.OOBActionVerifyEmail:
+			email, err := s.Store.ConfirmEmailVerification(ctx, oobCode)
+			if err != nil {
+				return nil, err
+			}
+			resp := &gitkit.SetAccountInfoResponse{Email: email, EmailVerified: true}
+			if u, err := s.Store.UserByEmail(ctx, email); err == nil {
+				resp.LocalID = u.LocalID
+			}
+			return resp, nil
+		}
+	}
+	return nil, &googleapi.Error{Code: http.StatusBadRequest, Message: "INVALID_OOB_CODE"}
+}
+
+func (s *Server) deleteAccount(ctx context.Context, dec *json.Decoder) (interface{}, error) {
+	var req gitkit.DeleteAccountRequest
+	if err := dec.Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := s.Store.DeleteUser(ctx, &gitkit.User{LocalID: req.LocalID}); err != nil {
+		return nil, err
+	}
+	return &gitkit.DeleteAccountResponse{}, nil
+}
+
+func (s *Server) uploadAccount(ctx context.Context, dec *json.Decoder) (interface{}, error) {
+	var req gitkit.UploadAccountRequest
+	if err := dec.Decode(&req); err != nil {
+		return nil, err
+	}
+	err := s.Store.UploadUsersWithOptions(ctx, req.Users, &gitkit.UploadOptions{
+		HashAlgorithm:     req.HashAlgorithm,
+		SignerKey:         req.SignerKey,
+		SaltSeparator:     req.SaltSeparator,
+		Rounds:            req.Rounds,
+		MemoryCost:        req.MemoryCost,
+		PasswordHashOrder: req.PasswordHashOrder,
+		Argon2Parameters:  req.Argon2Parameters,
+	})
+	if e, ok := err.(gitkit.UploadError); ok {
+		return &gitkit.UploadAccountResponse{Error: e}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &gitkit.UploadAccountResponse{}, nil
+}
+
+func (s *Server) downloadAccount(ctx context.Context, dec *json.Decoder) (interface{}, error) {
+	var req gitkit.DownloadAccountRequest
+	if err := dec.Decode(&req); err != nil {
+		return nil, err
+	}
+	users, next, err := s.Store.ListUsersN(ctx, req.MaxResults, gitkit.Cursor(req.NextPageToken))
+	if err != nil {
+		return nil, err
+	}
+	return &gitkit.DownloadAccountResponse{Users: users, NextPageToken: string(next)}, nil
+}
+
+func (s *Server) getOOBCode(ctx context.Context, dec *json.Decoder) (interface{}, error) {
+	var req gitkit.GetOOBCodeRequest
+	if err := dec.Decode(&req); err != nil {
+		return nil, err
+	}
+	var (
+		r   *gitkit.OOBCodeResponse
+		err error
+	)
+	switch req.RequestType {
+	case gitkit.ResetPasswordRequestType:
+		r, err = s.Store.GenerateResetPasswordOOBCode(ctx, nil, req.Email, req.CAPTCHAChallenge, req.CAPTCHAResponse)
+	case gitkit.ChangeEmailRequestType:
+		r, err = s.Store.GenerateChangeEmailOOBCode(ctx, nil, req.Email, req.NewEmail, req.Token)
+	case gitkit.VerifyEmailRequestType:
+		r, err = s.Store.GenerateVerifyEmailOOBCode(ctx, nil, req.Email)
+	default:
+		return nil, &googleapi.Error{Code: http.StatusBadRequest, Message: "INVALID_REQUEST_TYPE"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &gitkit.GetOOBCodeResponse{OOBCode: r.OOBCode}, nil
+}
+
+func (s *Server) resetPassword(ctx context.Context, dec *json.Decoder) (interface{}, error) {
+	var req gitkit.ResetPasswordRequest
+	if err := dec.Decode(&req); err != nil {
+		return nil, err
+	}
+	email, err := s.Store.ResetPassword(ctx, req.OOBCode, req.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+	return &gitkit.ResetPasswordResponse{Email: email, RequestType: gitkit.ResetPasswordRequestType}, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkittest
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+	"golang.org/x/net/context"
+)
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewServer()
+	if err != nil {
+		t.Fatalf("NewServer() returns error: %v", err)
+	}
+	defer s.Close()
+	c, err := gitkit.New(ctx, &gitkit.Config{Audiences: []string{"client"}}, s.Options()...)
+	if err != nil {
+		t.Fatalf("gitkit.New() returns error: %v", err)
+	}
+	defer c.Close()
+	s.Store.AddUser(&gitkit.User{LocalID: "1", Email: "user@example.com"})
+
+	if u, err := c.UserByLocalID(ctx, "1"); err != nil || u.Email != "user@example.com" {
+		t.Errorf("UserByLocalID() = %v, %v; want user@example.com", u, err)
+	}
+	if _, err := c.UserByEmail(ctx, "other@example.com"); err == nil {
+		t.Errorf("UserByEmail() of an unknown address returns no error")
+	}
+	if err := c.UpdateUser(ctx, &gitkit.User{LocalID: "1", DisplayName: "User"}); err != nil {
+		t.Fatalf("UpdateUser() returns error: %v", err)
+	}
+	if u, _ := s.Store.User("1"); u.DisplayName != "User" {
+		t.Errorf("display name after UpdateUser() = %q; want User", u.DisplayName)
+	}
+	if err := c.UploadUsers(ctx, []*gitkit.User{{LocalID: "2", Email: "two@example.com"}}, "HMAC_SHA256", []byte("key"), nil); err != nil {
+		t.Fatalf("UploadUsers() returns error: %v", err)
+	}
+	users, next, err := c.ListUsersN(ctx, 1, "")
+	if err != nil || len(users) != 1 || users[0].LocalID != "1" || next == "" {
+		t.Fatalf("ListUsersN() = %v, %q, %v; want the first user and a cursor", users, next, err)
+	}
+	if users, _, err = c.ListUsersN(ctx, 1, next); err != nil || len(users) != 1 || users[0].LocalID != "2" {
+		t.Errorf("ListUsersN() of the next page = %v, %v; want the second user", users, err)
+	}
+
+	r, err := c.GenerateVerifyEmailOOBCode(ctx, httptest.NewRequest("POST", "/", nil), "user@example.com")
+	if err != nil {
+		t.Fatalf("GenerateVerifyEmailOOBCode() returns error: %v", err)
+	}
+	if email, err := c.ConfirmEmailVerification(ctx, r.OOBCode); err != nil || email != "user@example.com" {
+		t.Errorf("ConfirmEmailVerification() = %q, %v; want user@example.com", email, err)
+	}
+	if u, _ := s.Store.User("1"); !u.EmailVerified {
+		t.Errorf("email not verified after ConfirmEmailVerification()")
+	}
+	if err := c.DeleteUser(ctx, &gitkit.User{LocalID: "2"}); err != nil {
+		t.Fatalf("DeleteUser() returns error: %v", err)
+	}
+	if _, ok := s.Store.User("2"); ok {
+		t.Errorf("user 2 is stored after DeleteUser()")
+	}
+
+	token, err := s.Token(&gitkit.Token{Audience: "client", LocalID: "1"})
+	if err != nil {
+		t.Fatalf("Token() returns error: %v", err)
+	}
+	if tok, err := c.ValidateToken(ctx, token, nil); err != nil || tok.LocalID != "1" {
+		t.Errorf("ValidateToken() = %v, %v; want the token of user 1", tok, err)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkittest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+)
+
+// TokenIssuer is the issuer of the tokens signed by a TokenSigner.
+const TokenIssuer = "https://identitytoolkit.google.com/"
+
+// A TokenSigner signs ID tokens with an in-memory key, and is the
+// gitkit.CertificateSource of its certificate, so that a real gitkit.Client
+// configured with it verifies the tokens without network access:
+//
+//	s, _ := gitkittest.NewTokenSigner()
+//	c, _ := gitkit.New(ctx, conf, gitkit.WithCertificateSource(s))
+//	token, _ := s.Sign(&gitkit.Token{Audience: clientID, LocalID: "1234"})
+type TokenSigner struct {
+	// KeyID is the key ID of the tokens and the certificate.
+	KeyID string
+
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+// NewTokenSigner creates a TokenSigner with a new RSA key and a self-signed
+// certificate.
+func NewTokenSigner() (*TokenSigner, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject:      pkix.Name{CommonName: "gitkittest"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &TokenSigner{KeyID: fmt.Sprintf("%x", id), key: key, cert: cert}, nil
+}
+
+// Cert implements the gitkit.CertificateSource interface.
+func (s *TokenSigner) Cert(keyID string) (*x509.Certificate, error) {
+	if keyID != s.KeyID {
+		return nil, fmt.Errorf("certificate not found for keyID: %s", keyID)
+	}
+	return s.cert, nil
+}
+
+// Certificates returns the certificate of s as gitkit.StaticCertificates.
+func (s *TokenSigner) Certificates() gitkit.StaticCertificates {
+	return gitkit.StaticCertificates{s.KeyID: s.cert}
+}
+
+// Sign returns the RS256 signed ID token with the claims of t. The issuer
+// defaults to TokenIssuer, the issue time to now and the expiration time to an
+// hour after the issue time.
+func (s *TokenSigner) Sign(t *gitkit.Token) (string, error) {
+	iss, iat, exp := t.Issuer, t.IssueAt, t.ExpireAt
+	if iss == "" {
+		iss = TokenIssuer
+	}
+	if iat.IsZero() {
+		iat = time.Now()
+	}
+	if exp.IsZero() {
+		exp = iat.Add(time.Hour)
+	}
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": s.KeyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":          iss,
+		"aud":          t.Audience,
+		"iat":          iat.Unix(),
+		"exp":          exp.Unix(),
+		"user_id":      t.LocalID,
+		"email":        t.Email,
+		"verified":     t.EmailVerified,
+		"provider_id":  t.ProviderID,
+		"display_name": t.DisplayName,
+		"photo_url":    t.PhotoURL,
+	})
+	if err != nil {
+		return "", err
+	}
+	input := encodeSegment(header) + "." + encodeSegment(claims)
+	hashed := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	return input + "." + encodeSegment(sig), nil
+}
+
+func encodeSegment(b []byte) string {
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(b), "=")
+}
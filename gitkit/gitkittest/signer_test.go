@@ -0,0 +1,59 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkittest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+func TestTokenSigner(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewTokenSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := gitkit.New(ctx, &gitkit.Config{Audiences: []string{"client"}},
+		gitkit.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})),
+		gitkit.WithCertificateSource(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.Sign(&gitkit.Token{Audience: "client", LocalID: "1234", Email: "user@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatalf("Sign() returns error: %v", err)
+	}
+	got, err := c.ValidateToken(ctx, token, nil)
+	if err != nil {
+		t.Fatalf("ValidateToken() returns error: %v", err)
+	}
+	if got.Issuer != TokenIssuer || got.LocalID != "1234" || got.Email != "user@example.com" || !got.EmailVerified {
+		t.Errorf("ValidateToken() = %+v; want the signed claims", got)
+	}
+
+	expired, _ := s.Sign(&gitkit.Token{Audience: "client", LocalID: "1234", IssueAt: time.Now().Add(-2 * time.Hour)})
+	if _, err := c.ValidateToken(ctx, expired, nil); err != gitkit.ErrExpired {
+		t.Errorf("ValidateToken() of an expired token returns error %v; want %v", err, gitkit.ErrExpired)
+	}
+	other, _ := NewTokenSigner()
+	foreign, _ := other.Sign(&gitkit.Token{Audience: "client", LocalID: "1234"})
+	if _, err := c.ValidateToken(ctx, foreign, nil); err != gitkit.ErrKeyNotFound {
+		t.Errorf("ValidateToken() of another signer's token returns error %v; want %v", err, gitkit.ErrKeyNotFound)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hash provides the gitkit upload options of the password hash schemes
+// of the systems users are commonly migrated from.
+//
+// The salted MD5 and SHA families are hashed with the salt either before or
+// after the password, and possibly iterated, e.g., a legacy CMS storing
+// md5(salt + password) is imported with
+//
+//	err := client.UploadUsersWithOptions(ctx, users, hash.MD5(gitkit.SaltAndPassword, 1))
+//
+// The passwords of a single upload must share the same options, so users
+// hashed with different schemes or parameters, e.g., Django users created
+// with different iteration counts, must be uploaded in separate batches.
+//
+// Argon2 hashes are imported with their parameters, e.g., from PHC strings
+// with PHCArgon2.
+//
+// Hash and SetPassword hash the known passwords, e.g., captured as the users
+// sign in to the old system, with the options of any algorithm accepted by
+// identitytoolkit, named by the Algorithm constants.
+//
+// The portable phpass hashes of WordPress ($P$) and Drupal 7 ($S$) mix the
+// password into every round and cannot be imported. Such users need to reset
+// their password, or be migrated when they next sign in to the old system.
+package hash
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+)
+
+func salted(algorithm, order string, rounds int) *gitkit.UploadOptions {
+	return &gitkit.UploadOptions{HashAlgorithm: algorithm, PasswordHashOrder: order, Rounds: rounds}
+}
+
+// MD5 returns the options of passwords hashed with MD5 rounds times, with the
+// salt, if any, in the given order, gitkit.SaltAndPassword or
+// gitkit.PasswordAndSalt.
+func MD5(order string, rounds int) *gitkit.UploadOptions {
+	return salted(string(AlgorithmMD5), order, rounds)
+}
+
+// SHA1 returns the options of passwords hashed with SHA-1 rounds times, with
+// the salt, if any, in the given order.
+func SHA1(order string, rounds int) *gitkit.UploadOptions {
+	return salted(string(AlgorithmSHA1), order, rounds)
+}
+
+// SHA256 returns the options of passwords hashed with SHA-256 rounds times,
+// with the salt, if any, in the given order.
+func SHA256(order string, rounds int) *gitkit.UploadOptions {
+	return salted(string(AlgorithmSHA256), order, rounds)
+}
+
+// SHA512 returns the options of passwords hashed with SHA-512 rounds times,
+// with the salt, if any, in the given order.
+func SHA512(order string, rounds int) *gitkit.UploadOptions {
+	return salted(string(AlgorithmSHA512), order, rounds)
+}
+
+// Argon2 returns the options of passwords hashed with Argon2 with the
+// parameters.
+func Argon2(params *gitkit.Argon2Parameters) *gitkit.UploadOptions {
+	return &gitkit.UploadOptions{HashAlgorithm: string(AlgorithmArgon2), Argon2Parameters: params}
+}
+
+// argon2Types maps the PHC identifiers of the Argon2 variants to their hash
+// types.
+var argon2Types = map[string]string{
+	"argon2d":  gitkit.Argon2D,
+	"argon2i":  gitkit.Argon2I,
+	"argon2id": gitkit.Argon2ID,
+}
+
+// PHCArgon2 sets the password hash and salt of the user from the Argon2 hash
+// in the PHC string format, e.g., $argon2id$v=19$m=4096,t=3,p=1$salt$hash as
+// produced by the reference implementation and most libraries, and returns the
+// options to upload the user with.
+func PHCArgon2(u *gitkit.User, encoded string) (*gitkit.UploadOptions, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" {
+		return nil, fmt.Errorf("malformed Argon2 PHC string")
+	}
+	params := &gitkit.Argon2Parameters{HashType: argon2Types[parts[1]]}
+	if params.HashType == "" {
+		return nil, fmt.Errorf("unsupported Argon2 variant %q", parts[1])
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &params.Version); err != nil {
+		return nil, fmt.Errorf("malformed Argon2 version %q", parts[2])
+	}
+	for _, kv := range strings.Split(parts[3], ",") {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("malformed Argon2 parameter %q", kv)
+		}
+		n, err := strconv.Atoi(kv[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("malformed Argon2 parameter %q", kv)
+		}
+		switch kv[:i] {
+		case "m":
+			params.MemoryCostKib = n
+		case "t":
+			params.Iterations = n
+		case "p":
+			params.Parallelism = n
+		}
+	}
+	salt, err := decodePHC(parts[4])
+	if err != nil {
+		return nil, fmt.Errorf("malformed Argon2 salt: %v", err)
+	}
+	hash, err := decodePHC(parts[5])
+	if err != nil {
+		return nil, fmt.Errorf("malformed Argon2 hash: %v", err)
+	}
+	params.HashLengthBytes = len(hash)
+	u.PasswordHash = hash
+	u.Salt = salt
+	return Argon2(params), nil
+}
+
+// decodePHC decodes the unpadded standard base64 encoding of the PHC strings.
+func decodePHC(s string) ([]byte, error) {
+	if m := len(s) % 4; m != 0 {
+		s += strings.Repeat("=", 4-m)
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// PBKDF2SHA1 returns the options of passwords hashed with PBKDF2 HMAC-SHA1.
+func PBKDF2SHA1(rounds int) *gitkit.UploadOptions {
+	return &gitkit.UploadOptions{HashAlgorithm: string(AlgorithmPBKDF2SHA1), Rounds: rounds}
+}
+
+// PBKDF2SHA256 returns the options of passwords hashed with PBKDF2
+// HMAC-SHA256.
+func PBKDF2SHA256(rounds int) *gitkit.UploadOptions {
+	return &gitkit.UploadOptions{HashAlgorithm: string(AlgorithmPBKDF2SHA256), Rounds: rounds}
+}
+
+// Django sets the password hash and salt of the user from the Django encoded
+// password, and returns the options to upload the user with. The supported
+// hashers are pbkdf2_sha256, pbkdf2_sha1, sha1, md5, unsalted_sha1 and
+// unsalted_md5.
+func Django(u *gitkit.User, encoded string) (*gitkit.UploadOptions, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) == 1 && len(encoded) == 32 {
+		// Legacy unsalted MD5 without a prefix.
+		parts = []string{"unsalted_md5", "", encoded}
+	}
+	var (
+		opts    *gitkit.UploadOptions
+		salt    string
+		decoded []byte
+		err     error
+	)
+	switch parts[0] {
+	case "pbkdf2_sha256", "pbkdf2_sha1":
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("malformed Django %s password", parts[0])
+		}
+		rounds, convErr := strconv.Atoi(parts[1])
+		if convErr != nil || rounds <= 0 {
+			return nil, fmt.Errorf("malformed Django %s iterations: %s", parts[0], parts[1])
+		}
+		if parts[0] == "pbkdf2_sha256" {
+			opts = PBKDF2SHA256(rounds)
+		} else {
+			opts = PBKDF2SHA1(rounds)
+		}
+		salt = parts[2]
+		decoded, err = base64.StdEncoding.DecodeString(parts[3])
+	case "sha1", "md5", "unsalted_sha1", "unsalted_md5":
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("malformed Django %s password", parts[0])
+		}
+		if strings.HasSuffix(parts[0], "sha1") {
+			opts = SHA1(gitkit.SaltAndPassword, 1)
+		} else {
+			opts = MD5(gitkit.SaltAndPassword, 1)
+		}
+		salt = parts[1]
+		decoded, err = hex.DecodeString(parts[2])
+	default:
+		return nil, fmt.Errorf("unsupported Django password hasher %q", parts[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed Django %s hash: %v", parts[0], err)
+	}
+	u.PasswordHash = decoded
+	u.Salt = []byte(salt)
+	if salt == "" {
+		opts.PasswordHashOrder = ""
+	}
+	return opts, nil
+}
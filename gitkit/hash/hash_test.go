@@ -0,0 +1,103 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hash
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+)
+
+func TestDjango(t *testing.T) {
+	tests := []struct {
+		encoded   string
+		algorithm string
+		rounds    int
+		order     string
+		salt      string
+		hash      []byte
+	}{
+		{"pbkdf2_sha256$24000$4Rq6rTSaH3Ha$AAEC", "PBKDF2_SHA256", 24000, "", "4Rq6rTSaH3Ha", []byte{0, 1, 2}},
+		{"pbkdf2_sha1$12000$salt$AAEC", "PBKDF_SHA1", 12000, "", "salt", []byte{0, 1, 2}},
+		{"sha1$salt$000102", "SHA1", 1, gitkit.SaltAndPassword, "salt", []byte{0, 1, 2}},
+		{"md5$salt$000102", "MD5", 1, gitkit.SaltAndPassword, "salt", []byte{0, 1, 2}},
+		{"unsalted_sha1$$000102", "SHA1", 1, "", "", []byte{0, 1, 2}},
+		{"5f4dcc3b5aa765d61d8327deb882cf99", "MD5", 1, "", "", []byte{0x5f, 0x4d, 0xcc, 0x3b, 0x5a, 0xa7, 0x65, 0xd6, 0x1d, 0x83, 0x27, 0xde, 0xb8, 0x82, 0xcf, 0x99}},
+	}
+	for _, tt := range tests {
+		u := &gitkit.User{}
+		opts, err := Django(u, tt.encoded)
+		if err != nil {
+			t.Errorf("Django(%q) returns error %v", tt.encoded, err)
+			continue
+		}
+		if opts.HashAlgorithm != tt.algorithm || opts.Rounds != tt.rounds || opts.PasswordHashOrder != tt.order {
+			t.Errorf("Django(%q) = %+v; want %s with %d rounds and order %q", tt.encoded, opts, tt.algorithm, tt.rounds, tt.order)
+		}
+		if string(u.Salt) != tt.salt || !bytes.Equal(u.PasswordHash, tt.hash) {
+			t.Errorf("Django(%q) sets salt %q and hash %x; want %q and %x", tt.encoded, u.Salt, u.PasswordHash, tt.salt, tt.hash)
+		}
+	}
+
+	for _, encoded := range []string{
+		"",
+		"bcrypt$$2b$12$abc",
+		"pbkdf2_sha256$x$salt$AAEC",
+		"pbkdf2_sha256$100$salt",
+		"md5$salt$zz",
+		"pbkdf2_sha256$100$salt$!!",
+	} {
+		if _, err := Django(&gitkit.User{}, encoded); err == nil {
+			t.Errorf("Django(%q) returns no error", encoded)
+		}
+	}
+}
+
+func TestSalted(t *testing.T) {
+	opts := SHA1(gitkit.PasswordAndSalt, 3)
+	if opts.HashAlgorithm != "SHA1" || opts.PasswordHashOrder != gitkit.PasswordAndSalt || opts.Rounds != 3 {
+		t.Errorf("SHA1() = %+v", opts)
+	}
+}
+
+func TestPHCArgon2(t *testing.T) {
+	u := &gitkit.User{}
+	opts, err := PHCArgon2(u, "$argon2id$v=19$m=4096,t=3,p=1$c29tZXNhbHQ$AAECAw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := gitkit.Argon2Parameters{HashType: gitkit.Argon2ID, Version: 19, HashLengthBytes: 4, Iterations: 3, MemoryCostKib: 4096, Parallelism: 1}
+	if opts.HashAlgorithm != "ARGON2" || opts.Argon2Parameters == nil || !reflect.DeepEqual(*opts.Argon2Parameters, want) {
+		t.Errorf("PHCArgon2() = %+v, %+v; want ARGON2 with %+v", opts, opts.Argon2Parameters, want)
+	}
+	if string(u.Salt) != "somesalt" || !bytes.Equal(u.PasswordHash, []byte{0, 1, 2, 3}) {
+		t.Errorf("PHCArgon2() sets salt %q and hash %x", u.Salt, u.PasswordHash)
+	}
+
+	for _, encoded := range []string{
+		"",
+		"$argon2x$v=19$m=4096,t=3,p=1$c29tZXNhbHQ$AAECAw",
+		"$argon2id$19$m=4096,t=3,p=1$c29tZXNhbHQ$AAECAw",
+		"$argon2id$v=19$m=x,t=3,p=1$c29tZXNhbHQ$AAECAw",
+		"$argon2id$v=19$m=4096,t=3,p=1$!$AAECAw",
+		"$argon2id$v=19$m=4096,t=3,p=1$c29tZXNhbHQ",
+	} {
+		if _, err := PHCArgon2(&gitkit.User{}, encoded); err == nil {
+			t.Errorf("PHCArgon2(%q) returns no error", encoded)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hash
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	gohash "hash"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// An Algorithm is a password hash algorithm accepted by identitytoolkit, the
+// HashAlgorithm of gitkit.UploadOptions.
+type Algorithm string
+
+// The password hash algorithms.
+const (
+	AlgorithmHMACSHA512   Algorithm = "HMAC_SHA512"
+	AlgorithmHMACSHA256   Algorithm = "HMAC_SHA256"
+	AlgorithmHMACSHA1     Algorithm = "HMAC_SHA1"
+	AlgorithmHMACMD5      Algorithm = "HMAC_MD5"
+	AlgorithmMD5          Algorithm = "MD5"
+	AlgorithmSHA1         Algorithm = "SHA1"
+	AlgorithmSHA256       Algorithm = "SHA256"
+	AlgorithmSHA512       Algorithm = "SHA512"
+	AlgorithmPBKDF2SHA1   Algorithm = "PBKDF_SHA1"
+	AlgorithmPBKDF2SHA256 Algorithm = "PBKDF2_SHA256"
+	AlgorithmScrypt       Algorithm = "SCRYPT"
+	AlgorithmBcrypt       Algorithm = "BCRYPT"
+	AlgorithmArgon2       Algorithm = "ARGON2"
+)
+
+// SaltSize is the size of the salts generated by Hash.
+const SaltSize = 16
+
+// HMAC returns the options of passwords hashed with the HMAC algorithm, e.g.,
+// AlgorithmHMACSHA256, keyed with key.
+func HMAC(algorithm Algorithm, key []byte) *gitkit.UploadOptions {
+	return &gitkit.UploadOptions{HashAlgorithm: string(algorithm), SignerKey: key}
+}
+
+// Scrypt returns the options of passwords hashed with the scrypt variant of
+// Firebase Authentication: the signer key encrypted with the scrypt key of the
+// password, of cost 2^memoryCost and block size rounds.
+func Scrypt(key, saltSeparator []byte, rounds, memoryCost int) *gitkit.UploadOptions {
+	return &gitkit.UploadOptions{
+		HashAlgorithm: string(AlgorithmScrypt),
+		SignerKey:     key,
+		SaltSeparator: saltSeparator,
+		Rounds:        rounds,
+		MemoryCost:    memoryCost,
+	}
+}
+
+// Bcrypt returns the options of passwords hashed with bcrypt, whose hashes
+// embed their salt and cost.
+func Bcrypt() *gitkit.UploadOptions {
+	return &gitkit.UploadOptions{HashAlgorithm: string(AlgorithmBcrypt)}
+}
+
+// Hash hashes the password as identitytoolkit checks it against the hashes
+// uploaded with opts, and returns the hash and its salt, a new random one. The
+// salted MD5 and SHA hashes are unsalted, and the salt nil, without a
+// PasswordHashOrder, like the bcrypt hashes, which embed theirs.
+//
+// For example, to migrate users whose passwords are known, e.g., as they sign
+// in to the old system,
+//
+//	opts := hash.HMAC(hash.AlgorithmHMACSHA256, key)
+//	u.PasswordHash, u.Salt, err = hash.Hash(opts, password)
+//	...
+//	err = client.UploadUsersWithOptions(ctx, users, opts)
+func Hash(opts *gitkit.UploadOptions, password string) (hash, salt []byte, err error) {
+	algorithm := Algorithm(opts.HashAlgorithm)
+	switch algorithm {
+	case AlgorithmBcrypt:
+		cost := opts.Rounds
+		if cost == 0 {
+			cost = bcrypt.DefaultCost
+		}
+		hash, err = bcrypt.GenerateFromPassword([]byte(password), cost)
+		return hash, nil, err
+	case AlgorithmMD5, AlgorithmSHA1, AlgorithmSHA256, AlgorithmSHA512:
+		if opts.PasswordHashOrder == "" {
+			return digest(algorithm, []byte(password), opts.Rounds), nil, nil
+		}
+	}
+	salt = make([]byte, SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+	hash, err = HashWithSalt(opts, password, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hash, salt, nil
+}
+
+// HashWithSalt hashes the password with the salt as identitytoolkit checks it
+// against the hashes uploaded with opts. It does not support AlgorithmBcrypt,
+// whose salts are generated by Hash.
+func HashWithSalt(opts *gitkit.UploadOptions, password string, salt []byte) ([]byte, error) {
+	algorithm := Algorithm(opts.HashAlgorithm)
+	switch algorithm {
+	case AlgorithmHMACSHA512, AlgorithmHMACSHA256, AlgorithmHMACSHA1, AlgorithmHMACMD5, AlgorithmScrypt:
+		// Hashed like gitkit.User.VerifyPassword checks them.
+		return gitkit.HashPassword(opts, password, salt)
+	case AlgorithmMD5, AlgorithmSHA1, AlgorithmSHA256, AlgorithmSHA512:
+		return digest(algorithm, saltedPassword(opts.PasswordHashOrder, password, salt), opts.Rounds), nil
+	case AlgorithmPBKDF2SHA1, AlgorithmPBKDF2SHA256:
+		if opts.Rounds <= 0 {
+			return nil, fmt.Errorf("%s needs the number of rounds", algorithm)
+		}
+		h := hashFuncs[algorithm]
+		return pbkdf2.Key([]byte(password), salt, opts.Rounds, h().Size(), h), nil
+	case AlgorithmArgon2:
+		return hashArgon2(opts.Argon2Parameters, password, salt)
+	}
+	return nil, fmt.Errorf("unsupported password hash algorithm %q", opts.HashAlgorithm)
+}
+
+// SetPassword sets the password hash and salt of the user from the password,
+// hashed by Hash.
+func SetPassword(u *gitkit.User, opts *gitkit.UploadOptions, password string) error {
+	hash, salt, err := Hash(opts, password)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = hash
+	u.Salt = salt
+	return nil
+}
+
+// hashFuncs are the hash functions of the digest and PBKDF2 algorithms.
+var hashFuncs = map[Algorithm]func() gohash.Hash{
+	AlgorithmMD5:          md5.New,
+	AlgorithmSHA1:         sha1.New,
+	AlgorithmSHA256:       sha256.New,
+	AlgorithmSHA512:       sha512.New,
+	AlgorithmPBKDF2SHA1:   sha1.New,
+	AlgorithmPBKDF2SHA256: sha256.New,
+}
+
+// saltedPassword concatenates the salt and the password in the order, the
+// password first by default.
+func saltedPassword(order, password string, salt []byte) []byte {
+	if order == gitkit.SaltAndPassword {
+		return append(append([]byte{}, salt...), password...)
+	}
+	return append([]byte(password), salt...)
+}
+
+// digest hashes b, then the hash rounds-1 times.
+func digest(algorithm Algorithm, b []byte, rounds int) []byte {
+	h := hashFuncs[algorithm]()
+	h.Write(b)
+	sum := h.Sum(nil)
+	for i := 1; i < rounds; i++ {
+		h.Reset()
+		h.Write(sum)
+		sum = h.Sum(sum[:0])
+	}
+	return sum
+}
+
+// hashArgon2 hashes the password with Argon2i or Argon2id. Argon2d is not
+// implemented.
+func hashArgon2(p *gitkit.Argon2Parameters, password string, salt []byte) ([]byte, error) {
+	if p == nil {
+		return nil, fmt.Errorf("%s needs parameters", AlgorithmArgon2)
+	}
+	if p.Version != 0 && p.Version != argon2.Version {
+		return nil, fmt.Errorf("unsupported Argon2 version %d", p.Version)
+	}
+	if p.Iterations <= 0 || p.MemoryCostKib <= 0 || p.Parallelism <= 0 || p.HashLengthBytes <= 0 {
+		return nil, fmt.Errorf("incomplete Argon2 parameters")
+	}
+	t, m, n, l := uint32(p.Iterations), uint32(p.MemoryCostKib), uint8(p.Parallelism), uint32(p.HashLengthBytes)
+	switch p.HashType {
+	case gitkit.Argon2I:
+		return argon2.Key([]byte(password), salt, t, m, n, l), nil
+	case gitkit.Argon2ID:
+		return argon2.IDKey([]byte(password), salt, t, m, n, l), nil
+	}
+	return nil, fmt.Errorf("unsupported Argon2 hash type %q", p.HashType)
+}
@@ -0,0 +1,112 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hash
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashWithSalt(t *testing.T) {
+	tests := []struct {
+		opts     *gitkit.UploadOptions
+		password string
+		salt     string
+		hash     string // Hex encoded.
+	}{
+		{MD5("", 1), "password", "", "5f4dcc3b5aa765d61d8327deb882cf99"},
+		{SHA256(gitkit.SaltAndPassword, 1), "bc", "a", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{SHA256(gitkit.PasswordAndSalt, 1), "a", "bc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		// sha256(sha256("abc")).
+		{SHA256(gitkit.SaltAndPassword, 2), "bc", "a", "4f8b42c22dd3729b519ba6f68d2da7cc5b2d606d05daed5ad5128cc03e6c6358"},
+		{HMAC(AlgorithmHMACSHA256, []byte("key")), "The quick brown fox jumps over the lazy dog", "", "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"},
+		// RFC 6070.
+		{PBKDF2SHA1(1), "password", "salt", "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+	}
+	for _, tt := range tests {
+		hash, err := HashWithSalt(tt.opts, tt.password, []byte(tt.salt))
+		if err != nil {
+			t.Errorf("HashWithSalt(%s, %q) returns error %v", tt.opts.HashAlgorithm, tt.password, err)
+			continue
+		}
+		if got := hex.EncodeToString(hash); got != tt.hash {
+			t.Errorf("HashWithSalt(%s, %q) = %s; want %s", tt.opts.HashAlgorithm, tt.password, got, tt.hash)
+		}
+	}
+
+	for _, opts := range []*gitkit.UploadOptions{
+		HMAC(AlgorithmHMACSHA256, nil),
+		PBKDF2SHA256(0),
+		Scrypt([]byte("key"), nil, 8, 0),
+		Bcrypt(),
+		Argon2(&gitkit.Argon2Parameters{HashType: gitkit.Argon2D, Iterations: 1, MemoryCostKib: 64, Parallelism: 1, HashLengthBytes: 32}),
+		{HashAlgorithm: "ROT13"},
+	} {
+		if _, err := HashWithSalt(opts, "password", []byte("salt")); err == nil {
+			t.Errorf("HashWithSalt(%+v) returns no error", opts)
+		}
+	}
+}
+
+func TestHashWithSalt_scrypt(t *testing.T) {
+	// The example of the Firebase scrypt implementation.
+	key, _ := base64.StdEncoding.DecodeString("jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVMXAn210wjLNmdZJzxUECKbm0QsEmYUSDzZvpjeJ9WmXA==")
+	sep, _ := base64.StdEncoding.DecodeString("Bw==")
+	salt, _ := base64.StdEncoding.DecodeString("42xEC+ixf3L2lw==")
+	hash, err := HashWithSalt(Scrypt(key, sep, 8, 14), "user1password", salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1SAyZvqmZqAjTdn3aoItz+VHjoZilo78198JAdRuid5lQ=="
+	if got := base64.StdEncoding.EncodeToString(hash); got != want {
+		t.Errorf("HashWithSalt() = %s; want %s", got, want)
+	}
+}
+
+func TestHash(t *testing.T) {
+	opts := HMAC(AlgorithmHMACSHA512, []byte("key"))
+	h1, s1, err := Hash(opts, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, s2, _ := Hash(opts, "password")
+	if len(s1) != SaltSize || bytes.Equal(s1, s2) || bytes.Equal(h1, h2) {
+		t.Errorf("Hash() returns salts %x and %x; want distinct random salts", s1, s2)
+	}
+	if h, _ := HashWithSalt(opts, "password", s1); !bytes.Equal(h, h1) {
+		t.Errorf("Hash() = %x; want %x", h1, h)
+	}
+
+	u := &gitkit.User{}
+	if err := SetPassword(u, &gitkit.UploadOptions{HashAlgorithm: string(AlgorithmBcrypt), Rounds: bcrypt.MinCost}, "password"); err != nil {
+		t.Fatal(err)
+	}
+	if u.Salt != nil || bcrypt.CompareHashAndPassword(u.PasswordHash, []byte("password")) != nil {
+		t.Errorf("SetPassword() with bcrypt sets hash %s and salt %x", u.PasswordHash, u.Salt)
+	}
+
+	params := &gitkit.Argon2Parameters{HashType: gitkit.Argon2ID, Iterations: 1, MemoryCostKib: 64, Parallelism: 1, HashLengthBytes: 24}
+	if err := SetPassword(u, Argon2(params), "password"); err != nil {
+		t.Fatal(err)
+	}
+	if len(u.PasswordHash) != 24 || len(u.Salt) != SaltSize {
+		t.Errorf("SetPassword() with Argon2 sets hash %x and salt %x", u.PasswordHash, u.Salt)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// Headers set by CorrelationHeaders.
+const (
+	RequestIDHeader = "X-Request-Id"
+	TraceIDHeader   = "X-Cloud-Trace-Context"
+	ActorHeader     = "X-Gitkit-Actor"
+)
+
+type requestIDKey struct{}
+
+type traceIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the ID of the request being
+// served, which CorrelationHeaders forwards to identitytoolkit.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// WithTraceID returns a copy of ctx carrying the trace ID of the request being
+// served, which CorrelationHeaders forwards to identitytoolkit.
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, id)
+}
+
+// CorrelationHeaders returns the request ID, trace ID and acting admin carried
+// by ctx, as set by WithRequestID, WithTraceID and WithActingAdmin, in the
+// RequestIDHeader, TraceIDHeader and ActorHeader headers. It returns nil if ctx
+// carries none of them. It can be used as Config.RequestHeaders.
+func CorrelationHeaders(ctx context.Context) http.Header {
+	var h http.Header
+	set := func(key string, v interface{}) {
+		if s, _ := v.(string); s != "" {
+			if h == nil {
+				h = make(http.Header)
+			}
+			h.Set(key, s)
+		}
+	}
+	set(RequestIDHeader, ctx.Value(requestIDKey{}))
+	set(TraceIDHeader, ctx.Value(traceIDKey{}))
+	set(ActorHeader, ctx.Value(actingAdminKey{}))
+	return h
+}
+
+// requestHeaders returns a copy of the headers Config.RequestHeaders stamps on
+// the requests made with ctx, or nil if there are none.
+func (c *Client) requestHeaders(ctx context.Context) http.Header {
+	if c.config == nil || c.config.RequestHeaders == nil {
+		return nil
+	}
+	h := make(http.Header)
+	for k, v := range c.config.RequestHeaders(ctx) {
+		h[k] = v
+	}
+	if len(h) == 0 {
+		return nil
+	}
+	return h
+}
+
+// callAPIClient returns the APIClient for the calls made with ctx, which stamps
+// the requests with the headers of Config.RequestHeaders.
+func (c *Client) callAPIClient(ctx context.Context) *APIClient {
+	return c.apiClientWithHeader(ctx, c.requestHeaders(ctx))
+}
+
+// apiClientWithHeader returns the APIClient for the calls made with ctx whose
+// requests carry the headers h.
+func (c *Client) apiClientWithHeader(ctx context.Context, h http.Header) *APIClient {
+	api := c.apiClient(ctx)
+	if len(h) == 0 {
+		return api
+	}
+	t := api.Transport
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	return &APIClient{
+		Client: http.Client{
+			Transport: &headerTransport{t, h},
+			Jar:       api.Jar,
+			Timeout:   api.Timeout,
+		},
+		BaseURL: api.BaseURL,
+	}
+}
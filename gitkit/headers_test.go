@@ -0,0 +1,75 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestCorrelationHeaders(t *testing.T) {
+	ctx := context.Background()
+	if h := CorrelationHeaders(ctx); h != nil {
+		t.Errorf("CorrelationHeaders() of an empty context = %v; want nil", h)
+	}
+	ctx = WithTraceID(WithRequestID(WithActingAdmin(ctx, "admin@example.com"), "req-1"), "trace-1/2;o=1")
+	want := http.Header{
+		RequestIDHeader: {"req-1"},
+		TraceIDHeader:   {"trace-1/2;o=1"},
+		ActorHeader:     {"admin@example.com"},
+	}
+	if h := CorrelationHeaders(ctx); !reflect.DeepEqual(h, want) {
+		t.Errorf("CorrelationHeaders() = %v; want %v", h, want)
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	rt := &recordingRoundTripper{roundTripper: roundTripper{200, `{"users": [{"localId": "123"}]}`}}
+	c := &Client{
+		config: &Config{
+			ActingAdminHeader: "X-Acting-Admin",
+			RequestHeaders:    CorrelationHeaders,
+		},
+		api: &APIClient{Client: http.Client{Transport: rt}},
+	}
+	ctx := WithRequestID(context.Background(), "req-1")
+	if _, err := c.UserByLocalID(ctx, "123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteUser(WithActingAdmin(ctx, "admin@example.com"), &User{LocalID: "123"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UserByLocalID(context.Background(), "123"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key  string
+		want []string // Per request.
+	}{
+		{RequestIDHeader, []string{"req-1", "req-1", ""}},
+		{ActorHeader, []string{"", "admin@example.com", ""}},
+		{"X-Acting-Admin", []string{"", "admin@example.com", ""}},
+	}
+	for _, tt := range tests {
+		for i, want := range tt.want {
+			if got := rt.reqs[i].Header.Get(tt.key); got != want {
+				t.Errorf("request %d: %s header = %q; want %q", i, tt.key, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// lookupResult is the outcome of a getAccountInfo call.
+type lookupResult struct {
+	resp *GetAccountInfoResponse
+	err  error
+}
+
+// lookupAccountInfo calls getAccountInfo, hedged according to
+// Config.LookupHedgeDelay: if the first request is neither answered within
+// the delay nor fails with a non retryable error, a second one is sent. The
+// first successful response wins and the other request is canceled.
+func (c *Client) lookupAccountInfo(ctx context.Context, req *GetAccountInfoRequest) (*GetAccountInfoResponse, error) {
+	api := c.callAPIClient(ctx)
+	if c.config == nil || c.config.LookupHedgeDelay <= 0 {
+		return api.GetAccountInfo(ctx, req)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan lookupResult, 2)
+	start := func() {
+		go func() {
+			resp, err := api.GetAccountInfo(ctx, req)
+			results <- lookupResult{resp, err}
+		}()
+	}
+	start()
+	timer := time.NewTimer(c.config.LookupHedgeDelay)
+	defer timer.Stop()
+	pending, hedged := 1, false
+	hedge := func() {
+		hedged = true
+		pending++
+		c.count(MetricLookupHedges, 1)
+		start()
+	}
+	for {
+		select {
+		case <-timer.C:
+			if !hedged {
+				hedge()
+			}
+		case r := <-results:
+			pending--
+			switch {
+			case r.err == nil:
+				return r.resp, nil
+			case !canFailover(r.err):
+				return nil, r.err
+			case !hedged:
+				// Fail over without waiting for the delay.
+				hedge()
+			case pending == 0:
+				return nil, r.err
+			}
+		}
+	}
+}
+
+// canFailover reports whether a lookup which failed with err can be sent
+// again: server and network errors can, quota and client errors can't.
+func canFailover(err error) bool {
+	switch e := err.(type) {
+	case *QuotaError:
+		return false
+	case *googleapi.Error:
+		return e.Code >= 500
+	}
+	return true
+}
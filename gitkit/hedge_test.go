@@ -0,0 +1,94 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// hedgeRoundTripper answers the n-th request with resps[n], or blocks it
+// until it is canceled if the response is empty.
+type hedgeRoundTripper struct {
+	resps    []roundTripper
+	mu       sync.Mutex
+	calls    int
+	canceled chan struct{}
+}
+
+func (r *hedgeRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	resp := r.resps[r.calls]
+	r.calls++
+	r.mu.Unlock()
+	if resp.statusCode == 0 {
+		<-req.Context().Done()
+		close(r.canceled)
+		return nil, req.Context().Err()
+	}
+	return resp.RoundTrip(req)
+}
+
+func TestLookupAccountInfo_hedged(t *testing.T) {
+	const user = `{"users":[{"localId":"123"}]}`
+	tests := []struct {
+		delay time.Duration
+		resps []roundTripper
+		calls int
+		err   bool
+	}{
+		// The slow request is hedged and canceled.
+		{time.Millisecond, []roundTripper{{}, {200, user}}, 2, false},
+		// The server error fails over before the delay.
+		{time.Minute, []roundTripper{{503, `{"error":{"code":503,"message":"unavailable"}}`}, {200, user}}, 2, false},
+		// The client and quota errors are not sent again.
+		{time.Minute, []roundTripper{{400, `{"error":{"code":400,"message":"INVALID_ID"}}`}}, 1, true},
+		{time.Minute, []roundTripper{{429, `{"error":{"code":429,"message":"QUOTA_EXCEEDED"}}`}}, 1, true},
+		// Both requests fail.
+		{time.Minute, []roundTripper{{500, `{}`}, {500, `{}`}}, 2, true},
+		// No hedging.
+		{0, []roundTripper{{200, user}}, 1, false},
+	}
+	for i, tt := range tests {
+		rt := &hedgeRoundTripper{resps: tt.resps, canceled: make(chan struct{})}
+		m := &counters{}
+		c := &Client{
+			config: &Config{LookupHedgeDelay: tt.delay, Metrics: m},
+			api:    &APIClient{Client: http.Client{Transport: rt}},
+		}
+		u, err := c.UserByLocalID(context.Background(), "123")
+		if tt.err {
+			if err == nil {
+				t.Errorf("[%d] UserByLocalID() returns nil error; want non nil", i)
+			}
+		} else if err != nil || u.LocalID != "123" {
+			t.Errorf("[%d] UserByLocalID() = %v, %v; want user 123", i, u, err)
+		}
+		if tt.resps[0].statusCode == 0 {
+			select {
+			case <-rt.canceled:
+			case <-time.After(time.Second):
+				t.Errorf("[%d] the slow request is not canceled", i)
+			}
+		}
+		if rt.calls != tt.calls || m.m[MetricLookupHedges] != int64(tt.calls-1) {
+			t.Errorf("[%d] %d requests sent, %d hedges counted; want %d, %d", i, rt.calls, m.m[MetricLookupHedges], tt.calls, tt.calls-1)
+		}
+	}
+}
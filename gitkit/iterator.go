@@ -0,0 +1,87 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"errors"
+
+	"golang.org/x/net/context"
+)
+
+// ErrIteratorDone is returned by UserIterator.Next when all the users have
+// been delivered.
+var ErrIteratorDone = errors.New("no more users")
+
+// A UserIterator delivers the users one at a time, fetching the pages lazily.
+// Unlike UserList, it runs no goroutine: nothing is fetched until Next is
+// called, and the iteration stops with its context.
+//
+// For example,
+//
+//	it := c.ListUsersIterator(ctx)
+//	for {
+//		u, err := it.Next()
+//		if err == gitkit.ErrIteratorDone {
+//			break
+//		}
+//		if err != nil {
+//			// Calling Next again retries the same page.
+//			...
+//		}
+//		...
+//	}
+type UserIterator struct {
+	ctx   context.Context
+	pager *Pager
+	users []*User
+}
+
+// NewUserIterator creates a UserIterator listing the users of l within ctx.
+func NewUserIterator(ctx context.Context, l UserLister) *UserIterator {
+	return &UserIterator{ctx: ctx, pager: &Pager{lister: l, pageSize: maxResultsPerPage}}
+}
+
+// ListUsersIterator returns a UserIterator listing all the users within ctx.
+func (c *Client) ListUsersIterator(ctx context.Context) *UserIterator {
+	return NewUserIterator(ctx, c)
+}
+
+// Next returns the next user, or ErrIteratorDone once all the users have been
+// delivered. If fetching a page fails, or ctx is done, the error is returned
+// and the position is kept, so that calling Next again retries the page.
+func (it *UserIterator) Next() (*User, error) {
+	for len(it.users) == 0 {
+		if it.pager.Done() {
+			return nil, ErrIteratorDone
+		}
+		if err := it.ctx.Err(); err != nil {
+			return nil, err
+		}
+		users, err := it.pager.NextPage(it.ctx)
+		if err != nil {
+			return nil, err
+		}
+		it.users = users
+	}
+	u := it.users[0]
+	it.users = it.users[1:]
+	return u, nil
+}
+
+// PageToken returns the token of the page after the users buffered by the
+// iterator, which is empty once all the pages have been fetched.
+func (it *UserIterator) PageToken() Cursor {
+	return it.pager.PageToken()
+}
@@ -0,0 +1,75 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"strconv"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestUserIterator(t *testing.T) {
+	var users []*User
+	for i := 0; i < 2*maxResultsPerPage+1; i++ {
+		users = append(users, &User{LocalID: strconv.Itoa(i)})
+	}
+	l := &sliceLister{users: users, failAt: Cursor(strconv.Itoa(maxResultsPerPage))}
+	it := NewUserIterator(context.Background(), l)
+	var got []*User
+	failures := 0
+	for {
+		u, err := it.Next()
+		if err == ErrIteratorDone {
+			break
+		}
+		if err != nil {
+			// The position is kept: the failed page is retried.
+			failures++
+			if failures > 1 {
+				t.Fatalf("Next() fails again with %v", err)
+			}
+			continue
+		}
+		got = append(got, u)
+	}
+	if failures != 1 {
+		t.Errorf("Next() fails %d times; want once", failures)
+	}
+	if len(got) != len(users) {
+		t.Fatalf("iterator delivers %d users; want %d", len(got), len(users))
+	}
+	for i, u := range got {
+		if u != users[i] {
+			t.Errorf("user %d is %s; want %s", i, u.LocalID, users[i].LocalID)
+		}
+	}
+	if it.PageToken() != "" {
+		t.Errorf("PageToken() = %q once done; want empty", it.PageToken())
+	}
+	if _, err := it.Next(); err != ErrIteratorDone {
+		t.Errorf("Next() once done returns error %v; want ErrIteratorDone", err)
+	}
+}
+
+func TestUserIterator_cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &sliceLister{users: []*User{{LocalID: "1"}}}
+	it := NewUserIterator(ctx, l)
+	cancel()
+	if _, err := it.Next(); err != context.Canceled {
+		t.Errorf("Next() after cancel returns error %v; want context.Canceled", err)
+	}
+}
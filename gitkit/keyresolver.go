@@ -0,0 +1,72 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"crypto"
+	"fmt"
+)
+
+// A KeyResolver returns the public keys verifying the signatures of the
+// tokens, e.g., from the keys held by Cloud KMS or an HSM. See
+// VerifyTokenWithResolver and Config.KeyResolver.
+type KeyResolver interface {
+	// ResolveKey returns the public key of the key ID for the JWS algorithm,
+	// RS256 or ES256, i.e., an *rsa.PublicKey or an *ecdsa.PublicKey.
+	ResolveKey(keyID, algorithm string) (crypto.PublicKey, error)
+}
+
+// KeyResolverFunc is an adapter to use a function as a KeyResolver.
+type KeyResolverFunc func(keyID, algorithm string) (crypto.PublicKey, error)
+
+// ResolveKey implements the KeyResolver interface.
+func (f KeyResolverFunc) ResolveKey(keyID, algorithm string) (crypto.PublicKey, error) {
+	return f(keyID, algorithm)
+}
+
+// ResolveKey implements the KeyResolver interface with the public keys of the
+// certificates.
+func (c *Certificates) ResolveKey(keyID, algorithm string) (crypto.PublicKey, error) {
+	return sourceKeyResolver{c}.ResolveKey(keyID, algorithm)
+}
+
+// StaticKeys is a KeyResolver of a fixed set of public keys indexed by key ID.
+type StaticKeys map[string]crypto.PublicKey
+
+// ResolveKey implements the KeyResolver interface.
+func (k StaticKeys) ResolveKey(keyID, algorithm string) (crypto.PublicKey, error) {
+	key, ok := k[keyID]
+	if !ok {
+		return nil, fmt.Errorf("key not found for keyID: %s", keyID)
+	}
+	return key, nil
+}
+
+// KeyResolvers is a KeyResolver which tries each resolver in turn, e.g., to
+// verify the tokens signed by several sources.
+type KeyResolvers []KeyResolver
+
+// ResolveKey implements the KeyResolver interface. It returns the first key
+// found, or the error of the last resolver.
+func (rs KeyResolvers) ResolveKey(keyID, algorithm string) (crypto.PublicKey, error) {
+	err := fmt.Errorf("key not found for keyID: %s", keyID)
+	for _, r := range rs {
+		var key crypto.PublicKey
+		if key, err = r.ResolveKey(keyID, algorithm); err == nil {
+			return key, nil
+		}
+	}
+	return nil, err
+}
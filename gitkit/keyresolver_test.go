@@ -0,0 +1,97 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// es256Signer signs with ES256 for testing.
+type es256Signer struct {
+	key *ecdsa.PrivateKey
+	kid string
+}
+
+func (s *es256Signer) Algorithm() string { return "ES256" }
+func (s *es256Signer) KeyID() string     { return s.kid }
+
+func (s *es256Signer) Sign(ctx context.Context, data []byte) ([]byte, error) {
+	h := sha256.Sum256(data)
+	r, ss, err := ecdsa.Sign(rand.Reader, s.key, h[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := make([]byte, 64)
+	rb, sb := r.Bytes(), ss.Bytes()
+	copy(sig[32-len(rb):], rb)
+	copy(sig[64-len(sb):], sb)
+	return sig, nil
+}
+
+func TestVerifyTokenWithResolver(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]interface{}{
+		"aud":     audience,
+		"exp":     time.Now().Add(time.Hour).Unix(),
+		"user_id": "123",
+	}
+	es256Token, err := signJWT(context.Background(), &es256Signer{key, "kms-1"}, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := initCerts()
+	kms := StaticKeys{"kms-1": &key.PublicKey}
+	tests := []struct {
+		token    string
+		resolver KeyResolver
+		err      error
+	}{
+		{es256Token, kms, nil},
+		{es256Token, certs, ErrKeyNotFound},
+		{es256Token, KeyResolvers{certs, kms}, nil},
+		{validToken, KeyResolvers{certs, kms}, nil},
+		{validToken, kms, ErrKeyNotFound},
+		// The RSA key of the certificate does not match ES256.
+		{es256Token, KeyResolverFunc(func(kid, alg string) (crypto.PublicKey, error) {
+			return certs.ResolveKey(testKeyID, alg)
+		}), ErrInvalidAlgorithm},
+		{validToken, KeyResolverFunc(func(kid, alg string) (crypto.PublicKey, error) {
+			return nil, errors.New("HSM unavailable")
+		}), ErrKeyNotFound},
+	}
+	for i, tt := range tests {
+		if _, err := VerifyTokenWithResolver(tt.token, []string{audience}, nil, tt.resolver); err != tt.err {
+			t.Errorf("[%d] VerifyTokenWithResolver() returns error %v; want %v", i, err, tt.err)
+		}
+	}
+
+	c := newMiddlewareClient()
+	c.config.KeyResolver = kms
+	if tok, err := c.ValidateToken(context.Background(), es256Token, []string{audience}); err != nil || tok.LocalID != "123" {
+		t.Errorf("ValidateToken() with Config.KeyResolver = %v, %v; want the token of user 123", tok, err)
+	}
+}
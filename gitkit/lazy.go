@@ -0,0 +1,107 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// An InitError is returned by the calls of a Client created by NewLazy whose
+// initialization, e.g., the loading of its credentials, failed.
+type InitError struct {
+	Err error
+}
+
+func (e *InitError) Error() string {
+	return "gitkit: client initialization failed: " + e.Err.Error()
+}
+
+// clientInit is the initialization of a Client created by NewLazy.
+type clientInit struct {
+	done chan struct{} // Closed once the Client is initialized.
+	err  error         // Initialization error, an *InitError.
+}
+
+// NewLazy is like New, but returns as soon as the configuration is checked,
+// for a fast process startup. The credentials are loaded and the API client
+// is created in the background, then the public certificates are downloaded
+// and the project configuration is fetched, which checks the credentials.
+//
+// The Client can be shared and used right away: the calls needing the
+// credentials wait for them, or fail with an InitError if they cannot be
+// loaded. WaitReady waits for the whole initialization, e.g., in a readiness
+// check, and returns its first error.
+//
+// The initialization carries the values of ctx, but not its cancellation or
+// deadline.
+func NewLazy(ctx context.Context, config *Config, opts ...Option) (*Client, error) {
+	c, err := newClient(config, opts)
+	if err != nil {
+		return nil, err
+	}
+	ctx = detachedContext{ctx}
+	c.init = &clientInit{done: make(chan struct{})}
+	c.ready = make(chan struct{})
+	go func() {
+		defer close(c.ready)
+		if err := c.initialize(ctx); err != nil {
+			c.init.err = &InitError{err}
+		}
+		close(c.init.done)
+		c.readyErr = c.init.err
+		if c.readyErr == nil {
+			c.readyErr = c.certs.Refresh(ctx)
+		}
+		if c.readyErr == nil {
+			_, c.readyErr = c.ProjectConfig(ctx, nil)
+		}
+		if c.readyErr != nil && c.config.Logf != nil {
+			c.config.Logf("gitkit: initializing client: %v", c.readyErr)
+		}
+	}()
+	return c, nil
+}
+
+// waitInit waits until the Client is initialized or ctx is done, and returns
+// the initialization error.
+func (c *Client) waitInit(ctx context.Context) error {
+	if c.init == nil {
+		return nil
+	}
+	select {
+	case <-c.init.done:
+		return c.init.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// failedAPIClient returns an APIClient whose calls fail with err.
+func failedAPIClient(err error) *APIClient {
+	return &APIClient{Client: http.Client{Transport: failedTransport{err}}}
+}
+
+// failedTransport fails the requests with its error.
+type failedTransport struct {
+	err error
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t failedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	closeBody(req)
+	return nil, t.err
+}
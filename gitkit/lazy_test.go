@@ -0,0 +1,77 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2/jwt"
+)
+
+func TestNewLazy(t *testing.T) {
+	ctx := context.Background()
+	rt := &gatedRoundTripper{release: make(chan struct{})}
+	c, err := NewLazy(ctx, &Config{}, WithTokenSource(staticTokenSource{}), WithHTTPClient(&http.Client{Transport: rt}))
+	if err != nil {
+		t.Fatalf("NewLazy() returns error: %v", err)
+	}
+	defer c.Close()
+	// The certificates are downloaded once the credentials are loaded.
+	waitCalls(t, rt, 1)
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := c.WaitReady(short); err != context.DeadlineExceeded {
+		t.Errorf("WaitReady() during the initialization returns %v; want %v", err, context.DeadlineExceeded)
+	}
+	close(rt.release)
+	if err := c.WaitReady(ctx); err != nil {
+		t.Fatalf("WaitReady() returns error: %v", err)
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.calls != 2 {
+		t.Errorf("initialization sends %d requests; want the certificates and the project config", rt.calls)
+	}
+}
+
+func TestNewLazy_failure(t *testing.T) {
+	ctx := context.Background()
+	_, err := NewLazy(ctx, &Config{JWTConfig: &jwt.Config{}, TokenSource: staticTokenSource{}})
+	if err == nil {
+		t.Errorf("NewLazy() with both JWT config and token source returns no error")
+	}
+	c, err := NewLazy(ctx, &Config{GoogleAppCredentialsPath: "testdata/missing.json"})
+	if err != nil {
+		t.Fatalf("NewLazy() returns error: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.UserByLocalID(ctx, "123"); !isInitError(err) {
+		t.Errorf("UserByLocalID() returns error %v; want InitError", err)
+	}
+	if _, err := c.CustomToken(ctx, "123", nil); !isInitError(err) {
+		t.Errorf("CustomToken() returns error %v; want InitError", err)
+	}
+	if err := c.WaitReady(ctx); !isInitError(err) {
+		t.Errorf("WaitReady() returns error %v; want InitError", err)
+	}
+}
+
+func isInitError(err error) bool {
+	_, ok := err.(*InitError)
+	return ok
+}
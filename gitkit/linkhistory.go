@@ -0,0 +1,170 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// LinkHistoryAttribute is the custom attribute which holds the provider link
+// history of a user recorded by RecordProviderLinks.
+const LinkHistoryAttribute = "gitkitLinkHistory"
+
+// MaxLinkEvents is the number of link events kept in the history of a user.
+// The oldest events are dropped.
+const MaxLinkEvents = 20
+
+// Operations of the link events.
+const (
+	LinkOpLink   = "link"
+	LinkOpUnlink = "unlink"
+)
+
+// A LinkEvent records that a provider was linked to or unlinked from an
+// account.
+type LinkEvent struct {
+	// Op is LinkOpLink or LinkOpUnlink.
+	Op          string    `json:"op"`
+	ProviderID  string    `json:"providerId"`
+	FederatedID string    `json:"federatedId,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+// linkHistory is the value of LinkHistoryAttribute: the providers last seen
+// linked and the events which led there.
+type linkHistory struct {
+	Providers []string     `json:"providers"`
+	Events    []*LinkEvent `json:"events"`
+}
+
+// providerKey identifies a linked provider account.
+func providerKey(providerID, federatedID string) string {
+	return providerID + " " + federatedID
+}
+
+// LinkHistory returns the provider link events of the user recorded by
+// RecordProviderLinks, the oldest first.
+func (u *User) LinkHistory() ([]*LinkEvent, error) {
+	h, err := u.linkHistory()
+	if err != nil {
+		return nil, err
+	}
+	return h.Events, nil
+}
+
+func (u *User) linkHistory() (*linkHistory, error) {
+	h := &linkHistory{}
+	if u.CustomAttributes == "" {
+		return h, nil
+	}
+	var attrs map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(u.CustomAttributes), &attrs); err != nil {
+		return nil, err
+	}
+	if raw, ok := attrs[LinkHistoryAttribute]; ok {
+		if err := json.Unmarshal(raw, h); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// linkChanges returns the link events turning the providers last seen linked
+// into the ones of the user, at t.
+func (u *User) linkChanges(h *linkHistory, t time.Time) ([]*LinkEvent, []string) {
+	seen := make(map[string]bool)
+	for _, k := range h.Providers {
+		seen[k] = true
+	}
+	var events []*LinkEvent
+	linked := make(map[string]bool)
+	var providers []string
+	for _, p := range u.ProviderUserInfo {
+		k := providerKey(p.ProviderID, p.FederatedID)
+		if linked[k] {
+			continue
+		}
+		linked[k] = true
+		providers = append(providers, k)
+		if !seen[k] {
+			events = append(events, &LinkEvent{LinkOpLink, p.ProviderID, p.FederatedID, t})
+		}
+	}
+	for _, k := range h.Providers {
+		if !linked[k] {
+			id := strings.SplitN(k, " ", 2)
+			events = append(events, &LinkEvent{LinkOpUnlink, id[0], id[len(id)-1], t})
+		}
+	}
+	sort.Strings(providers)
+	return events, providers
+}
+
+// RecordProviderLinks compares the providers linked to the user with the ones
+// last recorded in its LinkHistoryAttribute, and records the links and unlinks
+// since then, timestamped now, so that support can later reconstruct how the
+// account ended up with its providers. The providers found by the first record
+// are reported as linked at that time. The new events are returned, and u is
+// updated.
+//
+// If Config.RecordLinkHistory is set, the users retrieved by UserByToken and
+// the token middlewares are recorded as they sign in.
+func (c *Client) RecordProviderLinks(ctx context.Context, u *User) ([]*LinkEvent, error) {
+	h, err := u.linkHistory()
+	if err != nil {
+		return nil, err
+	}
+	events, providers := u.linkChanges(h, time.Now())
+	if len(events) == 0 {
+		return nil, nil
+	}
+	h.Providers = providers
+	h.Events = append(h.Events, events...)
+	if n := len(h.Events); n > MaxLinkEvents {
+		h.Events = h.Events[n-MaxLinkEvents:]
+	}
+	// The other attributes are kept as they are, so that, e.g., large numbers
+	// do not lose precision.
+	attrs := make(map[string]json.RawMessage)
+	if u.CustomAttributes != "" {
+		if err := json.Unmarshal([]byte(u.CustomAttributes), &attrs); err != nil {
+			return nil, err
+		}
+	}
+	raw, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	attrs[LinkHistoryAttribute] = raw
+	b, err := json.Marshal(attrs)
+	if err != nil {
+		return nil, err
+	}
+	_, err = c.mutatingAPIClient(ctx).SetAccountInfo(ctx, &SetAccountInfoRequest{
+		LocalID:          u.LocalID,
+		CustomAttributes: string(b),
+	})
+	c.audit(ctx, AuditOpRecordLinks, []string{u.LocalID}, err)
+	if err != nil {
+		return nil, err
+	}
+	u.CustomAttributes = string(b)
+	return events, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestRecordProviderLinks(t *testing.T) {
+	rt := &methodRoundTripper{resps: map[string]string{"setAccountInfo": `{}`}}
+	c := &Client{config: &Config{}, api: &APIClient{Client: http.Client{Transport: rt}}}
+	ctx := context.Background()
+	u := &User{
+		LocalID:          "123",
+		CustomAttributes: `{"role":"admin","id":9007199254740993}`,
+		ProviderUserInfo: []ProviderUserInfo{{ProviderID: "google.com", FederatedID: "g1"}, {ProviderID: "password"}},
+	}
+	events, err := c.RecordProviderLinks(ctx, u)
+	if err != nil {
+		t.Fatalf("RecordProviderLinks() returns error: %v", err)
+	}
+	if len(events) != 2 || events[0].Op != LinkOpLink || events[0].ProviderID != "google.com" || events[0].FederatedID != "g1" {
+		t.Fatalf("first RecordProviderLinks() = %v; want the links of both providers", events)
+	}
+	var attrs map[string]json.RawMessage
+	json.Unmarshal([]byte(u.CustomAttributes), &attrs)
+	if string(attrs["role"]) != `"admin"` || string(attrs["id"]) != "9007199254740993" || attrs[LinkHistoryAttribute] == nil {
+		t.Errorf("custom attributes = %s; want role, id and the link history", u.CustomAttributes)
+	}
+
+	// Nothing changed.
+	if events, err := c.RecordProviderLinks(ctx, u); err != nil || events != nil || len(rt.reqs) != 1 {
+		t.Errorf("RecordProviderLinks() without changes = %v, %v after %d requests; want no events nor request", events, err, len(rt.reqs))
+	}
+
+	// Google is unlinked, Facebook linked.
+	u.ProviderUserInfo = []ProviderUserInfo{{ProviderID: "password"}, {ProviderID: "facebook.com", FederatedID: "f1"}}
+	events, err = c.RecordProviderLinks(ctx, u)
+	if err != nil {
+		t.Fatalf("RecordProviderLinks() returns error: %v", err)
+	}
+	if len(events) != 2 || events[0].Op != LinkOpLink || events[0].ProviderID != "facebook.com" ||
+		events[1].Op != LinkOpUnlink || events[1].ProviderID != "google.com" || events[1].FederatedID != "g1" {
+		t.Errorf("RecordProviderLinks() = %+v; want the link of facebook.com and the unlink of google.com", events)
+	}
+	history, err := u.LinkHistory()
+	if err != nil || len(history) != 4 {
+		t.Errorf("LinkHistory() = %v, %v; want 4 events", history, err)
+	}
+}
+
+func TestUser_LinkHistory_max(t *testing.T) {
+	rt := &methodRoundTripper{resps: map[string]string{"setAccountInfo": `{}`}}
+	c := &Client{config: &Config{}, api: &APIClient{Client: http.Client{Transport: rt}}}
+	u := &User{LocalID: "123"}
+	for i := 0; i < MaxLinkEvents; i++ {
+		u.ProviderUserInfo = []ProviderUserInfo{{ProviderID: "google.com"}}
+		c.RecordProviderLinks(context.Background(), u)
+		u.ProviderUserInfo = nil
+		c.RecordProviderLinks(context.Background(), u)
+	}
+	history, err := u.LinkHistory()
+	if err != nil || len(history) != MaxLinkEvents {
+		t.Fatalf("LinkHistory() has %d events, error %v; want %d", len(history), err, MaxLinkEvents)
+	}
+	if history[len(history)-1].Op != LinkOpUnlink {
+		t.Errorf("last event = %+v; want the last unlink", history[len(history)-1])
+	}
+}
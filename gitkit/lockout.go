@@ -0,0 +1,263 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Defaults of LockoutPolicy.
+const (
+	DefaultLockoutThreshold = 5
+	DefaultLockoutDuration  = time.Minute
+	DefaultMaxLockout       = time.Hour
+)
+
+// An AttemptStore records the failed sign in attempts of the accounts, keyed
+// by, e.g., the email address. Implementations backed by a shared database or
+// cache let the servers of a deployment enforce the lockout together.
+type AttemptStore interface {
+	// Failures returns the number of consecutive failed attempts recorded
+	// for the key and the time of the last one.
+	Failures(ctx context.Context, key string) (n int, last time.Time, err error)
+	// RecordFailure records a failed attempt at the given time, and returns
+	// the number of failures and the time of the last one before it. It must
+	// be atomic, so that concurrent attempts see each other's failures.
+	RecordFailure(ctx context.Context, key string, at time.Time) (n int, last time.Time, err error)
+	// ForgetFailure takes back the failure recorded at the given time, for
+	// which RecordFailure returned last.
+	ForgetFailure(ctx context.Context, key string, at, last time.Time) error
+	// Reset forgets the failed attempts of the key.
+	Reset(ctx context.Context, key string) error
+}
+
+// MemoryAttemptStore is an AttemptStore keeping the attempts in memory, for
+// single server deployments. The zero value is ready to use. It is safe to use
+// from multiple concurrent goroutines.
+//
+// The attempts of a key are forgotten MaxAge after its last failure, when the
+// lockout of the policy is over, so that the memory does not grow with every
+// key ever tried. RecordFailure drops them, sweeping the store at most once
+// every MaxAge.
+type MemoryAttemptStore struct {
+	// MaxAge is the time the attempts are kept after the last failure. It
+	// should not be less than the MaxDuration of the LockoutPolicy.
+	// DefaultMaxLockout is used if it is not set.
+	MaxAge time.Duration
+
+	mu       sync.Mutex
+	attempts map[string]*attempts
+	swept    time.Time // Time of the last sweep.
+}
+
+type attempts struct {
+	n    int
+	last time.Time
+}
+
+// Failures implements the AttemptStore interface.
+func (s *MemoryAttemptStore) Failures(ctx context.Context, key string) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a := s.attempts[key]; a != nil && !s.expired(a, time.Now()) {
+		return a.n, a.last, nil
+	}
+	return 0, time.Time{}, nil
+}
+
+// maxAge returns the time the attempts are kept.
+func (s *MemoryAttemptStore) maxAge() time.Duration {
+	if s.MaxAge > 0 {
+		return s.MaxAge
+	}
+	return DefaultMaxLockout
+}
+
+// expired reports whether the attempts are forgotten at now.
+func (s *MemoryAttemptStore) expired(a *attempts, now time.Time) bool {
+	return now.Sub(a.last) > s.maxAge()
+}
+
+// RecordFailure implements the AttemptStore interface.
+func (s *MemoryAttemptStore) RecordFailure(ctx context.Context, key string, at time.Time) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attempts == nil {
+		s.attempts = make(map[string]*attempts)
+	}
+	if at.Sub(s.swept) >= s.maxAge() {
+		for k, a := range s.attempts {
+			if s.expired(a, at) {
+				delete(s.attempts, k)
+			}
+		}
+		s.swept = at
+	}
+	a := s.attempts[key]
+	if a == nil || s.expired(a, at) {
+		a = &attempts{}
+		s.attempts[key] = a
+	}
+	n, last := a.n, a.last
+	a.n++
+	a.last = at
+	return n, last, nil
+}
+
+// ForgetFailure implements the AttemptStore interface.
+func (s *MemoryAttemptStore) ForgetFailure(ctx context.Context, key string, at, last time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.attempts[key]
+	if a == nil {
+		return nil
+	}
+	if a.n--; a.n <= 0 {
+		delete(s.attempts, key)
+	} else if a.last.Equal(at) {
+		a.last = last
+	}
+	return nil
+}
+
+// Reset implements the AttemptStore interface.
+func (s *MemoryAttemptStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+// LockedOutError is returned when an account is locked out after too many
+// failed sign in attempts.
+type LockedOutError struct {
+	Key string
+	// RetryAfter is the time left before the next attempt is allowed.
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface.
+func (e *LockedOutError) Error() string {
+	return fmt.Sprintf("gitkit: too many failed attempts for %s; retry in %v", e.Key, e.RetryAfter)
+}
+
+// A LockoutPolicy refuses the sign in attempts of an account after Threshold
+// consecutive failures, for a lockout period doubling with each further
+// failure, so that password endpoints resist brute force.
+//
+// For example, in a handler checking the passwords,
+//
+//	ok, err := policy.Attempt(ctx, email, func() (bool, error) {
+//		return checkPassword(ctx, email, password)
+//	})
+//	if _, locked := err.(*gitkit.LockedOutError); locked {
+//		...
+//	}
+type LockoutPolicy struct {
+	// Store records the failed attempts. It is required.
+	Store AttemptStore
+	// Threshold is the number of consecutive failures which lock the
+	// account. DefaultLockoutThreshold is used if it is not set.
+	Threshold int
+	// Duration is the first lockout period. DefaultLockoutDuration is used if
+	// it is not set.
+	Duration time.Duration
+	// MaxDuration bounds the lockout period. DefaultMaxLockout is used if it
+	// is not set.
+	MaxDuration time.Duration
+}
+
+// lockout returns the lockout period after n consecutive failures.
+func (p *LockoutPolicy) lockout(n int) time.Duration {
+	threshold, d, max := p.Threshold, p.Duration, p.MaxDuration
+	if threshold <= 0 {
+		threshold = DefaultLockoutThreshold
+	}
+	if d <= 0 {
+		d = DefaultLockoutDuration
+	}
+	if max <= 0 {
+		max = DefaultMaxLockout
+	}
+	if n < threshold {
+		return 0
+	}
+	for i := threshold; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// check returns a *LockedOutError if n failures, the last one at last, lock
+// out the account identified by key at now.
+func (p *LockoutPolicy) check(key string, n int, last, now time.Time) error {
+	if d := p.lockout(n); d > 0 {
+		if left := last.Add(d).Sub(now); left > 0 {
+			return &LockedOutError{key, left}
+		}
+	}
+	return nil
+}
+
+// Check returns a *LockedOutError if the account identified by key is locked
+// out.
+func (p *LockoutPolicy) Check(ctx context.Context, key string) error {
+	n, last, err := p.Store.Failures(ctx, key)
+	if err != nil {
+		return err
+	}
+	return p.check(key, n, last, time.Now())
+}
+
+// Attempt calls try unless the account identified by key is locked out, and
+// records its outcome: try reports whether the credentials are valid, and
+// returns an error only if they could not be checked, which is not counted
+// as a failed attempt.
+//
+// The attempt is recorded as a failure before try is called, so that
+// concurrent attempts cannot get past the Threshold. The failures are reset
+// if it succeeds, and it is taken back if it is refused or try returns an
+// error.
+func (p *LockoutPolicy) Attempt(ctx context.Context, key string, try func() (bool, error)) (bool, error) {
+	now := time.Now()
+	n, last, err := p.Store.RecordFailure(ctx, key, now)
+	if err != nil {
+		return false, err
+	}
+	if err := p.check(key, n, last, now); err != nil {
+		if ferr := p.Store.ForgetFailure(ctx, key, now, last); ferr != nil {
+			return false, ferr
+		}
+		return false, err
+	}
+	ok, err := try()
+	if err != nil {
+		if ferr := p.Store.ForgetFailure(ctx, key, now, last); ferr != nil {
+			return false, ferr
+		}
+		return false, err
+	}
+	if ok {
+		return true, p.Store.Reset(ctx, key)
+	}
+	return false, nil
+}
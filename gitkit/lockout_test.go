@@ -0,0 +1,142 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestLockoutPolicy_lockout(t *testing.T) {
+	p := &LockoutPolicy{Threshold: 3, Duration: time.Minute, MaxDuration: 5 * time.Minute}
+	tests := []struct {
+		n    int
+		want time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if d := p.lockout(tt.n); d != tt.want {
+			t.Errorf("lockout(%d) = %v; want %v", tt.n, d, tt.want)
+		}
+	}
+	if d := (&LockoutPolicy{}).lockout(DefaultLockoutThreshold); d != DefaultLockoutDuration {
+		t.Errorf("default lockout = %v; want %v", d, DefaultLockoutDuration)
+	}
+}
+
+func TestLockoutPolicy_attempt(t *testing.T) {
+	ctx := context.Background()
+	store := &MemoryAttemptStore{}
+	p := &LockoutPolicy{Store: store, Threshold: 2}
+	wrong := func() (bool, error) { return false, nil }
+	right := func() (bool, error) { return true, nil }
+	unavailable := func() (bool, error) { return false, errors.New("backend error") }
+
+	for i := 0; i < 2; i++ {
+		if ok, err := p.Attempt(ctx, "user@example.com", wrong); ok || err != nil {
+			t.Fatalf("%d. Attempt() = %v, %v; want false, nil", i, ok, err)
+		}
+	}
+	// Errors are not failed attempts.
+	if _, err := p.Attempt(ctx, "other@example.com", unavailable); err == nil {
+		t.Error("Attempt() returns no error; want backend error")
+	}
+	if n, _, _ := store.Failures(ctx, "other@example.com"); n != 0 {
+		t.Errorf("Attempt() with error records %d failures; want 0", n)
+	}
+
+	_, err := p.Attempt(ctx, "user@example.com", right)
+	if e, ok := err.(*LockedOutError); !ok || e.RetryAfter <= 0 || e.RetryAfter > DefaultLockoutDuration {
+		t.Fatalf("Attempt() returns error %v; want *LockedOutError", err)
+	}
+	// Refused attempts are not counted.
+	if n, _, _ := store.Failures(ctx, "user@example.com"); n != 2 {
+		t.Errorf("Attempt() refused records %d failures; want 2", n)
+	}
+
+	// The lockout ends after its period.
+	store.attempts["user@example.com"].last = time.Now().Add(-DefaultLockoutDuration)
+	if ok, err := p.Attempt(ctx, "user@example.com", right); !ok || err != nil {
+		t.Fatalf("Attempt() after the lockout = %v, %v; want true, nil", ok, err)
+	}
+	if n, _, _ := store.Failures(ctx, "user@example.com"); n != 0 {
+		t.Errorf("Attempt() succeeding keeps %d failures; want 0", n)
+	}
+}
+
+func TestLockoutPolicy_concurrentAttempts(t *testing.T) {
+	ctx := context.Background()
+	p := &LockoutPolicy{Store: &MemoryAttemptStore{}, Threshold: 3}
+	var (
+		mu    sync.Mutex
+		tries int
+		start = make(chan struct{})
+		wg    sync.WaitGroup
+	)
+	wrong := func() (bool, error) {
+		mu.Lock()
+		tries++
+		mu.Unlock()
+		return false, nil
+	}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			p.Attempt(ctx, "user@example.com", wrong)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if tries != p.Threshold {
+		t.Errorf("%d concurrent attempts tried; want %d", tries, p.Threshold)
+	}
+}
+
+func TestMemoryAttemptStore_eviction(t *testing.T) {
+	ctx := context.Background()
+	store := &MemoryAttemptStore{MaxAge: time.Hour}
+	start := time.Now().Add(-3 * time.Hour)
+	for i := 0; i < 100; i++ {
+		store.RecordFailure(ctx, fmt.Sprintf("user%d@example.com", i), start)
+	}
+	store.RecordFailure(ctx, "user0@example.com", start.Add(time.Minute))
+	if n, _, _ := store.Failures(ctx, "user0@example.com"); n != 0 {
+		t.Errorf("Failures() after MaxAge = %d; want 0", n)
+	}
+
+	// The next failure sweeps the expired attempts.
+	now := time.Now()
+	store.RecordFailure(ctx, "user0@example.com", now)
+	if len(store.attempts) != 1 {
+		t.Errorf("%d keys kept after the sweep; want 1", len(store.attempts))
+	}
+	if n, last, _ := store.Failures(ctx, "user0@example.com"); n != 1 || !last.Equal(now) {
+		t.Errorf("Failures() = %d, %v; want 1, %v", n, last, now)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// maxLookupBatch is the number of email addresses or local IDs looked up per
+// getAccountInfo call.
+const maxLookupBatch = 100
+
+// UsersByEmails retrieves the account information of the users with the email
+// addresses, in as few calls as possible. The returned users are at the same
+// index as their email address, or nil if there is no account with the
+// address. The addresses without account are also returned as notFound.
+func (c *Client) UsersByEmails(ctx context.Context, emails []string) (users []*User, notFound []string, err error) {
+	return c.usersBy(ctx, emails, true)
+}
+
+// UsersByLocalIDs retrieves the account information of the users with the
+// local IDs, in as few calls as possible. The returned users are at the same
+// index as their local ID, or nil if there is no such user. The local IDs
+// without user are also returned as notFound.
+func (c *Client) UsersByLocalIDs(ctx context.Context, localIDs []string) (users []*User, notFound []string, err error) {
+	return c.usersBy(ctx, localIDs, false)
+}
+
+func (c *Client) usersBy(ctx context.Context, keys []string, byEmail bool) ([]*User, []string, error) {
+	key := func(u *User) string {
+		if byEmail {
+			return strings.ToLower(u.Email)
+		}
+		return u.LocalID
+	}
+	found := make(map[string]*User)
+	for start := 0; start < len(keys); start += maxLookupBatch {
+		end := start + maxLookupBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		req := &GetAccountInfoRequest{}
+		if byEmail {
+			req.Emails = keys[start:end]
+		} else {
+			req.LocalIDs = keys[start:end]
+		}
+		resp, err := c.callAPIClient(ctx).GetAccountInfo(ctx, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, u := range resp.Users {
+			found[key(u)] = u
+		}
+	}
+	users := make([]*User, len(keys))
+	var notFound []string
+	for i, k := range keys {
+		if byEmail {
+			k = strings.ToLower(k)
+		}
+		if u, ok := found[k]; ok {
+			users[i] = u
+		} else {
+			notFound = append(notFound, keys[i])
+		}
+	}
+	return users, notFound, nil
+}
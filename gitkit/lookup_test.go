@@ -0,0 +1,97 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// lookupRoundTripper answers getAccountInfo with the users whose local ID or
+// lower case email address is in known.
+type lookupRoundTripper struct {
+	known map[string]bool
+	calls int
+}
+
+func (r *lookupRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.calls++
+	var in GetAccountInfoRequest
+	b, _ := ioutil.ReadAll(req.Body)
+	json.Unmarshal(b, &in)
+	var out GetAccountInfoResponse
+	for _, e := range in.Emails {
+		if r.known[strings.ToLower(e)] {
+			out.Users = append(out.Users, &User{LocalID: "id-" + strings.ToLower(e), Email: strings.ToLower(e)})
+		}
+	}
+	for _, id := range in.LocalIDs {
+		if r.known[id] {
+			out.Users = append(out.Users, &User{LocalID: id})
+		}
+	}
+	b, _ = json.Marshal(out)
+	return roundTripper{http.StatusOK, string(b)}.RoundTrip(req)
+}
+
+func TestUsersByEmails(t *testing.T) {
+	rt := &lookupRoundTripper{known: map[string]bool{"a@example.com": true, "c@example.com": true}}
+	c := &Client{api: &APIClient{Client: http.Client{Transport: rt}}}
+	users, notFound, err := c.UsersByEmails(context.Background(), []string{"A@example.com", "b@example.com", "c@example.com"})
+	if err != nil {
+		t.Fatalf("UsersByEmails() returns error: %v", err)
+	}
+	if len(users) != 3 || users[0] == nil || users[0].LocalID != "id-a@example.com" || users[1] != nil || users[2] == nil {
+		t.Errorf("UsersByEmails() = %v; want a, nil, c", users)
+	}
+	if want := []string{"b@example.com"}; !reflect.DeepEqual(notFound, want) {
+		t.Errorf("UsersByEmails() reports %v not found; want %v", notFound, want)
+	}
+}
+
+func TestUsersByLocalIDs(t *testing.T) {
+	rt := &lookupRoundTripper{known: make(map[string]bool)}
+	var ids []string
+	for i := 0; i < 2*maxLookupBatch+1; i++ {
+		id := fmt.Sprint(i)
+		ids = append(ids, id)
+		if i%2 == 0 {
+			rt.known[id] = true
+		}
+	}
+	c := &Client{api: &APIClient{Client: http.Client{Transport: rt}}}
+	users, notFound, err := c.UsersByLocalIDs(context.Background(), ids)
+	if err != nil {
+		t.Fatalf("UsersByLocalIDs() returns error: %v", err)
+	}
+	if rt.calls != 3 {
+		t.Errorf("UsersByLocalIDs() makes %d calls; want 3", rt.calls)
+	}
+	for i, u := range users {
+		if (u != nil) != (i%2 == 0) || (u != nil && u.LocalID != ids[i]) {
+			t.Errorf("UsersByLocalIDs() returns user %v at %d", u, i)
+		}
+	}
+	if len(notFound) != maxLookupBatch || notFound[0] != "1" {
+		t.Errorf("UsersByLocalIDs() reports %d not found starting with %q; want %d starting with 1", len(notFound), notFound[0], maxLookupBatch)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"io"
+	"net/http"
+)
+
+// Metrics receives the counters of the Client, e.g., to monitor long running
+// exports and imports. It is implemented by *expvar.Map, so that
+//
+//	c, err := gitkit.New(ctx, &gitkit.Config{Metrics: expvar.NewMap("gitkit"), ...})
+//
+// publishes the counters at /debug/vars. Add must be safe to call from
+// multiple concurrent goroutines.
+type Metrics interface {
+	// Add adds delta to the named counter.
+	Add(name string, delta int64)
+}
+
+// MetricsFunc is an adapter to use a function as Metrics.
+type MetricsFunc func(name string, delta int64)
+
+// Add implements the Metrics interface.
+func (f MetricsFunc) Add(name string, delta int64) {
+	f(name, delta)
+}
+
+// Names of the counters reported to Config.Metrics.
+const (
+	// MetricAPIRequests counts the identitytoolkit API requests sent,
+	// including the retries.
+	MetricAPIRequests = "api_requests"
+	// MetricAPIRetries counts the identitytoolkit API requests retried.
+	MetricAPIRetries = "api_retries"
+	// MetricBytesSent counts the bytes of the request bodies sent.
+	MetricBytesSent = "bytes_sent"
+	// MetricBytesReceived counts the bytes of the response bodies read.
+	MetricBytesReceived = "bytes_received"
+	// MetricPagesFetched counts the pages of users downloaded by ListUsersN,
+	// ListUsers and the Pagers.
+	MetricPagesFetched = "pages_fetched"
+	// MetricUsersListed counts the users downloaded.
+	MetricUsersListed = "users_listed"
+	// MetricUsersUploaded counts the users uploaded successfully.
+	MetricUsersUploaded = "users_uploaded"
+	// MetricUploadFailures counts the users which failed to upload.
+	MetricUploadFailures = "upload_failures"
+	// MetricUsersUpdated counts the users updated successfully by
+	// UpdateUsers.
+	MetricUsersUpdated = "users_updated"
+	// MetricUserFromTokenFallbacks counts the users UserByToken built from
+	// the token claims because the account information couldn't be
+	// retrieved.
+	MetricUserFromTokenFallbacks = "user_from_token_fallbacks"
+	// MetricLookupHedges counts the second account lookup requests sent
+	// because of Config.LookupHedgeDelay.
+	MetricLookupHedges = "lookup_hedges"
+	// MetricLookupsCoalesced counts the UserByLocalID lookups which shared
+	// the getAccountInfo call of another one because of
+	// Config.LookupBatchWindow.
+	MetricLookupsCoalesced = "lookups_coalesced"
+	// MetricTokenCacheHits counts the ID tokens ValidateToken found in the
+	// cache of Config.TokenCacheSize instead of verifying them.
+	MetricTokenCacheHits = "token_cache_hits"
+)
+
+// count adds delta to the named counter of Config.Metrics, if set.
+func (c *Client) count(name string, delta int64) {
+	if c.config != nil && c.config.Metrics != nil {
+		c.config.Metrics.Add(name, delta)
+	}
+}
+
+// metricsTransport counts the requests and the bytes they transfer.
+type metricsTransport struct {
+	http.RoundTripper
+	metrics Metrics
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.metrics.Add(MetricAPIRequests, 1)
+	if req.ContentLength > 0 {
+		t.metrics.Add(MetricBytesSent, req.ContentLength)
+	}
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err == nil && resp.Body != nil {
+		resp.Body = &meteredBody{resp.Body, t.metrics}
+	}
+	return resp, err
+}
+
+// meteredBody counts the bytes read from a response body.
+type meteredBody struct {
+	io.ReadCloser
+	metrics Metrics
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.metrics.Add(MetricBytesReceived, int64(n))
+	}
+	return n, err
+}
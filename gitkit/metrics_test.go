@@ -0,0 +1,104 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"expvar"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var _ Metrics = (*expvar.Map)(nil)
+
+// counters is a Metrics recording the counters in a map.
+type counters struct {
+	mu sync.Mutex
+	m  map[string]int64
+}
+
+func (c *counters) Add(name string, delta int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = make(map[string]int64)
+	}
+	c.m[name] += delta
+}
+
+func newMetricsClient(metrics Metrics, rt http.RoundTripper) *Client {
+	return &Client{
+		config: &Config{Metrics: metrics},
+		api:    &APIClient{Client: http.Client{Transport: &metricsTransport{rt, metrics}}},
+	}
+}
+
+func TestMetrics_listUsers(t *testing.T) {
+	m := &counters{}
+	body := `{"users": [{"localId": "1"}, {"localId": "2"}], "nextPageToken": "next"}`
+	c := newMetricsClient(m, &roundTripper{http.StatusOK, body})
+	if _, _, err := c.ListUsersN(context.Background(), 2, ""); err != nil {
+		t.Fatalf("ListUsersN() returns error: %v", err)
+	}
+	if m.m[MetricBytesSent] <= 0 {
+		t.Errorf("ListUsersN() reports %d bytes sent; want > 0", m.m[MetricBytesSent])
+	}
+	delete(m.m, MetricBytesSent)
+	want := map[string]int64{
+		MetricAPIRequests:   1,
+		MetricBytesReceived: int64(len(body)),
+		MetricPagesFetched:  1,
+		MetricUsersListed:   2,
+	}
+	if !reflect.DeepEqual(m.m, want) {
+		t.Errorf("ListUsersN() reports %v; want %v", m.m, want)
+	}
+}
+
+func TestMetrics_uploadUsers(t *testing.T) {
+	m := &counters{}
+	c := newMetricsClient(m, &roundTripper{http.StatusOK, `{"error": [{"index": 1, "message": "DUPLICATE_EMAIL"}]}`})
+	users := []*User{{LocalID: "1"}, {LocalID: "2"}, {LocalID: "3"}}
+	if err := c.UploadUsers(context.Background(), users, "HMAC_SHA256", []byte("key"), nil); err == nil {
+		t.Fatal("UploadUsers() returns no error; want UploadError")
+	}
+	if m.m[MetricUsersUploaded] != 2 || m.m[MetricUploadFailures] != 1 {
+		t.Errorf("UploadUsers() reports %d users uploaded, %d failed; want 2, 1", m.m[MetricUsersUploaded], m.m[MetricUploadFailures])
+	}
+}
+
+func TestMetrics_retries(t *testing.T) {
+	timeAfter = func(d time.Duration) <-chan time.Time {
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+	defer func() { timeAfter = time.After }()
+
+	m := &counters{}
+	rt := &sequenceRoundTripper{resps: []*http.Response{response(503, ""), response(503, ""), response(200, "")}}
+	tr := &retryTransport{RoundTripper: &metricsTransport{rt, m}, maxRetries: 3, maxWait: time.Second, metrics: m}
+	req, _ := http.NewRequest("GET", "http://localhost", nil)
+	if _, err := tr.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip() returns error: %v", err)
+	}
+	if m.m[MetricAPIRetries] != 2 || m.m[MetricAPIRequests] != 3 {
+		t.Errorf("RoundTrip() reports %d retries, %d requests; want 2, 3", m.m[MetricAPIRetries], m.m[MetricAPIRequests])
+	}
+}
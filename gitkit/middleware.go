@@ -0,0 +1,276 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/context"
+)
+
+// Parameters of the sign in URLs built by SignInURL.
+const (
+	// ReturnURLParam is the parameter holding the URL the widget returns to
+	// after the user signs in.
+	ReturnURLParam = "signInSuccessUrl"
+	// ReturnURLSignatureParam is the parameter holding the signature of the
+	// return URL.
+	ReturnURLSignatureParam = "signInSuccessUrlSig"
+	// SelectMode is the widget mode which lets the user select how to sign in.
+	SelectMode = "select"
+)
+
+// A TokenHandlerFunc serves a request carrying a valid ID token.
+type TokenHandlerFunc func(w http.ResponseWriter, r *http.Request, t *Token)
+
+// ErrMissingToken is passed to TokenRequirement.OnFailure for the requests
+// without ID token.
+var ErrMissingToken = errors.New("missing ID token")
+
+type tokenKey struct{}
+
+type userKey struct{}
+
+// WithToken returns a copy of ctx carrying the validated token, as set by
+// TokenRequirement for the requests it serves.
+func WithToken(ctx context.Context, t *Token) context.Context {
+	return context.WithValue(ctx, tokenKey{}, t)
+}
+
+// TokenFromContext returns the validated token carried by ctx, if any.
+func TokenFromContext(ctx context.Context) (*Token, bool) {
+	t, ok := ctx.Value(tokenKey{}).(*Token)
+	return t, ok
+}
+
+// WithUser returns a copy of ctx carrying the user, as set by TokenRequirement
+// with LoadUser for the requests it serves.
+func WithUser(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, userKey{}, u)
+}
+
+// UserFromContext returns the user carried by ctx, if any.
+func UserFromContext(ctx context.Context) (*User, bool) {
+	u, ok := ctx.Value(userKey{}).(*User)
+	return u, ok
+}
+
+// A TokenRequirement is an http.Handler which only serves the requests
+// carrying a valid ID token. It is created by RequireToken.
+type TokenRequirement struct {
+	client    *Client
+	audiences []string
+	handler   TokenHandlerFunc
+
+	// Context returns the context used to validate the token of the request.
+	// If nil, the context of the request is used. On App Engine, it should be
+	// appengine.NewContext.
+	Context func(*http.Request) context.Context
+	// Browser, if true, redirects the GET requests without a valid token to
+	// the sign in widget, with the requested URL as the signed return URL.
+	// Config.WidgetURL and Config.ReturnURLKey must be set. Otherwise, such
+	// requests are answered with 401 Unauthorized.
+	Browser bool
+	// Claims are the requirements the valid tokens must meet in addition to
+	// Config.RequiredClaims. The requests whose tokens don't meet them are
+	// answered with 403 Forbidden.
+	Claims []ClaimRequirement
+	// Roles, if not empty, requires the valid tokens to have one of the
+	// roles mapped by Config.RoleMapper. The requests whose tokens don't are
+	// answered with 403 Forbidden.
+	Roles []string
+	// LoadUser, if true, retrieves the account information of the user of
+	// the valid tokens, like UserByToken, and adds it to the request context.
+	// See UserFromContext.
+	LoadUser bool
+	// OnFailure, if set, serves the requests which are not served by the
+	// handler, instead of the 401, 403 or 500 responses and the redirections
+	// to the sign in widget. err is ErrMissingToken, the error of the token
+	// validation, a *ClaimError, a *RoleError or the error retrieving the
+	// user.
+	OnFailure func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// RequireToken returns an http.Handler which calls h with the validated ID
+// token of the requests, and rejects the requests without a valid token. The
+// token must be issued for one of the audiences, or, if audiences is nil, for
+// one of those Config.AudiencesByHost maps the request host to, or else one of
+// Config.Audiences. The token, and the user if LoadUser is set, are also
+// added to the request context.
+//
+// For example, to protect HTML pages,
+//
+//	r := c.RequireToken(audiences, func(w http.ResponseWriter, r *http.Request, t *gitkit.Token) {
+//		fmt.Fprintf(w, "Hello, %s", t.Email)
+//	})
+//	r.Browser = true
+//	http.Handle("/account", r)
+func (c *Client) RequireToken(audiences []string, h TokenHandlerFunc) *TokenRequirement {
+	return &TokenRequirement{client: c, audiences: audiences, handler: h}
+}
+
+// RequireTokenHandler is like RequireToken for a plain http.Handler, which
+// gets the token and the user from the request context.
+//
+// For example,
+//
+//	r := c.RequireTokenHandler(audiences, mux)
+//	r.LoadUser = true
+//	http.Handle("/", r)
+//
+// and in the handlers of mux,
+//
+//	u, _ := gitkit.UserFromContext(r.Context())
+func (c *Client) RequireTokenHandler(audiences []string, next http.Handler) *TokenRequirement {
+	return c.RequireToken(audiences, func(w http.ResponseWriter, r *http.Request, t *Token) {
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole is like RequireTokenHandler, only serving the requests whose
+// token has one of the roles mapped by Config.RoleMapper, e.g.,
+//
+//	http.Handle("/admin/", c.RequireRole(audiences, adminMux, "admin"))
+func (c *Client) RequireRole(audiences []string, next http.Handler, roles ...string) *TokenRequirement {
+	r := c.RequireTokenHandler(audiences, next)
+	r.Roles = roles
+	return r
+}
+
+// RequestContext returns the context of the calls made while serving the
+// request: f(r) if f is not nil, or else the context of the request, which is
+// canceled when the client disconnects, or context.Background() if r is nil.
+// It is the default of the Context fields of the handlers.
+func RequestContext(f func(*http.Request) context.Context, r *http.Request) context.Context {
+	switch {
+	case f != nil:
+		return f(r)
+	case r != nil:
+		return r.Context()
+	}
+	return context.Background()
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (t *TokenRequirement) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := RequestContext(t.Context, r)
+	if len(t.audiences) == 0 {
+		ctx = WithRequestHost(ctx, r.Host)
+	}
+	s := t.client.TokenFromRequest(r)
+	if s == "" {
+		t.fail(w, r, ErrMissingToken, http.StatusUnauthorized)
+		return
+	}
+	token, err := t.client.ValidateToken(ctx, s, t.audiences)
+	if err != nil {
+		t.fail(w, r, err, http.StatusUnauthorized)
+		return
+	}
+	if err := CheckClaims(token, t.Claims...); err != nil {
+		t.fail(w, r, err, http.StatusForbidden)
+		return
+	}
+	if len(t.Roles) != 0 && !token.HasRole(t.Roles...) {
+		t.fail(w, r, &RoleError{t.Roles}, http.StatusForbidden)
+		return
+	}
+	rctx := WithToken(r.Context(), token)
+	if t.LoadUser {
+		u, err := t.client.userOfToken(ctx, token)
+		if _, notFound := err.(UserNotFoundError); notFound {
+			t.fail(w, r, err, http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			t.fail(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		rctx = WithUser(rctx, u)
+	}
+	t.handler(w, r.WithContext(rctx), token)
+}
+
+// fail serves the request rejected with err by OnFailure if set, or else
+// answers it with the status code. The unauthorized browser requests are
+// redirected to the sign in widget if Browser is set.
+func (t *TokenRequirement) fail(w http.ResponseWriter, r *http.Request, err error, code int) {
+	if t.OnFailure != nil {
+		t.OnFailure(w, r, err)
+		return
+	}
+	if code == http.StatusUnauthorized && t.Browser && (r.Method == "GET" || r.Method == "HEAD") {
+		if u, err := t.client.SignInURL(r); err == nil {
+			http.Redirect(w, r, u.String(), http.StatusFound)
+			return
+		}
+	}
+	http.Error(w, http.StatusText(code), code)
+}
+
+// SignInURL returns the widget URL in select mode which returns to the URL of
+// the request after the user signs in. The return URL is signed with
+// Config.ReturnURLKey, so that VerifyReturnURL can reject forged sign in URLs
+// and the widget cannot be used as an open redirector.
+func (c *Client) SignInURL(req *http.Request) (*url.URL, error) {
+	if c.widgetURL == nil {
+		return nil, errors.New("WidgetURL is not configured")
+	}
+	if len(c.config.ReturnURLKey) == 0 {
+		return nil, errors.New("ReturnURLKey is not configured")
+	}
+	returnURL := c.requestURL(req)
+	returnURL.RawQuery = req.URL.RawQuery
+	u := c.requestURL(req).ResolveReference(c.widgetURL)
+	q := u.Query()
+	q.Set(c.config.WidgetModeParamName, SelectMode)
+	q.Set(ReturnURLParam, returnURL.String())
+	q.Set(ReturnURLSignatureParam, c.signReturnURL(returnURL.String()))
+	u.RawQuery = q.Encode()
+	return u, nil
+}
+
+// VerifyReturnURL returns the return URL of a sign in URL built by SignInURL,
+// after checking its signature. It is meant to be called by the handler serving
+// the widget page before trusting the return URL.
+func (c *Client) VerifyReturnURL(req *http.Request) (string, error) {
+	if len(c.config.ReturnURLKey) == 0 {
+		return "", errors.New("ReturnURLKey is not configured")
+	}
+	q := req.URL.Query()
+	returnURL := q.Get(ReturnURLParam)
+	if returnURL == "" {
+		return "", errors.New("missing return URL")
+	}
+	sig, err := base64.URLEncoding.DecodeString(q.Get(ReturnURLSignatureParam))
+	if err != nil {
+		return "", errors.New("malformed return URL signature")
+	}
+	want, _ := base64.URLEncoding.DecodeString(c.signReturnURL(returnURL))
+	if !hmac.Equal(sig, want) {
+		return "", errors.New("invalid return URL signature")
+	}
+	return returnURL, nil
+}
+
+func (c *Client) signReturnURL(u string) string {
+	mac := hmac.New(sha256.New, c.config.ReturnURLKey)
+	mac.Write([]byte(u))
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
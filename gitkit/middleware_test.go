@@ -0,0 +1,212 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func newMiddlewareClient() *Client {
+	certs := initCerts()
+	certs.exp = neverExpire
+	widgetURL, _ := url.Parse("/widget")
+	return &Client{
+		config:    &Config{WidgetModeParamName: "mode", CookieName: "gtoken", ReturnURLKey: []byte("secret")},
+		widgetURL: widgetURL,
+		certs:     certs,
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	c := newMiddlewareClient()
+	var got *Token
+	h := c.RequireToken([]string{audience}, func(w http.ResponseWriter, r *http.Request, t *Token) {
+		got = t
+	})
+
+	tests := []struct {
+		method  string
+		token   string
+		browser bool
+		code    int
+	}{
+		{"GET", validToken, false, http.StatusOK},
+		{"GET", validToken, true, http.StatusOK},
+		{"GET", "", false, http.StatusUnauthorized},
+		{"GET", expiredToken, false, http.StatusUnauthorized},
+		{"GET", "", true, http.StatusFound},
+		{"GET", expiredToken, true, http.StatusFound},
+		{"POST", expiredToken, true, http.StatusUnauthorized},
+	}
+	for i, tt := range tests {
+		got = nil
+		h.Browser = tt.browser
+		req, _ := http.NewRequest(tt.method, "http://example.com/account?tab=1", nil)
+		if tt.token != "" {
+			req.AddCookie(&http.Cookie{Name: "gtoken", Value: tt.token})
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%d. status = %d; want %d", i, w.Code, tt.code)
+		}
+		if (got != nil) != (tt.code == http.StatusOK) {
+			t.Errorf("%d. handler called with token %v; want called %v", i, got, tt.code == http.StatusOK)
+		}
+		if w.Code != http.StatusFound {
+			continue
+		}
+		loc, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Errorf("%d. invalid redirect location: %v", i, err)
+			continue
+		}
+		q := loc.Query()
+		if loc.Path != "/widget" || q.Get("mode") != SelectMode || q.Get(ReturnURLParam) != "http://example.com/account?tab=1" {
+			t.Errorf("%d. redirect location = %s; want the widget in select mode returning to the request URL", i, loc)
+		}
+		widgetReq, _ := http.NewRequest("GET", loc.String(), nil)
+		if u, err := c.VerifyReturnURL(widgetReq); err != nil || u != "http://example.com/account?tab=1" {
+			t.Errorf("%d. VerifyReturnURL() = %q, %v; want the request URL", i, u, err)
+		}
+	}
+}
+
+func TestVerifyReturnURL(t *testing.T) {
+	c := newMiddlewareClient()
+	req, _ := http.NewRequest("GET", "http://example.com/account", nil)
+	u, err := c.SignInURL(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set(ReturnURLParam, "http://evil.com/")
+	u.RawQuery = q.Encode()
+	req, _ = http.NewRequest("GET", u.String(), nil)
+	if _, err := c.VerifyReturnURL(req); err == nil {
+		t.Errorf("VerifyReturnURL() accepts a forged return URL")
+	}
+
+	c.config.ReturnURLKey = nil
+	if _, err := c.SignInURL(req); err == nil {
+		t.Errorf("SignInURL() without ReturnURLKey returns no error")
+	}
+}
+
+func TestRequireTokenHandler(t *testing.T) {
+	c := newMiddlewareClient()
+	c.api = &APIClient{Client: http.Client{Transport: roundTripper{http.StatusOK, `{"users":[{"localId":"16109857760607106080","displayName":"Jane"}]}`}}}
+	var (
+		gotToken *Token
+		gotUser  *User
+	)
+	h := c.RequireTokenHandler([]string{audience}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotToken, _ = TokenFromContext(r.Context())
+		gotUser, _ = UserFromContext(r.Context())
+	}))
+	h.LoadUser = true
+	req, _ := http.NewRequest("GET", "http://www.example.com/account", nil)
+	req.AddCookie(&http.Cookie{Name: "gtoken", Value: validToken})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d; want 200", w.Code)
+	}
+	if gotToken == nil || gotToken.LocalID != "16109857760607106080" {
+		t.Errorf("token in context = %+v; want the validated token", gotToken)
+	}
+	if gotUser == nil || gotUser.DisplayName != "Jane" || gotUser.ProviderID != "google.com" {
+		t.Errorf("user in context = %+v; want the retrieved user", gotUser)
+	}
+
+	// The user lookup fails.
+	c.api = &APIClient{Client: http.Client{Transport: roundTripper{http.StatusInternalServerError, `{}`}}}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status when the user lookup fails = %d; want 500", w.Code)
+	}
+
+	// Custom failure handler.
+	var failure error
+	h.OnFailure = func(w http.ResponseWriter, r *http.Request, err error) {
+		failure = err
+		w.WriteHeader(http.StatusTeapot)
+	}
+	req, _ = http.NewRequest("GET", "http://www.example.com/account", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusTeapot || failure != ErrMissingToken {
+		t.Errorf("OnFailure called with %v and status %d; want ErrMissingToken and 418", failure, w.Code)
+	}
+	req.AddCookie(&http.Cookie{Name: "gtoken", Value: expiredToken})
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if failure != ErrExpired {
+		t.Errorf("OnFailure called with %v; want ErrExpired", failure)
+	}
+}
+
+type ctxKey string
+
+func TestRequestContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey("k"), "request"))
+	req, _ := http.NewRequest("GET", "/", nil)
+	req = req.WithContext(ctx)
+	if got := RequestContext(nil, req).Value(ctxKey("k")); got != "request" {
+		t.Errorf("RequestContext(nil) value = %v; want the context of the request", got)
+	}
+	f := func(*http.Request) context.Context { return context.WithValue(context.Background(), ctxKey("k"), "f") }
+	if got := RequestContext(f, req).Value(ctxKey("k")); got != "f" {
+		t.Errorf("RequestContext(f) value = %v; want the context of f", got)
+	}
+	if RequestContext(nil, nil) == nil {
+		t.Error("RequestContext(nil, nil) = nil")
+	}
+
+	// The validation stops when the client disconnects.
+	cancel()
+	c := newMiddlewareClient()
+	// The certificates are never downloaded.
+	block := make(chan struct{})
+	defer close(block)
+	c.certs = &Certificates{URL: publicCertsURL, Transport: blockingRoundTripper(block)}
+	defer c.certs.Close()
+	var validateErr error
+	h := c.RequireToken([]string{audience}, func(w http.ResponseWriter, r *http.Request, t *Token) {})
+	h.OnFailure = func(w http.ResponseWriter, r *http.Request, err error) { validateErr = err }
+	req.AddCookie(&http.Cookie{Name: "gtoken", Value: validToken})
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if validateErr != context.Canceled {
+		t.Errorf("RequireToken() of a canceled request fails with %v; want %v", validateErr, context.Canceled)
+	}
+}
+
+// blockingRoundTripper answers the requests once it is closed or they are
+// canceled.
+type blockingRoundTripper chan struct{}
+
+func (b blockingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case <-b:
+	case <-req.Context().Done():
+	}
+	return nil, errors.New("blocked")
+}
@@ -29,5 +29,8 @@ func defaultTransport(ctx context.Context) http.RoundTripper {
 
 // apiClient returns the APIClient instance in the Client.
 func (c *Client) apiClient(ctx context.Context) *APIClient {
+	if err := c.waitInit(ctx); err != nil {
+		return failedAPIClient(err)
+	}
 	return c.api
 }
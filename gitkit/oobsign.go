@@ -0,0 +1,98 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Parameters added to the OOB code URLs when Config.OOBCodeURLKey is set.
+const (
+	// OOBExpiresParam is the parameter holding the expiration time of the
+	// OOB code URL, in seconds since the epoch.
+	OOBExpiresParam = "oobExpires"
+	// OOBSignatureParam is the parameter holding the signature of the OOB
+	// code URL.
+	OOBSignatureParam = "oobSig"
+)
+
+// DefaultOOBCodeURLLifetime is the lifetime of the signed OOB code URLs if
+// Config.OOBCodeURLLifetime is not set.
+const DefaultOOBCodeURLLifetime = 24 * time.Hour
+
+// ErrOOBCodeURLExpired is returned by VerifyOOBCodeURL for a validly signed
+// OOB code URL past its expiration time.
+var ErrOOBCodeURLExpired = errors.New("gitkit: OOB code URL expired")
+
+// signOOBCodeURL adds the expiration time and the signature to the query of an
+// OOB code URL.
+func (c *Client) signOOBCodeURL(q url.Values, action, oobCode string) {
+	lifetime := c.config.OOBCodeURLLifetime
+	if lifetime <= 0 {
+		lifetime = DefaultOOBCodeURLLifetime
+	}
+	exp := strconv.FormatInt(time.Now().Add(lifetime).Unix(), 10)
+	q.Set(OOBExpiresParam, exp)
+	q.Set(OOBSignatureParam, c.oobCodeURLSignature(action, oobCode, exp))
+}
+
+func (c *Client) oobCodeURLSignature(action, oobCode, exp string) string {
+	mac := hmac.New(sha256.New, c.config.OOBCodeURLKey)
+	mac.Write([]byte(action + "\n" + oobCode + "\n" + exp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyOOBCodeURL returns the OOB code of a request to an OOB code URL built
+// by GenerateOOBCode, after checking its signature and expiration time. It is
+// meant to be called by the handler serving the widget page before rendering
+// it with the OOB code, so that the links stop working after
+// Config.OOBCodeURLLifetime even if the OOB code itself lives longer.
+//
+// It returns ErrOOBCodeURLExpired if the URL has expired.
+func (c *Client) VerifyOOBCodeURL(req *http.Request) (string, error) {
+	if len(c.config.OOBCodeURLKey) == 0 {
+		return "", errors.New("OOBCodeURLKey is not configured")
+	}
+	q := req.URL.Query()
+	oobCode := q.Get(OOBCodeParam)
+	if oobCode == "" {
+		return "", errors.New("missing OOB code")
+	}
+	exp := q.Get(OOBExpiresParam)
+	sec, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return "", errors.New("malformed OOB code URL expiration time")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(q.Get(OOBSignatureParam))
+	if err != nil {
+		return "", errors.New("malformed OOB code URL signature")
+	}
+	want, _ := base64.RawURLEncoding.DecodeString(
+		c.oobCodeURLSignature(q.Get(c.config.WidgetModeParamName), oobCode, exp))
+	if !hmac.Equal(sig, want) {
+		return "", errors.New("invalid OOB code URL signature")
+	}
+	if !time.Now().Before(time.Unix(sec, 0)) {
+		return "", ErrOOBCodeURLExpired
+	}
+	return oobCode, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newOOBSignClient() *Client {
+	widgetURL, _ := url.Parse("/widget")
+	return &Client{
+		config:    &Config{WidgetModeParamName: "mode", OOBCodeURLKey: []byte("secret"), OOBCodeURLLifetime: time.Hour},
+		widgetURL: widgetURL,
+	}
+}
+
+func TestVerifyOOBCodeURL(t *testing.T) {
+	c := newOOBSignClient()
+	req := &http.Request{Host: "www.example.com", URL: &url.URL{Path: "/oob"}}
+	u := c.buildOOBCodeURL(req, OOBActionResetPassword, "code")
+	exp, err := strconv.ParseInt(u.Query().Get(OOBExpiresParam), 10, 64)
+	if err != nil {
+		t.Fatalf("buildOOBCodeURL() = %s; want a valid %s", u, OOBExpiresParam)
+	}
+	if d := time.Unix(exp, 0).Sub(time.Now()); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("buildOOBCodeURL() expires in %v; want 1h", d)
+	}
+
+	modify := func(key, value string) *url.URL {
+		m, _ := url.Parse(u.String())
+		q := m.Query()
+		q.Set(key, value)
+		m.RawQuery = q.Encode()
+		return m
+	}
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	expired := modify(OOBExpiresParam, past)
+	q := expired.Query()
+	q.Set(OOBSignatureParam, c.oobCodeURLSignature(OOBActionResetPassword, "code", past))
+	expired.RawQuery = q.Encode()
+
+	tests := []struct {
+		u   *url.URL
+		ok  bool
+		err error
+	}{
+		{u, true, nil},
+		{modify(OOBCodeParam, "other"), false, nil},
+		{modify("mode", OOBActionVerifyEmail), false, nil},
+		{modify(OOBExpiresParam, strconv.FormatInt(exp+3600, 10)), false, nil},
+		{modify(OOBExpiresParam, "tomorrow"), false, nil},
+		{modify(OOBSignatureParam, "!"), false, nil},
+		{expired, false, ErrOOBCodeURLExpired},
+	}
+	for i, tt := range tests {
+		code, err := c.VerifyOOBCodeURL(&http.Request{URL: tt.u})
+		if tt.ok {
+			if err != nil || code != "code" {
+				t.Errorf("%d. VerifyOOBCodeURL(%s) = %q, %v; want code, nil", i, tt.u, code, err)
+			}
+			continue
+		}
+		if err == nil || (tt.err != nil && err != tt.err) {
+			t.Errorf("%d. VerifyOOBCodeURL(%s) returns error %v; want error %v", i, tt.u, err, tt.err)
+		}
+	}
+}
+
+func TestBuildOOBCodeURL_unsigned(t *testing.T) {
+	c := newOOBSignClient()
+	c.config.OOBCodeURLKey = nil
+	req := &http.Request{Host: "www.example.com", URL: &url.URL{Path: "/oob"}}
+	u := c.buildOOBCodeURL(req, OOBActionResetPassword, "code")
+	if want := "http://www.example.com/widget?mode=resetPassword&oobCode=code"; u.String() != want {
+		t.Errorf("buildOOBCodeURL() = %s; want %s", u, want)
+	}
+	if _, err := c.VerifyOOBCodeURL(&http.Request{URL: u}); err == nil {
+		t.Error("VerifyOOBCodeURL() without OOBCodeURLKey returns no error")
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+)
+
+// An Option overrides part of the configuration passed to New.
+type Option func(*Config)
+
+// WithCredentialsJSON sets the service account JSON key used to access
+// identitytoolkit API. See Config.GoogleAppCredentialsJSON.
+func WithCredentialsJSON(b []byte) Option {
+	return func(c *Config) {
+		c.GoogleAppCredentialsJSON = b
+	}
+}
+
+// WithTokenSource sets the OAuth2 token source used to access identitytoolkit
+// API. See Config.TokenSource.
+func WithTokenSource(ts oauth2.TokenSource) Option {
+	return func(c *Config) {
+		c.TokenSource = ts
+	}
+}
+
+// WithJWTConfig sets the service account configuration used to access
+// identitytoolkit API. See Config.JWTConfig.
+func WithJWTConfig(jc *jwt.Config) Option {
+	return func(c *Config) {
+		c.JWTConfig = jc
+	}
+}
+
+// WithTransportMiddleware appends the middlewares to the ones wrapping the
+// transport of the identitytoolkit API requests. See
+// Config.TransportMiddlewares.
+func WithTransportMiddleware(mws ...TransportMiddleware) Option {
+	return func(c *Config) {
+		c.TransportMiddlewares = append(c.TransportMiddlewares[:len(c.TransportMiddlewares):len(c.TransportMiddlewares)], mws...)
+	}
+}
+
+// WithHTTPClient sets the HTTP client sending the identitytoolkit API
+// requests, including the OOB code ones, and downloading the public
+// certificates. See Config.HTTPClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Config) {
+		c.HTTPClient = hc
+	}
+}
+
+// WithPrewarmedCerts makes New download the public certificates in the
+// background. See Config.PrewarmCerts.
+func WithPrewarmedCerts() Option {
+	return func(c *Config) {
+		c.PrewarmCerts = true
+	}
+}
+
+// WithCertificateSource sets the source of the certificates verifying the
+// tokens. See Config.CertificateSource.
+func WithCertificateSource(src CertificateSource) Option {
+	return func(c *Config) {
+		c.CertificateSource = src
+	}
+}
+
+// WithAPIEndpoint sets the base URL of the identitytoolkit API methods, e.g.,
+// of a local fake server. See Config.Endpoint.
+func WithAPIEndpoint(baseURL string) Option {
+	return func(c *Config) {
+		var e Endpoint
+		if c.Endpoint != nil {
+			e = *c.Endpoint
+		}
+		e.BaseURL = baseURL
+		c.Endpoint = &e
+	}
+}
+
+// WithAPIVersion selects the version of the identitytoolkit API, e.g., APIv1
+// with the ID of the project. See Config.APIVersion.
+func WithAPIVersion(version, projectID string) Option {
+	return func(c *Config) {
+		c.APIVersion = version
+		c.ProjectID = projectID
+	}
+}
+
+// WithPreset configures the Client for the environment of the preset, e.g.,
+// PresetFirebaseAuth with the ID of the project. See Config.Preset.
+func WithPreset(p Preset, projectID string) Option {
+	return func(c *Config) {
+		c.Preset = p
+		c.ProjectID = projectID
+	}
+}
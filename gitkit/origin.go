@@ -0,0 +1,85 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ForbiddenOriginError is returned when a request comes from an origin which
+// is not allowed by Config.AllowedOrigins.
+type ForbiddenOriginError struct {
+	// Origin is the origin of the request, or empty if it has neither an
+	// Origin nor a Referer header.
+	Origin string
+}
+
+// Error implements the error interface.
+func (e *ForbiddenOriginError) Error() string {
+	if e.Origin == "" {
+		return "gitkit: request origin is unknown"
+	}
+	return fmt.Sprintf("gitkit: request origin %s is not allowed", e.Origin)
+}
+
+// CheckOrigin returns a *ForbiddenOriginError if Config.AllowedOrigins is set
+// and the request comes from another origin than the site itself or one of
+// the allowed origins. The origin is taken from the Origin header, or from the
+// Referer header if the browser sent no Origin. Requests without both are
+// rejected.
+//
+// GenerateOOBCode calls it, so that other sites can't make the browsers of
+// the users post forms triggering emails sent from the site. Handlers serving
+// similar requests should call it too.
+func (c *Client) CheckOrigin(req *http.Request) error {
+	if c.config == nil || len(c.config.AllowedOrigins) == 0 {
+		return nil
+	}
+	origin := requestOrigin(req)
+	if origin == "" {
+		return &ForbiddenOriginError{}
+	}
+	if strings.EqualFold(origin, originOf(c.requestURL(req))) {
+		return nil
+	}
+	for _, o := range c.config.AllowedOrigins {
+		if strings.EqualFold(origin, strings.TrimSuffix(o, "/")) {
+			return nil
+		}
+	}
+	return &ForbiddenOriginError{origin}
+}
+
+// requestOrigin returns the origin of the request from its Origin or Referer
+// header.
+func requestOrigin(req *http.Request) string {
+	if o := req.Header.Get("Origin"); o != "" && o != "null" {
+		return o
+	}
+	if r := req.Header.Get("Referer"); r != "" {
+		if u, err := url.Parse(r); err == nil && u.Scheme != "" && u.Host != "" {
+			return originOf(u)
+		}
+	}
+	return ""
+}
+
+// originOf returns the scheme://host origin of the URL.
+func originOf(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,79 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	allowed := []string{"https://accounts.example.com/"}
+	tests := []struct {
+		origins []string
+		header  map[string]string
+		origin  string // Origin of the error, or "-" for no error.
+	}{
+		{nil, map[string]string{"Origin": "https://evil.example.org"}, "-"},
+		{allowed, map[string]string{"Origin": "http://www.example.com"}, "-"},
+		{allowed, map[string]string{"Origin": "https://Accounts.example.com"}, "-"},
+		{allowed, map[string]string{"Referer": "https://accounts.example.com/reset?a=b"}, "-"},
+		{allowed, map[string]string{"Origin": "https://evil.example.org"}, "https://evil.example.org"},
+		{allowed, map[string]string{"Origin": "https://evil.example.org", "Referer": "http://www.example.com/"}, "https://evil.example.org"},
+		{allowed, map[string]string{"Origin": "null"}, ""},
+		{allowed, nil, ""},
+	}
+	for i, tt := range tests {
+		c := &Client{config: &Config{AllowedOrigins: tt.origins}}
+		req := &http.Request{Host: "www.example.com", URL: &url.URL{Path: "/oob"}, Header: make(http.Header)}
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+		err := c.CheckOrigin(req)
+		if tt.origin == "-" {
+			if err != nil {
+				t.Errorf("%d. CheckOrigin() returns error: %v", i, err)
+			}
+			continue
+		}
+		if e, ok := err.(*ForbiddenOriginError); !ok || e.Origin != tt.origin {
+			t.Errorf("%d. CheckOrigin() = %v; want *ForbiddenOriginError with origin %q", i, err, tt.origin)
+		}
+	}
+}
+
+func TestGenerateOOBCode_forbiddenOrigin(t *testing.T) {
+	rt := &recordingRoundTripper{roundTripper: roundTripper{http.StatusOK, `{"oobCode":"code"}`}}
+	c := &Client{
+		config: &Config{AllowedOrigins: []string{"https://accounts.example.com"}},
+		api:    &APIClient{Client: http.Client{Transport: rt}},
+	}
+	form := url.Values{OOBActionParam: {OOBActionResetPassword}, OOBEmailParam: {"user@example.com"}}
+	req, _ := http.NewRequest("POST", "http://www.example.com/oob", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example.org")
+	if _, err := c.GenerateOOBCode(context.Background(), req); err == nil {
+		t.Fatal("GenerateOOBCode() returns no error; want *ForbiddenOriginError")
+	} else if _, ok := err.(*ForbiddenOriginError); !ok {
+		t.Errorf("GenerateOOBCode() returns error %v; want *ForbiddenOriginError", err)
+	}
+	if len(rt.reqs) != 0 {
+		t.Errorf("GenerateOOBCode() sends %d API requests; want 0", len(rt.reqs))
+	}
+}
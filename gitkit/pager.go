@@ -0,0 +1,93 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// MaxPageSize is the maximum number of users downloadAccount returns per page.
+const MaxPageSize = 1000
+
+// A UserLister lists the users page by page. It is implemented by Client.
+type UserLister interface {
+	ListUsersN(ctx context.Context, n int, cursor Cursor) ([]*User, Cursor, error)
+}
+
+// A Pager fetches the users one page at a time, threading the page tokens
+// between the calls. Unlike ListUsers, the caller controls when each page is
+// fetched and can persist PageToken to resume later.
+//
+// For example,
+//
+//	p, err := gitkit.NewPager(c, 500, "")
+//	...
+//	for !p.Done() {
+//		users, err := p.NextPage(ctx)
+//		if err != nil {
+//			// Calling NextPage again retries the same page.
+//			...
+//		}
+//		...
+//	}
+type Pager struct {
+	lister    UserLister
+	pageSize  int
+	pageToken Cursor
+	done      bool
+}
+
+// NewPager creates a Pager which fetches pages of pageSize users from l,
+// starting at pageToken or at the first page if pageToken is empty. pageSize
+// must be between 1 and MaxPageSize.
+func NewPager(l UserLister, pageSize int, pageToken Cursor) (*Pager, error) {
+	if pageSize < 1 || pageSize > MaxPageSize {
+		return nil, fmt.Errorf("page size must be between 1 and %d, got %d", MaxPageSize, pageSize)
+	}
+	if err := pageToken.Validate(); err != nil {
+		return nil, err
+	}
+	return &Pager{lister: l, pageSize: pageSize, pageToken: pageToken}, nil
+}
+
+// NextPage fetches the next page of users. It returns no users and no error
+// once Done. If it fails, the page token is unchanged so the call can be
+// retried.
+func (p *Pager) NextPage(ctx context.Context) ([]*User, error) {
+	if p.done {
+		return nil, nil
+	}
+	users, next, err := p.lister.ListUsersN(ctx, p.pageSize, p.pageToken)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 || next == "" {
+		p.done = true
+	}
+	p.pageToken = next
+	return users, nil
+}
+
+// PageToken returns the token of the next page, which is empty once Done.
+func (p *Pager) PageToken() Cursor {
+	return p.pageToken
+}
+
+// Done reports whether all the pages have been fetched.
+func (p *Pager) Done() bool {
+	return p.done
+}
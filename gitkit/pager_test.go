@@ -0,0 +1,100 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// sliceLister lists the users in the slice. The page token is the offset of
+// the page. It fails once if failAt is the offset requested.
+type sliceLister struct {
+	users  []*User
+	failAt Cursor
+}
+
+func (s *sliceLister) ListUsersN(ctx context.Context, n int, pageToken Cursor) ([]*User, Cursor, error) {
+	if pageToken != "" && pageToken == s.failAt {
+		s.failAt = ""
+		return nil, "", errors.New("backend error")
+	}
+	offset, _ := strconv.Atoi(string(pageToken))
+	end := offset + n
+	if end >= len(s.users) {
+		return s.users[offset:], "", nil
+	}
+	return s.users[offset:end], Cursor(strconv.Itoa(end)), nil
+}
+
+var _ UserLister = (*Client)(nil)
+
+func TestNewPager(t *testing.T) {
+	for _, size := range []int{0, -1, MaxPageSize + 1} {
+		if _, err := NewPager(&sliceLister{}, size, ""); err == nil {
+			t.Errorf("NewPager(%d) returns no error", size)
+		}
+	}
+	for _, size := range []int{1, MaxPageSize} {
+		if _, err := NewPager(&sliceLister{}, size, ""); err != nil {
+			t.Errorf("NewPager(%d) returns error %v", size, err)
+		}
+	}
+}
+
+func TestPager(t *testing.T) {
+	l := &sliceLister{failAt: "2"}
+	for i := 0; i < 5; i++ {
+		l.users = append(l.users, &User{LocalID: strconv.Itoa(i)})
+	}
+	p, err := NewPager(l, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	var got []string
+	var failures int
+	for !p.Done() {
+		users, err := p.NextPage(ctx)
+		if err != nil {
+			failures++
+			if p.PageToken() != "2" {
+				t.Errorf("PageToken() after failure = %q; want 2", p.PageToken())
+			}
+			continue
+		}
+		for _, u := range users {
+			got = append(got, u.LocalID)
+		}
+	}
+	if failures != 1 || len(got) != 5 {
+		t.Errorf("got %v with %d failures; want 5 users with 1 failure", got, failures)
+	}
+	if p.PageToken() != "" {
+		t.Errorf("PageToken() after Done = %q; want empty", p.PageToken())
+	}
+	if users, err := p.NextPage(ctx); users != nil || err != nil {
+		t.Errorf("NextPage() after Done = %v, %v; want nil, nil", users, err)
+	}
+
+	// Resume from a persisted page token.
+	p, _ = NewPager(l, 2, "4")
+	if users, _ := p.NextPage(ctx); len(users) != 1 || users[0].LocalID != "4" || !p.Done() {
+		t.Errorf("NextPage() from token 4 = %v; want the last user", users)
+	}
+}
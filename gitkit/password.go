@@ -0,0 +1,154 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultMinPasswordLength is the minimum password length accepted by
+// identitytoolkit, used by PasswordRules without MinLength.
+const DefaultMinPasswordLength = 6
+
+// A PasswordPolicy decides which passwords the users may choose. See
+// Config.PasswordPolicy.
+type PasswordPolicy interface {
+	// CheckPassword returns an error, typically a *WeakPasswordError, if the
+	// password is not allowed.
+	CheckPassword(ctx context.Context, password string) error
+}
+
+// PasswordPolicyFunc is an adapter to use a function as a PasswordPolicy.
+type PasswordPolicyFunc func(ctx context.Context, password string) error
+
+// CheckPassword implements the PasswordPolicy interface.
+func (f PasswordPolicyFunc) CheckPassword(ctx context.Context, password string) error {
+	return f(ctx, password)
+}
+
+// WeakPasswordError is returned when a password is rejected by a
+// PasswordPolicy.
+type WeakPasswordError struct {
+	Reason string // Why the password is rejected, e.g., "too short".
+}
+
+// Error implements the error interface.
+func (e *WeakPasswordError) Error() string {
+	return "gitkit: weak password: " + e.Reason
+}
+
+// PasswordRules is a PasswordPolicy checking the length and the kinds of
+// characters of the passwords.
+type PasswordRules struct {
+	// MinLength and MaxLength bound the number of characters of the
+	// passwords. DefaultMinPasswordLength is used if MinLength is zero, and
+	// the length is unbounded if MaxLength is zero.
+	MinLength int
+	MaxLength int
+	// RequireLower, RequireUpper, RequireDigit and RequireSymbol require at
+	// least one lower case letter, upper case letter, digit or other
+	// character respectively.
+	RequireLower  bool
+	RequireUpper  bool
+	RequireDigit  bool
+	RequireSymbol bool
+}
+
+// CheckPassword implements the PasswordPolicy interface.
+func (r *PasswordRules) CheckPassword(ctx context.Context, password string) error {
+	min := r.MinLength
+	if min == 0 {
+		min = DefaultMinPasswordLength
+	}
+	n := utf8.RuneCountInString(password)
+	if n < min {
+		return &WeakPasswordError{fmt.Sprintf("shorter than %d characters", min)}
+	}
+	if r.MaxLength > 0 && n > r.MaxLength {
+		return &WeakPasswordError{fmt.Sprintf("longer than %d characters", r.MaxLength)}
+	}
+	var lower, upper, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	switch {
+	case r.RequireLower && !lower:
+		return &WeakPasswordError{"no lower case letter"}
+	case r.RequireUpper && !upper:
+		return &WeakPasswordError{"no upper case letter"}
+	case r.RequireDigit && !digit:
+		return &WeakPasswordError{"no digit"}
+	case r.RequireSymbol && !symbol:
+		return &WeakPasswordError{"no symbol"}
+	}
+	return nil
+}
+
+// checkPassword checks the password against Config.PasswordPolicy, if set.
+func (c *Client) checkPassword(ctx context.Context, password string) error {
+	if c.config == nil || c.config.PasswordPolicy == nil {
+		return nil
+	}
+	return c.config.PasswordPolicy.CheckPassword(ctx, password)
+}
+
+// ResetPassword sets the password of the user the reset password OOB code was
+// generated for, after checking it against Config.PasswordPolicy. It returns
+// the email address of the user.
+func (c *Client) ResetPassword(ctx context.Context, oobCode, newPassword string) (string, error) {
+	if err := c.checkPassword(ctx, newPassword); err != nil {
+		return "", err
+	}
+	resp, err := c.mutatingAPIClient(ctx).ResetPassword(ctx, &ResetPasswordRequest{
+		OOBCode:     oobCode,
+		NewPassword: newPassword,
+	})
+	c.audit(ctx, AuditOpResetPassword, nil, err)
+	if err != nil {
+		return "", err
+	}
+	return resp.Email, nil
+}
+
+// ConfirmEmailVerification applies the verify email OOB code, i.e., marks the
+// email address of the user it was generated for as verified, e.g., on a
+// landing page of the verification emails served without the widget. It
+// returns the email address of the user.
+func (c *Client) ConfirmEmailVerification(ctx context.Context, oobCode string) (string, error) {
+	resp, err := c.mutatingAPIClient(ctx).ConfirmEmailVerification(ctx, oobCode)
+	if err != nil {
+		c.audit(ctx, AuditOpVerifyEmail, nil, err)
+		return "", err
+	}
+	var localIDs []string
+	if resp.LocalID != "" {
+		localIDs = []string{resp.LocalID}
+	}
+	c.audit(ctx, AuditOpVerifyEmail, localIDs, nil)
+	return resp.Email, nil
+}
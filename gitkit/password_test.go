@@ -0,0 +1,106 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestPasswordRules(t *testing.T) {
+	tests := []struct {
+		rules    PasswordRules
+		password string
+		ok       bool
+	}{
+		{PasswordRules{}, "12345", false},
+		{PasswordRules{}, "123456", true},
+		{PasswordRules{MinLength: 8}, "1234567", false},
+		{PasswordRules{MaxLength: 8}, "123456789", false},
+		{PasswordRules{RequireLower: true}, "ABCDEF", false},
+		{PasswordRules{RequireLower: true}, "ABCDEf", true},
+		{PasswordRules{RequireUpper: true}, "abcdef", false},
+		{PasswordRules{RequireDigit: true}, "abcdef", false},
+		{PasswordRules{RequireDigit: true}, "abcde1", true},
+		{PasswordRules{RequireSymbol: true}, "abcde1", false},
+		{PasswordRules{RequireSymbol: true}, "abcde!", true},
+		{PasswordRules{MinLength: 3}, "été", true},
+	}
+	ctx := context.Background()
+	for i, tt := range tests {
+		err := tt.rules.CheckPassword(ctx, tt.password)
+		if tt.ok && err != nil {
+			t.Errorf("[%d] CheckPassword(%q) returns error: %v", i, tt.password, err)
+		}
+		if _, weak := err.(*WeakPasswordError); !tt.ok && !weak {
+			t.Errorf("[%d] CheckPassword(%q) returns error %v; want *WeakPasswordError", i, tt.password, err)
+		}
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	rt := &recordingRoundTripper{roundTripper: roundTripper{http.StatusOK, `{"email":"user@example.com","requestType":"PASSWORD_RESET"}`}}
+	c := &Client{
+		config: &Config{PasswordPolicy: &PasswordRules{MinLength: 8}},
+		api:    &APIClient{Client: http.Client{Transport: rt}},
+	}
+	ctx := context.Background()
+	if _, err := c.ResetPassword(ctx, "code", "short"); err == nil {
+		t.Errorf("ResetPassword() with a weak password returns no error")
+	}
+	if len(rt.reqs) != 0 {
+		t.Errorf("ResetPassword() sends %d API requests for a weak password; want 0", len(rt.reqs))
+	}
+	email, err := c.ResetPassword(ctx, "code", "long enough")
+	if err != nil {
+		t.Fatalf("ResetPassword() returns error: %v", err)
+	}
+	if email != "user@example.com" {
+		t.Errorf("ResetPassword() = %q; want user@example.com", email)
+	}
+	if len(rt.reqs) != 1 || rt.reqs[0].URL.String() != resetPassword.url() {
+		t.Errorf("ResetPassword() sends %d requests; want 1 to %s", len(rt.reqs), resetPassword.url())
+	}
+}
+
+func TestConfirmEmailVerification(t *testing.T) {
+	var records []*AuditRecord
+	rt := &recordingRoundTripper{roundTripper: roundTripper{http.StatusOK, `{"localId":"123","email":"user@example.com","emailVerified":true}`}}
+	c := &Client{
+		config: &Config{AuditHook: func(ctx context.Context, r *AuditRecord) {
+			records = append(records, r)
+		}},
+		api: &APIClient{Client: http.Client{Transport: rt}},
+	}
+	ctx := context.Background()
+	if _, err := c.ConfirmEmailVerification(ctx, ""); err == nil {
+		t.Errorf("ConfirmEmailVerification() without an OOB code returns no error")
+	}
+	email, err := c.ConfirmEmailVerification(ctx, "code")
+	if err != nil {
+		t.Fatalf("ConfirmEmailVerification() returns error: %v", err)
+	}
+	if email != "user@example.com" {
+		t.Errorf("ConfirmEmailVerification() = %q; want user@example.com", email)
+	}
+	if len(rt.reqs) != 1 || rt.reqs[0].URL.String() != setAccountInfo.url() {
+		t.Errorf("ConfirmEmailVerification() sends %d requests; want 1 to %s", len(rt.reqs), setAccountInfo.url())
+	}
+	if len(records) != 2 || records[1].Op != AuditOpVerifyEmail || len(records[1].LocalIDs) != 1 || records[1].LocalIDs[0] != "123" {
+		t.Errorf("audit records = %+v; want a failed and a successful %s of 123", records, AuditOpVerifyEmail)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"crypto"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/oauth2"
+)
+
+// A Preset names an environment issuing the ID tokens and serving the
+// identitytoolkit API, and bundles its settings, so that a Client is
+// configured for it by a single value. See Config.Preset.
+type Preset string
+
+const (
+	// PresetGitkit is the legacy Google Identity Toolkit.
+	PresetGitkit Preset = "gitkit"
+	// PresetFirebaseAuth is Firebase Authentication, whose ID tokens are
+	// issued by securetoken for the project.
+	PresetFirebaseAuth Preset = "firebaseAuth"
+	// PresetIdentityPlatform is Google Cloud Identity Platform, which issues
+	// the tokens like Firebase Authentication and serves the v1 API.
+	PresetIdentityPlatform Preset = "identityPlatform"
+	// PresetEmulator is the local Firebase Authentication emulator, at the
+	// host:port of EmulatorHostEnv, or else DefaultEmulatorHost. The
+	// emulator does not sign its tokens, and the Client accepts them
+	// unsigned, unless Config.KeyResolver or Config.CertificateSource is
+	// set. New fails then if EmulatorHostEnv is not set to a loopback
+	// host, and the unsigned tokens are only accepted while it is, so that
+	// the preset cannot turn off the signature checks in production.
+	PresetEmulator Preset = "emulator"
+)
+
+const (
+	// GitkitIssuer is the issuer of the Google Identity Toolkit ID tokens.
+	GitkitIssuer = "https://identitytoolkit.google.com/"
+	// SecureTokenIssuerPrefix, followed by the project ID, is the issuer of
+	// the Firebase Authentication and Identity Platform ID tokens.
+	SecureTokenIssuerPrefix = "https://securetoken.google.com/"
+	// EmulatorHostEnv is the environment variable holding the host:port of
+	// the Firebase Authentication emulator.
+	EmulatorHostEnv = "FIREBASE_AUTH_EMULATOR_HOST"
+	// DefaultEmulatorHost is the host:port the emulator listens on by
+	// default.
+	DefaultEmulatorHost = "localhost:9099"
+)
+
+// PresetSettings are the settings bundled by a Preset.
+type PresetSettings struct {
+	// Issuers are the accepted issuers of the ID tokens.
+	Issuers []string
+	// CertsURL is the URL of the public certificates verifying the ID
+	// tokens. It is empty if the tokens are not signed.
+	CertsURL string
+	// APIBaseURL is the base URL of the identitytoolkit API methods.
+	APIBaseURL string
+	// APIVersion is the version of the API served at APIBaseURL.
+	APIVersion string
+}
+
+// Settings returns the settings of the preset for the project. All presets
+// but PresetGitkit need the project ID.
+func (p Preset) Settings(projectID string) (*PresetSettings, error) {
+	if p != PresetGitkit && projectID == "" {
+		return nil, fmt.Errorf("gitkit: preset %s needs a project ID", p)
+	}
+	switch p {
+	case PresetGitkit:
+		return &PresetSettings{
+			Issuers:    []string{GitkitIssuer},
+			CertsURL:   publicCertsURL,
+			APIBaseURL: LegacyAPIBaseURL,
+			APIVersion: APIv3,
+		}, nil
+	case PresetFirebaseAuth:
+		return &PresetSettings{
+			Issuers:    []string{SecureTokenIssuerPrefix + projectID},
+			CertsURL:   SecureTokenCertsURL,
+			APIBaseURL: IdentityPlatformAPIBaseURL,
+			APIVersion: APIv3,
+		}, nil
+	case PresetIdentityPlatform:
+		return &PresetSettings{
+			Issuers:    []string{SecureTokenIssuerPrefix + projectID},
+			CertsURL:   SecureTokenCertsURL,
+			APIBaseURL: IdentityPlatformV1BaseURL,
+			APIVersion: APIv1,
+		}, nil
+	case PresetEmulator:
+		host := os.Getenv(EmulatorHostEnv)
+		if host == "" {
+			host = DefaultEmulatorHost
+		}
+		return &PresetSettings{
+			Issuers:    []string{SecureTokenIssuerPrefix + projectID},
+			APIBaseURL: "http://" + host + "/www.googleapis.com/identitytoolkit/v3/relyingparty",
+			APIVersion: APIv3,
+		}, nil
+	}
+	return nil, fmt.Errorf("gitkit: unsupported preset: %s", p)
+}
+
+// applyPreset fills the settings of conf.Preset the configuration leaves
+// unset, and returns the public certificates URL of the preset.
+func applyPreset(conf *Config) (string, error) {
+	if conf.Preset == "" {
+		return publicCertsURL, nil
+	}
+	s, err := conf.Preset.Settings(conf.ProjectID)
+	if err != nil {
+		return "", err
+	}
+	if conf.Issuers == nil {
+		conf.Issuers = s.Issuers
+	}
+	if conf.APIVersion == "" {
+		conf.APIVersion = s.APIVersion
+	}
+	if conf.APIVersion == APIv3 && s.APIVersion == APIv3 && (conf.Endpoint == nil || conf.Endpoint.BaseURL == "") {
+		e := Endpoint{}
+		if conf.Endpoint != nil {
+			e = *conf.Endpoint
+		}
+		e.BaseURL = s.APIBaseURL
+		conf.Endpoint = &e
+	}
+	if conf.Preset == PresetEmulator {
+		if conf.KeyResolver == nil && conf.CertificateSource == nil {
+			if !emulatorHostIsLoopback() {
+				return "", fmt.Errorf("gitkit: the emulator preset accepts unsigned tokens and requires %s to be set to a loopback host", EmulatorHostEnv)
+			}
+			conf.KeyResolver = unsignedKeyResolver{}
+		}
+		// The emulator accepts any access token.
+		if conf.GoogleAppCredentialsPath == "" && len(conf.GoogleAppCredentialsJSON) == 0 &&
+			conf.JWTConfig == nil && conf.TokenSource == nil {
+			conf.TokenSource = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "owner"})
+		}
+	}
+	if s.CertsURL == "" {
+		// The tokens are not signed, the certificates are left unused.
+		return publicCertsURL, nil
+	}
+	return s.CertsURL, nil
+}
+
+// emulatorHostIsLoopback reports whether EmulatorHostEnv is set to a
+// loopback host:port, e.g., localhost:9099 or 127.0.0.1:9099.
+func emulatorHostIsLoopback() bool {
+	host, _, err := net.SplitHostPort(os.Getenv(EmulatorHostEnv))
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// unsignedKeyResolver is the KeyResolver of PresetEmulator. It has no keys,
+// but makes VerifyTokenWithResolver accept the unsigned tokens of the
+// emulator while EmulatorHostEnv is set to a loopback host.
+type unsignedKeyResolver struct{}
+
+// ResolveKey implements the KeyResolver interface.
+func (unsignedKeyResolver) ResolveKey(keyID, algorithm string) (crypto.PublicKey, error) {
+	return nil, ErrKeyNotFound
+}
@@ -0,0 +1,192 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestPresetSettings(t *testing.T) {
+	os.Setenv(EmulatorHostEnv, "127.0.0.1:9000")
+	defer os.Unsetenv(EmulatorHostEnv)
+	tests := []struct {
+		preset    Preset
+		projectID string
+		issuer    string
+		certsURL  string
+		baseURL   string
+		version   string
+		err       bool
+	}{
+		{PresetGitkit, "", GitkitIssuer, publicCertsURL, LegacyAPIBaseURL, APIv3, false},
+		{PresetFirebaseAuth, "p", "https://securetoken.google.com/p", SecureTokenCertsURL, IdentityPlatformAPIBaseURL, APIv3, false},
+		{PresetIdentityPlatform, "p", "https://securetoken.google.com/p", SecureTokenCertsURL, IdentityPlatformV1BaseURL, APIv1, false},
+		{PresetEmulator, "p", "https://securetoken.google.com/p", "", "http://127.0.0.1:9000/www.googleapis.com/identitytoolkit/v3/relyingparty", APIv3, false},
+		{PresetFirebaseAuth, "", "", "", "", "", true},
+		{"other", "p", "", "", "", "", true},
+	}
+	for _, tt := range tests {
+		s, err := tt.preset.Settings(tt.projectID)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s.Settings(%q) returns no error", tt.preset, tt.projectID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s.Settings(%q) returns error: %v", tt.preset, tt.projectID, err)
+			continue
+		}
+		if len(s.Issuers) != 1 || s.Issuers[0] != tt.issuer || s.CertsURL != tt.certsURL || s.APIBaseURL != tt.baseURL || s.APIVersion != tt.version {
+			t.Errorf("%s.Settings(%q) = %+v; want issuer %s, certs %q, base %s, version %s", tt.preset, tt.projectID, s, tt.issuer, tt.certsURL, tt.baseURL, tt.version)
+		}
+	}
+}
+
+func TestNew_preset(t *testing.T) {
+	c, err := New(context.Background(), &Config{
+		Endpoint: &Endpoint{Overrides: map[string]string{"getProjectConfig": "http://localhost/config"}},
+	}, WithPreset(PresetFirebaseAuth, "p"), WithTokenSource(staticTokenSource{}))
+	if err != nil {
+		t.Fatalf("New() returns error: %v", err)
+	}
+	if c.certs.URL != SecureTokenCertsURL {
+		t.Errorf("certificates URL = %s; want %s", c.certs.URL, SecureTokenCertsURL)
+	}
+	if c.api.BaseURL != IdentityPlatformAPIBaseURL {
+		t.Errorf("API base URL = %s; want %s", c.api.BaseURL, IdentityPlatformAPIBaseURL)
+	}
+	if len(c.config.Endpoint.Overrides) != 1 {
+		t.Errorf("endpoint overrides = %v; want them kept", c.config.Endpoint.Overrides)
+	}
+	if len(c.config.Issuers) != 1 || c.config.Issuers[0] != "https://securetoken.google.com/p" {
+		t.Errorf("issuers = %v; want the securetoken issuer of the project", c.config.Issuers)
+	}
+
+	// The explicit settings take precedence.
+	c, err = New(context.Background(), &Config{Issuers: []string{issuer}},
+		WithPreset(PresetIdentityPlatform, "p"), WithAPIVersion(APIv3, "p"), WithAPIEndpoint("http://localhost/staging"),
+		WithTokenSource(staticTokenSource{}))
+	if err != nil {
+		t.Fatalf("New() returns error: %v", err)
+	}
+	if c.config.APIVersion != APIv3 || c.api.BaseURL != "http://localhost/staging" {
+		t.Errorf("API %s at %s; want v3 at the staging endpoint", c.config.APIVersion, c.api.BaseURL)
+	}
+	if len(c.config.Issuers) != 1 || c.config.Issuers[0] != issuer {
+		t.Errorf("issuers = %v; want [%s]", c.config.Issuers, issuer)
+	}
+
+	if _, err := New(context.Background(), &Config{}, WithPreset(PresetIdentityPlatform, ""), WithTokenSource(staticTokenSource{})); err == nil {
+		t.Error("New() without the project ID of the preset returns no error")
+	}
+}
+
+func TestClient_ValidateToken_preset(t *testing.T) {
+	certs := initCerts()
+	c := &Client{config: &Config{Preset: PresetGitkit, KeyResolver: certs}}
+	if _, err := applyPreset(c.config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ValidateToken(context.Background(), validToken, []string{audience}); err != nil {
+		t.Errorf("ValidateToken() of a Gitkit token returns error: %v", err)
+	}
+	c.config = &Config{Preset: PresetFirebaseAuth, ProjectID: "p", KeyResolver: certs}
+	if _, err := applyPreset(c.config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ValidateToken(context.Background(), validToken, []string{audience}); err != ErrInvalidIssuer {
+		t.Errorf("ValidateToken() of a Gitkit token with the Firebase preset returns error %v; want ErrInvalidIssuer", err)
+	}
+}
+
+// unsignedToken returns an unsigned token with the claims, like those of the
+// emulator.
+func unsignedToken(claims map[string]interface{}) string {
+	b, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(b) + "."
+}
+
+func TestClient_ValidateToken_emulator(t *testing.T) {
+	os.Setenv(EmulatorHostEnv, "localhost:9099")
+	defer os.Unsetenv(EmulatorHostEnv)
+	c, err := New(context.Background(), &Config{}, WithPreset(PresetEmulator, "p"))
+	if err != nil {
+		t.Fatalf("New() returns error: %v", err)
+	}
+	token := unsignedToken(map[string]interface{}{
+		"iss":     "https://securetoken.google.com/p",
+		"aud":     "p",
+		"exp":     time.Now().Add(time.Hour).Unix(),
+		"user_id": "123",
+	})
+	tok, err := c.ValidateToken(context.Background(), token, []string{"p"})
+	if err != nil {
+		t.Fatalf("ValidateToken() of an emulator token returns error: %v", err)
+	}
+	if tok.LocalID != "123" {
+		t.Errorf("LocalID = %s; want 123", tok.LocalID)
+	}
+
+	// Unsigned tokens are rejected otherwise.
+	if _, err := VerifyTokenWithResolver(token, []string{"p"}, nil, initCerts()); err != ErrInvalidAlgorithm {
+		t.Errorf("VerifyTokenWithResolver() of an unsigned token returns error %v; want ErrInvalidAlgorithm", err)
+	}
+	// Including by the emulator Client once the variable is unset.
+	os.Unsetenv(EmulatorHostEnv)
+	if _, err := c.ValidateToken(context.Background(), token, []string{"p"}); err != ErrInvalidAlgorithm {
+		t.Errorf("ValidateToken() of an unsigned token without %s returns error %v; want ErrInvalidAlgorithm", EmulatorHostEnv, err)
+	}
+}
+
+func TestNew_emulatorRequiresLoopbackHost(t *testing.T) {
+	defer os.Unsetenv(EmulatorHostEnv)
+	tests := []struct {
+		host string
+		ok   bool
+	}{
+		{"", false},
+		{"auth.example.com:9099", false},
+		{"10.0.0.1:9099", false},
+		{"localhost", false},
+		{"localhost:9099", true},
+		{"127.0.0.1:9099", true},
+		{"[::1]:9099", true},
+	}
+	for _, tt := range tests {
+		os.Setenv(EmulatorHostEnv, tt.host)
+		// As loaded by LoadConfig.
+		var conf Config
+		if err := json.Unmarshal([]byte(`{"preset": "emulator", "projectId": "p"}`), &conf); err != nil {
+			t.Fatal(err)
+		}
+		_, err := New(context.Background(), &conf)
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("New() of the emulator preset with %s=%q returns error %v; want success %v", EmulatorHostEnv, tt.host, err, tt.ok)
+		}
+	}
+	// A key resolver does not need the emulator host.
+	os.Unsetenv(EmulatorHostEnv)
+	if _, err := New(context.Background(), &Config{KeyResolver: initCerts()}, WithPreset(PresetEmulator, "p")); err != nil {
+		t.Errorf("New() of the emulator preset with a KeyResolver returns error: %v", err)
+	}
+}
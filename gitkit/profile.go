@@ -0,0 +1,186 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// maxUserInfoBody is the maximum size of the userinfo responses.
+const maxUserInfoBody = 1 << 20
+
+// UserInfoEndpoints are the OAuth2 userinfo endpoints of the well known IDPs by
+// provider ID. Config.UserInfoEndpoints adds to or overrides them.
+var UserInfoEndpoints = map[string]string{
+	"google.com":    "https://openidconnect.googleapis.com/v1/userinfo",
+	"facebook.com":  "https://graph.facebook.com/me?fields=id,name,first_name,last_name,email,locale,picture",
+	"microsoft.com": "https://graph.microsoft.com/oidc/userinfo",
+	"yahoo.com":     "https://api.login.yahoo.com/openid/v1/userinfo",
+}
+
+// ErrNoUserInfoEndpoint is returned by FetchProfile for an IDP without a
+// userinfo endpoint.
+var ErrNoUserInfoEndpoint = errors.New("gitkit: no userinfo endpoint for the provider")
+
+// A Profile is the normalized profile of a user at an IDP.
+type Profile struct {
+	// ProviderID is the IDP, e.g., google.com.
+	ProviderID string `json:"providerId,omitempty"`
+	// Subject is the ID of the user at the IDP.
+	Subject       string `json:"subject,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"emailVerified,omitempty"`
+	Name          string `json:"name,omitempty"`
+	GivenName     string `json:"givenName,omitempty"`
+	FamilyName    string `json:"familyName,omitempty"`
+	// Locale is the BCP 47 language tag of the user, e.g., en-US.
+	Locale   string `json:"locale,omitempty"`
+	PhotoURL string `json:"photoUrl,omitempty"`
+	// FetchedAt is when the profile was fetched from the userinfo endpoint,
+	// i.e., how fresh EmailVerified is. It is zero if the profile only holds
+	// the information of the sign in.
+	FetchedAt time.Time `json:"fetchedAt,omitempty"`
+}
+
+// userInfo is the union of the OpenID Connect and Facebook userinfo fields.
+type userInfo struct {
+	Sub           string          `json:"sub"`
+	ID            string          `json:"id"`
+	Email         string          `json:"email"`
+	EmailVerified interface{}     `json:"email_verified"` // A bool, or a string for some IDPs.
+	Name          string          `json:"name"`
+	GivenName     string          `json:"given_name"`
+	FamilyName    string          `json:"family_name"`
+	FirstName     string          `json:"first_name"`
+	LastName      string          `json:"last_name"`
+	Locale        string          `json:"locale"`
+	Picture       json.RawMessage `json:"picture"` // A URL, or {"data": {"url": ...}} on Facebook.
+}
+
+// merge overrides the fields of p with the non-empty fields of info.
+func (info *userInfo) merge(p *Profile) {
+	set := func(dst *string, vs ...string) {
+		for _, v := range vs {
+			if v != "" {
+				*dst = v
+				return
+			}
+		}
+	}
+	set(&p.Subject, info.Sub, info.ID)
+	set(&p.Email, info.Email)
+	set(&p.Name, info.Name)
+	set(&p.GivenName, info.GivenName, info.FirstName)
+	set(&p.FamilyName, info.FamilyName, info.LastName)
+	set(&p.Locale, info.Locale)
+	switch v := info.EmailVerified.(type) {
+	case bool:
+		p.EmailVerified = v
+	case string:
+		p.EmailVerified = v == "true"
+	}
+	var photo string
+	if json.Unmarshal(info.Picture, &photo) != nil {
+		var fb struct {
+			Data struct {
+				URL string `json:"url"`
+			} `json:"data"`
+		}
+		json.Unmarshal(info.Picture, &fb)
+		photo = fb.Data.URL
+	}
+	set(&p.PhotoURL, photo)
+}
+
+// userInfoEndpoint returns the userinfo endpoint of the IDP.
+func (c *Client) userInfoEndpoint(providerID string) string {
+	if c.config == nil {
+		return UserInfoEndpoints[providerID]
+	}
+	if u, ok := c.config.UserInfoEndpoints[providerID]; ok {
+		return u
+	}
+	return UserInfoEndpoints[providerID]
+}
+
+// FetchProfile fetches the profile of the user from the userinfo endpoint of
+// the IDP of providerID, authorized by the OAuth2 access token of the user,
+// e.g., the OAuthAccessToken returned by VerifyAssertion.
+func (c *Client) FetchProfile(ctx context.Context, providerID, accessToken string) (*Profile, error) {
+	p := &Profile{ProviderID: providerID}
+	if err := c.fetchProfile(ctx, p, accessToken); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// fetchProfile fetches the userinfo of p.ProviderID into p.
+func (c *Client) fetchProfile(ctx context.Context, p *Profile, accessToken string) error {
+	u := c.userInfoEndpoint(p.ProviderID)
+	if u == "" {
+		return ErrNoUserInfoEndpoint
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	hc := &http.Client{Transport: defaultTransport(ctx)}
+	if c.config != nil && c.config.HTTPClient != nil {
+		hc = c.config.HTTPClient
+	}
+	resp, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gitkit: userinfo of %s: %s", p.ProviderID, resp.Status)
+	}
+	var info userInfo
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxUserInfoBody)).Decode(&info); err != nil {
+		return fmt.Errorf("gitkit: userinfo of %s: %v", p.ProviderID, err)
+	}
+	info.merge(p)
+	p.FetchedAt = time.Now()
+	return nil
+}
+
+// EnrichProfile returns the profile of the user signed in by VerifyAssertion:
+// the information of the response, refreshed from the userinfo endpoint of the
+// IDP if it is known and the response has an OAuth2 access token. On a fetch
+// error, the profile of the response is returned with the error.
+func (c *Client) EnrichProfile(ctx context.Context, resp *VerifyAssertionResponse) (*Profile, error) {
+	p := &Profile{
+		ProviderID:    resp.ProviderID,
+		Email:         resp.Email,
+		EmailVerified: resp.EmailVerified,
+		Name:          resp.DisplayName,
+		GivenName:     resp.FirstName,
+		FamilyName:    resp.LastName,
+		PhotoURL:      resp.PhotoURL,
+	}
+	if resp.OAuthAccessToken == "" || c.userInfoEndpoint(resp.ProviderID) == "" {
+		return p, nil
+	}
+	return p, c.fetchProfile(ctx, p, resp.OAuthAccessToken)
+}
@@ -0,0 +1,124 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestFetchProfile(t *testing.T) {
+	tests := []struct {
+		status  int
+		body    string
+		want    Profile
+		wantErr bool
+	}{
+		{
+			http.StatusOK,
+			`{"sub":"1","email":"user@example.com","email_verified":true,"name":"Jane Doe","given_name":"Jane","family_name":"Doe","locale":"en-GB","picture":"https://example.com/p.jpg"}`,
+			Profile{Subject: "1", Email: "user@example.com", EmailVerified: true, Name: "Jane Doe", GivenName: "Jane", FamilyName: "Doe", Locale: "en-GB", PhotoURL: "https://example.com/p.jpg"},
+			false,
+		},
+		{
+			http.StatusOK,
+			`{"id":"2","email":"user@example.com","name":"Jane Doe","first_name":"Jane","last_name":"Doe","locale":"fr_FR","picture":{"data":{"url":"https://example.com/fb.jpg"}}}`,
+			Profile{Subject: "2", Email: "user@example.com", Name: "Jane Doe", GivenName: "Jane", FamilyName: "Doe", Locale: "fr_FR", PhotoURL: "https://example.com/fb.jpg"},
+			false,
+		},
+		{
+			http.StatusOK,
+			`{"sub":"3","email":"user@example.com","email_verified":"true"}`,
+			Profile{Subject: "3", Email: "user@example.com", EmailVerified: true},
+			false,
+		},
+		{http.StatusUnauthorized, `{"error":"invalid_token"}`, Profile{}, true},
+		{http.StatusOK, `not JSON`, Profile{}, true},
+	}
+	for i, tt := range tests {
+		var auth string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth = r.Header.Get("Authorization")
+			w.WriteHeader(tt.status)
+			fmt.Fprint(w, tt.body)
+		}))
+		c := &Client{config: &Config{UserInfoEndpoints: map[string]string{"example.com": ts.URL}}}
+		p, err := c.FetchProfile(context.Background(), "example.com", "access")
+		ts.Close()
+		if auth != "Bearer access" {
+			t.Errorf("[%d] Authorization = %q; want %q", i, auth, "Bearer access")
+		}
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("[%d] FetchProfile() returns no error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%d] FetchProfile() returns error: %v", i, err)
+			continue
+		}
+		if p.FetchedAt.IsZero() {
+			t.Errorf("[%d] FetchProfile().FetchedAt is zero", i)
+		}
+		p.FetchedAt = tt.want.FetchedAt
+		tt.want.ProviderID = "example.com"
+		if *p != tt.want {
+			t.Errorf("[%d] FetchProfile() = %+v; want %+v", i, p, tt.want)
+		}
+	}
+
+	c := &Client{config: &Config{}}
+	if _, err := c.FetchProfile(context.Background(), "saml.example", "access"); err != ErrNoUserInfoEndpoint {
+		t.Errorf("FetchProfile() of an unknown IDP returns error %v; want %v", err, ErrNoUserInfoEndpoint)
+	}
+}
+
+func TestVerifyAssertion_enrichProfiles(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"sub":"1","email_verified":false,"locale":"de"}`)
+	}))
+	defer ts.Close()
+	rt := roundTripper{http.StatusOK, `{"idToken":"token","localId":"123","providerId":"google.com","email":"user@example.com","emailVerified":true,"firstName":"Jane","oauthAccessToken":"access"}`}
+	c := &Client{
+		config: &Config{EnrichProfiles: true, UserInfoEndpoints: map[string]string{"google.com": ts.URL}},
+		api:    &APIClient{Client: http.Client{Transport: rt}},
+	}
+	req, _ := http.NewRequest("GET", "http://www.example.com/callback?code=abc", nil)
+	resp, err := c.VerifyAssertion(context.Background(), req)
+	if err != nil {
+		t.Fatalf("VerifyAssertion() returns error: %v", err)
+	}
+	p := resp.Profile
+	if p == nil {
+		t.Fatal("VerifyAssertion() returns no profile")
+	}
+	if p.Subject != "1" || p.Locale != "de" || p.GivenName != "Jane" || p.Email != "user@example.com" || p.EmailVerified {
+		t.Errorf("Profile = %+v; want the assertion refreshed by the userinfo", p)
+	}
+
+	// A userinfo failure doesn't fail the sign in.
+	ts.Close()
+	if resp, err = c.VerifyAssertion(context.Background(), req); err != nil {
+		t.Fatalf("VerifyAssertion() returns error: %v", err)
+	}
+	if p := resp.Profile; p == nil || !p.FetchedAt.IsZero() || p.GivenName != "Jane" {
+		t.Errorf("Profile = %+v; want the profile of the assertion", p)
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/net/context"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigFormat is the format of a ProjectConfigDocument.
+type ConfigFormat string
+
+// The formats of the project configuration documents.
+const (
+	ConfigFormatYAML ConfigFormat = "yaml"
+	ConfigFormatJSON ConfigFormat = "json"
+)
+
+// A ProjectConfigDocument is the declarative configuration of a project: the
+// identity providers, the authorized domains and the templates of the emails.
+// It is written by Client.ExportProjectConfig and applied by
+// Client.ApplyProjectConfig, e.g., to review the configuration in a version
+// control system and promote it from a staging project to production.
+//
+// The fields which are nil or empty are left unchanged when the document is
+// applied: the API cannot clear the lists of the providers and the domains.
+type ProjectConfigDocument struct {
+	AllowPasswordUser   *bool `json:"allowPasswordUser,omitempty"`
+	EnableAnonymousUser *bool `json:"enableAnonymousUser,omitempty"`
+	// IdpConfigs replace the configurations of the identity providers. The
+	// secrets of the providers are never exported: they must be added to
+	// the document, e.g., from a secret store, to be changed.
+	IdpConfigs            []*IdpConfig   `json:"idpConfigs,omitempty"`
+	AuthorizedDomains     []string       `json:"authorizedDomains,omitempty"`
+	UseEmailSending       *bool          `json:"useEmailSending,omitempty"`
+	ResetPasswordTemplate *EmailTemplate `json:"resetPasswordTemplate,omitempty"`
+	ChangeEmailTemplate   *EmailTemplate `json:"changeEmailTemplate,omitempty"`
+	VerifyEmailTemplate   *EmailTemplate `json:"verifyEmailTemplate,omitempty"`
+}
+
+// Document returns the declarative configuration of the project. The fields
+// which do not depend on the project, e.g., the API key, are left out.
+func (pc *ProjectConfig) Document() *ProjectConfigDocument {
+	allowPassword, enableAnonymous, useEmailSending := pc.AllowPasswordUser, pc.EnableAnonymousUser, pc.UseEmailSending
+	d := &ProjectConfigDocument{
+		AllowPasswordUser:     &allowPassword,
+		EnableAnonymousUser:   &enableAnonymous,
+		AuthorizedDomains:     append([]string(nil), pc.AuthorizedDomains...),
+		UseEmailSending:       &useEmailSending,
+		ResetPasswordTemplate: copyTemplate(pc.ResetPasswordTemplate),
+		ChangeEmailTemplate:   copyTemplate(pc.ChangeEmailTemplate),
+		VerifyEmailTemplate:   copyTemplate(pc.VerifyEmailTemplate),
+	}
+	for _, idp := range pc.IdpConfigs {
+		cp := *idp
+		cp.Secret = ""
+		cp.WhitelistedAudiences = append([]string(nil), idp.WhitelistedAudiences...)
+		d.IdpConfigs = append(d.IdpConfigs, &cp)
+	}
+	return d
+}
+
+func copyTemplate(t *EmailTemplate) *EmailTemplate {
+	if t == nil {
+		return nil
+	}
+	cp := *t
+	return &cp
+}
+
+// Encode writes the document to w in the format. The keys of the YAML
+// documents are sorted, so that the documents of the same configuration are
+// identical.
+func (d *ProjectConfigDocument) Encode(w io.Writer, format ConfigFormat) error {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	switch format {
+	case ConfigFormatJSON:
+		b = append(b, '\n')
+	case ConfigFormatYAML:
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+		if b, err = yaml.Marshal(v); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("gitkit: unknown project configuration format %q", format)
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// DecodeProjectConfigDocument reads a document in the YAML or JSON format
+// from r. The unknown fields are errors, so that the typos are not silently
+// ignored.
+func DecodeProjectConfigDocument(r io.Reader) (*ProjectConfigDocument, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is a subset of YAML: the documents of both formats are converted
+	// to JSON to be decoded with the JSON tags of the API types.
+	var v interface{}
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("gitkit: invalid project configuration: %v", err)
+	}
+	v, err = jsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = json.Marshal(v); err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	d := &ProjectConfigDocument{}
+	if err := dec.Decode(d); err != nil {
+		return nil, fmt.Errorf("gitkit: invalid project configuration: %v", err)
+	}
+	return d, nil
+}
+
+// jsonValue converts the maps of the YAML values, whose keys may be of any
+// type, to JSON objects.
+func jsonValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			s, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("gitkit: invalid project configuration: key %v is not a string", k)
+			}
+			var err error
+			if m[s], err = jsonValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		for i, e := range v {
+			var err error
+			if v[i], err = jsonValue(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// Request returns the request applying the document to the project selected
+// by opts, or to the project of the credentials if opts is nil.
+func (d *ProjectConfigDocument) Request(opts *ProjectConfigOptions) *SetProjectConfigRequest {
+	req := &SetProjectConfigRequest{
+		AllowPasswordUser:     d.AllowPasswordUser,
+		EnableAnonymousUser:   d.EnableAnonymousUser,
+		IdpConfigs:            d.IdpConfigs,
+		AuthorizedDomains:     d.AuthorizedDomains,
+		UseEmailSending:       d.UseEmailSending,
+		ResetPasswordTemplate: d.ResetPasswordTemplate,
+		ChangeEmailTemplate:   d.ChangeEmailTemplate,
+		VerifyEmailTemplate:   d.VerifyEmailTemplate,
+	}
+	if opts != nil {
+		req.DelegatedProjectNumber = opts.DelegatedProjectNumber
+	}
+	return req
+}
+
+// ExportProjectConfig writes the configuration of the project of the Client
+// credentials to w as a ProjectConfigDocument in the format.
+//
+// For example,
+//
+//	err := c.ExportProjectConfig(ctx, os.Stdout, gitkit.ConfigFormatYAML)
+func (c *Client) ExportProjectConfig(ctx context.Context, w io.Writer, format ConfigFormat) error {
+	pc, err := c.ProjectConfig(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return pc.Document().Encode(w, format)
+}
+
+// ApplyProjectConfig reads a ProjectConfigDocument in the YAML or JSON format
+// from r and applies it to the project of the Client credentials. Nothing is
+// changed if the document is invalid.
+func (c *Client) ApplyProjectConfig(ctx context.Context, r io.Reader) error {
+	d, err := DecodeProjectConfigDocument(r)
+	if err != nil {
+		return err
+	}
+	_, err = c.mutatingAPIClient(ctx).SetProjectConfig(ctx, d.Request(nil))
+	return err
+}
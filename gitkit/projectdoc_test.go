@@ -0,0 +1,132 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+const projectDocConfigJSON = `{
+	"projectId": "staging",
+	"apiKey": "key",
+	"allowPasswordUser": true,
+	"idpConfig": [{"provider": "GOOGLE", "enabled": true, "clientId": "client", "experimentPercent": 50}],
+	"authorizedDomains": ["example.com"],
+	"verifyEmailTemplate": {"subject": "Verify", "body": "%LINK%", "format": "HTML"}
+}`
+
+func TestExportProjectConfig_roundTrip(t *testing.T) {
+	for _, format := range []ConfigFormat{ConfigFormatYAML, ConfigFormatJSON} {
+		rt := &methodRoundTripper{resps: map[string]string{
+			"getProjectConfig": projectDocConfigJSON,
+			"setProjectConfig": `{"projectId": "production"}`,
+		}}
+		c := &Client{config: &Config{}, api: &APIClient{Client: http.Client{Transport: rt}}}
+		var buf bytes.Buffer
+		if err := c.ExportProjectConfig(context.Background(), &buf, format); err != nil {
+			t.Fatalf("%s: ExportProjectConfig() returns error: %v", format, err)
+		}
+		if strings.Contains(buf.String(), "staging") || strings.Contains(buf.String(), "key") {
+			t.Errorf("%s: ExportProjectConfig() writes the settings of the project: %s", format, buf.String())
+		}
+		if err := c.ApplyProjectConfig(context.Background(), &buf); err != nil {
+			t.Fatalf("%s: ApplyProjectConfig() returns error: %v", format, err)
+		}
+		if len(rt.reqs) != 2 || !strings.HasPrefix(rt.reqs[1], "setProjectConfig ") {
+			t.Fatalf("%s: calls %q; want getProjectConfig and setProjectConfig", format, rt.reqs)
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(rt.reqs[1], "setProjectConfig ")), &got); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]interface{}{
+			"allowPasswordUser":   true,
+			"enableAnonymousUser": false,
+			"useEmailSending":     false,
+			"idpConfig": []interface{}{map[string]interface{}{
+				"provider": "GOOGLE", "enabled": true, "clientId": "client", "experimentPercent": float64(50),
+			}},
+			"authorizedDomains":   []interface{}{"example.com"},
+			"verifyEmailTemplate": map[string]interface{}{"subject": "Verify", "body": "%LINK%", "format": "HTML"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: ApplyProjectConfig() sends %v; want %v", format, got, want)
+		}
+	}
+}
+
+func TestDecodeProjectConfigDocument(t *testing.T) {
+	d, err := DecodeProjectConfigDocument(strings.NewReader(`
+authorizedDomains:
+  - example.com
+idpConfigs:
+  - provider: GOOGLE
+    enabled: true
+    secret: s3cret
+`))
+	if err != nil {
+		t.Fatalf("DecodeProjectConfigDocument() returns error: %v", err)
+	}
+	want := &ProjectConfigDocument{
+		AuthorizedDomains: []string{"example.com"},
+		IdpConfigs:        []*IdpConfig{{Provider: "GOOGLE", Enabled: true, Secret: "s3cret"}},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("DecodeProjectConfigDocument() = %+v; want %+v", d, want)
+	}
+
+	for _, doc := range []string{
+		`authorisedDomains: [example.com]`,
+		`{"allowPasswordUser": "yes"}`,
+		`1: true`,
+		`: [`,
+	} {
+		if _, err := DecodeProjectConfigDocument(strings.NewReader(doc)); err == nil {
+			t.Errorf("DecodeProjectConfigDocument(%q) returns no error", doc)
+		}
+	}
+}
+
+func TestApplyProjectConfig_invalid(t *testing.T) {
+	rt := &methodRoundTripper{}
+	c := &Client{config: &Config{}, api: &APIClient{Client: http.Client{Transport: rt}}}
+	if err := c.ApplyProjectConfig(context.Background(), strings.NewReader(`unknown: true`)); err == nil {
+		t.Error("ApplyProjectConfig() of an invalid document returns no error")
+	}
+	if len(rt.reqs) != 0 {
+		t.Errorf("ApplyProjectConfig() of an invalid document calls %q", rt.reqs)
+	}
+	if err := c.ExportProjectConfig(context.Background(), &bytes.Buffer{}, "toml"); err == nil {
+		t.Error("ExportProjectConfig() in an unknown format returns no error")
+	}
+}
+
+func TestProjectConfig_Document(t *testing.T) {
+	pc := &ProjectConfig{IdpConfigs: []*IdpConfig{{Provider: "FACEBOOK", Secret: "s3cret"}}}
+	d := pc.Document()
+	if d.IdpConfigs[0].Secret != "" {
+		t.Error("Document() exports the secrets of the providers")
+	}
+	if pc.IdpConfigs[0].Secret != "s3cret" {
+		t.Error("Document() modifies the configuration")
+	}
+}
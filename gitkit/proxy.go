@@ -0,0 +1,125 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// parseTrustedProxies parses the IP addresses and CIDR ranges of
+// Config.TrustedProxies.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %s", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s", p)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// trustedProxy reports whether the IP address is one of Config.TrustedProxies.
+func (c *Client) trustedProxy(addr string) bool {
+	if len(c.proxies) == 0 {
+		return false
+	}
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return false
+	}
+	for _, n := range c.proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestURL returns the URL of the request as seen by the browser, without
+// the query. The scheme and host are taken from Config.BaseURL if set, or
+// from the X-Forwarded-Proto and X-Forwarded-Host headers set by a trusted
+// proxy, or from the request itself.
+func (c *Client) requestURL(req *http.Request) *url.URL {
+	u := extractRequestURL(req)
+	if c.config != nil && c.config.BaseURL != "" {
+		// BaseURL is validated by New.
+		if base, err := url.Parse(c.config.BaseURL); err == nil {
+			u.Scheme, u.Host = base.Scheme, base.Host
+			return u
+		}
+	}
+	if !c.trustedProxy(extractRemoteIP(req)) {
+		return u
+	}
+	if proto := strings.ToLower(firstHeaderValue(req, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+		u.Scheme = proto
+	}
+	if host := firstHeaderValue(req, "X-Forwarded-Host"); host != "" {
+		u.Host = host
+	}
+	return u
+}
+
+// remoteIP returns the IP address of the client which sent the request. Behind
+// trusted proxies, it is the last address of the X-Forwarded-For header which
+// is not a trusted proxy.
+func (c *Client) remoteIP(req *http.Request) string {
+	ip := extractRemoteIP(req)
+	if !c.trustedProxy(ip) {
+		return ip
+	}
+	var hops []string
+	for _, h := range req.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !c.trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// firstHeaderValue returns the first comma separated value of the header.
+func firstHeaderValue(req *http.Request, key string) string {
+	v := req.Header.Get(key)
+	if i := strings.Index(v, ","); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,118 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		proxies []string
+		ok      bool
+	}{
+		{nil, true},
+		{[]string{"10.0.0.1", "10.1.0.0/16", "::1", "fd00::/8"}, true},
+		{[]string{"10.0.0.256"}, false},
+		{[]string{"10.0.0.0/33"}, false},
+		{[]string{"proxy.example.com"}, false},
+	}
+	for i, tt := range tests {
+		if _, err := parseTrustedProxies(tt.proxies); (err == nil) != tt.ok {
+			t.Errorf("%d. parseTrustedProxies(%v) returns error %v; want ok %v", i, tt.proxies, err, tt.ok)
+		}
+	}
+}
+
+func newProxyRequest(remoteAddr string, header map[string]string) *http.Request {
+	req := &http.Request{
+		Host:       "internal:8080",
+		URL:        &url.URL{Path: "/path", RawQuery: "a=b"},
+		RemoteAddr: remoteAddr,
+		Header:     make(http.Header),
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	return req
+}
+
+func TestRequestURL(t *testing.T) {
+	forwarded := map[string]string{
+		"X-Forwarded-Proto": "HTTPS",
+		"X-Forwarded-Host":  "www.example.com, internal:8080",
+	}
+	tests := []struct {
+		config *Config
+		req    *http.Request
+		url    string
+	}{
+		{nil, newProxyRequest("10.0.0.1:1234", forwarded), "http://internal:8080/path"},
+		{&Config{}, newProxyRequest("10.0.0.1:1234", forwarded), "http://internal:8080/path"},
+		{
+			&Config{TrustedProxies: []string{"10.0.0.0/8"}},
+			newProxyRequest("10.0.0.1:1234", forwarded),
+			"https://www.example.com/path",
+		},
+		{
+			&Config{TrustedProxies: []string{"10.0.0.0/8"}},
+			newProxyRequest("192.168.0.1:1234", forwarded),
+			"http://internal:8080/path",
+		},
+		{
+			&Config{TrustedProxies: []string{"10.0.0.1"}},
+			newProxyRequest("10.0.0.1:1234", map[string]string{"X-Forwarded-Proto": "gopher"}),
+			"http://internal:8080/path",
+		},
+		{
+			&Config{BaseURL: "https://www.example.com", TrustedProxies: []string{"10.0.0.1"}},
+			newProxyRequest("10.0.0.1:1234", map[string]string{"X-Forwarded-Host": "evil.example.com"}),
+			"https://www.example.com/path",
+		},
+	}
+	for i, tt := range tests {
+		c := &Client{config: tt.config}
+		if tt.config != nil {
+			c.proxies, _ = parseTrustedProxies(tt.config.TrustedProxies)
+		}
+		if u := c.requestURL(tt.req); u.String() != tt.url {
+			t.Errorf("%d. requestURL() = %q; want %q", i, u.String(), tt.url)
+		}
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	tests := []struct {
+		proxies []string
+		req     *http.Request
+		ip      string
+	}{
+		{nil, newProxyRequest("10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}), "10.0.0.1"},
+		{[]string{"10.0.0.0/8"}, newProxyRequest("10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}), "1.2.3.4"},
+		{[]string{"10.0.0.0/8"}, newProxyRequest("10.0.0.1:1234", map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.0.0.2"}), "1.2.3.4"},
+		{[]string{"10.0.0.0/8"}, newProxyRequest("10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}), "10.0.0.3"},
+		{[]string{"10.0.0.0/8"}, newProxyRequest("10.0.0.1:1234", map[string]string{"X-Forwarded-For": "unknown, 10.0.0.2"}), "10.0.0.2"},
+		{[]string{"10.0.0.0/8"}, newProxyRequest("10.0.0.1:1234", nil), "10.0.0.1"},
+	}
+	for i, tt := range tests {
+		proxies, _ := parseTrustedProxies(tt.proxies)
+		c := &Client{config: &Config{TrustedProxies: tt.proxies}, proxies: proxies}
+		if ip := c.remoteIP(tt.req); ip != tt.ip {
+			t.Errorf("%d. remoteIP() = %q; want %q", i, ip, tt.ip)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// QuarantineAttribute is the custom attribute which holds the Unix time in
+// seconds at which a user was quarantined by QuarantineUser.
+const QuarantineAttribute = "gitkitQuarantinedAt"
+
+// QuarantinedAt returns the time at which the user was quarantined, if the user
+// is quarantined.
+func (u *User) QuarantinedAt() (time.Time, bool) {
+	if !u.Disabled || u.CustomAttributes == "" {
+		return time.Time{}, false
+	}
+	var attrs map[string]interface{}
+	if err := json.Unmarshal([]byte(u.CustomAttributes), &attrs); err != nil {
+		return time.Time{}, false
+	}
+	sec, ok := attrs[QuarantineAttribute].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(sec), 0), true
+}
+
+// setQuarantineAttribute returns the custom attributes JSON object attrs with
+// the quarantine time set, or removed if t is zero. The other attributes are
+// kept as they are.
+func setQuarantineAttribute(attrs string, t time.Time) (string, error) {
+	m := make(map[string]json.RawMessage)
+	if attrs != "" {
+		if err := json.Unmarshal([]byte(attrs), &m); err != nil {
+			return "", err
+		}
+	}
+	if t.IsZero() {
+		delete(m, QuarantineAttribute)
+	} else {
+		m[QuarantineAttribute] = json.RawMessage(strconv.FormatInt(t.Unix(), 10))
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// QuarantineUser disables the user specified by the local ID and records when
+// it was quarantined in its custom attributes, the first phase of a deletion.
+// The user is permanently deleted by SweepQuarantinedUsers once the grace
+// period is over, and can be restored with RestoreUser until then.
+func (c *Client) QuarantineUser(ctx context.Context, user *User) error {
+	err := c.setQuarantine(ctx, user.LocalID, time.Now())
+	c.audit(ctx, AuditOpQuarantine, []string{user.LocalID}, err)
+	return err
+}
+
+// RestoreUser enables the quarantined user specified by the local ID and
+// removes its quarantine record.
+func (c *Client) RestoreUser(ctx context.Context, user *User) error {
+	err := c.setQuarantine(ctx, user.LocalID, time.Time{})
+	c.audit(ctx, AuditOpRestore, []string{user.LocalID}, err)
+	return err
+}
+
+// setQuarantine disables the user and records t if t is not zero. Otherwise,
+// it enables the user and removes the record.
+func (c *Client) setQuarantine(ctx context.Context, localID string, t time.Time) error {
+	u, err := c.UserByLocalID(ctx, localID)
+	if err != nil {
+		return err
+	}
+	attrs, err := setQuarantineAttribute(u.CustomAttributes, t)
+	if err != nil {
+		return err
+	}
+	disable := !t.IsZero()
+	_, err = c.mutatingAPIClient(ctx).SetAccountInfo(ctx, &SetAccountInfoRequest{
+		LocalID:          localID,
+		DisableUser:      &disable,
+		CustomAttributes: attrs,
+	})
+	if err != nil {
+		return err
+	}
+	if c.config.OnUserUpdated != nil {
+		u.Disabled = disable
+		u.CustomAttributes = attrs
+		c.config.OnUserUpdated(ctx, u)
+	}
+	return nil
+}
+
+// SweepQuarantinedUsers permanently deletes the users quarantined for longer
+// than the grace period. It returns the local IDs of the deleted users, and
+// stops at the first error.
+func (c *Client) SweepQuarantinedUsers(ctx context.Context, gracePeriod time.Duration) ([]string, error) {
+	deadline := time.Now().Add(-gracePeriod)
+	var deleted []string
+	var pageToken Cursor
+	for {
+		users, next, err := c.ListUsersN(ctx, maxResultsPerPage, pageToken)
+		if err != nil {
+			return deleted, err
+		}
+		for _, u := range users {
+			if t, ok := u.QuarantinedAt(); !ok || t.After(deadline) {
+				continue
+			}
+			if err := c.DeleteUser(ctx, u); err != nil {
+				return deleted, err
+			}
+			deleted = append(deleted, u.LocalID)
+		}
+		if len(users) == 0 || next == "" {
+			return deleted, nil
+		}
+		pageToken = next
+	}
+}
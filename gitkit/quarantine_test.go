@@ -0,0 +1,155 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// methodRoundTripper responds with the response body for the API method and
+// records the request bodies.
+type methodRoundTripper struct {
+	resps map[string]string
+	reqs  []string // API method and request body.
+}
+
+func (m *methodRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	method := path.Base(req.URL.Path)
+	var b []byte
+	if req.Body != nil {
+		b, _ = ioutil.ReadAll(req.Body)
+	}
+	m.reqs = append(m.reqs, method+" "+string(b))
+	return roundTripper{200, m.resps[method]}.RoundTrip(req)
+}
+
+func TestQuarantinedAt(t *testing.T) {
+	tests := []struct {
+		user User
+		want int64
+		ok   bool
+	}{
+		{User{}, 0, false},
+		{User{CustomAttributes: `{"gitkitQuarantinedAt":100}`}, 0, false},
+		{User{Disabled: true}, 0, false},
+		{User{Disabled: true, CustomAttributes: `{"role":"admin"}`}, 0, false},
+		{User{Disabled: true, CustomAttributes: `{"gitkitQuarantinedAt":100}`}, 100, true},
+	}
+	for i, tt := range tests {
+		got, ok := tt.user.QuarantinedAt()
+		if ok != tt.ok || (ok && got.Unix() != tt.want) {
+			t.Errorf("%d. QuarantinedAt() = %v, %v; want %d, %v", i, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSetQuarantineAttribute(t *testing.T) {
+	tests := []struct {
+		attrs string
+		t     time.Time
+		want  string
+	}{
+		{"", time.Unix(100, 0), `{"gitkitQuarantinedAt":100}`},
+		{`{"id":9007199254740993}`, time.Unix(100, 0), `{"gitkitQuarantinedAt":100,"id":9007199254740993}`},
+		{`{"gitkitQuarantinedAt":100,"id":9007199254740993}`, time.Time{}, `{"id":9007199254740993}`},
+	}
+	for i, tt := range tests {
+		if got, err := setQuarantineAttribute(tt.attrs, tt.t); err != nil || got != tt.want {
+			t.Errorf("%d. setQuarantineAttribute(%q, %v) = %q, %v; want %q", i, tt.attrs, tt.t, got, err, tt.want)
+		}
+	}
+}
+
+func TestQuarantineUser(t *testing.T) {
+	rt := &methodRoundTripper{resps: map[string]string{
+		"getAccountInfo": `{"users":[{"localId":"123","customAttributes":"{\"role\":\"admin\"}"}]}`,
+		"setAccountInfo": `{}`,
+	}}
+	var records []*AuditRecord
+	c := &Client{
+		config: &Config{AuditHook: func(ctx context.Context, r *AuditRecord) { records = append(records, r) }},
+		api:    &APIClient{Client: http.Client{Transport: rt}},
+	}
+	before := time.Now().Unix()
+	if err := c.QuarantineUser(context.Background(), &User{LocalID: "123"}); err != nil {
+		t.Fatal(err)
+	}
+	var req struct {
+		LocalID          string `json:"localId"`
+		DisableUser      *bool  `json:"disableUser"`
+		CustomAttributes string `json:"customAttributes"`
+	}
+	if len(rt.reqs) != 2 {
+		t.Fatalf("got %d requests; want 2", len(rt.reqs))
+	}
+	json.Unmarshal([]byte(rt.reqs[1][len("setAccountInfo "):]), &req)
+	var attrs map[string]interface{}
+	json.Unmarshal([]byte(req.CustomAttributes), &attrs)
+	if req.LocalID != "123" || req.DisableUser == nil || !*req.DisableUser {
+		t.Errorf("setAccountInfo request = %s; want disabled user 123", rt.reqs[1])
+	}
+	if attrs["role"] != "admin" || attrs[QuarantineAttribute].(float64) < float64(before) {
+		t.Errorf("custom attributes = %s; want role and quarantine time", req.CustomAttributes)
+	}
+
+	rt.reqs = nil
+	rt.resps["getAccountInfo"] = fmt.Sprintf(`{"users":[{"localId":"123","disabled":true,"customAttributes":%q}]}`, req.CustomAttributes)
+	if err := c.RestoreUser(context.Background(), &User{LocalID: "123"}); err != nil {
+		t.Fatal(err)
+	}
+	req.DisableUser = nil
+	json.Unmarshal([]byte(rt.reqs[1][len("setAccountInfo "):]), &req)
+	if req.DisableUser == nil || *req.DisableUser || req.CustomAttributes != `{"role":"admin"}` {
+		t.Errorf("setAccountInfo request = %s; want enabled user without quarantine attribute", rt.reqs[1])
+	}
+
+	if len(records) != 2 || records[0].Op != AuditOpQuarantine || records[1].Op != AuditOpRestore {
+		t.Errorf("audit records = %v; want quarantine and restore", records)
+	}
+}
+
+func TestSweepQuarantinedUsers(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour).Unix()
+	recent := time.Now().Add(-time.Hour).Unix()
+	rt := &methodRoundTripper{resps: map[string]string{
+		"downloadAccount": fmt.Sprintf(`{"users":[
+			{"localId":"1"},
+			{"localId":"2","disabled":true,"customAttributes":"{\"gitkitQuarantinedAt\":%d}"},
+			{"localId":"3","disabled":true,"customAttributes":"{\"gitkitQuarantinedAt\":%d}"},
+			{"localId":"4","customAttributes":"{\"gitkitQuarantinedAt\":%d}"}
+		]}`, old, recent, old),
+		"deleteAccount": `{}`,
+	}}
+	c := &Client{config: &Config{}, api: &APIClient{Client: http.Client{Transport: rt}}}
+	deleted, err := c.SweepQuarantinedUsers(context.Background(), 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(deleted, []string{"2"}) {
+		t.Errorf("SweepQuarantinedUsers() = %v; want [2]", deleted)
+	}
+	if len(rt.reqs) != 2 || rt.reqs[1] != `deleteAccount {"localId":"2"}` {
+		t.Errorf("requests = %q; want download and deletion of user 2", rt.reqs)
+	}
+}
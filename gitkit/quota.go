@@ -0,0 +1,123 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// A QuotaError is returned when an identitytoolkit API request is rejected
+// because a quota of the project is exhausted. Batch jobs can wait for
+// RetryAfter before resuming.
+type QuotaError struct {
+	// Reason is the reason reported by the API, e.g., rateLimitExceeded.
+	Reason string
+	// Metric is the exhausted quota metric, if reported.
+	Metric string
+	// Limit is the name of the exceeded quota limit, if reported.
+	Limit string
+	// LimitValue is the value of the exceeded quota limit, or zero if it is
+	// not reported.
+	LimitValue int64
+	// RetryAfter is the wait suggested by the API before retrying, or zero if
+	// none is given.
+	RetryAfter time.Duration
+	// Err is the underlying API error.
+	Err *googleapi.Error
+}
+
+// Error implements the error interface.
+func (e *QuotaError) Error() string {
+	return "gitkit: quota exceeded: " + e.Err.Error()
+}
+
+// quotaReasons are the legacy error reasons of quota errors.
+var quotaReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+	"quotaExceeded":         true,
+	"dailyLimitExceeded":    true,
+	"RATE_LIMIT_EXCEEDED":   true,
+	"RESOURCE_EXHAUSTED":    true,
+}
+
+// quotaErrorBody is the error response body of the Google APIs. Quota errors
+// are described either by the legacy errors list or by the ErrorInfo and
+// RetryInfo details.
+type quotaErrorBody struct {
+	Error struct {
+		Code   int    `json:"code"`
+		Status string `json:"status"`
+		Errors []struct {
+			Reason string `json:"reason"`
+		} `json:"errors"`
+		Details []struct {
+			Type       string            `json:"@type"`
+			Reason     string            `json:"reason"`
+			Metadata   map[string]string `json:"metadata"`
+			RetryDelay string            `json:"retryDelay"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// asQuotaError returns a QuotaError if the API error err, returned for resp,
+// reports an exhausted quota. Otherwise err is returned unchanged.
+func asQuotaError(resp *http.Response, err error) error {
+	ae, ok := err.(*googleapi.Error)
+	if !ok {
+		return err
+	}
+	var b quotaErrorBody
+	json.Unmarshal([]byte(ae.Body), &b)
+	qe := &QuotaError{Err: ae}
+	for _, e := range b.Error.Errors {
+		if quotaReasons[e.Reason] {
+			qe.Reason = e.Reason
+			break
+		}
+	}
+	for _, d := range b.Error.Details {
+		switch d.Type {
+		case "type.googleapis.com/google.rpc.ErrorInfo":
+			if quotaReasons[d.Reason] {
+				qe.Reason = d.Reason
+			}
+			qe.Metric = d.Metadata["quota_metric"]
+			qe.Limit = d.Metadata["quota_limit"]
+			qe.LimitValue, _ = strconv.ParseInt(d.Metadata["quota_limit_value"], 10, 64)
+		case "type.googleapis.com/google.rpc.RetryInfo":
+			qe.RetryAfter, _ = time.ParseDuration(d.RetryDelay)
+		}
+	}
+	if qe.Reason == "" {
+		switch {
+		case b.Error.Status == "RESOURCE_EXHAUSTED":
+			qe.Reason = b.Error.Status
+		case ae.Code == http.StatusTooManyRequests:
+			qe.Reason = "rateLimitExceeded"
+		default:
+			return err
+		}
+	}
+	if qe.RetryAfter == 0 {
+		qe.RetryAfter, _ = retryAfter(resp, time.Now())
+	}
+	return qe
+}
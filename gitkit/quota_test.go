@@ -0,0 +1,86 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+func TestQuotaError(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   *QuotaError // nil if the error is not a quota error.
+	}{
+		{
+			403,
+			`{"error": {"errors": [{"domain": "global", "reason": "forbidden"}], "code": 403, "message": "Forbidden"}}`,
+			nil,
+		},
+		{
+			403,
+			`{"error": {"errors": [{"domain": "usageLimits", "reason": "dailyLimitExceeded"}], "code": 403, "message": "Daily Limit Exceeded"}}`,
+			&QuotaError{Reason: "dailyLimitExceeded"},
+		},
+		{
+			429,
+			`{"error": {"code": 429, "message": "Quota exceeded", "status": "RESOURCE_EXHAUSTED", "details": [
+				{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "RATE_LIMIT_EXCEEDED", "domain": "googleapis.com",
+				 "metadata": {"quota_metric": "identitytoolkit.googleapis.com/account_uploads", "quota_limit": "AccountUploadsPerMinute", "quota_limit_value": "600"}},
+				{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "42s"}]}}`,
+			&QuotaError{
+				Reason:     "RATE_LIMIT_EXCEEDED",
+				Metric:     "identitytoolkit.googleapis.com/account_uploads",
+				Limit:      "AccountUploadsPerMinute",
+				LimitValue: 600,
+				RetryAfter: 42 * time.Second,
+			},
+		},
+		{
+			429,
+			`not json`,
+			&QuotaError{Reason: "rateLimitExceeded"},
+		},
+	}
+	for i, tt := range tests {
+		c := &APIClient{Client: http.Client{Transport: &roundTripper{tt.status, tt.body}}}
+		_, err := c.GetAccountInfo(context.Background(), &GetAccountInfoRequest{LocalIDs: []string{"1234"}})
+		qe, ok := err.(*QuotaError)
+		if tt.want == nil {
+			if ok {
+				t.Errorf("[%d]: got quota error %v; want a plain API error", i, qe)
+			} else if _, ok := err.(*googleapi.Error); !ok {
+				t.Errorf("[%d]: got error %#v; want a *googleapi.Error", i, err)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("[%d]: got error %#v; want a *QuotaError", i, err)
+			continue
+		}
+		if qe.Err == nil || qe.Err.Code != tt.status {
+			t.Errorf("[%d]: underlying error = %v; want code %d", i, qe.Err, tt.status)
+		}
+		qe.Err = nil
+		if *qe != *tt.want {
+			t.Errorf("[%d]: got %+v; want %+v", i, *qe, *tt.want)
+		}
+	}
+}
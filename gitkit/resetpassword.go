@@ -0,0 +1,121 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// A ResetPasswordHandler is an http.Handler which completes the reset
+// password flow. It accepts the form posted by the widget in resetPassword
+// mode, with the OOB code of the reset password email in OOBCodeParam and the
+// new password in OOBNewPasswordParam, and sets the password by
+// Client.ResetPassword.
+//
+// The response is a JSON object, {"email": "user@example.com"} on success or
+// {"error": {"code": 400, "message": "INVALID_OOB_CODE"}} on failure, in the
+// format of the identitytoolkit API.
+type ResetPasswordHandler struct {
+	client *Client
+
+	// Context returns the context of the API calls. If nil, the context of
+	// the request is used. On App Engine, it should be
+	// appengine.NewContext.
+	Context func(*http.Request) context.Context
+}
+
+// ResetPasswordHandler returns an http.Handler completing the reset password
+// flow.
+//
+// For example,
+//
+//	http.Handle("/resetPassword", c.ResetPasswordHandler())
+func (c *Client) ResetPasswordHandler() *ResetPasswordHandler {
+	return &ResetPasswordHandler{client: c}
+}
+
+// handlerError is the error of the JSON responses of the handlers.
+type handlerError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *ResetPasswordHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, string(ErrorCodeMethodNotAllowed))
+		return
+	}
+	if err := h.client.CheckOrigin(r); err != nil {
+		writeJSONError(w, http.StatusForbidden, string(ErrorCodeForbiddenOrigin))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxWidgetPostBody)
+	oobCode := r.PostFormValue(OOBCodeParam)
+	newPassword := r.PostFormValue(OOBNewPasswordParam)
+	if oobCode == "" {
+		writeJSONError(w, http.StatusBadRequest, string(ErrorCodeMissingOOBCode))
+		return
+	}
+	if newPassword == "" {
+		writeJSONError(w, http.StatusBadRequest, string(ErrorCodeMissingPassword))
+		return
+	}
+	ctx := RequestContext(h.Context, r)
+	email, err := h.client.ResetPassword(ctx, oobCode, newPassword)
+	switch e := err.(type) {
+	case nil:
+		writeJSON(w, http.StatusOK, map[string]string{"email": email})
+	case *WeakPasswordError:
+		writeJSONError(w, http.StatusBadRequest, string(ErrorCodeWeakPassword)+" : "+e.Reason)
+	default:
+		writeAPIError(w, err)
+	}
+}
+
+// writeAPIError writes the error returned by an identitytoolkit API call. The
+// client errors, e.g., invalid or expired OOB codes, are passed through.
+func writeAPIError(w http.ResponseWriter, err error) {
+	switch e := err.(type) {
+	case *QuotaError:
+		writeJSONError(w, http.StatusTooManyRequests, string(ErrorCodeQuotaExceeded))
+	case *googleapi.Error:
+		if e.Code >= 400 && e.Code < 500 {
+			writeJSONError(w, e.Code, e.Message)
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, string(ErrorCodeInternalError))
+	default:
+		writeJSONError(w, http.StatusInternalServerError, string(ErrorCodeInternalError))
+	}
+}
+
+// writeJSONError writes the error response in the format of the
+// identitytoolkit API.
+func writeJSONError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]*handlerError{"error": {code, message}})
+}
+
+// writeJSON writes the JSON encoded v as the response with the status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
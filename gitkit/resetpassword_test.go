@@ -0,0 +1,93 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestResetPasswordHandler(t *testing.T) {
+	tests := []struct {
+		method  string
+		form    url.Values
+		origin  string
+		api     roundTripper
+		code    int
+		email   string
+		message string
+	}{
+		{"GET", nil, "", roundTripper{}, http.StatusMethodNotAllowed, "", "METHOD_NOT_ALLOWED"},
+		{"POST", url.Values{"newPassword": {"new password"}}, "", roundTripper{}, http.StatusBadRequest, "", "MISSING_OOB_CODE"},
+		{"POST", url.Values{"oobCode": {"code"}}, "", roundTripper{}, http.StatusBadRequest, "", "MISSING_PASSWORD"},
+		{"POST", url.Values{"oobCode": {"code"}, "newPassword": {"new password"}}, "https://evil.example.org", roundTripper{}, http.StatusForbidden, "", "FORBIDDEN_ORIGIN"},
+		{"POST", url.Values{"oobCode": {"code"}, "newPassword": {"short"}}, "", roundTripper{}, http.StatusBadRequest, "", "WEAK_PASSWORD : shorter than 8 characters"},
+		{
+			"POST", url.Values{"oobCode": {"code"}, "newPassword": {"new password"}}, "",
+			roundTripper{http.StatusBadRequest, `{"error":{"code":400,"message":"EXPIRED_OOB_CODE"}}`},
+			http.StatusBadRequest, "", "EXPIRED_OOB_CODE",
+		},
+		{
+			"POST", url.Values{"oobCode": {"code"}, "newPassword": {"new password"}}, "",
+			roundTripper{http.StatusInternalServerError, `{"error":{"code":500,"message":"backend error"}}`},
+			http.StatusInternalServerError, "", "INTERNAL_ERROR",
+		},
+		{
+			"POST", url.Values{"oobCode": {"code"}, "newPassword": {"new password"}}, "http://www.example.com",
+			roundTripper{http.StatusOK, `{"email":"user@example.com"}`},
+			http.StatusOK, "user@example.com", "",
+		},
+	}
+	for i, tt := range tests {
+		c := &Client{
+			config: &Config{
+				AllowedOrigins: []string{"https://accounts.example.com"},
+				PasswordPolicy: &PasswordRules{MinLength: 8},
+			},
+			api: &APIClient{Client: http.Client{Transport: tt.api}},
+		}
+		req, _ := http.NewRequest(tt.method, "http://www.example.com/resetPassword", strings.NewReader(tt.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.origin == "" {
+			tt.origin = "https://accounts.example.com"
+		}
+		req.Header.Set("Origin", tt.origin)
+		w := httptest.NewRecorder()
+		c.ResetPasswordHandler().ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("[%d] status = %d; want %d", i, w.Code, tt.code)
+		}
+		var resp struct {
+			Email string        `json:"email"`
+			Error *handlerError `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Errorf("[%d] invalid JSON response %q: %v", i, w.Body.String(), err)
+			continue
+		}
+		if resp.Email != tt.email {
+			t.Errorf("[%d] email = %q; want %q", i, resp.Email, tt.email)
+		}
+		if tt.message == "" && resp.Error != nil {
+			t.Errorf("[%d] error = %+v; want none", i, resp.Error)
+		} else if tt.message != "" && (resp.Error == nil || resp.Error.Message != tt.message || resp.Error.Code != tt.code) {
+			t.Errorf("[%d] error = %+v; want %d %q", i, resp.Error, tt.code, tt.message)
+		}
+	}
+}
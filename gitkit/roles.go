@@ -0,0 +1,114 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A RoleRule grants Roles to the tokens whose Claim matches Value: it is equal
+// to Value, or contains it if the claim is an array. If Value is nil, any
+// value of the claim matches, as long as it is present.
+type RoleRule struct {
+	Claim string      `json:"claim"`
+	Value interface{} `json:"value,omitempty"`
+	Roles []string    `json:"roles"`
+}
+
+// A RoleMapper maps the claims of the tokens to application roles with
+// declarative rules, e.g., in the JSON configuration,
+//
+//	"roleMapper": {"rules": [
+//	  {"claim": "groups", "value": "ops", "roles": ["admin", "viewer"]},
+//	  {"claim": "email_verified", "value": true, "roles": ["viewer"]}
+//	]}
+//
+// See Config.RoleMapper.
+type RoleMapper struct {
+	Rules []RoleRule `json:"rules"`
+}
+
+// Roles returns the roles granted by the rules matching the claims, without
+// duplicates, in the order of the rules.
+func (m *RoleMapper) Roles(claims map[string]interface{}) []string {
+	var roles []string
+	seen := make(map[string]bool)
+	for _, r := range m.Rules {
+		if !r.matches(claims) {
+			continue
+		}
+		for _, role := range r.Roles {
+			if !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+// MapRoles sets the roles of the token from its claims.
+func (m *RoleMapper) MapRoles(t *Token) error {
+	claims, err := t.claimSet()
+	if err != nil {
+		return err
+	}
+	t.Roles = m.Roles(claims)
+	return nil
+}
+
+func (r *RoleRule) matches(claims map[string]interface{}) bool {
+	v, ok := claims[r.Claim]
+	if !ok {
+		return false
+	}
+	if r.Value == nil {
+		return true
+	}
+	want := normalizeClaim(r.Value)
+	if a, ok := v.([]interface{}); ok {
+		for _, e := range a {
+			if reflect.DeepEqual(e, want) {
+				return true
+			}
+		}
+		return false
+	}
+	return reflect.DeepEqual(v, want)
+}
+
+// HasRole reports whether the token has any of the roles.
+func (t *Token) HasRole(roles ...string) bool {
+	for _, have := range t.Roles {
+		for _, role := range roles {
+			if have == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RoleError is returned when a token has none of the required roles.
+type RoleError struct {
+	Roles []string // The required roles.
+}
+
+// Error implements the error interface.
+func (e *RoleError) Error() string {
+	return fmt.Sprintf("gitkit: token has none of the roles %s", strings.Join(e.Roles, ", "))
+}
@@ -0,0 +1,96 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestRoleMapper(t *testing.T) {
+	var m RoleMapper
+	err := json.Unmarshal([]byte(`{"rules": [
+		{"claim": "groups", "value": "ops", "roles": ["admin", "viewer"]},
+		{"claim": "verified", "value": true, "roles": ["viewer"]},
+		{"claim": "tier", "value": 2, "roles": ["premium"]},
+		{"claim": "beta", "roles": ["tester"]}
+	]}`), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		claims map[string]interface{}
+		roles  []string
+	}{
+		{map[string]interface{}{}, nil},
+		{map[string]interface{}{"groups": []interface{}{"dev", "ops"}, "verified": true}, []string{"admin", "viewer"}},
+		{map[string]interface{}{"groups": "ops"}, []string{"admin", "viewer"}},
+		{map[string]interface{}{"groups": []interface{}{"dev"}, "verified": false}, nil},
+		{map[string]interface{}{"tier": float64(2), "beta": false}, []string{"premium", "tester"}},
+	}
+	for i, tt := range tests {
+		if roles := m.Roles(tt.claims); !reflect.DeepEqual(roles, tt.roles) {
+			t.Errorf("[%d] Roles() = %v; want %v", i, roles, tt.roles)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	c := newMiddlewareClient()
+	c.config.RoleMapper = &RoleMapper{Rules: []RoleRule{
+		{Claim: "provider_id", Value: "google.com", Roles: []string{"employee"}},
+	}}
+	tok, err := c.ValidateToken(context.Background(), validToken, []string{audience})
+	if err != nil {
+		t.Fatalf("ValidateToken() returns error: %v", err)
+	}
+	if !reflect.DeepEqual(tok.Roles, []string{"employee"}) || !tok.HasRole("admin", "employee") {
+		t.Errorf("token roles = %v; want [employee]", tok.Roles)
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		roles []string
+		code  int
+	}{
+		{[]string{"employee"}, http.StatusOK},
+		{[]string{"admin", "employee"}, http.StatusOK},
+		{[]string{"admin"}, http.StatusForbidden},
+	}
+	for i, tt := range tests {
+		req, _ := http.NewRequest("GET", "http://www.example.com/admin", nil)
+		req.AddCookie(&http.Cookie{Name: "gtoken", Value: validToken})
+		w := httptest.NewRecorder()
+		c.RequireRole([]string{audience}, next, tt.roles...).ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("[%d] RequireRole(%v) responds %d; want %d", i, tt.roles, w.Code, tt.code)
+		}
+	}
+
+	var got error
+	r := c.RequireRole([]string{audience}, next, "admin")
+	r.OnFailure = func(w http.ResponseWriter, r *http.Request, err error) { got = err }
+	req, _ := http.NewRequest("GET", "http://www.example.com/admin", nil)
+	req.AddCookie(&http.Cookie{Name: "gtoken", Value: validToken})
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if _, ok := got.(*RoleError); !ok {
+		t.Errorf("OnFailure called with %v; want a *RoleError", got)
+	}
+}
@@ -0,0 +1,463 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scim exposes the gitkit user store as a SCIM 2.0 Users endpoint
+// (RFC 7644), so that enterprise identity providers can provision accounts.
+//
+// Only the core User attributes which have a gitkit counterpart are
+// supported: id, userName (the email address), displayName, emails, password
+// and active, which is false for the disabled accounts: identity providers
+// deprovision users by replacing them with active set to false.
+//
+// The endpoint creates, updates and deletes users, including their passwords,
+// so every request must be authenticated: Handler calls its Authorize
+// function before serving any request. For example, with the bearer token
+// configured in the identity provider,
+//
+//	client, err := gitkit.New(ctx, config)
+//	...
+//	h, err := scim.NewHandler(client, scim.BearerToken(os.Getenv("SCIM_TOKEN")))
+//	...
+//	http.Handle("/scim/v2/Users", h)
+//	http.Handle("/scim/v2/Users/", h)
+package scim
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+	"golang.org/x/net/context"
+)
+
+// SCIM schema URNs.
+const (
+	UserSchema         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	ListResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	ErrorSchema        = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// Store is the subset of the *gitkit.Client methods used by Handler.
+type Store interface {
+	UserByLocalID(ctx context.Context, localID string) (*gitkit.User, error)
+	UserByEmail(ctx context.Context, email string) (*gitkit.User, error)
+	UpdateUser(ctx context.Context, user *gitkit.User) error
+	SetUserDisabled(ctx context.Context, user *gitkit.User, disabled bool) error
+	DeleteUser(ctx context.Context, user *gitkit.User) error
+	UploadUsers(ctx context.Context, users []*gitkit.User, algorithm string, key, saltSeparator []byte) error
+	ListUsersN(ctx context.Context, n int, cursor gitkit.Cursor) ([]*gitkit.User, gitkit.Cursor, error)
+}
+
+// Email is a SCIM email address.
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Meta is the SCIM resource metadata.
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location,omitempty"`
+}
+
+// User is a SCIM user resource.
+type User struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Emails      []Email  `json:"emails,omitempty"`
+	// Password is only accepted in requests and never returned.
+	Password string `json:"password,omitempty"`
+	// Active is false for the disabled accounts. The account is left enabled
+	// or disabled if it is missing from a request.
+	Active *bool `json:"active,omitempty"`
+	Meta   *Meta `json:"meta,omitempty"`
+}
+
+// ListResponse is the SCIM response to a list query.
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []*User  `json:"Resources"`
+}
+
+// Error is the SCIM error response.
+type Error struct {
+	Schemas []string `json:"schemas"`
+	Status  string   `json:"status"`
+	Detail  string   `json:"detail,omitempty"`
+}
+
+// Default and maximum number of users in a list response.
+const (
+	defaultCount = 100
+	maxCount     = 500
+)
+
+// ErrUnauthorized is returned by the BearerToken authorization of the requests
+// without the expected token.
+var ErrUnauthorized = errors.New("scim: unauthorized")
+
+// BearerToken returns an Authorize function of Handler which accepts the
+// requests whose Authorization header is the bearer token, e.g., the secret
+// token configured in the identity provider. All the requests are rejected if
+// the token is empty.
+func BearerToken(token string) func(*http.Request) error {
+	want := []byte("Bearer " + token)
+	return func(r *http.Request) error {
+		got := []byte(r.Header.Get("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare(got, want) != 1 {
+			return ErrUnauthorized
+		}
+		return nil
+	}
+}
+
+// Handler serves the SCIM Users endpoint. It must be mounted on a path ending
+// with "/Users" and its subtree.
+type Handler struct {
+	// Store is where the users are provisioned, usually a *gitkit.Client.
+	Store Store
+	// Authorize authenticates the identity provider sending the request,
+	// which is rejected with 401 Unauthorized if it returns an error. It is
+	// required: the requests are rejected if it is nil. See BearerToken.
+	Authorize func(*http.Request) error
+	// Context returns the context for the calls to Store made while serving
+	// the request. If nil, the context of the request is used. On App Engine,
+	// it should be appengine.NewContext.
+	Context func(*http.Request) context.Context
+	// HashAlgorithm and SignerKey are passed to UploadUsers when creating
+	// users, e.g., the password hash configuration of the project. As created
+	// users never carry a password hash, they only need to be accepted by the
+	// API. Passwords in requests are set afterwards with UpdateUser. They are
+	// required to create users: HashAlgorithm always, and SignerKey for the
+	// HMAC and SCRYPT algorithms.
+	HashAlgorithm string
+	SignerKey     []byte
+}
+
+// NewHandler creates a Handler backed by the store, serving the requests
+// accepted by authorize.
+func NewHandler(store Store, authorize func(*http.Request) error) (*Handler, error) {
+	if authorize == nil {
+		return nil, errors.New("scim: NewHandler requires an authorize function")
+	}
+	return &Handler{Store: store, Authorize: authorize}, nil
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Authorize == nil {
+		writeError(w, http.StatusInternalServerError, "no authorization configured")
+		return
+	}
+	if err := h.Authorize(r); err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	ctx := gitkit.RequestContext(h.Context, r)
+	i := strings.LastIndex(r.URL.Path, "/Users")
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "unknown resource")
+		return
+	}
+	id := strings.Trim(r.URL.Path[i+len("/Users"):], "/")
+	switch {
+	case id == "" && r.Method == "GET":
+		h.list(ctx, w, r)
+	case id == "" && r.Method == "POST":
+		h.create(ctx, w, r)
+	case id != "" && r.Method == "GET":
+		h.get(ctx, w, id)
+	case id != "" && r.Method == "PUT":
+		h.replace(ctx, w, r, id)
+	case id != "" && r.Method == "DELETE":
+		h.delete(ctx, w, id)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%s is not supported", r.Method))
+	}
+}
+
+func (h *Handler) get(ctx context.Context, w http.ResponseWriter, id string) {
+	u, err := h.Store.UserByLocalID(ctx, id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toSCIM(u))
+}
+
+func (h *Handler) create(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	su, ok := decodeUser(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.Store.UserByEmail(ctx, su.UserName); err == nil {
+		writeError(w, http.StatusConflict, fmt.Sprintf("user %s already exists", su.UserName))
+		return
+	} else if _, ok := err.(gitkit.UserNotFoundError); !ok {
+		writeStoreError(w, err)
+		return
+	}
+	if !h.canUpload() {
+		writeError(w, http.StatusInternalServerError, "the password hash configuration to create users is not set")
+		return
+	}
+	id, err := newLocalID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	u := fromSCIM(su)
+	u.LocalID = id
+	if err := h.Store.UploadUsers(ctx, []*gitkit.User{u}, h.HashAlgorithm, h.SignerKey, nil); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if su.Password != "" {
+		if err := h.Store.UpdateUser(ctx, &gitkit.User{LocalID: id, Password: su.Password}); err != nil {
+			// Don't leave a user without its password behind.
+			if derr := h.Store.DeleteUser(ctx, u); derr != nil {
+				err = fmt.Errorf("%v; user %s created without password: %v", err, id, derr)
+			}
+			writeStoreError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusCreated, toSCIM(u))
+}
+
+// canUpload reports whether HashAlgorithm and SignerKey are set to upload the
+// created users.
+func (h *Handler) canUpload() bool {
+	switch {
+	case h.HashAlgorithm == "":
+		return false
+	case strings.HasPrefix(h.HashAlgorithm, "HMAC_"), h.HashAlgorithm == "SCRYPT":
+		return len(h.SignerKey) != 0
+	}
+	return true
+}
+
+func (h *Handler) replace(ctx context.Context, w http.ResponseWriter, r *http.Request, id string) {
+	su, ok := decodeUser(w, r)
+	if !ok {
+		return
+	}
+	old, err := h.Store.UserByLocalID(ctx, id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	u := fromSCIM(su)
+	u.LocalID = id
+	if err := h.Store.UpdateUser(ctx, u); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	// UpdateUser does not change whether the account is disabled.
+	if su.Active != nil && old.Disabled == *su.Active {
+		if err := h.Store.SetUserDisabled(ctx, u, !*su.Active); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	h.get(ctx, w, id)
+}
+
+func (h *Handler) delete(ctx context.Context, w http.ResponseWriter, id string) {
+	if _, err := h.Store.UserByLocalID(ctx, id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if err := h.Store.DeleteUser(ctx, &gitkit.User{LocalID: id}); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// list serves list queries. The only supported filter is userName eq "...".
+//
+// As the store does not report the number of users, every query reads the
+// whole store to count them for totalResults, which the clients use to stop
+// paging.
+func (h *Handler) list(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, count := 1, defaultCount
+	if s := q.Get("startIndex"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid startIndex")
+			return
+		}
+		if n > 1 {
+			start = n
+		}
+	}
+	if s := q.Get("count"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid count")
+			return
+		}
+		count = n
+	}
+	if count > maxCount {
+		count = maxCount
+	}
+	resp := &ListResponse{Schemas: []string{ListResponseSchema}, StartIndex: start, Resources: []*User{}}
+
+	if f := q.Get("filter"); f != "" {
+		email, ok := parseUserNameFilter(f)
+		if !ok {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported filter: %s", f))
+			return
+		}
+		u, err := h.Store.UserByEmail(ctx, email)
+		if _, notFound := err.(gitkit.UserNotFoundError); err != nil && !notFound {
+			writeStoreError(w, err)
+			return
+		}
+		if u != nil {
+			resp.TotalResults = 1
+			if start == 1 && count > 0 {
+				resp.Resources = append(resp.Resources, toSCIM(u))
+			}
+		}
+		resp.ItemsPerPage = len(resp.Resources)
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	var pageToken gitkit.Cursor
+	for {
+		users, next, err := h.Store.ListUsersN(ctx, maxCount, pageToken)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		for _, u := range users {
+			resp.TotalResults++
+			if resp.TotalResults >= start && len(resp.Resources) < count {
+				resp.Resources = append(resp.Resources, toSCIM(u))
+			}
+		}
+		if len(users) == 0 || next == "" {
+			break
+		}
+		pageToken = next
+	}
+	resp.ItemsPerPage = len(resp.Resources)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// parseUserNameFilter parses the filter `userName eq "value"`.
+func parseUserNameFilter(f string) (string, bool) {
+	fields := strings.SplitN(strings.TrimSpace(f), " ", 3)
+	if len(fields) != 3 || !strings.EqualFold(fields[0], "userName") || !strings.EqualFold(fields[1], "eq") {
+		return "", false
+	}
+	v, err := strconv.Unquote(strings.TrimSpace(fields[2]))
+	if err != nil {
+		return "", false
+	}
+	return v, true
+}
+
+func toSCIM(u *gitkit.User) *User {
+	active := !u.Disabled
+	su := &User{
+		Schemas:     []string{UserSchema},
+		ID:          u.LocalID,
+		UserName:    u.Email,
+		DisplayName: u.DisplayName,
+		Active:      &active,
+		Meta:        &Meta{ResourceType: "User", Location: "Users/" + u.LocalID},
+	}
+	if u.Email != "" {
+		su.Emails = []Email{{Value: u.Email, Primary: true}}
+	}
+	return su
+}
+
+func fromSCIM(su *User) *gitkit.User {
+	u := &gitkit.User{
+		Email:       su.UserName,
+		DisplayName: su.DisplayName,
+		Password:    su.Password,
+	}
+	if su.Active != nil {
+		u.Disabled = !*su.Active
+	}
+	for _, e := range su.Emails {
+		if e.Primary && u.Email == "" {
+			u.Email = e.Value
+		}
+	}
+	return u
+}
+
+func decodeUser(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	su := &User{}
+	if err := json.NewDecoder(r.Body).Decode(su); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid user: %v", err))
+		return nil, false
+	}
+	if su.UserName == "" {
+		writeError(w, http.StatusBadRequest, "userName is required")
+		return nil, false
+	}
+	return su, true
+}
+
+// newLocalID generates a random local ID for a new user.
+func newLocalID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if _, ok := err.(gitkit.UserNotFoundError); ok {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func writeError(w http.ResponseWriter, status int, detail string) {
+	writeJSON(w, status, &Error{Schemas: []string{ErrorSchema}, Status: strconv.Itoa(status), Detail: detail})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,266 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+	"github.com/google/identity-toolkit-go-client/gitkit/gitkittest"
+	"golang.org/x/net/context"
+)
+
+var _ Store = (*gitkit.Client)(nil)
+
+const testToken = "secret-token"
+
+func newHandler(t *testing.T, store Store) *Handler {
+	h, err := NewHandler(store, BearerToken(testToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.HashAlgorithm, h.SignerKey = "HMAC_SHA256", []byte("key")
+	return h
+}
+
+func serve(h *Handler, method, path, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, "http://example.com/scim/v2"+path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreate(t *testing.T) {
+	store := gitkittest.NewClient()
+	h := newHandler(t, store)
+	w := serve(h, "POST", "/Users", `{"schemas":["`+UserSchema+`"],"userName":"alice@example.com","displayName":"Alice","password":"secret"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	var su User
+	if err := json.Unmarshal(w.Body.Bytes(), &su); err != nil {
+		t.Fatal(err)
+	}
+	if su.ID == "" || su.UserName != "alice@example.com" || su.DisplayName != "Alice" || su.Active == nil || !*su.Active || su.Password != "" {
+		t.Errorf("user = %+v", su)
+	}
+	u, ok := store.User(su.ID)
+	if !ok {
+		t.Fatalf("user %s not stored", su.ID)
+	}
+	if u.Email != "alice@example.com" || u.Password != "secret" {
+		t.Errorf("stored user = %+v", u)
+	}
+
+	w = serve(h, "POST", "/Users", `{"userName":"carol@example.com","active":false}`)
+	json.Unmarshal(w.Body.Bytes(), &su)
+	if got, _ := store.User(su.ID); w.Code != http.StatusCreated || got == nil || !got.Disabled {
+		t.Errorf("user created with active false = %+v; want disabled", got)
+	}
+
+	w = serve(h, "POST", "/Users", `{"userName":"alice@example.com"}`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("duplicate status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	w = serve(h, "POST", "/Users", `{"displayName":"Nobody"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("missing userName status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestGetReplaceDelete(t *testing.T) {
+	store := gitkittest.NewClient()
+	u := store.AddUser(&gitkit.User{Email: "bob@example.com", DisplayName: "Bob"})
+	h := newHandler(t, store)
+
+	w := serve(h, "GET", "/Users/"+u.LocalID, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET status = %d: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/scim+json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	w = serve(h, "PUT", "/Users/"+u.LocalID, `{"userName":"robert@example.com","displayName":"Robert"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d: %s", w.Code, w.Body)
+	}
+	if got, _ := store.User(u.LocalID); got.Email != "robert@example.com" || got.DisplayName != "Robert" {
+		t.Errorf("updated user = %+v", got)
+	}
+
+	// Identity providers deprovision users with active set to false.
+	w = serve(h, "PUT", "/Users/"+u.LocalID, `{"userName":"robert@example.com","active":false}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d: %s", w.Code, w.Body)
+	}
+	var su User
+	json.Unmarshal(w.Body.Bytes(), &su)
+	if got, _ := store.User(u.LocalID); !got.Disabled || su.Active == nil || *su.Active {
+		t.Errorf("user after PUT active false = %+v, response %s; want disabled", got, w.Body)
+	}
+	w = serve(h, "PUT", "/Users/"+u.LocalID, `{"userName":"robert@example.com","displayName":"Rob"}`)
+	if got, _ := store.User(u.LocalID); w.Code != http.StatusOK || !got.Disabled {
+		t.Errorf("user after PUT without active = %+v; want still disabled", got)
+	}
+	w = serve(h, "PUT", "/Users/"+u.LocalID, `{"userName":"robert@example.com","active":true}`)
+	if got, _ := store.User(u.LocalID); w.Code != http.StatusOK || got.Disabled {
+		t.Errorf("user after PUT active true = %+v; want enabled", got)
+	}
+
+	w = serve(h, "DELETE", "/Users/"+u.LocalID, "")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE status = %d: %s", w.Code, w.Body)
+	}
+	if _, ok := store.User(u.LocalID); ok {
+		t.Error("user not deleted")
+	}
+
+	for _, method := range []string{"GET", "PUT", "DELETE"} {
+		w = serve(h, method, "/Users/"+u.LocalID, `{"userName":"robert@example.com"}`)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s of deleted user status = %d, want %d", method, w.Code, http.StatusNotFound)
+		}
+		var e Error
+		if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil || e.Status != "404" || e.Schemas[0] != ErrorSchema {
+			t.Errorf("%s of deleted user error = %+v, %v", method, e, err)
+		}
+	}
+	if w = serve(h, "PATCH", "/Users/"+u.LocalID, "{}"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PATCH status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestList(t *testing.T) {
+	store := gitkittest.NewClient()
+	for i := 0; i < 5; i++ {
+		store.AddUser(&gitkit.User{LocalID: fmt.Sprintf("id%d", i), Email: fmt.Sprintf("user%d@example.com", i)})
+	}
+	h := newHandler(t, store)
+
+	tests := []struct {
+		query string
+		total int
+		ids   []string
+	}{
+		{"", 5, []string{"id0", "id1", "id2", "id3", "id4"}},
+		{"?startIndex=2&count=2", 5, []string{"id1", "id2"}},
+		{"?count=0", 5, nil},
+		{"?startIndex=4&count=5", 5, []string{"id3", "id4"}},
+		{"?startIndex=9", 5, nil},
+		{"?filter=" + url.QueryEscape(`userName eq "user3@example.com"`), 1, []string{"id3"}},
+		{"?filter=" + url.QueryEscape(`userName eq "nobody@example.com"`), 0, nil},
+	}
+	for i, tt := range tests {
+		w := serve(h, "GET", "/Users"+tt.query, "")
+		if w.Code != http.StatusOK {
+			t.Errorf("%d: status = %d: %s", i, w.Code, w.Body)
+			continue
+		}
+		var resp ListResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		var ids []string
+		for _, u := range resp.Resources {
+			ids = append(ids, u.ID)
+		}
+		if resp.TotalResults != tt.total || fmt.Sprint(ids) != fmt.Sprint(tt.ids) || resp.ItemsPerPage != len(tt.ids) {
+			t.Errorf("%d: got total %d, ids %v; want total %d, ids %v", i, resp.TotalResults, ids, tt.total, tt.ids)
+		}
+	}
+
+	for _, query := range []string{"?startIndex=x", "?count=-1", "?filter=" + url.QueryEscape(`displayName co "a"`)} {
+		if w := serve(h, "GET", "/Users"+query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	store := gitkittest.NewClient()
+	u := store.AddUser(&gitkit.User{Email: "bob@example.com"})
+	if _, err := NewHandler(store, nil); err == nil {
+		t.Error("NewHandler() without authorize function returns no error")
+	}
+	h := newHandler(t, store)
+	for _, auth := range []string{"", "Bearer", "Bearer other", "Basic " + testToken} {
+		req, _ := http.NewRequest("DELETE", "http://example.com/scim/v2/Users/"+u.LocalID, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("DELETE with Authorization %q status = %d, want %d", auth, w.Code, http.StatusUnauthorized)
+		}
+	}
+	if _, ok := store.User(u.LocalID); !ok {
+		t.Error("unauthorized DELETE deletes the user")
+	}
+
+	h, _ = NewHandler(store, BearerToken(""))
+	if w := serve(h, "GET", "/Users", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("status with an empty bearer token = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := serve(&Handler{Store: store}, "GET", "/Users", ""); w.Code == http.StatusOK {
+		t.Error("Handler without Authorize serves the request")
+	}
+}
+
+// failingUpdateStore fails the user updates.
+type failingUpdateStore struct {
+	*gitkittest.Client
+}
+
+func (failingUpdateStore) UpdateUser(ctx context.Context, user *gitkit.User) error {
+	return errors.New("update failed")
+}
+
+func TestCreate_errors(t *testing.T) {
+	store := gitkittest.NewClient()
+	h := newHandler(t, store)
+	for _, conf := range []struct {
+		algorithm string
+		key       []byte
+	}{{"", nil}, {"HMAC_SHA256", nil}, {"SCRYPT", nil}} {
+		h.HashAlgorithm, h.SignerKey = conf.algorithm, conf.key
+		if w := serve(h, "POST", "/Users", `{"userName":"alice@example.com"}`); w.Code != http.StatusInternalServerError {
+			t.Errorf("status with hash algorithm %q and no key = %d, want %d", conf.algorithm, w.Code, http.StatusInternalServerError)
+		}
+	}
+	if len(store.Users()) != 0 {
+		t.Errorf("users created without the password hash configuration: %v", store.Users())
+	}
+
+	// The user is deleted if its password can't be set.
+	h = newHandler(t, failingUpdateStore{store})
+	w := serve(h, "POST", "/Users", `{"userName":"alice@example.com","password":"secret"}`)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status when the password update fails = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if users := store.Users(); len(users) != 0 {
+		t.Errorf("users left after the failed creation: %v", users)
+	}
+}
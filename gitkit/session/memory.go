@@ -0,0 +1,91 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// A MemoryStore keeps the sessions in memory. The sessions are lost when the
+// process exits and are not shared between processes.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]*Session)}
+}
+
+// Save implements the Store interface.
+func (m *MemoryStore) Save(ctx context.Context, s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *s
+	m.sessions[s.ID] = &cp
+	return nil
+}
+
+// Get implements the Store interface. Expired sessions are removed when read.
+func (m *MemoryStore) Get(ctx context.Context, id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !s.Expires.After(time.Now()) {
+		delete(m.sessions, id)
+		return nil, ErrNotFound
+	}
+	cp := *s
+	return &cp, nil
+}
+
+// Delete implements the Store interface.
+func (m *MemoryStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// DeleteUser implements the UserStore interface.
+func (m *MemoryStore) DeleteUser(ctx context.Context, localID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, s := range m.sessions {
+		if s.LocalID == localID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+// DeleteExpired removes the expired sessions.
+func (m *MemoryStore) DeleteExpired() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for id, s := range m.sessions {
+		if !s.Expires.After(now) {
+			delete(m.sessions, id)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// A RedisConn is a connection to a Redis server. Its method set matches the
+// Conn of github.com/gomodule/redigo/redis, so that package is usable without
+// this one depending on it.
+type RedisConn interface {
+	Do(commandName string, args ...interface{}) (reply interface{}, err error)
+	Close() error
+}
+
+// DefaultRedisPrefix is the default prefix of the Redis keys of the sessions.
+const DefaultRedisPrefix = "gitkit:session:"
+
+// A RedisStore keeps the sessions in Redis as JSON values which expire with the
+// sessions, and the IDs of the sessions of each user in a set.
+type RedisStore struct {
+	// Dial returns a connection, closed after each operation. With redigo, it
+	// is typically
+	//
+	//	func() (session.RedisConn, error) { return pool.Get(), nil }
+	Dial func() (RedisConn, error)
+	// Prefix is prepended to the session IDs to build the Redis keys.
+	Prefix string
+}
+
+// NewRedisStore creates a RedisStore using the connections returned by dial.
+func NewRedisStore(dial func() (RedisConn, error)) *RedisStore {
+	return &RedisStore{Dial: dial, Prefix: DefaultRedisPrefix}
+}
+
+func (r *RedisStore) do(cmd string, args ...interface{}) (interface{}, error) {
+	conn, err := r.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.Do(cmd, args...)
+}
+
+// Save implements the Store interface.
+func (r *RedisStore) Save(ctx context.Context, s *Session) error {
+	ttl := s.Expires.Sub(time.Now()) / time.Millisecond
+	if ttl <= 0 {
+		return nil
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if _, err := r.do("SET", r.Prefix+s.ID, b, "PX", int64(ttl)); err != nil {
+		return err
+	}
+	// Index the session by user for DeleteUser. The index expires with the
+	// last session saved.
+	if _, err := r.do("SADD", r.userKey(s.LocalID), s.ID); err != nil {
+		return err
+	}
+	_, err = r.do("PEXPIRE", r.userKey(s.LocalID), int64(ttl))
+	return err
+}
+
+// userKey returns the Redis key of the set of the session IDs of the user.
+func (r *RedisStore) userKey(localID string) string {
+	return r.Prefix + "user:" + localID
+}
+
+// Get implements the Store interface.
+func (r *RedisStore) Get(ctx context.Context, id string) (*Session, error) {
+	reply, err := r.do("GET", r.Prefix+id)
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	switch v := reply.(type) {
+	case nil:
+		return nil, ErrNotFound
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return nil, fmt.Errorf("unexpected Redis reply type %T", reply)
+	}
+	s := &Session{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	if !s.Expires.After(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return s, nil
+}
+
+// Delete implements the Store interface.
+func (r *RedisStore) Delete(ctx context.Context, id string) error {
+	_, err := r.do("DEL", r.Prefix+id)
+	return err
+}
+
+// DeleteUser implements the UserStore interface.
+func (r *RedisStore) DeleteUser(ctx context.Context, localID string) error {
+	reply, err := r.do("SMEMBERS", r.userKey(localID))
+	if err != nil {
+		return err
+	}
+	ids, ok := reply.([]interface{})
+	if !ok && reply != nil {
+		return fmt.Errorf("unexpected Redis reply type %T", reply)
+	}
+	keys := []interface{}{r.userKey(localID)}
+	for _, id := range ids {
+		switch v := id.(type) {
+		case []byte:
+			keys = append(keys, r.Prefix+string(v))
+		case string:
+			keys = append(keys, r.Prefix+v)
+		default:
+			return fmt.Errorf("unexpected Redis reply type %T", id)
+		}
+	}
+	_, err = r.do("DEL", keys...)
+	return err
+}
@@ -0,0 +1,84 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeRedis implements the SET, GET, DEL, SADD, SMEMBERS and PEXPIRE commands
+// used by RedisStore over maps, ignoring the expiration.
+type fakeRedis struct {
+	data   map[string][]byte
+	sets   map[string][]string
+	ttls   map[string]int64
+	closed int
+}
+
+func (f *fakeRedis) Do(cmd string, args ...interface{}) (interface{}, error) {
+	key := args[0].(string)
+	switch cmd {
+	case "SET":
+		if len(args) != 4 || args[2] != "PX" {
+			return nil, errors.New("unexpected SET arguments")
+		}
+		f.data[key] = args[1].([]byte)
+		f.ttls[key] = args[3].(int64)
+		return "OK", nil
+	case "GET":
+		if b, ok := f.data[key]; ok {
+			return b, nil
+		}
+		return nil, nil
+	case "DEL":
+		for _, k := range args {
+			delete(f.data, k.(string))
+			delete(f.sets, k.(string))
+		}
+		return int64(len(args)), nil
+	case "SADD":
+		f.sets[key] = append(f.sets[key], args[1].(string))
+		return int64(1), nil
+	case "SMEMBERS":
+		var members []interface{}
+		for _, m := range f.sets[key] {
+			members = append(members, []byte(m))
+		}
+		return members, nil
+	case "PEXPIRE":
+		f.ttls[key] = args[1].(int64)
+		return int64(1), nil
+	}
+	return nil, errors.New("unknown command " + cmd)
+}
+
+func (f *fakeRedis) Close() error {
+	f.closed++
+	return nil
+}
+
+func TestRedisStore(t *testing.T) {
+	f := &fakeRedis{data: make(map[string][]byte), sets: make(map[string][]string), ttls: make(map[string]int64)}
+	store := NewRedisStore(func() (RedisConn, error) { return f, nil })
+	testStore(t, store)
+	testUserStore(t, store)
+	if ttl := f.ttls[DefaultRedisPrefix+"s1"]; ttl <= 0 || ttl > 3600*1000 {
+		t.Errorf("TTL = %dms; want at most an hour", ttl)
+	}
+	if f.closed == 0 {
+		t.Errorf("connections are not closed")
+	}
+}
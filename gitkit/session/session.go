@@ -0,0 +1,232 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package session provides revocable server side sessions for users signed in
+// with gitkit.
+//
+// A Manager creates a session once the ID token set by the sign in widget is
+// validated, and identifies the later requests by the session ID cookie, so
+// that the ID token is only validated once per sign in. Sessions are kept in a
+// Store: MemoryStore for a single process, RedisStore or SQLStore when they
+// must be shared between processes. Deleting a session from the store revokes
+// it immediately.
+//
+//	m := session.NewManager(client, session.NewMemoryStore(), audiences)
+//	http.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+//		if _, err := m.Login(w, r); err != nil {
+//			http.Error(w, err.Error(), http.StatusUnauthorized)
+//			return
+//		}
+//		http.Redirect(w, r, "/", http.StatusFound)
+//	})
+//	http.Handle("/account", m.Require(func(w http.ResponseWriter, r *http.Request, s *session.Session) {
+//		fmt.Fprintf(w, "Hello, %s", s.Email)
+//	}))
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+	"golang.org/x/net/context"
+)
+
+// ErrNotFound is returned by Store.Get if the session does not exist or has
+// expired.
+var ErrNotFound = errors.New("session not found")
+
+// A Session is a signed in user.
+type Session struct {
+	ID         string    `json:"id"`
+	LocalID    string    `json:"localId"`
+	Email      string    `json:"email,omitempty"`
+	ProviderID string    `json:"providerId,omitempty"`
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires"`
+}
+
+// A Store keeps the sessions. Implementations must be safe for concurrent use.
+type Store interface {
+	// Save stores the session until it expires.
+	Save(ctx context.Context, s *Session) error
+	// Get returns the unexpired session with the ID, or ErrNotFound.
+	Get(ctx context.Context, id string) (*Session, error)
+	// Delete removes the session with the ID. Deleting a missing session is
+	// not an error.
+	Delete(ctx context.Context, id string) error
+}
+
+// A UserStore is a Store which can delete all the sessions of a user. It is
+// required by Manager.RevokeSessions.
+type UserStore interface {
+	Store
+	// DeleteUser removes the sessions of the user with the local ID.
+	DeleteUser(ctx context.Context, localID string) error
+}
+
+// A TokenValidator extracts and validates the ID tokens of the requests. It is
+// implemented by *gitkit.Client.
+type TokenValidator interface {
+	TokenFromRequest(req *http.Request) string
+	ValidateToken(ctx context.Context, token string, audiences []string) (*gitkit.Token, error)
+}
+
+// Default values of the Manager fields.
+const (
+	DefaultCookieName = "gsession"
+	DefaultTTL        = 24 * time.Hour
+)
+
+// A Manager creates sessions from the ID tokens and reads them back from the
+// session cookie.
+type Manager struct {
+	validator TokenValidator
+	store     Store
+	audiences []string
+
+	// CookieName is the name of the session ID cookie.
+	CookieName string
+	// TTL is the lifetime of the sessions.
+	TTL time.Duration
+	// Secure sets the Secure attribute of the session cookie. It should be set
+	// if the site is served over HTTPS only.
+	Secure bool
+	// Context returns the context of the calls made while serving a request.
+	// If nil, the context of the request is used. On App Engine, it should be
+	// appengine.NewContext.
+	Context func(*http.Request) context.Context
+}
+
+// NewManager creates a Manager which validates the ID tokens issued for one of
+// the audiences with v and keeps the sessions in store.
+func NewManager(v TokenValidator, store Store, audiences []string) *Manager {
+	return &Manager{
+		validator:  v,
+		store:      store,
+		audiences:  audiences,
+		CookieName: DefaultCookieName,
+		TTL:        DefaultTTL,
+	}
+}
+
+func (m *Manager) context(r *http.Request) context.Context {
+	return gitkit.RequestContext(m.Context, r)
+}
+
+// Login validates the ID token of the request, saves a new session for its
+// user and sets the session cookie.
+func (m *Manager) Login(w http.ResponseWriter, r *http.Request) (*Session, error) {
+	ctx := m.context(r)
+	token := m.validator.TokenFromRequest(r)
+	if token == "" {
+		return nil, errors.New("missing ID token")
+	}
+	t, err := m.validator.ValidateToken(ctx, token, m.audiences)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	s := &Session{
+		ID:         id,
+		LocalID:    t.LocalID,
+		Email:      t.Email,
+		ProviderID: t.ProviderID,
+		Created:    now,
+		Expires:    now.Add(m.TTL),
+	}
+	if err := m.store.Save(ctx, s); err != nil {
+		return nil, err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.CookieName,
+		Value:    s.ID,
+		Path:     "/",
+		Expires:  s.Expires,
+		Secure:   m.Secure,
+		HttpOnly: true,
+	})
+	return s, nil
+}
+
+// Session returns the session of the request, or ErrNotFound if the request
+// has no valid session cookie.
+func (m *Manager) Session(r *http.Request) (*Session, error) {
+	c, err := r.Cookie(m.CookieName)
+	if err != nil || c.Value == "" {
+		return nil, ErrNotFound
+	}
+	return m.store.Get(m.context(r), c.Value)
+}
+
+// Logout deletes the session of the request, if any, and clears the session
+// cookie.
+func (m *Manager) Logout(w http.ResponseWriter, r *http.Request) error {
+	http.SetCookie(w, &http.Cookie{Name: m.CookieName, Path: "/", MaxAge: -1})
+	c, err := r.Cookie(m.CookieName)
+	if err != nil || c.Value == "" {
+		return nil
+	}
+	return m.store.Delete(m.context(r), c.Value)
+}
+
+// RevokeSessions deletes all the sessions of the user with the local ID, e.g.,
+// after a change of their email address. The store must be a UserStore. It
+// implements gitkit.SessionRevoker.
+func (m *Manager) RevokeSessions(ctx context.Context, localID string) error {
+	us, ok := m.store.(UserStore)
+	if !ok {
+		return fmt.Errorf("session: %T cannot delete the sessions of a user", m.store)
+	}
+	return us.DeleteUser(ctx, localID)
+}
+
+// A HandlerFunc serves a request with a valid session.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request, s *Session)
+
+// Require returns an http.Handler which calls h with the session of the
+// requests, and answers the requests without a valid session with 401
+// Unauthorized.
+func (m *Manager) Require(h HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := m.Session(r)
+		if err == ErrNotFound {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h(w, r, s)
+	})
+}
+
+// newID generates a random session ID.
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(b), "="), nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+	"github.com/google/identity-toolkit-go-client/gitkit/gitkittest"
+	"golang.org/x/net/context"
+)
+
+var (
+	_ TokenValidator        = (*gitkit.Client)(nil)
+	_ gitkit.SessionRevoker = (*Manager)(nil)
+)
+
+func TestManager(t *testing.T) {
+	c := gitkittest.NewClient()
+	c.AddToken("token", &gitkit.Token{LocalID: "123", Email: "user@example.com", Audience: "aud", ExpireAt: time.Now().Add(time.Hour)})
+	store := NewMemoryStore()
+	m := NewManager(c, store, []string{"aud"})
+
+	var got *Session
+	protected := m.Require(func(w http.ResponseWriter, r *http.Request, s *Session) {
+		got = s
+	})
+	req, _ := http.NewRequest("GET", "http://example.com/account", nil)
+	w := httptest.NewRecorder()
+	protected.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status without session = %d; want 401", w.Code)
+	}
+
+	// Login with an invalid token.
+	req, _ = http.NewRequest("GET", "http://example.com/login", nil)
+	req.AddCookie(&http.Cookie{Name: gitkit.DefaultCookieName, Value: "forged"})
+	if _, err := m.Login(httptest.NewRecorder(), req); err == nil {
+		t.Errorf("Login() with invalid token returns no error")
+	}
+
+	req, _ = http.NewRequest("GET", "http://example.com/login", nil)
+	req.AddCookie(&http.Cookie{Name: gitkit.DefaultCookieName, Value: "token"})
+	w = httptest.NewRecorder()
+	s, err := m.Login(w, req)
+	if err != nil {
+		t.Fatalf("Login() returns error %v", err)
+	}
+	if s.LocalID != "123" || s.Email != "user@example.com" || s.ID == "" {
+		t.Errorf("Login() = %+v; want a session of user 123", s)
+	}
+	cookie := w.Result().Cookies()[0]
+	if cookie.Name != DefaultCookieName || cookie.Value != s.ID || !cookie.HttpOnly {
+		t.Errorf("session cookie = %v; want an HttpOnly cookie with the session ID", cookie)
+	}
+
+	req, _ = http.NewRequest("GET", "http://example.com/account", nil)
+	req.AddCookie(cookie)
+	protected.ServeHTTP(httptest.NewRecorder(), req)
+	if got == nil || got.ID != s.ID {
+		t.Errorf("handler called with session %v; want %v", got, s)
+	}
+
+	// Revoke the session.
+	if err := m.Logout(httptest.NewRecorder(), req); err != nil {
+		t.Fatalf("Logout() returns error %v", err)
+	}
+	if _, err := store.Get(context.Background(), s.ID); err != ErrNotFound {
+		t.Errorf("Get() after Logout() returns error %v; want ErrNotFound", err)
+	}
+	w = httptest.NewRecorder()
+	protected.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status after logout = %d; want 401", w.Code)
+	}
+
+	// Revoke all the sessions of the user.
+	req, _ = http.NewRequest("GET", "http://example.com/login", nil)
+	req.AddCookie(&http.Cookie{Name: gitkit.DefaultCookieName, Value: "token"})
+	if s, err = m.Login(httptest.NewRecorder(), req); err != nil {
+		t.Fatalf("Login() returns error %v", err)
+	}
+	if err := m.RevokeSessions(context.Background(), "123"); err != nil {
+		t.Fatalf("RevokeSessions() returns error %v", err)
+	}
+	if _, err := store.Get(context.Background(), s.ID); err != ErrNotFound {
+		t.Errorf("Get() after RevokeSessions() returns error %v; want ErrNotFound", err)
+	}
+}
+
+// testStore checks the behavior common to all the Store implementations.
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	now := time.Now()
+	s := &Session{ID: "s1", LocalID: "123", Email: "user@example.com", ProviderID: "google.com", Created: now, Expires: now.Add(time.Hour)}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("Save() returns error %v", err)
+	}
+	got, err := store.Get(ctx, "s1")
+	if err != nil {
+		t.Fatalf("Get() returns error %v", err)
+	}
+	if got.LocalID != s.LocalID || got.Email != s.Email || got.ProviderID != s.ProviderID || got.Expires.Unix() != s.Expires.Unix() {
+		t.Errorf("Get() = %+v; want %+v", got, s)
+	}
+	if _, err := store.Get(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("Get() of missing session returns error %v; want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, "s1"); err != nil {
+		t.Fatalf("Delete() returns error %v", err)
+	}
+	if _, err := store.Get(ctx, "s1"); err != ErrNotFound {
+		t.Errorf("Get() of deleted session returns error %v; want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, "s1"); err != nil {
+		t.Errorf("Delete() of missing session returns error %v", err)
+	}
+}
+
+// testUserStore checks the deletion of the sessions of a user.
+func testUserStore(t *testing.T, store UserStore) {
+	ctx := context.Background()
+	exp := time.Now().Add(time.Hour)
+	for _, s := range []*Session{
+		{ID: "a1", LocalID: "a", Expires: exp},
+		{ID: "a2", LocalID: "a", Expires: exp},
+		{ID: "b1", LocalID: "b", Expires: exp},
+	} {
+		if err := store.Save(ctx, s); err != nil {
+			t.Fatalf("Save() returns error %v", err)
+		}
+	}
+	if err := store.DeleteUser(ctx, "a"); err != nil {
+		t.Fatalf("DeleteUser() returns error %v", err)
+	}
+	for _, id := range []string{"a1", "a2"} {
+		if _, err := store.Get(ctx, id); err != ErrNotFound {
+			t.Errorf("Get(%q) after DeleteUser() returns error %v; want ErrNotFound", id, err)
+		}
+	}
+	if _, err := store.Get(ctx, "b1"); err != nil {
+		t.Errorf("Get() of the session of another user returns error %v", err)
+	}
+	store.Delete(ctx, "b1")
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	testStore(t, store)
+	testUserStore(t, store)
+
+	ctx := context.Background()
+	store.Save(ctx, &Session{ID: "expired", Expires: time.Now().Add(-time.Second)})
+	if _, err := store.Get(ctx, "expired"); err != ErrNotFound {
+		t.Errorf("Get() of expired session returns error %v; want ErrNotFound", err)
+	}
+}
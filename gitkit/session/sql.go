@@ -0,0 +1,113 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultSQLTable is the default name of the sessions table.
+const DefaultSQLTable = "gitkit_sessions"
+
+// A SQLStore keeps the sessions in a SQL table created with, e.g.,
+//
+//	CREATE TABLE gitkit_sessions (
+//		id          VARCHAR(64) PRIMARY KEY,
+//		local_id    VARCHAR(128) NOT NULL,
+//		email       VARCHAR(256) NOT NULL,
+//		provider_id VARCHAR(64) NOT NULL,
+//		created     BIGINT NOT NULL,
+//		expires     BIGINT NOT NULL
+//	)
+//
+// The times are stored as Unix times in seconds. Expired sessions are not
+// returned but stay in the table until DeleteExpired is called.
+type SQLStore struct {
+	// DB is the database holding the table.
+	DB *sql.DB
+	// Table is the name of the sessions table.
+	Table string
+	// DollarPlaceholders, if true, uses $1, $2... as query placeholders, e.g.,
+	// for PostgreSQL, instead of ?.
+	DollarPlaceholders bool
+}
+
+// NewSQLStore creates a SQLStore using DefaultSQLTable in db.
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{DB: db, Table: DefaultSQLTable}
+}
+
+// query formats the query with the table name and converts the ? placeholders
+// if needed.
+func (s *SQLStore) query(format string) string {
+	q := fmt.Sprintf(format, s.Table)
+	if !s.DollarPlaceholders {
+		return q
+	}
+	parts := strings.Split(q, "?")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = fmt.Sprintf("$%d", i) + parts[i]
+	}
+	return strings.Join(parts, "")
+}
+
+// Save implements the Store interface.
+func (s *SQLStore) Save(ctx context.Context, sess *Session) error {
+	_, err := s.DB.Exec(s.query("INSERT INTO %s (id, local_id, email, provider_id, created, expires) VALUES (?, ?, ?, ?, ?, ?)"),
+		sess.ID, sess.LocalID, sess.Email, sess.ProviderID, sess.Created.Unix(), sess.Expires.Unix())
+	return err
+}
+
+// Get implements the Store interface.
+func (s *SQLStore) Get(ctx context.Context, id string) (*Session, error) {
+	var created, expires int64
+	sess := &Session{ID: id}
+	err := s.DB.QueryRow(s.query("SELECT local_id, email, provider_id, created, expires FROM %s WHERE id = ?"), id).
+		Scan(&sess.LocalID, &sess.Email, &sess.ProviderID, &created, &expires)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	sess.Created, sess.Expires = time.Unix(created, 0), time.Unix(expires, 0)
+	if !sess.Expires.After(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return sess, nil
+}
+
+// Delete implements the Store interface.
+func (s *SQLStore) Delete(ctx context.Context, id string) error {
+	_, err := s.DB.Exec(s.query("DELETE FROM %s WHERE id = ?"), id)
+	return err
+}
+
+// DeleteUser implements the UserStore interface.
+func (s *SQLStore) DeleteUser(ctx context.Context, localID string) error {
+	_, err := s.DB.Exec(s.query("DELETE FROM %s WHERE local_id = ?"), localID)
+	return err
+}
+
+// DeleteExpired removes the expired sessions from the table.
+func (s *SQLStore) DeleteExpired(ctx context.Context) error {
+	_, err := s.DB.Exec(s.query("DELETE FROM %s WHERE expires <= ?"), time.Now().Unix())
+	return err
+}
@@ -0,0 +1,135 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// fakeDriver is a database/sql driver which understands the queries of
+// SQLStore and keeps the rows in a map indexed by session ID.
+type fakeDriver struct {
+	rows map[string][]driver.Value
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) { return d, nil }
+func (d *fakeDriver) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{d, query}, nil
+}
+func (d *fakeDriver) Close() error              { return nil }
+func (d *fakeDriver) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type fakeStmt struct {
+	d     *fakeDriver
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	switch {
+	case strings.HasPrefix(s.query, "INSERT INTO gitkit_sessions"):
+		s.d.rows[args[0].(string)] = args[1:]
+	case strings.HasPrefix(s.query, "DELETE FROM gitkit_sessions WHERE id = ?"):
+		delete(s.d.rows, args[0].(string))
+	case strings.HasPrefix(s.query, "DELETE FROM gitkit_sessions WHERE local_id = ?"):
+		for id, row := range s.d.rows {
+			if row[0].(string) == args[0].(string) {
+				delete(s.d.rows, id)
+			}
+		}
+	case strings.HasPrefix(s.query, "DELETE FROM gitkit_sessions WHERE expires <= ?"):
+		for id, row := range s.d.rows {
+			if row[4].(int64) <= args[0].(int64) {
+				delete(s.d.rows, id)
+			}
+		}
+	default:
+		return nil, errors.New("unexpected query: " + s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if !strings.HasPrefix(s.query, "SELECT local_id, email, provider_id, created, expires FROM gitkit_sessions WHERE id = ?") {
+		return nil, errors.New("unexpected query: " + s.query)
+	}
+	rows := &fakeRows{}
+	if row, ok := s.d.rows[args[0].(string)]; ok {
+		rows.rows = append(rows.rows, row)
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"local_id", "email", "provider_id", "created", "expires"}
+}
+func (r *fakeRows) Close() error { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+var testDriver = &fakeDriver{rows: make(map[string][]driver.Value)}
+
+func init() {
+	sql.Register("sessiontest", testDriver)
+}
+
+func TestSQLStore(t *testing.T) {
+	db, err := sql.Open("sessiontest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+	testStore(t, store)
+	testUserStore(t, store)
+
+	testDriver.rows["expired"] = []driver.Value{"123", "", "", int64(0), int64(1)}
+	if err := store.DeleteExpired(context.Background()); err != nil {
+		t.Fatalf("DeleteExpired() returns error %v", err)
+	}
+	if _, ok := testDriver.rows["expired"]; ok {
+		t.Errorf("DeleteExpired() does not delete the expired session")
+	}
+}
+
+func TestSQLStoreQuery(t *testing.T) {
+	s := &SQLStore{Table: "sessions"}
+	if got, want := s.query("DELETE FROM %s WHERE id = ? AND expires <= ?"), "DELETE FROM sessions WHERE id = ? AND expires <= ?"; got != want {
+		t.Errorf("query() = %q; want %q", got, want)
+	}
+	s.DollarPlaceholders = true
+	if got, want := s.query("DELETE FROM %s WHERE id = ? AND expires <= ?"), "DELETE FROM sessions WHERE id = $1 AND expires <= $2"; got != want {
+		t.Errorf("query() = %q; want %q", got, want)
+	}
+}
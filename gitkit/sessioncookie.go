@@ -0,0 +1,329 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Default values of the SessionManager fields.
+const (
+	DefaultSessionCookieName = "gitkit_session"
+	DefaultSessionLifetime   = 24 * time.Hour
+	DefaultSessionIssuer     = "gitkit"
+	DefaultSessionAudience   = "gitkit_session"
+)
+
+// ErrNoSession is returned by SessionManager.Session if the request has no
+// session cookie.
+var ErrNoSession = errors.New("no session cookie")
+
+// A SessionManager issues signed session cookies to the users whose ID token
+// has been validated, and validates them on the later requests, so that the ID
+// token is only validated once per sign in. The cookies are stateless JWTs
+// signed with HS256, RS256 or ES256: no store is needed, but they can't be
+// revoked before they expire. See the session subpackage for revocable
+// sessions.
+//
+//	m := gitkit.NewHMACSessionManager(key)
+//	m.Secure = true
+//	http.Handle("/login", c.RequireToken(audiences, func(w http.ResponseWriter, r *http.Request, t *gitkit.Token) {
+//		if err := m.SetSession(w, r, t); err != nil {
+//			http.Error(w, err.Error(), http.StatusInternalServerError)
+//			return
+//		}
+//		http.Redirect(w, r, "/", http.StatusFound)
+//	}))
+//	http.Handle("/account", m.Require(func(w http.ResponseWriter, r *http.Request, t *gitkit.Token) {
+//		fmt.Fprintf(w, "Hello, %s", t.Email)
+//	}))
+type SessionManager struct {
+	signer Signer
+	pub    crypto.PublicKey // Verifies the signatures, or hmacKey.
+
+	// CookieName is the name of the session cookie.
+	CookieName string
+	// Path and Domain are the attributes of the session cookie. Path defaults
+	// to "/".
+	Path   string
+	Domain string
+	// Secure sets the Secure attribute of the session cookie. It should be set
+	// if the site is served over HTTPS only.
+	Secure bool
+	// SameSite is the SameSite attribute of the session cookie.
+	SameSite http.SameSite
+	// Lifetime is the lifetime of the session cookies.
+	Lifetime time.Duration
+	// RotateAfter, if not zero, is the age after which Require replaces the
+	// session cookie of a request by a fresh one, so that active users stay
+	// signed in.
+	RotateAfter time.Duration
+	// MaxLifetime, if not zero, bounds the lifetime of the sessions since the
+	// user signed in, whatever the rotations.
+	MaxLifetime time.Duration
+	// Issuer and Audience are the iss and aud claims of the session cookies,
+	// which are checked so that other JWTs signed with the same key, e.g.,
+	// by a TokenExchanger, are not taken for session cookies. They default to
+	// DefaultSessionIssuer and DefaultSessionAudience.
+	Issuer   string
+	Audience string
+	// Context returns the context used to sign the cookies while serving a
+	// request. If nil, the context of the request is used.
+	Context func(*http.Request) context.Context
+}
+
+// NewSessionManager creates a SessionManager which signs the session cookies
+// with the signer, e.g., a KMS signer, and validates them with its public key,
+// an *rsa.PublicKey for RS256 or an *ecdsa.PublicKey for ES256.
+func NewSessionManager(s Signer, pub crypto.PublicKey) *SessionManager {
+	return &SessionManager{
+		signer:     s,
+		pub:        pub,
+		CookieName: DefaultSessionCookieName,
+		Path:       "/",
+		Lifetime:   DefaultSessionLifetime,
+		Issuer:     DefaultSessionIssuer,
+		Audience:   DefaultSessionAudience,
+	}
+}
+
+// NewHMACSessionManager creates a SessionManager which signs the session
+// cookies with HS256 using the key, which should be at least 32 random bytes.
+func NewHMACSessionManager(key []byte) *SessionManager {
+	return NewSessionManager(&hmacSigner{key}, hmacKey(key))
+}
+
+// NewRSASessionManager creates a SessionManager which signs the session
+// cookies with RS256 using the private key, so that other services can
+// validate them with the public key.
+func NewRSASessionManager(key *rsa.PrivateKey) *SessionManager {
+	return NewSessionManager(NewRSASigner(key, ""), &key.PublicKey)
+}
+
+// hmacKey is the key of HS256 signatures, which verifies them as a public
+// key.
+type hmacKey []byte
+
+// verify checks the signature of the session cookie input.
+func (m *SessionManager) verify(input, signature []byte) error {
+	if key, ok := m.pub.(hmacKey); ok {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(input)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+	return checkSignature(m.pub, m.signer.Algorithm(), input, signature)
+}
+
+type hmacSigner struct {
+	key []byte
+}
+
+func (s *hmacSigner) Algorithm() string { return "HS256" }
+func (s *hmacSigner) KeyID() string     { return "" }
+
+func (s *hmacSigner) Sign(ctx context.Context, data []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// sessionClaims are the claims of the session cookies.
+type sessionClaims struct {
+	Issuer        string `json:"iss"`
+	Audience      string `json:"aud"`
+	Subject       string `json:"sub"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
+	ProviderID    string `json:"provider_id,omitempty"`
+	Name          string `json:"name,omitempty"`
+	Picture       string `json:"picture,omitempty"`
+	AuthTime      int64  `json:"auth_time"`
+	IssuedAt      int64  `json:"iat"`
+	Expires       int64  `json:"exp"`
+}
+
+func (m *SessionManager) context(r *http.Request) context.Context {
+	return RequestContext(m.Context, r)
+}
+
+// SetSession sets the session cookie of the user of t, which must have been
+// validated, e.g., by Client.ValidateToken. The user is considered signed in
+// at the time t was issued.
+func (m *SessionManager) SetSession(w http.ResponseWriter, r *http.Request, t *Token) error {
+	authTime := t.IssueAt
+	if authTime.IsZero() {
+		authTime = time.Now()
+	}
+	return m.setCookie(w, r, &sessionClaims{
+		Subject:       t.LocalID,
+		Email:         t.Email,
+		EmailVerified: t.EmailVerified,
+		ProviderID:    t.ProviderID,
+		Name:          t.DisplayName,
+		Picture:       t.PhotoURL,
+		AuthTime:      authTime.Unix(),
+	})
+}
+
+// setCookie signs the claims, issued now, and sets them as the session
+// cookie.
+func (m *SessionManager) setCookie(w http.ResponseWriter, r *http.Request, claims *sessionClaims) error {
+	now := time.Now()
+	exp := now.Add(m.Lifetime)
+	if m.MaxLifetime > 0 {
+		if max := time.Unix(claims.AuthTime, 0).Add(m.MaxLifetime); max.Before(exp) {
+			exp = max
+		}
+	}
+	if !exp.After(now) {
+		return ErrExpired
+	}
+	claims.Issuer, claims.Audience = m.Issuer, m.Audience
+	claims.IssuedAt, claims.Expires = now.Unix(), exp.Unix()
+	value, err := signJWT(m.context(r), m.signer, claims)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.CookieName,
+		Value:    value,
+		Path:     m.Path,
+		Domain:   m.Domain,
+		Expires:  exp,
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: m.SameSite,
+	})
+	return nil
+}
+
+// ClearSession deletes the session cookie, e.g., to sign the user out. The
+// cookie is deleted with the attributes it is set with, which browsers may
+// require to replace it.
+func (m *SessionManager) ClearSession(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.CookieName,
+		Path:     m.Path,
+		Domain:   m.Domain,
+		MaxAge:   -1,
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: m.SameSite,
+	})
+}
+
+// Session validates the session cookie of the request and returns the token
+// of its user. Issuer and Audience are those of the SessionManager, IssueAt
+// and ExpireAt are the times the cookie was issued at and expires at, and
+// TokenString is the cookie value. ErrNoSession is returned if the request has no session
+// cookie.
+func (m *SessionManager) Session(r *http.Request) (*Token, error) {
+	t, _, err := m.session(r)
+	return t, err
+}
+
+func (m *SessionManager) session(r *http.Request) (*Token, *sessionClaims, error) {
+	c, err := r.Cookie(m.CookieName)
+	if err != nil || c.Value == "" {
+		return nil, nil, ErrNoSession
+	}
+	parts := strings.Split(c.Value, ".")
+	if len(parts) != 3 {
+		return nil, nil, ErrMalformed
+	}
+	h, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, nil, ErrMalformed
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	if err := json.Unmarshal(h, &header); err != nil {
+		return nil, nil, ErrMalformed
+	}
+	if header.Algorithm != m.signer.Algorithm() {
+		return nil, nil, ErrInvalidAlgorithm
+	}
+	sig, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, nil, ErrMalformed
+	}
+	if err := m.verify([]byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, nil, err
+	}
+	b, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, nil, ErrMalformed
+	}
+	claims := &sessionClaims{}
+	if err := json.Unmarshal(b, claims); err != nil {
+		return nil, nil, ErrMalformed
+	}
+	if claims.Issuer != m.Issuer {
+		return nil, nil, ErrInvalidIssuer
+	}
+	if claims.Audience != m.Audience {
+		return nil, nil, ErrInvalidAudience
+	}
+	exp := time.Unix(claims.Expires, 0)
+	if !time.Now().Before(exp) {
+		return nil, nil, ErrExpired
+	}
+	return &Token{
+		Issuer:        claims.Issuer,
+		Audience:      claims.Audience,
+		IssueAt:       time.Unix(claims.IssuedAt, 0),
+		ExpireAt:      exp,
+		LocalID:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		ProviderID:    claims.ProviderID,
+		DisplayName:   claims.Name,
+		PhotoURL:      claims.Picture,
+		TokenString:   c.Value,
+	}, claims, nil
+}
+
+// Require returns an http.Handler which calls h with the token of the valid
+// session cookie of the requests, and answers the requests without one with
+// 401 Unauthorized. The session cookies older than RotateAfter are replaced.
+func (m *SessionManager) Require(h TokenHandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, claims, err := m.session(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if m.RotateAfter > 0 && time.Since(t.IssueAt) >= m.RotateAfter {
+			// The current cookie stays valid if it can't be rotated, e.g.,
+			// at the end of MaxLifetime.
+			m.setCookie(w, r, claims)
+		}
+		h(w, r, t)
+	})
+}
@@ -0,0 +1,172 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// sessionRequest returns a request carrying the session cookies set on w.
+func sessionRequest(w *httptest.ResponseRecorder) *http.Request {
+	req, _ := http.NewRequest("GET", "http://www.example.com/account", nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestSessionManager(t *testing.T) {
+	token := &Token{LocalID: "123", Email: "user@example.com", EmailVerified: true, ProviderID: "google.com", IssueAt: time.Now()}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	managers := map[string]*SessionManager{
+		"HMAC":  NewHMACSessionManager([]byte("0123456789abcdef0123456789abcdef")),
+		"RSA":   NewRSASessionManager(loadTestKey(t)),
+		"ES256": NewSessionManager(&es256Signer{key: ecKey}, &ecKey.PublicKey),
+	}
+	for name, m := range managers {
+		m.Secure = true
+		m.SameSite = http.SameSiteLaxMode
+		w := httptest.NewRecorder()
+		if err := m.SetSession(w, nil, token); err != nil {
+			t.Fatalf("%s: SetSession() returns error: %v", name, err)
+		}
+		c := w.Result().Cookies()[0]
+		if c.Name != DefaultSessionCookieName || !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode {
+			t.Errorf("%s: session cookie = %v; want an HttpOnly, Secure and SameSite=Lax cookie", name, c)
+		}
+		cw := httptest.NewRecorder()
+		m.ClearSession(cw)
+		if c := cw.Result().Cookies()[0]; c.Name != DefaultSessionCookieName || c.MaxAge >= 0 || !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode {
+			t.Errorf("%s: cleared session cookie = %v; want an expired HttpOnly, Secure and SameSite=Lax cookie", name, c)
+		}
+		got, err := m.Session(sessionRequest(w))
+		if err != nil {
+			t.Fatalf("%s: Session() returns error: %v", name, err)
+		}
+		if got.LocalID != token.LocalID || got.Email != token.Email || !got.EmailVerified || got.ProviderID != token.ProviderID {
+			t.Errorf("%s: Session() = %+v; want the user of %+v", name, got, token)
+		}
+		if got.Issuer != DefaultSessionIssuer || got.Audience != DefaultSessionAudience {
+			t.Errorf("%s: Session() issuer and audience = %q, %q; want the defaults", name, got.Issuer, got.Audience)
+		}
+
+		// Tamper with the claims.
+		req, _ := http.NewRequest("GET", "http://www.example.com/account", nil)
+		req.AddCookie(&http.Cookie{Name: DefaultSessionCookieName, Value: c.Value[:len(c.Value)-2] + "AA"})
+		if _, err := m.Session(req); err != ErrInvalidSignature {
+			t.Errorf("%s: Session() of a forged cookie returns error %v; want ErrInvalidSignature", name, err)
+		}
+	}
+
+	// A cookie of another manager is rejected.
+	w := httptest.NewRecorder()
+	managers["RSA"].SetSession(w, nil, token)
+	if _, err := managers["HMAC"].Session(sessionRequest(w)); err != ErrInvalidAlgorithm {
+		t.Errorf("Session() of an RS256 cookie returns error %v; want ErrInvalidAlgorithm", err)
+	}
+	// So is a JWT of the same key for another audience.
+	e := &TokenExchanger{Signer: NewRSASigner(loadTestKey(t), ""), Issuer: "https://example.com", Audience: "backend"}
+	jwt, err := e.Exchange(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "http://www.example.com/account", nil)
+	req.AddCookie(&http.Cookie{Name: DefaultSessionCookieName, Value: jwt})
+	if _, err := managers["RSA"].Session(req); err != ErrInvalidIssuer {
+		t.Errorf("Session() of an exchanged token returns error %v; want ErrInvalidIssuer", err)
+	}
+	e.Issuer = DefaultSessionIssuer
+	jwt, _ = e.Exchange(context.Background(), token)
+	req, _ = http.NewRequest("GET", "http://www.example.com/account", nil)
+	req.AddCookie(&http.Cookie{Name: DefaultSessionCookieName, Value: jwt})
+	if _, err := managers["RSA"].Session(req); err != ErrInvalidAudience {
+		t.Errorf("Session() of an exchanged token returns error %v; want ErrInvalidAudience", err)
+	}
+
+	req, _ = http.NewRequest("GET", "http://www.example.com/account", nil)
+	if _, err := managers["HMAC"].Session(req); err != ErrNoSession {
+		t.Errorf("Session() without cookie returns error %v; want ErrNoSession", err)
+	}
+}
+
+func TestSessionManager_lifetime(t *testing.T) {
+	m := NewHMACSessionManager([]byte("key"))
+	m.MaxLifetime = time.Hour
+	w := httptest.NewRecorder()
+	if err := m.SetSession(w, nil, &Token{LocalID: "123", IssueAt: time.Now().Add(-2 * time.Hour)}); err != ErrExpired {
+		t.Errorf("SetSession() after MaxLifetime returns error %v; want ErrExpired", err)
+	}
+	if err := m.SetSession(w, nil, &Token{LocalID: "123", IssueAt: time.Now().Add(-30 * time.Minute)}); err != nil {
+		t.Fatalf("SetSession() returns error: %v", err)
+	}
+	got, err := m.Session(sessionRequest(w))
+	if err != nil {
+		t.Fatalf("Session() returns error: %v", err)
+	}
+	if d := got.ExpireAt.Sub(time.Now()); d > 31*time.Minute {
+		t.Errorf("session expires in %v; want at most 30m, the end of MaxLifetime", d)
+	}
+
+	m.Lifetime = -time.Minute
+	m.MaxLifetime = 0
+	w = httptest.NewRecorder()
+	if err := m.SetSession(w, nil, &Token{LocalID: "123"}); err != ErrExpired {
+		t.Errorf("SetSession() with a negative lifetime returns error %v; want ErrExpired", err)
+	}
+}
+
+func TestSessionManager_Require(t *testing.T) {
+	m := NewHMACSessionManager([]byte("key"))
+	var got *Token
+	h := m.Require(func(w http.ResponseWriter, r *http.Request, t *Token) {
+		got = t
+	})
+	req, _ := http.NewRequest("GET", "http://www.example.com/account", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status without session = %d; want 401", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	m.SetSession(w, nil, &Token{LocalID: "123"})
+	req = sessionRequest(w)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got == nil || got.LocalID != "123" {
+		t.Errorf("handler called with %+v; want the token of user 123", got)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("Require() rotates a fresh session cookie")
+	}
+
+	m.RotateAfter = time.Nanosecond
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != DefaultSessionCookieName {
+		t.Errorf("Require() sets cookies %v; want a rotated session cookie", cookies)
+	}
+}
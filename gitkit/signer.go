@@ -0,0 +1,205 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// A Signer signs the JWTs minted by the Client utilities.
+type Signer interface {
+	// Algorithm returns the JWS algorithm of the signatures, e.g., RS256.
+	Algorithm() string
+	// KeyID returns the ID of the signing key set in the JWT header, if any.
+	KeyID() string
+	// Sign signs the data.
+	Sign(ctx context.Context, data []byte) ([]byte, error)
+}
+
+type rsaSigner struct {
+	key   *rsa.PrivateKey
+	keyID string
+}
+
+// NewRSASigner returns a Signer which signs with RS256 using the private key.
+func NewRSASigner(key *rsa.PrivateKey, keyID string) Signer {
+	return &rsaSigner{key, keyID}
+}
+
+func (s *rsaSigner) Algorithm() string { return "RS256" }
+func (s *rsaSigner) KeyID() string     { return s.keyID }
+
+func (s *rsaSigner) Sign(ctx context.Context, data []byte) ([]byte, error) {
+	h := sha256.Sum256(data)
+	return rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, h[:])
+}
+
+// Endpoints of the remote signing APIs.
+var (
+	IAMCredentialsBaseURL = "https://iamcredentials.googleapis.com/v1"
+	CloudKMSBaseURL       = "https://cloudkms.googleapis.com/v1"
+)
+
+// maxSignerResponse is the maximum size of the signing API responses read by
+// postJSON.
+const maxSignerResponse = 1 << 20
+
+// postJSON posts the JSON encoded req to the URL and decodes the response into
+// resp.
+func postJSON(ctx context.Context, client *http.Client, u string, req, resp interface{}) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequest("POST", u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	res, err := client.Do(r.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	res.Body = ioutil.NopCloser(io.LimitReader(res.Body, maxSignerResponse))
+	if err := googleapi.CheckResponse(res); err != nil {
+		return err
+	}
+	b, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, resp)
+}
+
+type iamSigner struct {
+	client         *http.Client
+	serviceAccount string
+	keyID          string
+}
+
+// NewIAMSigner returns a Signer which signs with RS256 using the system managed
+// key of the service account through the IAM Credentials signBlob API, so no
+// private key is kept by the application. The client must be authorized with
+// the https://www.googleapis.com/auth/cloud-platform scope for an identity
+// granted iam.serviceAccounts.signBlob on the service account, e.g.,
+//
+//	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/cloud-platform")
+//
+// keyID is set in the JWT headers if not empty. As signBlob may sign with any
+// of the keys of the service account, verifiers should fetch the public keys
+// of the service account rather than rely on the key ID.
+func NewIAMSigner(client *http.Client, serviceAccount, keyID string) Signer {
+	return &iamSigner{client, serviceAccount, keyID}
+}
+
+func (s *iamSigner) Algorithm() string { return "RS256" }
+func (s *iamSigner) KeyID() string     { return s.keyID }
+
+func (s *iamSigner) Sign(ctx context.Context, data []byte) ([]byte, error) {
+	u := fmt.Sprintf("%s/projects/-/serviceAccounts/%s:signBlob", IAMCredentialsBaseURL, url.QueryEscape(s.serviceAccount))
+	req := struct {
+		Payload []byte `json:"payload"`
+	}{data}
+	var resp struct {
+		KeyID      string `json:"keyId"`
+		SignedBlob []byte `json:"signedBlob"`
+	}
+	if err := postJSON(ctx, s.client, u, &req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.SignedBlob, nil
+}
+
+type kmsSigner struct {
+	client     *http.Client
+	keyVersion string
+	algorithm  string
+}
+
+// NewKMSSigner returns a Signer which signs with the Cloud KMS asymmetric
+// signing key version, e.g.,
+// projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1,
+// so the private key never leaves Cloud KMS. The key version name is used as
+// the key ID.
+//
+// The algorithm must match the key: RS256 for RSA_SIGN_PKCS1_*_SHA256 keys or
+// ES256 for EC_SIGN_P256_SHA256 keys. The client must be authorized with the
+// https://www.googleapis.com/auth/cloudkms scope for an identity granted
+// cloudkms.cryptoKeyVersions.useToSign on the key.
+func NewKMSSigner(client *http.Client, keyVersion, algorithm string) (Signer, error) {
+	if algorithm != "RS256" && algorithm != "ES256" {
+		return nil, fmt.Errorf("unsupported Cloud KMS signing algorithm %s", algorithm)
+	}
+	return &kmsSigner{client, keyVersion, algorithm}, nil
+}
+
+func (s *kmsSigner) Algorithm() string { return s.algorithm }
+func (s *kmsSigner) KeyID() string     { return s.keyVersion }
+
+func (s *kmsSigner) Sign(ctx context.Context, data []byte) ([]byte, error) {
+	h := sha256.Sum256(data)
+	req := struct {
+		Digest struct {
+			SHA256 []byte `json:"sha256"`
+		} `json:"digest"`
+	}{}
+	req.Digest.SHA256 = h[:]
+	var resp struct {
+		Signature []byte `json:"signature"`
+	}
+	u := fmt.Sprintf("%s/%s:asymmetricSign", CloudKMSBaseURL, s.keyVersion)
+	if err := postJSON(ctx, s.client, u, &req, &resp); err != nil {
+		return nil, err
+	}
+	if s.algorithm == "ES256" {
+		return ecdsaDERToJWS(resp.Signature, 32)
+	}
+	return resp.Signature, nil
+}
+
+// ecdsaDERToJWS converts an ASN.1 DER encoded ECDSA signature to the JWS
+// encoding, the concatenation of r and s of size bytes each.
+func ecdsaDERToJWS(der []byte, size int) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("malformed ECDSA signature: %v", err)
+	}
+	rb, sb := sig.R.Bytes(), sig.S.Bytes()
+	if len(rb) > size || len(sb) > size {
+		return nil, fmt.Errorf("malformed ECDSA signature: invalid size")
+	}
+	b := make([]byte, 2*size)
+	copy(b[size-len(rb):], rb)
+	copy(b[2*size-len(sb):], sb)
+	return b, nil
+}
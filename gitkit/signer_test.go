@@ -0,0 +1,143 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// signingRoundTripper serves the signing APIs with the local keys.
+type signingRoundTripper struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	urls   []string
+}
+
+func (s *signingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.urls = append(s.urls, req.URL.String())
+	b, _ := ioutil.ReadAll(req.Body)
+	var body struct {
+		Payload []byte `json:"payload"`
+		Digest  struct {
+			SHA256 []byte `json:"sha256"`
+		} `json:"digest"`
+	}
+	json.Unmarshal(b, &body)
+	var resp interface{}
+	switch {
+	case strings.HasSuffix(req.URL.Path, ":signBlob"):
+		h := sha256.Sum256(body.Payload)
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, s.rsaKey, crypto.SHA256, h[:])
+		resp = map[string]interface{}{"keyId": "k", "signedBlob": sig}
+	case strings.HasSuffix(req.URL.Path, ":asymmetricSign") && s.ecKey != nil:
+		sig, _ := s.ecKey.Sign(rand.Reader, body.Digest.SHA256, crypto.SHA256)
+		resp = map[string]interface{}{"signature": sig}
+	case strings.HasSuffix(req.URL.Path, ":asymmetricSign"):
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, s.rsaKey, crypto.SHA256, body.Digest.SHA256)
+		resp = map[string]interface{}{"signature": sig}
+	default:
+		return roundTripper{404, `{"error":{"code":404,"message":"not found"}}`}.RoundTrip(req)
+	}
+	b, _ = json.Marshal(resp)
+	return roundTripper{200, string(b)}.RoundTrip(req)
+}
+
+func TestIAMSigner(t *testing.T) {
+	key := loadTestKey(t)
+	rt := &signingRoundTripper{rsaKey: key}
+	e := &TokenExchanger{Signer: NewIAMSigner(&http.Client{Transport: rt}, "signer@p.iam.gserviceaccount.com", "")}
+	s, err := e.Exchange(context.Background(), &Token{LocalID: "123", ExpireAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _ := decodeJWT(t, s, &key.PublicKey)
+	if header["alg"] != "RS256" || header["kid"] != nil {
+		t.Errorf("header = %v; want RS256 without key ID", header)
+	}
+	if want := IAMCredentialsBaseURL + "/projects/-/serviceAccounts/signer%40p.iam.gserviceaccount.com:signBlob"; rt.urls[0] != want {
+		t.Errorf("signBlob URL = %s; want %s", rt.urls[0], want)
+	}
+}
+
+func TestKMSSigner(t *testing.T) {
+	const keyVersion = "projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+	if _, err := NewKMSSigner(http.DefaultClient, keyVersion, "HS256"); err == nil {
+		t.Errorf("NewKMSSigner() accepts HS256")
+	}
+
+	key := loadTestKey(t)
+	rt := &signingRoundTripper{rsaKey: key}
+	signer, err := NewKMSSigner(&http.Client{Transport: rt}, keyVersion, "RS256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &TokenExchanger{Signer: signer}
+	s, err := e.Exchange(context.Background(), &Token{LocalID: "123", ExpireAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _ := decodeJWT(t, s, &key.PublicKey)
+	if header["alg"] != "RS256" || header["kid"] != keyVersion {
+		t.Errorf("header = %v; want RS256 with the key version as key ID", header)
+	}
+	if want := CloudKMSBaseURL + "/" + keyVersion + ":asymmetricSign"; rt.urls[0] != want {
+		t.Errorf("asymmetricSign URL = %s; want %s", rt.urls[0], want)
+	}
+
+	// ES256 signatures are converted from DER to the JWS encoding.
+	rt.ecKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	signer, _ = NewKMSSigner(&http.Client{Transport: rt}, keyVersion, "ES256")
+	for i := 0; i < 10; i++ {
+		data := []byte(fmt.Sprintf("data %d", i))
+		sig, err := signer.Sign(context.Background(), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sig) != 64 {
+			t.Fatalf("ES256 signature length = %d; want 64", len(sig))
+		}
+		h := sha256.Sum256(data)
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(&rt.ecKey.PublicKey, h[:], r, s) {
+			t.Errorf("invalid ES256 signature of %q", data)
+		}
+	}
+}
+
+func TestIAMSigner_context(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	signer := NewIAMSigner(&http.Client{Transport: blockingRoundTripper(block)}, "signer@p.iam.gserviceaccount.com", "")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := signer.Sign(ctx, []byte("data")); err == nil {
+		t.Error("Sign() with a canceled context returns no error")
+	}
+}
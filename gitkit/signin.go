@@ -0,0 +1,83 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrInvalidCredentials is returned by SignInWithPassword when the email
+// address is unknown or the password is wrong.
+var ErrInvalidCredentials = errors.New("gitkit: invalid email or password")
+
+// SignInWithPassword signs the user in with the email address and password,
+// e.g., for a mobile API backend which does not use the widget, and returns
+// the ID token and the account information of the user. Wrong credentials are
+// reported as ErrInvalidCredentials. If Config.PasswordLockout is set, the
+// failed attempts are counted per email address and a *LockedOutError is
+// returned while the address is locked out, without calling identitytoolkit.
+func (c *Client) SignInWithPassword(ctx context.Context, email, password string) (string, *User, error) {
+	var resp *VerifyPasswordResponse
+	try := func() (bool, error) {
+		var err error
+		resp, err = c.callAPIClient(ctx).VerifyPassword(ctx, &VerifyPasswordRequest{
+			Email:    email,
+			Password: password,
+		})
+		if isInvalidCredentials(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	var (
+		ok  bool
+		err error
+	)
+	if p := c.config.PasswordLockout; p != nil {
+		ok, err = p.Attempt(ctx, strings.ToLower(email), try)
+	} else {
+		ok, err = try()
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if !ok {
+		return "", nil, ErrInvalidCredentials
+	}
+	u, err := c.UserByLocalID(ctx, resp.LocalID)
+	if err != nil {
+		return "", nil, err
+	}
+	return resp.IDToken, u, nil
+}
+
+// isInvalidCredentials reports whether the verifyPassword error is caused by
+// an unknown email address or a wrong password.
+func isInvalidCredentials(err error) bool {
+	ae, ok := err.(*googleapi.Error)
+	if !ok || ae.Code != http.StatusBadRequest {
+		return false
+	}
+	switch ae.Message {
+	case string(ErrorCodeEmailNotFound), string(ErrorCodeInvalidPassword):
+		return true
+	}
+	return false
+}
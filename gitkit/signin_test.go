@@ -0,0 +1,93 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// passwordRoundTripper signs in user@example.com with the password and
+// returns the user from getAccountInfo.
+type passwordRoundTripper struct {
+	password string
+	calls    int // verifyPassword calls.
+}
+
+func (r *passwordRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if path.Base(req.URL.Path) == string(getAccountInfo) {
+		return roundTripper{http.StatusOK, `{"users":[{"localId":"123","email":"user@example.com"}]}`}.RoundTrip(req)
+	}
+	r.calls++
+	var vr VerifyPasswordRequest
+	json.NewDecoder(req.Body).Decode(&vr)
+	switch {
+	case vr.Email != "user@example.com":
+		return roundTripper{http.StatusBadRequest, `{"error":{"code":400,"message":"EMAIL_NOT_FOUND"}}`}.RoundTrip(req)
+	case vr.Password != r.password:
+		return roundTripper{http.StatusBadRequest, `{"error":{"code":400,"message":"INVALID_PASSWORD"}}`}.RoundTrip(req)
+	}
+	return roundTripper{http.StatusOK, `{"idToken":"token","localId":"123","email":"user@example.com","registered":true}`}.RoundTrip(req)
+}
+
+func TestSignInWithPassword(t *testing.T) {
+	tests := []struct {
+		email, password string
+		err             error
+	}{
+		{"user@example.com", "secret", nil},
+		{"user@example.com", "wrong", ErrInvalidCredentials},
+		{"other@example.com", "secret", ErrInvalidCredentials},
+	}
+	for i, tt := range tests {
+		rt := &passwordRoundTripper{password: "secret"}
+		c := &Client{config: &Config{}, api: &APIClient{Client: http.Client{Transport: rt}}}
+		token, u, err := c.SignInWithPassword(context.Background(), tt.email, tt.password)
+		if err != tt.err {
+			t.Errorf("[%d] SignInWithPassword() returns error %v; want %v", i, err, tt.err)
+		}
+		if err == nil && (token != "token" || u == nil || u.LocalID != "123") {
+			t.Errorf("[%d] SignInWithPassword() = %q, %+v; want the token and user 123", i, token, u)
+		}
+	}
+}
+
+func TestSignInWithPassword_lockout(t *testing.T) {
+	rt := &passwordRoundTripper{password: "secret"}
+	c := &Client{
+		config: &Config{PasswordLockout: &LockoutPolicy{Store: &MemoryAttemptStore{}, Threshold: 2, Duration: time.Hour}},
+		api:    &APIClient{Client: http.Client{Transport: rt}},
+	}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, _, err := c.SignInWithPassword(ctx, "user@example.com", "wrong"); err != ErrInvalidCredentials {
+			t.Fatalf("[%d] SignInWithPassword() returns error %v; want ErrInvalidCredentials", i, err)
+		}
+	}
+	// The lockout applies regardless of the case of the email address.
+	if _, _, err := c.SignInWithPassword(ctx, "User@Example.com", "secret"); err == nil {
+		t.Errorf("SignInWithPassword() of a locked out user returns nil error")
+	} else if _, ok := err.(*LockedOutError); !ok {
+		t.Errorf("SignInWithPassword() of a locked out user returns error %v; want *LockedOutError", err)
+	}
+	if rt.calls != 2 {
+		t.Errorf("verifyPassword called %d times; want 2", rt.calls)
+	}
+}
@@ -15,14 +15,17 @@
 package gitkit
 
 import (
-	"crypto/x509"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"math/big"
 	"strings"
+	"sync"
 	"time"
-
-	_ "crypto/sha256" // sha256 is required to check the token signature.
 )
 
 // Token is a verified ID token issued by identitytoolkit service.
@@ -54,6 +57,11 @@ type Token struct {
 	PhotoURL string
 	// The token string.
 	TokenString string
+	// Roles are the application roles of the user, mapped from the claims by
+	// Config.RoleMapper.
+	Roles []string
+
+	claims map[string]interface{} // All the claims, decoded by the verification.
 }
 
 // Expired checks whether or not the ID token is expired.
@@ -71,25 +79,79 @@ var (
 	ErrKeyNotFound      = errors.New("key not found")
 	ErrExpired          = errors.New("token expired")
 	ErrMissingAudience  = errors.New("missing audiences for token validation")
+	ErrIssuedInFuture   = errors.New("token issued in the future")
 )
 
+// DefaultClockSkew is the leeway of the expiration and issue times of the ID
+// tokens, allowing for the clock skew between identitytoolkit and the server.
+const DefaultClockSkew = 5 * time.Minute
+
+// VerifyOptions are the options of VerifyTokenWithOptions.
+type VerifyOptions struct {
+	// Audiences are the accepted audiences. At least one is required.
+	Audiences []string
+	// Issuers, if not nil, are the accepted issuers.
+	Issuers []string
+	// ClockSkew is the leeway of the expiration and issue times,
+	// DefaultClockSkew if zero. A negative value disables the leeway.
+	ClockSkew time.Duration
+}
+
 // VerifyToken verifies the JWT is valid and signed by identitytoolkit service
 // and returns the verfied token. A token is valid if and only if it passes the
 // following checks:
 // 1. The value of "iss" field is one of the issuers if issuers is not nil;
 // 2. The value of "aud" field is the same as the audience;
 // 3. The token is not expired according to the "exp" field;
-// 4. The signature can be verified from one of the certs;
+// 4. The token is not issued in the future according to the "iat" field;
+// 5. The signature can be verified from one of the certs;
+//
+// The expiration and issue times are checked with DefaultClockSkew leeway.
 func VerifyToken(token string, audiences []string, issuers []string, certs *Certificates) (*Token, error) {
+	return VerifyTokenWithResolver(token, audiences, issuers, certs)
+}
+
+// VerifyTokenWithResolver is like VerifyToken with the public keys verifying
+// the signatures resolved by r. RS256 and ES256 signatures are supported.
+func VerifyTokenWithResolver(token string, audiences []string, issuers []string, r KeyResolver) (*Token, error) {
+	return VerifyTokenWithOptions(token, r, &VerifyOptions{Audiences: audiences, Issuers: issuers})
+}
+
+// VerifyTokenWithOptions is like VerifyTokenWithResolver with the accepted
+// audiences and issuers and the clock skew leeway of opts.
+func VerifyTokenWithOptions(token string, r KeyResolver, opts *VerifyOptions) (*Token, error) {
+	audiences, issuers := opts.Audiences, opts.Issuers
 	if len(audiences) == 0 {
 		return nil, ErrMissingAudience
 	}
-	parts := strings.Split(token, ".")
-	if len(parts) != 3 {
+	skew := opts.ClockSkew
+	switch {
+	case skew == 0:
+		skew = DefaultClockSkew
+	case skew < 0:
+		skew = 0
+	}
+	// Split the token without allocating the parts. The signing input is the
+	// token up to the second dot.
+	dot1 := strings.IndexByte(token, '.')
+	if dot1 < 0 {
+		return nil, ErrMalformed
+	}
+	dot2 := strings.IndexByte(token[dot1+1:], '.')
+	if dot2 < 0 {
+		return nil, ErrMalformed
+	}
+	dot2 += dot1 + 1
+	if strings.IndexByte(token[dot2+1:], '.') >= 0 {
 		return nil, ErrMalformed
 	}
+	buf := verifyBufPool.Get().(*verifyBuf)
+	defer verifyBufPool.Put(buf)
+	buf.token = append(buf.token[:0], token...)
+	header, claimSet, sig := buf.token[:dot1], buf.token[dot1+1:dot2], buf.token[dot2+1:]
+
 	// Check the claim set.
-	c, err := decodeSegment(parts[1])
+	c, err := buf.decode(claimSet)
 	if err != nil {
 		return nil, ErrMalformed
 	}
@@ -114,36 +176,48 @@ func VerifyToken(token string, audiences []string, issuers []string, certs *Cert
 	if !inArray(audiences, claims.Aud) {
 		return nil, ErrInvalidAudience
 	}
-	exp := time.Unix(claims.Exp, 0)
-	if time.Now().After(exp) {
+	now := time.Now()
+	if now.After(time.Unix(claims.Exp, 0).Add(skew)) {
 		return nil, ErrExpired
 	}
+	if now.Add(skew).Before(time.Unix(claims.Iat, 0)) {
+		return nil, ErrIssuedInFuture
+	}
+	// Keep all the claims for Token.Claims while c is valid.
+	var all map[string]interface{}
+	if err = json.Unmarshal(c, &all); err != nil {
+		return nil, ErrMalformed
+	}
 	// Check the header to extract the "kid" field.
-	h, err := decodeSegment(parts[0])
+	h, err := buf.decode(header)
 	if err != nil {
 		return nil, err
 	}
-	header := struct {
+	hdr := struct {
 		Algorithm string `json:"alg,omitempty"`
 		KeyID     string `json:"kid,omitempty"`
 	}{}
-	if err = json.Unmarshal(h, &header); err != nil {
+	if err = json.Unmarshal(h, &hdr); err != nil {
 		return nil, ErrMalformed
 	}
-	if header.Algorithm != "RS256" {
-		return nil, ErrInvalidAlgorithm
-	}
-	cert, err := certs.Cert(header.KeyID)
-	if err != nil {
-		return nil, ErrKeyNotFound
-	}
-	// Check the signature.
-	signature, err := decodeSegment(parts[2])
-	if err != nil {
-		return nil, ErrMalformed
-	}
-	if err := cert.CheckSignature(x509.SHA256WithRSA, []byte(parts[0]+"."+parts[1]), signature); err != nil {
-		return nil, ErrInvalidSignature
+	if _, ok := r.(unsignedKeyResolver); ok && hdr.Algorithm == "none" && emulatorHostIsLoopback() {
+		// The unsigned tokens of the emulator, see PresetEmulator.
+	} else {
+		if hdr.Algorithm != "RS256" && hdr.Algorithm != "ES256" {
+			return nil, ErrInvalidAlgorithm
+		}
+		key, err := r.ResolveKey(hdr.KeyID, hdr.Algorithm)
+		if err != nil {
+			return nil, ErrKeyNotFound
+		}
+		// Check the signature.
+		signature, err := buf.decode(sig)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		if err := checkSignature(key, hdr.Algorithm, buf.token[:dot2], signature); err != nil {
+			return nil, err
+		}
 	}
 	return &Token{
 		Issuer:        claims.Iss,
@@ -157,6 +231,7 @@ func VerifyToken(token string, audiences []string, issuers []string, certs *Cert
 		DisplayName:   claims.DisplayName,
 		PhotoURL:      claims.PhotoURL,
 		TokenString:   token,
+		claims:        all,
 	}, nil
 }
 
@@ -169,6 +244,62 @@ func inArray(a []string, e string) bool {
 	return false
 }
 
+// verifyBuf holds the buffers VerifyToken reuses across calls.
+type verifyBuf struct {
+	token []byte // Copy of the token the segments are sliced from.
+	dec   []byte // Decoded segment.
+}
+
+var verifyBufPool = sync.Pool{New: func() interface{} { return new(verifyBuf) }}
+
+// decode decodes the Base64 encoding segment of the JWT token into b.dec and
+// returns it. The result is only valid until the next call.
+func (b *verifyBuf) decode(seg []byte) ([]byte, error) {
+	for len(seg) > 0 && seg[len(seg)-1] == '=' {
+		seg = seg[:len(seg)-1]
+	}
+	n := base64.RawURLEncoding.DecodedLen(len(seg))
+	if cap(b.dec) < n {
+		b.dec = make([]byte, n)
+	}
+	n, err := base64.RawURLEncoding.Decode(b.dec[:n], seg)
+	if err != nil {
+		return nil, err
+	}
+	return b.dec[:n], nil
+}
+
+// checkSignature checks the RS256 or ES256 signature of the signing input
+// with the public key. ErrInvalidAlgorithm is returned if the key does not
+// match the algorithm.
+func checkSignature(key crypto.PublicKey, alg string, input, signature []byte) error {
+	hashed := sha256.Sum256(input)
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return ErrInvalidAlgorithm
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], signature) != nil {
+			return ErrInvalidSignature
+		}
+	case *ecdsa.PublicKey:
+		if alg != "ES256" {
+			return ErrInvalidAlgorithm
+		}
+		if len(signature) != 64 {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, hashed[:], r, s) {
+			return ErrInvalidSignature
+		}
+	default:
+		return ErrInvalidAlgorithm
+	}
+	return nil
+}
+
 // decodeSegment decodes the Base64 encoding segment of the JWT token.
 // It pads the string if necessary.
 func decodeSegment(s string) ([]byte, error) {
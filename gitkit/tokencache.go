@@ -0,0 +1,90 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// tokenCache is a LRU cache of the verified ID tokens, keyed by the token
+// string. See Config.TokenCacheSize.
+type tokenCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	ll      *list.List // Most recently used first.
+	entries map[string]*list.Element
+}
+
+// A tokenCacheEntry is an element of tokenCache.ll.
+type tokenCacheEntry struct {
+	t   *Token
+	exp time.Time // When the entry must not be used anymore.
+}
+
+func newTokenCache(size int, ttl time.Duration) *tokenCache {
+	return &tokenCache{size: size, ttl: ttl, ll: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns a copy of the cached token of the token string, or nil if it is
+// not cached or its entry expired at now.
+func (tc *tokenCache) get(token string, now time.Time) *Token {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	e, ok := tc.entries[token]
+	if !ok {
+		return nil
+	}
+	entry := e.Value.(*tokenCacheEntry)
+	if !now.Before(entry.exp) {
+		tc.ll.Remove(e)
+		delete(tc.entries, token)
+		return nil
+	}
+	tc.ll.MoveToFront(e)
+	t := *entry.t
+	return &t
+}
+
+// add caches a copy of the verified token until it expires, or for the TTL of
+// the cache if it is shorter. The least recently used token is evicted if the
+// cache is full.
+func (tc *tokenCache) add(t *Token, now time.Time) {
+	exp := t.ExpireAt
+	if tc.ttl > 0 && now.Add(tc.ttl).Before(exp) {
+		exp = now.Add(tc.ttl)
+	}
+	if !now.Before(exp) {
+		return
+	}
+	cp := *t
+	entry := &tokenCacheEntry{&cp, exp}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if e, ok := tc.entries[t.TokenString]; ok {
+		e.Value = entry
+		tc.ll.MoveToFront(e)
+		return
+	}
+	tc.entries[t.TokenString] = tc.ll.PushFront(entry)
+	for tc.ll.Len() > tc.size {
+		last := tc.ll.Back()
+		tc.ll.Remove(last)
+		delete(tc.entries, last.Value.(*tokenCacheEntry).t.TokenString)
+	}
+}
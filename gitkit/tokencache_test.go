@@ -0,0 +1,94 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"crypto"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestTokenCache(t *testing.T) {
+	now := time.Now()
+	tc := newTokenCache(2, time.Minute)
+	tokens := make([]*Token, 3)
+	for i := range tokens {
+		tokens[i] = &Token{TokenString: strconv.Itoa(i), ExpireAt: now.Add(time.Hour)}
+	}
+	tc.add(tokens[0], now)
+	tc.add(tokens[1], now)
+	if tc.get("0", now) == nil {
+		t.Fatal("get() of a cached token returns nil")
+	}
+	// Token 1 is the least recently used.
+	tc.add(tokens[2], now)
+	for s, want := range map[string]bool{"0": true, "1": false, "2": true} {
+		if got := tc.get(s, now) != nil; got != want {
+			t.Errorf("get(%q) cached = %v; want %v", s, got, want)
+		}
+	}
+	if tc.get("0", now.Add(time.Minute)) != nil {
+		t.Error("get() after the TTL returns the token")
+	}
+
+	tc = newTokenCache(2, 0)
+	tc.add(&Token{TokenString: "short", ExpireAt: now.Add(time.Second)}, now)
+	if tc.get("short", now) == nil || tc.get("short", now.Add(time.Second)) != nil {
+		t.Error("the token is not cached until it expires")
+	}
+	tc.add(&Token{TokenString: "expired", ExpireAt: now}, now)
+	if tc.ll.Len() != 0 {
+		t.Error("add() caches an expired token")
+	}
+}
+
+func TestValidateToken_cache(t *testing.T) {
+	c := newMiddlewareClient()
+	resolved := 0
+	c.config.KeyResolver = KeyResolverFunc(func(keyID, algorithm string) (crypto.PublicKey, error) {
+		resolved++
+		return c.certs.ResolveKey(keyID, algorithm)
+	})
+	hits := int64(0)
+	c.config.Metrics = MetricsFunc(func(name string, delta int64) {
+		if name == MetricTokenCacheHits {
+			hits += delta
+		}
+	})
+	c.tokens = newTokenCache(10, 0)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		tok, err := c.ValidateToken(ctx, validToken, []string{audience})
+		if err != nil {
+			t.Fatalf("ValidateToken() returns error: %v", err)
+		}
+		if tok.Roles != nil {
+			t.Errorf("ValidateToken() returns the roles set on a previous token: %v", tok.Roles)
+		}
+		tok.Roles = []string{"admin"}
+	}
+	if resolved != 1 || hits != 2 {
+		t.Errorf("keys resolved %d times with %d cache hits; want 1 and 2", resolved, hits)
+	}
+	if _, err := c.ValidateToken(ctx, validToken, []string{"other-client-id"}); err != ErrInvalidAudience {
+		t.Errorf("ValidateToken() of a cached token for another audience returns error %v; want %v", err, ErrInvalidAudience)
+	}
+	if _, err := c.ValidateToken(ctx, malformedToken, []string{audience}); err == nil {
+		t.Error("ValidateToken() of a malformed token returns no error")
+	}
+}
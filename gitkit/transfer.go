@@ -0,0 +1,128 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"golang.org/x/net/context"
+)
+
+// defaultTransferPageSize is the number of users transferred per page if
+// TransferOptions.PageSize is not set.
+const defaultTransferPageSize = 500
+
+// A UserUploader uploads users. It is implemented by Client.
+type UserUploader interface {
+	UploadUsersWithOptions(ctx context.Context, users []*User, opts *UploadOptions) error
+}
+
+// TransferOptions controls TransferUsers.
+type TransferOptions struct {
+	// PageSize is the number of users downloaded and uploaded at a time, at
+	// most MaxPageSize. A default is used if it is not set.
+	PageSize int
+	// PageToken is the page to start from, e.g., the PageToken of the
+	// TransferProgress of an interrupted transfer. The transfer starts from
+	// the first page if it is empty.
+	PageToken Cursor
+	// Upload describes how the passwords are hashed in the source project,
+	// i.e., its password hash configuration. Without it, the passwords of the
+	// users are not usable in the destination project.
+	Upload *UploadOptions
+	// Progress, if set, is called after each page.
+	Progress func(*TransferProgress)
+}
+
+// TransferProgress reports the state of a transfer.
+type TransferProgress struct {
+	// Transferred is the number of users uploaded successfully.
+	Transferred int
+	// Failed are the users rejected by the destination project.
+	Failed []*TransferFailure
+	// PageToken is the token of the next page to transfer, which is empty once
+	// all the users are transferred.
+	PageToken Cursor
+}
+
+// TransferFailure describes a user which failed to upload.
+type TransferFailure struct {
+	LocalID string
+	Email   string
+	// Failure is the error reported by the destination project. Its Index is
+	// the index of the user in its page.
+	Failure *UploadFailure
+}
+
+// TransferUsers copies all the users of the source project, listed by src,
+// to the destination project, uploaded by dst, one page at a time. src and dst
+// are typically Clients created with the credentials of each project. The
+// users are uploaded as downloaded, so their local IDs, password hashes,
+// provider links and custom attributes are preserved.
+//
+// Users rejected by the destination are reported in the Failed field of the
+// returned TransferProgress and do not stop the transfer. If listing or
+// uploading a page fails, TransferUsers returns the error along with the
+// progress so far, whose PageToken can be used to resume the transfer.
+//
+// For example, to clone a project,
+//
+//	p, err := gitkit.TransferUsers(ctx, prod, staging, &gitkit.TransferOptions{
+//		Upload: &gitkit.UploadOptions{HashAlgorithm: "SCRYPT", SignerKey: key, ...},
+//	})
+func TransferUsers(ctx context.Context, src UserLister, dst UserUploader, opts *TransferOptions) (*TransferProgress, error) {
+	if opts == nil {
+		opts = &TransferOptions{}
+	}
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = defaultTransferPageSize
+	}
+	pager, err := NewPager(src, pageSize, opts.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	upload := opts.Upload
+	if upload == nil {
+		upload = &UploadOptions{}
+	}
+	progress := &TransferProgress{PageToken: opts.PageToken}
+	for !pager.Done() {
+		users, err := pager.NextPage(ctx)
+		if err != nil {
+			return progress, err
+		}
+		if len(users) > 0 {
+			err = dst.UploadUsersWithOptions(ctx, users, upload)
+			uploadErr, ok := err.(UploadError)
+			if err != nil && !ok {
+				return progress, err
+			}
+			failed := 0
+			for _, f := range uploadErr {
+				if f.Index < 0 || f.Index >= len(users) {
+					continue
+				}
+				u := users[f.Index]
+				progress.Failed = append(progress.Failed, &TransferFailure{u.LocalID, u.Email, f})
+				failed++
+			}
+			progress.Transferred += len(users) - failed
+		}
+		progress.PageToken = pager.PageToken()
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+	return progress, nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// recordingUploader records the uploaded users and rejects the ones whose
+// email is reject.
+type recordingUploader struct {
+	users  []*User
+	opts   []*UploadOptions
+	reject string
+}
+
+func (u *recordingUploader) UploadUsersWithOptions(ctx context.Context, users []*User, opts *UploadOptions) error {
+	var uploadErr UploadError
+	for i, user := range users {
+		if user.Email == u.reject {
+			uploadErr = append(uploadErr, &UploadFailure{Index: i, Message: "DUPLICATE_EMAIL"})
+			continue
+		}
+		u.users = append(u.users, user)
+	}
+	u.opts = append(u.opts, opts)
+	if uploadErr != nil {
+		return uploadErr
+	}
+	return nil
+}
+
+func transferTestUsers(n int) []*User {
+	users := make([]*User, n)
+	for i := range users {
+		id := strconv.Itoa(i)
+		users[i] = &User{
+			LocalID:          id,
+			Email:            "user" + id + "@example.com",
+			PasswordHash:     []byte("hash" + id),
+			CustomAttributes: `{"role":"admin"}`,
+			ProviderUserInfo: []ProviderUserInfo{{ProviderID: "google.com", RawID: id}},
+		}
+	}
+	return users
+}
+
+var _ UserUploader = (*Client)(nil)
+
+func TestTransferUsers(t *testing.T) {
+	src := &sliceLister{users: transferTestUsers(5)}
+	dst := &recordingUploader{reject: "user3@example.com"}
+	upload := &UploadOptions{HashAlgorithm: "SCRYPT", SignerKey: []byte("key")}
+	var pages []Cursor
+	p, err := TransferUsers(context.Background(), src, dst, &TransferOptions{
+		PageSize: 2,
+		Upload:   upload,
+		Progress: func(p *TransferProgress) { pages = append(pages, p.PageToken) },
+	})
+	if err != nil {
+		t.Fatalf("TransferUsers() returns error: %v", err)
+	}
+	if p.Transferred != 4 || p.PageToken != "" {
+		t.Errorf("TransferUsers() = %+v; want 4 users transferred and no page token", p)
+	}
+	if len(p.Failed) != 1 || p.Failed[0].LocalID != "3" || p.Failed[0].Failure.Index != 1 {
+		t.Errorf("TransferUsers() reports failures %+v; want user 3 at index 1", p.Failed)
+	}
+	if want := []Cursor{"2", "4", ""}; !reflect.DeepEqual(pages, want) {
+		t.Errorf("TransferUsers() reports progress at pages %q; want %q", pages, want)
+	}
+	if want := append(append([]*User(nil), src.users[:3]...), src.users[4]); !reflect.DeepEqual(dst.users, want) {
+		t.Errorf("TransferUsers() uploads %v; want %v", dst.users, want)
+	}
+	for _, o := range dst.opts {
+		if o != upload {
+			t.Errorf("TransferUsers() uploads with options %+v; want %+v", o, upload)
+		}
+	}
+}
+
+func TestTransferUsers_resume(t *testing.T) {
+	src := &sliceLister{users: transferTestUsers(5), failAt: "4"}
+	dst := &recordingUploader{}
+	p, err := TransferUsers(context.Background(), src, dst, &TransferOptions{PageSize: 2})
+	if err == nil {
+		t.Fatal("TransferUsers() returns no error; want download error")
+	}
+	if p.Transferred != 4 || p.PageToken != "4" {
+		t.Fatalf("TransferUsers() = %+v; want 4 users transferred and page token 4", p)
+	}
+	p, err = TransferUsers(context.Background(), src, dst, &TransferOptions{PageSize: 2, PageToken: p.PageToken})
+	if err != nil {
+		t.Fatalf("TransferUsers() returns error: %v", err)
+	}
+	if p.Transferred != 1 || len(dst.users) != 5 {
+		t.Errorf("resumed TransferUsers() = %+v, %d users uploaded; want 1 user transferred, 5 users uploaded", p, len(dst.users))
+	}
+}
@@ -14,13 +14,72 @@
 
 package gitkit
 
-import "net/http"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
 
 const (
 	clientUserAgent = "gitkit-go-client/0.1.1"
 	contentType     = "application/json"
 )
 
+// A TransportMiddleware wraps the transport of the identitytoolkit API
+// requests, e.g., to log or instrument them. See Config.TransportMiddlewares.
+type TransportMiddleware func(http.RoundTripper) http.RoundTripper
+
+// ChainTransport wraps rt with the middlewares. The first middleware is the
+// outermost one, i.e., it sees the requests first.
+func ChainTransport(rt http.RoundTripper, mws ...TransportMiddleware) http.RoundTripper {
+	for i := len(mws) - 1; i >= 0; i-- {
+		rt = mws[i](rt)
+	}
+	return rt
+}
+
+// UserAgentMiddleware sets the User-Agent and Content-Type headers of the
+// identitytoolkit API requests. It is always the innermost middleware of the
+// Client transport, above the auth transport.
+func UserAgentMiddleware(next http.RoundTripper) http.RoundTripper {
+	return &transport{next}
+}
+
+// LoggingMiddleware returns a TransportMiddleware which reports the method,
+// URL, status and latency of every request to logf.
+func LoggingMiddleware(logf func(format string, args ...interface{})) TransportMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &logTransport{next, logf}
+	}
+}
+
+// logTransport is an implementation of http.RoundTripper that logs the
+// requests.
+type logTransport struct {
+	http.RoundTripper                              // Underlying HTTP transport.
+	logf              func(string, ...interface{}) // Receives the log lines.
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *logTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		t.logf("gitkit: %s %s failed after %v: %v", req.Method, req.URL, time.Since(start), err)
+		return nil, err
+	}
+	t.logf("gitkit: %s %s: %s in %v", req.Method, req.URL, resp.Status, time.Since(start))
+	return resp, nil
+}
+
 // transport is an implementation of http.RoundTripper that add a User-Agent
 // HTTP header in the request.
 type transport struct {
@@ -41,3 +100,330 @@ func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	newReq.Header.Set("Content-Type", contentType)
 	return t.RoundTripper.RoundTrip(&newReq)
 }
+
+// errRequestCanceled is returned when a request is canceled while waiting for
+// a free slot in limitTransport.
+var errRequestCanceled = errors.New("gitkit: request canceled while waiting for a free connection slot")
+
+// limitTransport is an implementation of http.RoundTripper that limits the
+// number of requests in flight. A slot is taken before the request is sent and
+// released once the response body is closed.
+type limitTransport struct {
+	http.RoundTripper               // Underlying HTTP transport.
+	sem               chan struct{} // Semaphore shared by all the requests of a Client.
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case t.sem <- struct{}{}:
+	case <-req.Cancel:
+		return nil, errRequestCanceled
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		<-t.sem
+		return nil, err
+	}
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: func() { <-t.sem }}
+	return resp, nil
+}
+
+// releaseOnClose wraps a response body and calls release exactly once when the
+// body is closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+// Close implements the io.Closer interface.
+func (b *releaseOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// headerTransport is an implementation of http.RoundTripper that sets headers
+// in the request.
+type headerTransport struct {
+	http.RoundTripper             // Underlying HTTP transport.
+	header            http.Header // The headers to set.
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	newReq := *req
+	newReq.Header = make(http.Header)
+	for k, v := range req.Header {
+		newReq.Header[k] = v
+	}
+	for k, v := range t.header {
+		newReq.Header[k] = v
+	}
+	return t.RoundTripper.RoundTrip(&newReq)
+}
+
+// errRetryCanceled is returned when a request is canceled while waiting to be
+// retried in retryTransport.
+var errRetryCanceled = errors.New("gitkit: request canceled while waiting to be retried")
+
+// DefaultRetryBaseWait is the wait before the first retry of a request without
+// a Retry-After header. It doubles with every further retry.
+const DefaultRetryBaseWait = 500 * time.Millisecond
+
+// timeAfter is replaced in tests to avoid sleeping.
+var timeAfter = time.After
+
+// DefaultRetryableStatus are the HTTP status codes of the transient failures
+// retried by a RetryPolicy without RetryableStatus.
+var DefaultRetryableStatus = []int{
+	http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+	http.StatusServiceUnavailable, http.StatusGatewayTimeout,
+}
+
+// IdempotentMethods are the identitytoolkit API methods which only read, and
+// are thus retried by a RetryPolicy although they are POST requests.
+var IdempotentMethods = []string{"getAccountInfo", "downloadAccount", "getProjectConfig"}
+
+// A RetryPolicy controls how the identitytoolkit API requests failing with a
+// network error or a transient HTTP status are retried. The Retry-After
+// header of 429 and 503 responses is honored, up to MaxWait. Otherwise, the
+// wait starts at BaseWait and doubles with every retry, randomized so that
+// concurrent clients spread their retries. See Config.RetryPolicy and
+// RetryMiddleware.
+//
+// Only the GET and HEAD requests and the IdempotentMethods are retried by
+// default: the first attempt of a failed request may have succeeded, e.g., a
+// retried signupNewUser would fail with EMAIL_EXISTS and a retried
+// getOobConfirmationCode would send a second email. The requests canceled by
+// their context are never retried.
+//
+// The BaseWait and MaxWait of the JSON configurations are Duration strings.
+type RetryPolicy struct {
+	// MaxRetries is the number of times a request is retried. Zero disables
+	// retrying.
+	MaxRetries int `json:"maxRetries,omitempty"`
+	// BaseWait is the wait before the first retry. DefaultRetryBaseWait is
+	// used if it is zero.
+	BaseWait time.Duration `json:"baseWait,omitempty"`
+	// MaxWait bounds the wait before a retry. DefaultMaxRetryWait is used if
+	// it is zero.
+	MaxWait time.Duration `json:"maxWait,omitempty"`
+	// RetryableStatus are the HTTP status codes retried.
+	// DefaultRetryableStatus is used if it is empty.
+	RetryableStatus []int `json:"retryableStatus,omitempty"`
+	// Methods are further API methods retried, although they are not
+	// idempotent, e.g., "setAccountInfo" if sending the same update twice is
+	// harmless.
+	Methods []string `json:"methods,omitempty"`
+}
+
+// retryPolicyJSON has the fields of RetryPolicy without its UnmarshalJSON
+// method.
+type retryPolicyJSON RetryPolicy
+
+// UnmarshalJSON decodes the JSON policy, whose durations are Duration
+// strings.
+func (p *RetryPolicy) UnmarshalJSON(b []byte) error {
+	aux := struct {
+		*retryPolicyJSON
+		BaseWait Duration `json:"baseWait,omitempty"`
+		MaxWait  Duration `json:"maxWait,omitempty"`
+	}{retryPolicyJSON: (*retryPolicyJSON)(p)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	p.BaseWait, p.MaxWait = time.Duration(aux.BaseWait), time.Duration(aux.MaxWait)
+	return nil
+}
+
+// RetryMiddleware returns a TransportMiddleware which retries the requests
+// according to the policy, e.g., for an APIClient created without a Client,
+//
+//	api := &gitkit.APIClient{Client: http.Client{
+//		Transport: gitkit.RetryMiddleware(&gitkit.RetryPolicy{MaxRetries: 5})(authTransport),
+//	}}
+func RetryMiddleware(p *RetryPolicy) TransportMiddleware {
+	return retryMiddleware(p, nil, nil)
+}
+
+// retryMiddleware is like RetryMiddleware, reporting the waits to logf and
+// counting the retries in metrics if they are not nil.
+func retryMiddleware(p *RetryPolicy, logf func(string, ...interface{}), metrics Metrics) TransportMiddleware {
+	maxWait, baseWait := p.MaxWait, p.BaseWait
+	if maxWait <= 0 {
+		maxWait = DefaultMaxRetryWait
+	}
+	if baseWait <= 0 {
+		baseWait = DefaultRetryBaseWait
+	}
+	statuses := p.RetryableStatus
+	if len(statuses) == 0 {
+		statuses = DefaultRetryableStatus
+	}
+	methods := make(map[string]bool)
+	for _, m := range append(IdempotentMethods, p.Methods...) {
+		methods[m] = true
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &retryTransport{
+			RoundTripper: next,
+			maxRetries:   p.MaxRetries,
+			baseWait:     baseWait,
+			maxWait:      maxWait,
+			statuses:     statuses,
+			methods:      methods,
+			logf:         logf,
+			metrics:      metrics,
+		}
+	}
+}
+
+// retryTransport is an implementation of http.RoundTripper that retries the
+// requests failing with a network error or a transient HTTP status. It waits
+// for the duration given by the Retry-After header of 429 and 503 responses,
+// and backs off exponentially otherwise. No wait exceeds maxWait.
+type retryTransport struct {
+	http.RoundTripper                              // Underlying HTTP transport.
+	maxRetries        int                          // Maximum number of retries of a request.
+	baseWait          time.Duration                // Wait before the first retry; DefaultRetryBaseWait if zero.
+	maxWait           time.Duration                // Upper bound of the wait before a retry.
+	statuses          []int                        // Retryable statuses; DefaultRetryableStatus if nil.
+	methods           map[string]bool              // Retryable API methods of the POST requests.
+	logf              func(string, ...interface{}) // Receives the waits if not nil.
+	metrics           Metrics                      // Counts the retries if not nil.
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.retryable(req) {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	// Buffer the body so that it can be sent again.
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		newReq := *req
+		if body != nil {
+			newReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		resp, err := t.RoundTripper.RoundTrip(&newReq)
+		if attempt >= t.maxRetries || !shouldRetry(resp, err, t.statuses) {
+			return resp, err
+		}
+		if err != nil && req.Context().Err() != nil {
+			// The request failed because it is canceled.
+			return resp, err
+		}
+		wait := t.backoff(attempt)
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			if d, ok := retryAfter(resp, time.Now()); ok {
+				wait = d
+			}
+			// Drain the body so that the connection can be reused.
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if wait > t.maxWait {
+			wait = t.maxWait
+		}
+		if t.metrics != nil {
+			t.metrics.Add(MetricAPIRetries, 1)
+		}
+		if t.logf != nil {
+			t.logf("gitkit: %s %s failed: %s; retrying in %v (retry %d of %d)", req.Method, req.URL, reason, wait, attempt+1, t.maxRetries)
+		}
+		select {
+		case <-timeAfter(wait):
+		case <-req.Cancel:
+			return nil, errRetryCanceled
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// retryable reports whether the request can be sent again: it is a GET or HEAD
+// request, or calls one of the retryable API methods.
+func (t *retryTransport) retryable(req *http.Request) bool {
+	if req.Method == "GET" || req.Method == "HEAD" {
+		return true
+	}
+	m, _ := req.Context().Value(calledMethodKey{}).(apiMethod)
+	if m == "" {
+		name, _ := apiMethodOf(req)
+		m = apiMethod(name)
+	}
+	return t.methods[string(m)]
+}
+
+// backoff returns the randomized exponential wait before the given retry.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	base := t.baseWait
+	if base <= 0 {
+		base = DefaultRetryBaseWait
+	}
+	d := t.maxWait
+	if attempt < 30 && base<<uint(attempt) < d {
+		d = base << uint(attempt)
+	}
+	// Wait between d/2 and d so that concurrent clients spread their retries.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// shouldRetry reports whether a request that got resp or err can be retried,
+// given the retryable statuses, DefaultRetryableStatus if nil.
+func shouldRetry(resp *http.Response, err error, statuses []int) bool {
+	if err != nil {
+		return true
+	}
+	if statuses == nil {
+		statuses = DefaultRetryableStatus
+	}
+	for _, code := range statuses {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter returns the wait requested by the Retry-After header of a 429 or
+// 503 response. The header is either a number of seconds or an HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	v := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	date, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := date.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
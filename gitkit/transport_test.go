@@ -16,10 +16,17 @@ package gitkit
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"golang.org/x/net/context"
 )
 
 type roundTripper struct {
@@ -72,3 +79,449 @@ func TestRoundTrip(t *testing.T) {
 		}
 	}
 }
+
+// countingRoundTripper records the maximum number of responses whose bodies
+// are not yet closed.
+type countingRoundTripper struct {
+	mu       sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (r *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	r.inFlight++
+	if r.inFlight > r.max {
+		r.max = r.inFlight
+	}
+	r.mu.Unlock()
+	return &http.Response{StatusCode: 200, Body: countingBody{r}, Request: req}, nil
+}
+
+type countingBody struct {
+	r *countingRoundTripper
+}
+
+func (b countingBody) Read(p []byte) (int, error) { return 0, io.EOF }
+
+func (b countingBody) Close() error {
+	b.r.mu.Lock()
+	b.r.inFlight--
+	b.r.mu.Unlock()
+	return nil
+}
+
+func TestLimitTransport(t *testing.T) {
+	const limit = 2
+	rt := &countingRoundTripper{}
+	lt := &limitTransport{rt, make(chan struct{}, limit)}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("POST", "http://localhost", nil)
+			resp, err := lt.RoundTrip(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+			resp.Body.Close()
+			resp.Body.Close() // Closing twice must not release two slots.
+		}()
+	}
+	wg.Wait()
+	if rt.max > limit {
+		t.Errorf("max in-flight requests = %d; want <= %d", rt.max, limit)
+	}
+	if len(lt.sem) != 0 {
+		t.Errorf("%d slots are still held after all bodies are closed", len(lt.sem))
+	}
+}
+
+func TestLimitTransport_canceled(t *testing.T) {
+	lt := &limitTransport{roundTripper{}, make(chan struct{}, 1)}
+	lt.sem <- struct{}{} // Occupy the only slot.
+	req, _ := http.NewRequest("GET", "http://localhost", nil)
+	cancel := make(chan struct{})
+	req.Cancel = cancel
+	close(cancel)
+	if _, err := lt.RoundTrip(req); err != errRequestCanceled {
+		t.Errorf("RoundTrip() returns error %v; want %v", err, errRequestCanceled)
+	}
+}
+
+// sequenceRoundTripper returns its responses in order and records the request
+// bodies.
+type sequenceRoundTripper struct {
+	resps  []*http.Response
+	bodies []string
+}
+
+func (r *sequenceRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		b, _ := ioutil.ReadAll(req.Body)
+		r.bodies = append(r.bodies, string(b))
+	}
+	resp := r.resps[0]
+	r.resps = r.resps[1:]
+	if resp == nil {
+		return nil, errors.New("connection reset")
+	}
+	return resp, nil
+}
+
+func response(status int, retryAfter string) *http.Response {
+	resp := &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+	}
+	if retryAfter != "" {
+		resp.Header.Set("Retry-After", retryAfter)
+	}
+	return resp
+}
+
+func TestRetryTransport(t *testing.T) {
+	var waits []time.Duration
+	timeAfter = func(d time.Duration) <-chan time.Time {
+		waits = append(waits, d)
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+	defer func() { timeAfter = time.After }()
+
+	tests := []struct {
+		resps      []*http.Response
+		maxRetries int
+		wantStatus int
+		wantErr    bool
+		wantWaits  []time.Duration // -1 for a random backoff.
+	}{
+		{
+			[]*http.Response{response(200, "")},
+			3, 200, false, nil,
+		},
+		{
+			[]*http.Response{response(429, "2"), response(503, "5"), response(200, "")},
+			3, 200, false, []time.Duration{2 * time.Second, 5 * time.Second},
+		},
+		{
+			// Retry-After is bounded by maxWait.
+			[]*http.Response{response(429, "3600"), response(200, "")},
+			3, 200, false, []time.Duration{10 * time.Second},
+		},
+		{
+			// Retry-After is ignored for other statuses.
+			[]*http.Response{response(500, "7"), nil, response(200, "")},
+			3, 200, false, []time.Duration{-1, -1},
+		},
+		{
+			[]*http.Response{response(429, "1"), response(429, "1")},
+			1, 429, false, []time.Duration{time.Second},
+		},
+		{
+			[]*http.Response{nil, nil},
+			1, 0, true, []time.Duration{-1},
+		},
+		{
+			[]*http.Response{response(400, "1")},
+			3, 400, false, nil,
+		},
+	}
+	for i, tt := range tests {
+		waits = nil
+		var logs []string
+		rt := &sequenceRoundTripper{resps: tt.resps}
+		tr := &retryTransport{
+			RoundTripper: rt,
+			maxRetries:   tt.maxRetries,
+			maxWait:      10 * time.Second,
+			methods:      map[string]bool{"getAccountInfo": true},
+			logf:         func(format string, args ...interface{}) { logs = append(logs, fmt.Sprintf(format, args...)) },
+		}
+		req, _ := http.NewRequest("POST", getAccountInfo.url(), bytes.NewReader([]byte("body")))
+		resp, err := tr.RoundTrip(req)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("[%d]: RoundTrip() returns no error", i)
+			}
+		} else if err != nil {
+			t.Errorf("[%d]: RoundTrip() returns error %v", i, err)
+		} else if resp.StatusCode != tt.wantStatus {
+			t.Errorf("[%d]: status = %d; want %d", i, resp.StatusCode, tt.wantStatus)
+		}
+		if len(waits) != len(tt.wantWaits) {
+			t.Errorf("[%d]: waits = %v; want %v", i, waits, tt.wantWaits)
+			continue
+		}
+		for j, w := range tt.wantWaits {
+			if w < 0 {
+				if waits[j] <= 0 || waits[j] > tr.maxWait {
+					t.Errorf("[%d]: backoff %v out of range", i, waits[j])
+				}
+			} else if waits[j] != w {
+				t.Errorf("[%d]: waits[%d] = %v; want %v", i, j, waits[j], w)
+			}
+		}
+		if len(logs) != len(waits) {
+			t.Errorf("[%d]: %d messages logged; want %d", i, len(logs), len(waits))
+		}
+		for j, b := range rt.bodies {
+			if b != "body" {
+				t.Errorf("[%d]: body of attempt %d = %q; want %q", i, j, b, "body")
+			}
+		}
+	}
+}
+
+func TestRetryTransport_canceled(t *testing.T) {
+	rt := &sequenceRoundTripper{resps: []*http.Response{response(503, "60")}}
+	tr := &retryTransport{RoundTripper: rt, maxRetries: 1, maxWait: time.Minute}
+	req, _ := http.NewRequest("GET", "http://localhost", nil)
+	cancel := make(chan struct{})
+	req.Cancel = cancel
+	close(cancel)
+	if _, err := tr.RoundTrip(req); err != errRetryCanceled {
+		t.Errorf("RoundTrip() returns error %v; want %v", err, errRetryCanceled)
+	}
+}
+
+func TestRetryTransport_contextCanceled(t *testing.T) {
+	rt := &sequenceRoundTripper{resps: []*http.Response{response(503, "60")}}
+	tr := &retryTransport{RoundTripper: rt, maxRetries: 1, maxWait: time.Minute}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequest("GET", "http://localhost", nil)
+	if _, err := tr.RoundTrip(req.WithContext(ctx)); err != context.Canceled {
+		t.Errorf("RoundTrip() returns error %v; want %v", err, context.Canceled)
+	}
+}
+
+func TestRetryMiddleware(t *testing.T) {
+	var waits []time.Duration
+	timeAfter = func(d time.Duration) <-chan time.Time {
+		waits = append(waits, d)
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+	defer func() { timeAfter = time.After }()
+
+	tests := []struct {
+		policy     RetryPolicy
+		resps      []*http.Response
+		wantStatus int
+		wantWaits  []time.Duration // Bounds of the backoffs.
+	}{
+		{
+			RetryPolicy{MaxRetries: 2},
+			[]*http.Response{response(500, ""), response(200, "")},
+			200, []time.Duration{DefaultRetryBaseWait},
+		},
+		{
+			RetryPolicy{MaxRetries: 2, BaseWait: time.Second, MaxWait: 3 * time.Second},
+			[]*http.Response{response(502, ""), response(502, ""), response(502, "")},
+			502, []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			RetryPolicy{MaxRetries: 2, BaseWait: time.Second, MaxWait: 3 * time.Second},
+			[]*http.Response{response(503, "10"), response(200, "")},
+			200, []time.Duration{3 * time.Second},
+		},
+		{
+			RetryPolicy{MaxRetries: 2, RetryableStatus: []int{409}},
+			[]*http.Response{response(409, ""), response(503, "1")},
+			503, []time.Duration{DefaultRetryBaseWait},
+		},
+		{
+			RetryPolicy{},
+			[]*http.Response{response(503, "1")},
+			503, nil,
+		},
+	}
+	for i, tt := range tests {
+		waits = nil
+		rt := RetryMiddleware(&tt.policy)(&sequenceRoundTripper{resps: tt.resps})
+		req, _ := http.NewRequest("POST", getAccountInfo.url(), nil)
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Errorf("[%d]: RoundTrip() returns error %v", i, err)
+			continue
+		}
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("[%d]: status = %d; want %d", i, resp.StatusCode, tt.wantStatus)
+		}
+		if len(waits) != len(tt.wantWaits) {
+			t.Errorf("[%d]: waits = %v; want %v", i, waits, tt.wantWaits)
+			continue
+		}
+		for j, w := range tt.wantWaits {
+			if waits[j] < w/2 || waits[j] > w {
+				t.Errorf("[%d]: waits[%d] = %v; want between %v and %v", i, j, waits[j], w/2, w)
+			}
+		}
+	}
+}
+
+func TestRetryMiddleware_methods(t *testing.T) {
+	timeAfter = func(d time.Duration) <-chan time.Time {
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+	defer func() { timeAfter = time.After }()
+
+	tests := []struct {
+		method  apiMethod
+		version string
+		policy  RetryPolicy
+		want    int // Attempts.
+	}{
+		{getAccountInfo, APIv3, RetryPolicy{MaxRetries: 1}, 2},
+		{downloadAccount, APIv3, RetryPolicy{MaxRetries: 1}, 2},
+		{getProjectConfig, APIv3, RetryPolicy{MaxRetries: 1}, 2},
+		{signupNewUser, APIv3, RetryPolicy{MaxRetries: 1}, 1},
+		{uploadAccount, APIv3, RetryPolicy{MaxRetries: 1}, 1},
+		{getOOBCode, APIv3, RetryPolicy{MaxRetries: 1}, 1},
+		{setAccountInfo, APIv3, RetryPolicy{MaxRetries: 1}, 1},
+		{setAccountInfo, APIv3, RetryPolicy{MaxRetries: 1, Methods: []string{"setAccountInfo"}}, 2},
+		// The method is known after the translation to v1.
+		{getAccountInfo, APIv1, RetryPolicy{MaxRetries: 1}, 2},
+		{signupNewUser, APIv1, RetryPolicy{MaxRetries: 1}, 1},
+	}
+	for i, tt := range tests {
+		attempts := 0
+		rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			attempts++
+			return response(503, ""), nil
+		})
+		c := &APIClient{Client: http.Client{Transport: ChainTransport(rt,
+			APIVersionMiddleware(tt.version, "project"),
+			RetryMiddleware(&tt.policy),
+		)}}
+		c.request(context.Background(), POST, tt.method, struct{}{}, &GetAccountInfoResponse{})
+		if attempts != tt.want {
+			t.Errorf("[%d]: %s in %s sent %d times; want %d", i, tt.method, tt.version, attempts, tt.want)
+		}
+	}
+}
+
+func TestRetryTransport_contextError(t *testing.T) {
+	attempts := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		cancel()
+		return nil, req.Context().Err()
+	})
+	tr := RetryMiddleware(&RetryPolicy{MaxRetries: 3})(rt)
+	req, _ := http.NewRequest("GET", "http://localhost", nil)
+	if _, err := tr.RoundTrip(req.WithContext(ctx)); err != context.Canceled {
+		t.Errorf("RoundTrip() returns error %v; want %v", err, context.Canceled)
+	}
+	if attempts != 1 {
+		t.Errorf("canceled request sent %d times; want 1", attempts)
+	}
+}
+
+func TestAPIClient_context(t *testing.T) {
+	var got context.Context
+	c := &APIClient{Client: http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		got = req.Context()
+		return nil, req.Context().Err()
+	})}}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	c.GetAccountInfo(ctx, &GetAccountInfoRequest{LocalIDs: []string{"1234"}})
+	if got == nil {
+		t.Fatal("GetAccountInfo() sends no request")
+	}
+	if _, ok := got.Deadline(); !ok {
+		t.Error("GetAccountInfo() request context has no deadline")
+	}
+	cancel()
+	if _, err := c.DownloadAccount(ctx, &DownloadAccountRequest{}); err == nil {
+		t.Error("DownloadAccount() with a canceled context returns no error")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		status int
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{429, "", 0, false},
+		{429, "120", 2 * time.Minute, true},
+		{503, "0", 0, true},
+		{503, "-1", 0, false},
+		{503, "Fri, 01 Jan 2016 00:00:30 GMT", 30 * time.Second, true},
+		{503, "Thu, 31 Dec 2015 23:00:00 GMT", 0, true},
+		{503, "soon", 0, false},
+		{500, "10", 0, false},
+	}
+	for i, tt := range tests {
+		d, ok := retryAfter(response(tt.status, tt.header), now)
+		if d != tt.want || ok != tt.ok {
+			t.Errorf("[%d]: retryAfter(%d, %q) = %v, %v; want %v, %v", i, tt.status, tt.header, d, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestChainTransport(t *testing.T) {
+	var order []string
+	mw := func(name string) TransportMiddleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	rt := ChainTransport(roundTripper{200, ""}, mw("first"), mw("second"))
+	req, _ := http.NewRequest("GET", "http://localhost", nil)
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 2 || order[0] != "first" || order[1] != "second" {
+		t.Errorf("middlewares called in order %v; want [first second]", order)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestNew_transportMiddlewares(t *testing.T) {
+	var logs []string
+	logf := func(format string, args ...interface{}) { logs = append(logs, fmt.Sprintf(format, args...)) }
+	// The middleware answers the requests itself, so that no request reaches
+	// the network.
+	fake := func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return roundTripper{200, `{"apiKey": "key"}`}.RoundTrip(req)
+		})
+	}
+	c, err := New(context.Background(), &Config{}, WithTokenSource(staticTokenSource{}),
+		WithTransportMiddleware(LoggingMiddleware(logf), fake))
+	if err != nil {
+		t.Fatalf("New() returns error: %v", err)
+	}
+	pc, err := c.GetProjectConfig(context.Background())
+	if err != nil {
+		t.Fatalf("GetProjectConfig() returns error: %v", err)
+	}
+	if pc.BrowserAPIKey != "key" {
+		t.Errorf("BrowserAPIKey = %q; want %q", pc.BrowserAPIKey, "key")
+	}
+	if len(logs) != 1 || !strings.Contains(logs[0], "getProjectConfig: 200") {
+		t.Errorf("logged %q; want one line for getProjectConfig", logs)
+	}
+}
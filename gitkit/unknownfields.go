@@ -0,0 +1,276 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// An UnknownFieldsMode tells what to do with the fields of the API responses
+// that the response types do not model. See Config.UnknownFields.
+type UnknownFieldsMode string
+
+// Modes of Config.UnknownFields.
+const (
+	// UnknownFieldsIgnore drops the unknown fields silently.
+	UnknownFieldsIgnore UnknownFieldsMode = ""
+	// UnknownFieldsLog reports the unknown fields of each method once to
+	// Config.Logf.
+	UnknownFieldsLog UnknownFieldsMode = "log"
+	// UnknownFieldsReject fails the calls whose successful responses have
+	// unknown fields with an UnknownFieldsError.
+	UnknownFieldsReject UnknownFieldsMode = "reject"
+)
+
+// An UnknownFieldsError is returned for an API response with fields that its
+// type does not model, in UnknownFieldsReject mode.
+type UnknownFieldsError struct {
+	// Method is the name of the API method, e.g., "downloadAccount".
+	Method string
+	// Fields are the paths of the unknown fields, e.g., "users[].lastLoginAt".
+	Fields []string
+}
+
+func (e *UnknownFieldsError) Error() string {
+	return fmt.Sprintf("gitkit: response of %s has unknown fields: %s", e.Method, strings.Join(e.Fields, ", "))
+}
+
+// responseTypes are the types the responses of the API methods are decoded
+// into.
+var responseTypes = map[apiMethod]reflect.Type{
+	getAccountInfo:   reflect.TypeOf(GetAccountInfoResponse{}),
+	setAccountInfo:   reflect.TypeOf(SetAccountInfoResponse{}),
+	deleteAccount:    reflect.TypeOf(DeleteAccountResponse{}),
+	uploadAccount:    reflect.TypeOf(UploadAccountResponse{}),
+	downloadAccount:  reflect.TypeOf(DownloadAccountResponse{}),
+	getOOBCode:       reflect.TypeOf(GetOOBCodeResponse{}),
+	resetPassword:    reflect.TypeOf(ResetPasswordResponse{}),
+	verifyPassword:   reflect.TypeOf(VerifyPasswordResponse{}),
+	signupNewUser:    reflect.TypeOf(SignupNewUserResponse{}),
+	createAuthURI:    reflect.TypeOf(CreateAuthURIResponse{}),
+	verifyAssertion:  reflect.TypeOf(VerifyAssertionResponse{}),
+	getProjectConfig: reflect.TypeOf(GetProjectConfigResponse{}),
+	setProjectConfig: reflect.TypeOf(SetProjectConfigResponse{}),
+}
+
+// apiErrorBody is the shape of the error responses that googleapi decodes.
+type apiErrorBody struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Errors  []struct {
+			Domain       string `json:"domain"`
+			Reason       string `json:"reason"`
+			Message      string `json:"message"`
+			Location     string `json:"location"`
+			LocationType string `json:"locationType"`
+			ExtendedHelp string `json:"extendedHelp"`
+			SendReport   string `json:"sendReport"`
+		} `json:"errors"`
+		Details []interface{} `json:"details"`
+	} `json:"error"`
+}
+
+// UnknownFieldsMiddleware returns a TransportMiddleware checking the responses
+// of the identitytoolkit API methods for fields their types do not model, so
+// that the changes of the API are noticed instead of dropped silently, e.g.,
+// new user fields during an export. The unknown fields of each method, in the
+// successful and the error responses, are reported once to logf, if not nil.
+// In UnknownFieldsReject mode, the successful responses with unknown fields
+// fail with an UnknownFieldsError.
+func UnknownFieldsMiddleware(mode UnknownFieldsMode, logf func(format string, args ...interface{})) TransportMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		if mode == UnknownFieldsIgnore {
+			return next
+		}
+		return &unknownFieldsTransport{RoundTripper: next, reject: mode == UnknownFieldsReject, logf: logf}
+	}
+}
+
+// unknownFieldsTransport checks the API responses for unknown fields.
+type unknownFieldsTransport struct {
+	http.RoundTripper
+	reject bool
+	logf   func(format string, args ...interface{})
+
+	mu       sync.Mutex
+	reported map[string]bool // Reported method and field paths.
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *unknownFieldsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	method, ok := apiMethodOf(req)
+	typ, known := responseTypes[apiMethod(method)]
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || !ok || !known {
+		return resp, err
+	}
+	success := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !success {
+		typ = reflect.TypeOf(apiErrorBody{})
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	var v interface{}
+	if json.Unmarshal(b, &v) != nil {
+		// Left to the decoding of the response.
+		return resp, nil
+	}
+	fields := unknownFields(v, typ, "")
+	if len(fields) == 0 {
+		return resp, nil
+	}
+	t.report(method, fields)
+	if t.reject && success {
+		return nil, &UnknownFieldsError{method, fields}
+	}
+	return resp, nil
+}
+
+// report logs the fields of the method not reported yet.
+func (t *unknownFieldsTransport) report(method string, fields []string) {
+	if t.logf == nil {
+		return
+	}
+	var fresh []string
+	t.mu.Lock()
+	if t.reported == nil {
+		t.reported = make(map[string]bool)
+	}
+	for _, f := range fields {
+		if k := method + " " + f; !t.reported[k] {
+			t.reported[k] = true
+			fresh = append(fresh, f)
+		}
+	}
+	t.mu.Unlock()
+	if len(fresh) > 0 {
+		t.logf("gitkit: response of %s has unknown fields: %s", method, strings.Join(fresh, ", "))
+	}
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// unknownFields returns the sorted paths of the fields of the decoded JSON
+// value v, below path, that typ does not model. The "kind" fields, which
+// only name the type of the responses, are ignored.
+func unknownFields(v interface{}, typ reflect.Type, path string) []string {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if reflect.PtrTo(typ).Implements(unmarshalerType) {
+		return nil
+	}
+	var fields []string
+	switch v := v.(type) {
+	case map[string]interface{}:
+		switch typ.Kind() {
+		case reflect.Map:
+			for k, e := range v {
+				fields = append(fields, unknownFields(e, typ.Elem(), joinPath(path, k))...)
+			}
+		case reflect.Struct:
+			for k, e := range v {
+				f, ok := jsonField(typ, k)
+				switch {
+				case ok:
+					fields = append(fields, unknownFields(e, f.Type, joinPath(path, k))...)
+				case k != "kind":
+					fields = append(fields, joinPath(path, k))
+				}
+			}
+		}
+	case []interface{}:
+		if typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array {
+			for _, e := range v {
+				fields = append(fields, unknownFields(e, typ.Elem(), path+"[]")...)
+			}
+		}
+	}
+	return dedupe(fields)
+}
+
+// jsonField returns the field of the struct type decoding the JSON key, like
+// encoding/json: by the name in the tag or else the field name, ignoring the
+// case, including those of the embedded structs.
+func jsonField(typ reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+		if f.Anonymous && f.Tag.Get("json") == "" {
+			et := f.Type
+			if et.Kind() == reflect.Ptr {
+				et = et.Elem()
+			}
+			if et.Kind() == reflect.Struct {
+				if ef, ok := jsonField(et, key); ok {
+					return ef, true
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// dedupe sorts the paths and removes the duplicates, e.g., of the elements of
+// a slice.
+func dedupe(paths []string) []string {
+	if len(paths) < 2 {
+		return paths
+	}
+	sort.Strings(paths)
+	out := paths[:1]
+	for _, p := range paths[1:] {
+		if p != out[len(out)-1] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,92 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestUnknownFieldsMiddleware(t *testing.T) {
+	tests := []struct {
+		mode       UnknownFieldsMode
+		status     int
+		body       string
+		wantFields []string
+		wantErr    bool
+	}{
+		{UnknownFieldsLog, http.StatusOK, `{"kind":"identitytoolkit#DownloadAccountResponse","users":[{"localId":"1","providerUserInfo":[{"providerId":"google.com"}]}],"nextPageToken":"n"}`, nil, false},
+		{UnknownFieldsLog, http.StatusOK, `{"users":[{"localId":"1","lastLoginAt":"1"},{"localId":"2","lastLoginAt":"2","providerUserInfo":[{"providerId":"google.com","rawId":"x"}]}],"total":2}`, []string{"total", "users[].lastLoginAt", "users[].providerUserInfo[].rawId"}, false},
+		{UnknownFieldsReject, http.StatusOK, `{"users":[],"total":2}`, []string{"total"}, true},
+		{UnknownFieldsReject, http.StatusBadRequest, `{"error":{"code":400,"message":"INVALID","errors":[{"reason":"invalid","message":"INVALID"}],"trace":"t"}}`, []string{"error.trace"}, false},
+		{UnknownFieldsIgnore, http.StatusOK, `{"total":2}`, nil, false},
+	}
+	for i, tt := range tests {
+		var logged []string
+		logf := func(format string, args ...interface{}) {
+			logged = append(logged, fmt.Sprintf(format, args...))
+		}
+		rt := roundTripper{tt.status, tt.body}
+		c := &APIClient{Client: http.Client{Transport: UnknownFieldsMiddleware(tt.mode, logf)(rt)}}
+		_, err := c.DownloadAccount(context.Background(), &DownloadAccountRequest{})
+		e, ok := err.(*UnknownFieldsError)
+		if ok != tt.wantErr {
+			t.Errorf("[%d] DownloadAccount() returns error %v; want UnknownFieldsError: %v", i, err, tt.wantErr)
+		}
+		if ok && !reflect.DeepEqual(e.Fields, tt.wantFields) {
+			t.Errorf("[%d] unknown fields = %v; want %v", i, e.Fields, tt.wantFields)
+		}
+		if len(logged) != 0 != (len(tt.wantFields) != 0) {
+			t.Errorf("[%d] logged %q; want unknown fields %v", i, logged, tt.wantFields)
+		}
+		if len(tt.wantFields) == 0 || tt.wantErr {
+			continue
+		}
+		// Each field is reported once.
+		c.DownloadAccount(context.Background(), &DownloadAccountRequest{})
+		if len(logged) != 1 {
+			t.Errorf("[%d] logged %q; want the unknown fields once", i, logged)
+		}
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	type inner struct {
+		A string `json:"a"`
+	}
+	type outer struct {
+		inner
+		B       TimestampMilli    `json:"b"`
+		C       Bytes             `json:"c"`
+		M       map[string]*inner `json:"m"`
+		Ignored string            `json:"-"`
+		Name    string
+	}
+	v := map[string]interface{}{
+		"a":       "x",
+		"c":       "AA",
+		"name":    "n",
+		"Ignored": "i",
+		"m":       map[string]interface{}{"k": map[string]interface{}{"a": "x", "z": 1}},
+	}
+	want := []string{"Ignored", "m.k.z"}
+	if got := unknownFields(v, reflect.TypeOf(outer{}), ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("unknownFields() = %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// The scrypt parameters of the projects whose password hash configuration is
+// not customized, used by User.VerifyPassword.
+const (
+	DefaultScryptRounds     = 8
+	DefaultScryptMemoryCost = 14
+)
+
+// hmacHashes are the hash functions of the HMAC algorithms.
+var hmacHashes = map[string]func() hash.Hash{
+	"HMAC_SHA512": sha512.New,
+	"HMAC_SHA256": sha256.New,
+	"HMAC_SHA1":   sha1.New,
+	"HMAC_MD5":    md5.New,
+}
+
+// VerifyPassword reports whether the password matches the PasswordHash and
+// Salt of the user, e.g., downloaded by ListUsersN before migrating away,
+// without calling the API. The algorithm is HMAC_SHA256 or another HMAC
+// algorithm keyed with signerKey, or SCRYPT, the scrypt variant of the
+// projects with the default parameters, DefaultScryptRounds and
+// DefaultScryptMemoryCost. See VerifyPasswordWithOptions for the other
+// parameters.
+func (u *User) VerifyPassword(password string, signerKey, saltSeparator []byte, algorithm string) (bool, error) {
+	return u.VerifyPasswordWithOptions(password, &UploadOptions{
+		HashAlgorithm: algorithm,
+		SignerKey:     signerKey,
+		SaltSeparator: saltSeparator,
+		Rounds:        DefaultScryptRounds,
+		MemoryCost:    DefaultScryptMemoryCost,
+	})
+}
+
+// VerifyPasswordWithOptions reports whether the password matches the
+// PasswordHash and Salt of the user hashed as described by opts, the password
+// hash configuration of the project. Only the HMAC and SCRYPT algorithms are
+// supported: the other hashes can be compared with those of the
+// hash.HashWithSalt function.
+func (u *User) VerifyPasswordWithOptions(password string, opts *UploadOptions) (bool, error) {
+	if len(u.PasswordHash) == 0 {
+		return false, fmt.Errorf("gitkit: user %s has no password hash", u.LocalID)
+	}
+	h, err := hashPassword(opts, password, u.Salt)
+	if err != nil {
+		return false, err
+	}
+	return hmac.Equal(h, u.PasswordHash), nil
+}
+
+// ErrUnsupportedHashAlgorithm is returned by HashPassword for the password
+// hash algorithms other than the HMAC algorithms and SCRYPT.
+var ErrUnsupportedHashAlgorithm = errors.New("gitkit: unsupported password hash algorithm")
+
+// HashPassword hashes the password with the salt as identitytoolkit checks it
+// against the hashes uploaded with opts, like User.VerifyPasswordWithOptions.
+// It only supports the HMAC algorithms and SCRYPT, and returns
+// ErrUnsupportedHashAlgorithm otherwise: the hash package implements the
+// others on top of it.
+func HashPassword(opts *UploadOptions, password string, salt []byte) ([]byte, error) {
+	return hashPassword(opts, password, salt)
+}
+
+// hashPassword implements HashPassword.
+func hashPassword(opts *UploadOptions, password string, salt []byte) ([]byte, error) {
+	switch {
+	case hmacHashes[opts.HashAlgorithm] != nil:
+		if len(opts.SignerKey) == 0 {
+			return nil, fmt.Errorf("gitkit: %s needs a signer key", opts.HashAlgorithm)
+		}
+		mac := hmac.New(hmacHashes[opts.HashAlgorithm], opts.SignerKey)
+		if opts.PasswordHashOrder == SaltAndPassword {
+			mac.Write(salt)
+			mac.Write([]byte(password))
+		} else {
+			mac.Write([]byte(password))
+			mac.Write(salt)
+		}
+		return mac.Sum(nil), nil
+	case opts.HashAlgorithm == "SCRYPT":
+		return scryptHash(opts, password, salt)
+	}
+	return nil, ErrUnsupportedHashAlgorithm
+}
+
+// scryptHash encrypts the signer key with AES-256-CTR, keyed with the scrypt
+// key of the password, the scrypt variant of the projects.
+func scryptHash(opts *UploadOptions, password string, salt []byte) ([]byte, error) {
+	if len(opts.SignerKey) == 0 {
+		return nil, fmt.Errorf("gitkit: SCRYPT needs a signer key")
+	}
+	if opts.Rounds <= 0 || opts.MemoryCost <= 0 || opts.MemoryCost > 32 {
+		return nil, fmt.Errorf("gitkit: SCRYPT needs the rounds and a memory cost between 1 and 32")
+	}
+	s := append(append([]byte{}, salt...), opts.SaltSeparator...)
+	key, err := scrypt.Key([]byte(password), s, 1<<uint(opts.MemoryCost), opts.Rounds, 1, 64)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key[:32])
+	if err != nil {
+		return nil, err
+	}
+	h := make([]byte, len(opts.SignerKey))
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(h, opts.SignerKey)
+	return h, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+)
+
+func TestUser_VerifyPassword(t *testing.T) {
+	key := []byte("key")
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("passwordsalt"))
+	u := &User{LocalID: "123", PasswordHash: mac.Sum(nil), Salt: []byte("salt")}
+	if ok, err := u.VerifyPassword("password", key, nil, "HMAC_SHA256"); !ok || err != nil {
+		t.Errorf("VerifyPassword() of the password = %v, %v; want true", ok, err)
+	}
+	if ok, err := u.VerifyPassword("wrong", key, nil, "HMAC_SHA256"); ok || err != nil {
+		t.Errorf("VerifyPassword() of a wrong password = %v, %v; want false", ok, err)
+	}
+	if ok, _ := u.VerifyPassword("password", []byte("other"), nil, "HMAC_SHA256"); ok {
+		t.Error("VerifyPassword() with another key returns true")
+	}
+	for _, alg := range []string{"MD5", "BCRYPT", ""} {
+		if _, err := u.VerifyPassword("password", key, nil, alg); err == nil {
+			t.Errorf("VerifyPassword() with %q returns no error", alg)
+		}
+	}
+	if _, err := HashPassword(&UploadOptions{HashAlgorithm: "MD5"}, "password", nil); err != ErrUnsupportedHashAlgorithm {
+		t.Errorf("HashPassword() with MD5 returns error %v; want %v", err, ErrUnsupportedHashAlgorithm)
+	}
+	if _, err := (&User{}).VerifyPassword("password", key, nil, "HMAC_SHA256"); err == nil {
+		t.Error("VerifyPassword() of a user without password returns no error")
+	}
+}
+
+func TestUser_VerifyPassword_scrypt(t *testing.T) {
+	// The example of the scrypt implementation of the projects.
+	key, _ := base64.StdEncoding.DecodeString("jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVMXAn210wjLNmdZJzxUECKbm0QsEmYUSDzZvpjeJ9WmXA==")
+	sep, _ := base64.StdEncoding.DecodeString("Bw==")
+	salt, _ := base64.StdEncoding.DecodeString("42xEC+ixf3L2lw==")
+	hash, _ := base64.StdEncoding.DecodeString("lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1SAyZvqmZqAjTdn3aoItz+VHjoZilo78198JAdRuid5lQ==")
+	u := &User{PasswordHash: hash, Salt: salt}
+	if ok, err := u.VerifyPassword("user1password", key, sep, "SCRYPT"); !ok || err != nil {
+		t.Errorf("VerifyPassword() of the password = %v, %v; want true", ok, err)
+	}
+	if ok, err := u.VerifyPassword("user2password", key, sep, "SCRYPT"); ok || err != nil {
+		t.Errorf("VerifyPassword() of a wrong password = %v, %v; want false", ok, err)
+	}
+}
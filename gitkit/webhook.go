@@ -0,0 +1,175 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Headers of the webhook requests.
+const (
+	// WebhookTimestampHeader holds the time the request was signed, in
+	// seconds since the epoch.
+	WebhookTimestampHeader = "X-Gitkit-Timestamp"
+	// WebhookSignatureHeader holds the signature of the request.
+	WebhookSignatureHeader = "X-Gitkit-Signature"
+)
+
+// DefaultWebhookTolerance is the maximum age of the webhook requests accepted
+// by VerifyWebhook if its tolerance is not positive.
+const DefaultWebhookTolerance = 5 * time.Minute
+
+// maxWebhookBody is the maximum size of the webhook requests read by
+// VerifyWebhook.
+const maxWebhookBody = 1 << 20
+
+// ErrInvalidWebhookSignature is returned by VerifyWebhook for a request which
+// is not signed with the secret, or signed too long ago.
+var ErrInvalidWebhookSignature = errors.New("gitkit: invalid webhook signature")
+
+// A Webhook posts JSON events to a user provided URL, e.g., a custom mailer
+// sending the OOB code emails. The requests are signed with Secret, so that
+// the receiver can check them with VerifyWebhook. See Config.OOBWebhook.
+type Webhook struct {
+	// URL receives the events.
+	URL string `json:"url"`
+	// Secret is the HMAC key signing the requests, shared with the receiver.
+	Secret []byte `json:"secret"`
+	// Client sends the requests. If nil, a client with the default transport
+	// of the context is used.
+	Client *http.Client `json:"-"`
+}
+
+// OOBCodeEvent is the event posted to Config.OOBWebhook for each OOB code
+// generated by GenerateOOBCode.
+type OOBCodeEvent struct {
+	Action     string `json:"action"`
+	Email      string `json:"email"`
+	NewEmail   string `json:"newEmail,omitempty"`
+	OOBCode    string `json:"oobCode"`
+	OOBCodeURL string `json:"oobCodeUrl,omitempty"`
+}
+
+// Post posts the JSON encoded event to the URL, signed. It fails unless the
+// receiver answers with a 2xx status.
+func (h *Webhook) Post(ctx context.Context, event interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, ts)
+	req.Header.Set(WebhookSignatureHeader, webhookSignature(h.Secret, ts, body))
+	hc := h.Client
+	if hc == nil {
+		hc = &http.Client{Transport: defaultTransport(ctx)}
+	}
+	resp, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxWebhookBody))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("gitkit: webhook %s answered with status %s", h.URL, resp.Status)
+	}
+	return nil
+}
+
+// webhookSignature signs the timestamp and the body, so that the requests
+// cannot be replayed with another timestamp.
+func webhookSignature(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature of a request posted by a Webhook with the
+// secret, and that it was signed less than tolerance ago, or
+// DefaultWebhookTolerance if tolerance is not positive. It returns the body of
+// the request, or ErrInvalidWebhookSignature.
+//
+// For example, in the handler of a custom mailer,
+//
+//	body, err := gitkit.VerifyWebhook(r, secret, 0)
+//	if err != nil {
+//		http.Error(w, err.Error(), http.StatusUnauthorized)
+//		return
+//	}
+//	var e gitkit.OOBCodeEvent
+//	err = json.Unmarshal(body, &e)
+//	...
+func VerifyWebhook(r *http.Request, secret []byte, tolerance time.Duration) ([]byte, error) {
+	if tolerance <= 0 {
+		tolerance = DefaultWebhookTolerance
+	}
+	ts := r.Header.Get(WebhookTimestampHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidWebhookSignature
+	}
+	if d := time.Since(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+		return nil, ErrInvalidWebhookSignature
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(r.Header.Get(WebhookSignatureHeader))
+	if err != nil {
+		return nil, ErrInvalidWebhookSignature
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		return nil, err
+	}
+	want, _ := base64.RawURLEncoding.DecodeString(webhookSignature(secret, ts, body))
+	if !hmac.Equal(sig, want) {
+		return nil, ErrInvalidWebhookSignature
+	}
+	return body, nil
+}
+
+// postOOBCode posts the generated OOB code to Config.OOBWebhook, if set.
+func (c *Client) postOOBCode(ctx context.Context, resp *OOBCodeResponse) error {
+	if c.config == nil || c.config.OOBWebhook == nil {
+		return nil
+	}
+	e := &OOBCodeEvent{
+		Action:   resp.Action,
+		Email:    resp.Email,
+		NewEmail: resp.NewEmail,
+		OOBCode:  resp.OOBCode,
+	}
+	if resp.OOBCodeURL != nil {
+		e.OOBCodeURL = resp.OOBCodeURL.String()
+	}
+	return c.config.OOBWebhook.Post(ctx, e)
+}
@@ -0,0 +1,96 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestClient_GenerateOOBCode_webhook(t *testing.T) {
+	secret := []byte("secret")
+	var events []*OOBCodeEvent
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := VerifyWebhook(r, secret, 0)
+		if err != nil {
+			t.Errorf("VerifyWebhook() returns error: %v", err)
+		}
+		e := &OOBCodeEvent{}
+		json.Unmarshal(body, e)
+		events = append(events, e)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	c := &Client{
+		config: &Config{OOBWebhook: &Webhook{URL: srv.URL, Secret: secret}},
+		api:    &APIClient{Client: http.Client{Transport: roundTripper{200, `{"oobCode":"code"}`}}},
+	}
+	form := url.Values{OOBActionParam: {OOBActionVerifyEmail}, OOBEmailParam: {"user@example.com"}}
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("POST", "/oob", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+	if _, err := c.GenerateOOBCode(context.Background(), newRequest()); err != nil {
+		t.Fatalf("GenerateOOBCode() returns error: %v", err)
+	}
+	if len(events) != 1 || events[0].Action != OOBActionVerifyEmail || events[0].Email != "user@example.com" || events[0].OOBCode != "code" {
+		t.Errorf("webhook events = %+v; want the verify email OOB code", events)
+	}
+
+	status = http.StatusInternalServerError
+	if _, err := c.GenerateOOBCode(context.Background(), newRequest()); err == nil {
+		t.Error("GenerateOOBCode() returns no error when the webhook fails")
+	}
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	secret := []byte("secret")
+	body := `{"action":"resetPassword"}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	tests := []struct {
+		ts, sig, body string
+		ok            bool
+	}{
+		{now, webhookSignature(secret, now, []byte(body)), body, true},
+		{now, webhookSignature([]byte("other"), now, []byte(body)), body, false},
+		{now, webhookSignature(secret, now, []byte(body)), `{"action":"changeEmail"}`, false},
+		{old, webhookSignature(secret, old, []byte(body)), body, false},
+		{"", webhookSignature(secret, "", []byte(body)), body, false},
+	}
+	for i, tt := range tests {
+		r := httptest.NewRequest("POST", "/hook", strings.NewReader(tt.body))
+		r.Header.Set(WebhookTimestampHeader, tt.ts)
+		r.Header.Set(WebhookSignatureHeader, tt.sig)
+		b, err := VerifyWebhook(r, secret, 0)
+		if tt.ok && (err != nil || string(b) != tt.body) {
+			t.Errorf("%d. VerifyWebhook() = %s, %v; want the body", i, b, err)
+		}
+		if !tt.ok && err != ErrInvalidWebhookSignature {
+			t.Errorf("%d. VerifyWebhook() returns error %v; want ErrInvalidWebhookSignature", i, err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// WidgetConfig is the configuration of the identitytoolkit javascript widget,
+// as passed to google.identitytoolkit.start.
+type WidgetConfig struct {
+	WidgetURL                         string   `json:"widgetUrl,omitempty"`
+	SignInSuccessURL                  string   `json:"signInSuccessUrl,omitempty"`
+	SignOutURL                        string   `json:"signOutUrl,omitempty"`
+	OOBActionURL                      string   `json:"oobActionUrl,omitempty"`
+	APIKey                            string   `json:"apiKey,omitempty"`
+	SiteName                          string   `json:"siteName,omitempty"`
+	SignInOptions                     []string `json:"signInOptions,omitempty"`
+	QueryParameterForWidgetMode       string   `json:"queryParameterForWidgetMode,omitempty"`
+	QueryParameterForSignInSuccessURL string   `json:"queryParameterForSignInSuccessUrl,omitempty"`
+}
+
+// WidgetConfig returns the widget configuration of the project, built from the
+// Client configuration and the project configuration.
+func (c *Client) WidgetConfig(ctx context.Context) (*WidgetConfig, error) {
+	f, err := c.FrontendConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &WidgetConfig{
+		WidgetURL:                         f.WidgetURL,
+		APIKey:                            f.APIKey,
+		SignInOptions:                     f.SignInOptions,
+		QueryParameterForWidgetMode:       f.ModeParam,
+		QueryParameterForSignInSuccessURL: ReturnURLParam,
+	}, nil
+}
+
+// maxWidgetPostBody is the maximum size of the IDP responses posted to the
+// widget page.
+const maxWidgetPostBody = 1 << 20
+
+// DefaultWidgetTemplate is the page served by WidgetHandler if its Template is
+// nil. It is executed with a WidgetPage.
+var DefaultWidgetTemplate = template.Must(template.New("widget").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Config.SiteName}}</title>
+<script src="https://www.gstatic.com/authtoolkit/js/gitkit.js"></script>
+<link type="text/css" rel="stylesheet" href="https://www.gstatic.com/authtoolkit/css/gitkit.css">
+<script>
+  window.google.identitytoolkit.start('#gitkitWidgetDiv', {{.Config}}, {{.PostBody}});
+</script>
+</head>
+<body>
+<div id="gitkitWidgetDiv"></div>
+</body>
+</html>
+`))
+
+// A WidgetPage is the data the widget page template is executed with.
+type WidgetPage struct {
+	// Config is the widget configuration.
+	Config *WidgetConfig
+	// PostBody is the body of the request if posted by an IDP.
+	PostBody string
+}
+
+// A WidgetHandler is an http.Handler which serves a sign in page hosting the
+// identitytoolkit javascript widget. It is created by WidgetHandler and should
+// be served at Config.WidgetURL.
+//
+// The project configuration is fetched on the first request and cached.
+type WidgetHandler struct {
+	client *Client
+
+	// Context returns the context used to fetch the project configuration. If
+	// nil, the context of the request is used. On App Engine, it should be
+	// appengine.NewContext.
+	Context func(*http.Request) context.Context
+	// SiteName, SignInSuccessURL, SignOutURL and OOBActionURL are copied to
+	// the widget configuration. SignInSuccessURL is overridden by the return
+	// URL of the sign in URLs built by SignInURL.
+	SiteName         string
+	SignInSuccessURL string
+	SignOutURL       string
+	OOBActionURL     string
+	// Template is the page template, executed with a WidgetPage. If nil,
+	// DefaultWidgetTemplate is used.
+	Template *template.Template
+
+	mu     sync.Mutex
+	config *WidgetConfig
+}
+
+// WidgetHandler returns an http.Handler serving the sign in widget page.
+//
+// For example,
+//
+//	w := c.WidgetHandler()
+//	w.SiteName = "Example"
+//	w.SignInSuccessURL = "/"
+//	http.Handle("/gitkit", w)
+func (c *Client) WidgetHandler() *WidgetHandler {
+	return &WidgetHandler{client: c}
+}
+
+// widgetConfig returns a copy of the cached widget configuration.
+func (h *WidgetHandler) widgetConfig(ctx context.Context) (*WidgetConfig, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.config == nil {
+		conf, err := h.client.WidgetConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		h.config = conf
+	}
+	conf := *h.config
+	return &conf, nil
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *WidgetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := RequestContext(h.Context, r)
+	conf, err := h.widgetConfig(ctx)
+	if err != nil {
+		http.Error(w, "failed to load the widget configuration", http.StatusInternalServerError)
+		return
+	}
+	conf.SiteName = h.SiteName
+	conf.SignInSuccessURL = h.SignInSuccessURL
+	conf.SignOutURL = h.SignOutURL
+	conf.OOBActionURL = h.OOBActionURL
+	if r.URL.Query().Get(ReturnURLParam) != "" {
+		u, err := h.client.VerifyReturnURL(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		conf.SignInSuccessURL = u
+	}
+	page := &WidgetPage{Config: conf}
+	if r.Method == "POST" {
+		b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWidgetPostBody))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page.PostBody = string(b)
+	}
+	tmpl := h.Template
+	if tmpl == nil {
+		tmpl = DefaultWidgetTemplate
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	b.WriteTo(w)
+}
@@ -0,0 +1,77 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const projectConfigJSON = `{"projectId":"p","apiKey":"browser-key","allowPasswordUser":true,"idpConfig":[{"provider":"GOOGLE","enabled":true,"clientId":"cid"}]}`
+
+func TestWidgetHandler(t *testing.T) {
+	c := newMiddlewareClient()
+	c.config.WidgetURL = "/widget"
+	c.api = prepareClient(false, projectConfigJSON)
+	h := c.WidgetHandler()
+	h.SiteName = "Example"
+	h.SignInSuccessURL = "/home"
+
+	req, _ := http.NewRequest("GET", "http://example.com/widget", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200: %s", w.Code, w.Body)
+	}
+	for _, s := range []string{
+		`"apiKey":"browser-key"`,
+		`"signInOptions":["google","password"]`,
+		`"signInSuccessUrl":"/home"`,
+		`"queryParameterForWidgetMode":"mode"`,
+		`<title>Example</title>`,
+	} {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("page does not contain %s:\n%s", s, w.Body)
+		}
+	}
+
+	// The return URL of a sign in URL replaces SignInSuccessURL.
+	req, _ = http.NewRequest("GET", "http://example.com/account", nil)
+	u, _ := c.SignInURL(req)
+	req, _ = http.NewRequest("GET", u.String(), nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"signInSuccessUrl":"http://example.com/account"`) {
+		t.Errorf("page with return URL = %d:\n%s", w.Code, w.Body)
+	}
+
+	// Forged return URLs are rejected.
+	req, _ = http.NewRequest("GET", "http://example.com/widget?signInSuccessUrl=http://evil.com/", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status with forged return URL = %d; want 400", w.Code)
+	}
+
+	// IDP responses are passed to the widget as a JS string.
+	req, _ = http.NewRequest("POST", "http://example.com/widget", strings.NewReader(`id_token=x'</script>`))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if body := w.Body.String(); w.Code != http.StatusOK || strings.Contains(body, `x'</script>`) || !strings.Contains(body, `id_token=x`) {
+		t.Errorf("page with post body = %d:\n%s", w.Code, body)
+	}
+}
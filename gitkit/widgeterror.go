@@ -0,0 +1,131 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// An ErrorCode is a machine-readable error understood by the gitkit widget,
+// which shows a localized message for it instead of a generic failure.
+type ErrorCode string
+
+// Error codes of the widget error responses. Most of them are the error
+// messages of the identitytoolkit API.
+const (
+	ErrorCodeInvalidOOBCode        ErrorCode = "INVALID_OOB_CODE"
+	ErrorCodeExpiredOOBCode        ErrorCode = "EXPIRED_OOB_CODE"
+	ErrorCodeCaptchaCheckFailed    ErrorCode = "CAPTCHA_CHECK_FAILED"
+	ErrorCodeEmailNotFound         ErrorCode = "EMAIL_NOT_FOUND"
+	ErrorCodeEmailExists           ErrorCode = "EMAIL_EXISTS"
+	ErrorCodeInvalidEmail          ErrorCode = "INVALID_EMAIL"
+	ErrorCodeEmailNotAllowed       ErrorCode = "EMAIL_NOT_ALLOWED"
+	ErrorCodeEmailNotVerified      ErrorCode = "EMAIL_NOT_VERIFIED"
+	ErrorCodeInvalidPassword       ErrorCode = "INVALID_PASSWORD"
+	ErrorCodeWeakPassword          ErrorCode = "WEAK_PASSWORD"
+	ErrorCodeUserNotFound          ErrorCode = "USER_NOT_FOUND"
+	ErrorCodeUserDisabled          ErrorCode = "USER_DISABLED"
+	ErrorCodeResetPasswordLimit    ErrorCode = "RESET_PASSWORD_EXCEED_LIMIT"
+	ErrorCodeTooManyAttempts       ErrorCode = "TOO_MANY_ATTEMPTS_TRY_LATER"
+	ErrorCodeQuotaExceeded         ErrorCode = "QUOTA_EXCEEDED"
+	ErrorCodeForbiddenOrigin       ErrorCode = "FORBIDDEN_ORIGIN"
+	ErrorCodeInvalidIDToken        ErrorCode = "INVALID_ID_TOKEN"
+	ErrorCodeInternalError         ErrorCode = "INTERNAL_ERROR"
+	ErrorCodeMissingOOBCode        ErrorCode = "MISSING_OOB_CODE"
+	ErrorCodeMissingPassword       ErrorCode = "MISSING_PASSWORD"
+	ErrorCodeMethodNotAllowed      ErrorCode = "METHOD_NOT_ALLOWED"
+	ErrorCodeCredentialTooOldLogin ErrorCode = "CREDENTIAL_TOO_OLD_LOGIN_AGAIN"
+)
+
+// apiErrorCodes are the identitytoolkit API error messages passed through as
+// error codes.
+var apiErrorCodes = map[ErrorCode]bool{
+	ErrorCodeInvalidOOBCode:        true,
+	ErrorCodeExpiredOOBCode:        true,
+	ErrorCodeCaptchaCheckFailed:    true,
+	ErrorCodeEmailNotFound:         true,
+	ErrorCodeEmailExists:           true,
+	ErrorCodeInvalidEmail:          true,
+	ErrorCodeInvalidPassword:       true,
+	ErrorCodeWeakPassword:          true,
+	ErrorCodeUserNotFound:          true,
+	ErrorCodeUserDisabled:          true,
+	ErrorCodeResetPasswordLimit:    true,
+	ErrorCodeTooManyAttempts:       true,
+	ErrorCodeInvalidIDToken:        true,
+	ErrorCodeCredentialTooOldLogin: true,
+}
+
+// ErrorCodeOf returns the ErrorCode of err, and the HTTP status of the error
+// response. The errors of the identitytoolkit API with a known message, e.g.,
+// EXPIRED_OOB_CODE, keep it as the code, the errors of this package are mapped
+// to their closest code, and the other errors are INTERNAL_ERROR.
+func ErrorCodeOf(err error) (ErrorCode, int) {
+	switch e := err.(type) {
+	case *googleapi.Error:
+		// The messages may carry details, e.g., "WEAK_PASSWORD : too short".
+		code := ErrorCode(strings.TrimSpace(strings.SplitN(e.Message, ":", 2)[0]))
+		if apiErrorCodes[code] && e.Code >= 400 && e.Code < 500 {
+			return code, e.Code
+		}
+	case *QuotaError:
+		return ErrorCodeQuotaExceeded, http.StatusTooManyRequests
+	case *LockedOutError:
+		return ErrorCodeTooManyAttempts, http.StatusTooManyRequests
+	case *WeakPasswordError:
+		return ErrorCodeWeakPassword, http.StatusBadRequest
+	case *BlockedEmailError:
+		return ErrorCodeEmailNotAllowed, http.StatusBadRequest
+	case *UndeliverableEmailError:
+		return ErrorCodeInvalidEmail, http.StatusBadRequest
+	case *CaptchaError:
+		return ErrorCodeCaptchaCheckFailed, http.StatusBadRequest
+	case *ForbiddenOriginError:
+		return ErrorCodeForbiddenOrigin, http.StatusForbidden
+	case UserNotFoundError:
+		return ErrorCodeUserNotFound, http.StatusNotFound
+	}
+	switch err {
+	case ErrInvalidCredentials:
+		return ErrorCodeInvalidPassword, http.StatusBadRequest
+	case ErrOOBCodeURLExpired:
+		return ErrorCodeExpiredOOBCode, http.StatusBadRequest
+	case ErrEmailNotVerified:
+		return ErrorCodeEmailNotVerified, http.StatusForbidden
+	}
+	return ErrorCodeInternalError, http.StatusInternalServerError
+}
+
+// widgetError is the JSON error response understood by the widget.
+type widgetError struct {
+	Error     string    `json:"error"`
+	ErrorCode ErrorCode `json:"errorCode"`
+}
+
+func newWidgetError(err error) *widgetError {
+	code, _ := ErrorCodeOf(err)
+	return &widgetError{err.Error(), code}
+}
+
+// WriteError writes the JSON error response of err for the widget, e.g.,
+// {"error": "...", "errorCode": "EXPIRED_OOB_CODE"}, with the HTTP status
+// returned by ErrorCodeOf.
+func WriteError(w http.ResponseWriter, err error) {
+	_, status := ErrorCodeOf(err)
+	writeJSON(w, status, newWidgetError(err))
+}
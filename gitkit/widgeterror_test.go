@@ -0,0 +1,76 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestErrorCodeOf(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantCode   ErrorCode
+		wantStatus int
+	}{
+		{&googleapi.Error{Code: 400, Message: "INVALID_OOB_CODE"}, ErrorCodeInvalidOOBCode, 400},
+		{&googleapi.Error{Code: 400, Message: "EXPIRED_OOB_CODE"}, ErrorCodeExpiredOOBCode, 400},
+		{&googleapi.Error{Code: 400, Message: "CAPTCHA_CHECK_FAILED"}, ErrorCodeCaptchaCheckFailed, 400},
+		{&googleapi.Error{Code: 400, Message: "WEAK_PASSWORD : too short"}, ErrorCodeWeakPassword, 400},
+		{&googleapi.Error{Code: 400, Message: "SOMETHING_NEW"}, ErrorCodeInternalError, 500},
+		{&googleapi.Error{Code: 503, Message: "INVALID_OOB_CODE"}, ErrorCodeInternalError, 500},
+		{&QuotaError{Reason: "rateLimitExceeded"}, ErrorCodeQuotaExceeded, 429},
+		{&LockedOutError{Key: "user@example.com", RetryAfter: time.Minute}, ErrorCodeTooManyAttempts, 429},
+		{&WeakPasswordError{"too short"}, ErrorCodeWeakPassword, 400},
+		{&BlockedEmailError{"user@mailinator.com", "mailinator.com"}, ErrorCodeEmailNotAllowed, 400},
+		{&UndeliverableEmailError{"user@", "missing domain"}, ErrorCodeInvalidEmail, 400},
+		{&ForbiddenOriginError{"https://evil.com"}, ErrorCodeForbiddenOrigin, 403},
+		{UserNotFoundError("1234"), ErrorCodeUserNotFound, 404},
+		{ErrInvalidCredentials, ErrorCodeInvalidPassword, 400},
+		{ErrOOBCodeURLExpired, ErrorCodeExpiredOOBCode, 400},
+		{ErrEmailNotVerified, ErrorCodeEmailNotVerified, 403},
+		{errors.New("boom"), ErrorCodeInternalError, 500},
+	}
+	for i, tt := range tests {
+		code, status := ErrorCodeOf(tt.err)
+		if code != tt.wantCode || status != tt.wantStatus {
+			t.Errorf("[%d]: ErrorCodeOf(%v) = %q, %d; want %q, %d", i, tt.err, code, status, tt.wantCode, tt.wantStatus)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteError(w, &googleapi.Error{Code: 400, Message: "EXPIRED_OOB_CODE"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d; want %d", w.Code, http.StatusBadRequest)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type = %q; want JSON", ct)
+	}
+	var e widgetError
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		t.Fatalf("WriteError() writes a non JSON: %q", w.Body)
+	}
+	if e.ErrorCode != ErrorCodeExpiredOOBCode || e.Error == "" {
+		t.Errorf("WriteError() = %+v; want errorCode %q and a message", e, ErrorCodeExpiredOOBCode)
+	}
+}